@@ -0,0 +1,360 @@
+package handlers
+
+import (
+	"archive/zip"
+	"database/sql"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"booonus-backend/internal/auth"
+	"booonus-backend/internal/database"
+	"booonus-backend/internal/export"
+	"booonus-backend/internal/storage"
+	"booonus-backend/pkg/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// formerPartnerPrefix 注销后保留共享记录的用户，用户名替换为 former_partner_<id>
+// 注册和修改用户名时不允许使用该前缀
+const formerPartnerPrefix = "former_partner_"
+
+// 注销方式
+const (
+	accountAnonymised = "anonymised" // 有共享记录：保留记录，去除个人信息
+	accountErased     = "erased"     // 没有共享记录：删除全部数据
+)
+
+// DeleteAccount 注销账号，需要确认密码
+// 与他人有共享记录（情侣、事件、购买等）时匿名化：伴侣看到的历史中显示为 former_partner_<id>；
+// 否则删除全部数据。两种情况都会删除附件、导出文件等个人数据
+func DeleteAccount(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req struct {
+		Password string `json:"password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var hash string
+	if err := database.DB.QueryRow("SELECT password FROM users WHERE id = ?", userID).Scan(&hash); err != nil {
+		logger.Error("Failed to get user: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	if !auth.CheckPassword(req.Password, hash) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Incorrect password"})
+		return
+	}
+
+	blobKeys, err := userBlobKeys(userID)
+	if err != nil {
+		logger.Error("Failed to get user blobs: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete account"})
+		return
+	}
+
+	shared, err := hasSharedHistory(userID)
+	if err != nil {
+		logger.Error("Failed to check shared history: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete account"})
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		logger.Error("Failed to begin transaction: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete account"})
+		return
+	}
+	defer tx.Rollback()
+
+	mode := accountErased
+	if shared {
+		mode = accountAnonymised
+	}
+
+	if err = removePersonalData(tx, userID); err == nil {
+		if shared {
+			err = anonymiseUser(tx, userID)
+		} else {
+			err = eraseUser(tx, userID)
+		}
+	}
+	if err != nil {
+		logger.Error("Failed to delete account: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete account"})
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		logger.Error("Failed to commit transaction: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete account"})
+		return
+	}
+
+	// 数据库记录已删除，对象删除失败只记录日志
+	for _, key := range blobKeys {
+		if err := storage.Blobs.Delete(key); err != nil {
+			logger.Error("Failed to delete blob " + key + ": " + err.Error())
+		}
+	}
+
+	logger.Info("Account deleted (" + mode + "): " + strconv.Itoa(userID))
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Account deleted successfully",
+		"mode":    mode,
+	})
+}
+
+// DownloadMyData 下载个人数据压缩包：data.json 包含账号、情侣、规则、商品、购买、事件、积分历史、
+// 评论、表情回应和附件信息，attachments/ 下为上传的附件原文件；时间按 tz 参数的时区输出
+func DownloadMyData(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	loc, ok := requestLocation(c)
+	if !ok {
+		return
+	}
+
+	tables, err := exportTables(userID, loc)
+	if err != nil {
+		logger.Error("Failed to prepare data archive: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export data"})
+		return
+	}
+	tables = append(tables,
+		queryExportTable("comments", loc,
+			[]string{"id", "target_type", "target_id", "content", "created_at", "updated_at", "deleted_at"},
+			"FROM comments WHERE user_id = ? ORDER BY id", userID),
+		queryExportTable("reactions", loc,
+			[]string{"id", "target_type", "target_id", "emoji", "created_at"},
+			"FROM reactions WHERE user_id = ? ORDER BY id", userID),
+		queryExportTable("attachments", loc,
+			[]string{"id", "content_type", "size", "created_at"},
+			"FROM attachments WHERE owner_id = ? ORDER BY id", userID),
+		queryExportTable("notifications", loc,
+			[]string{"id", "type", "actor_id", "message", "points", "reference_type", "reference_id", "read_at", "created_at"},
+			"FROM notifications WHERE user_id = ? ORDER BY id", userID),
+		queryExportTable("devices", loc,
+			[]string{"id", "platform", "name", "created_at", "last_seen_at"},
+			"FROM device_tokens WHERE user_id = ? ORDER BY id", userID),
+		queryExportTable("email", loc,
+			[]string{"email", "email_verified_at", "email_digest", "last_digest_at"},
+			"FROM users WHERE id = ? AND email IS NOT NULL", userID),
+		queryExportTable("preferences", loc,
+			[]string{"timezone", "locale", "week_start"},
+			"FROM users WHERE id = ?", userID),
+		queryExportTable("reminders", loc,
+			[]string{"id", "created_by", "user_id", "title", "message", "repeat", "repeat_interval", "weekdays",
+				"time_of_day", "start_date", "end_date", "timezone", "next_run_at", "is_active", "created_at"},
+			"FROM reminders WHERE user_id = ? ORDER BY id", userID),
+		queryExportTable("notification_preferences", loc,
+			[]string{"type", "in_app", "push"},
+			"FROM notification_preferences WHERE user_id = ? ORDER BY type", userID),
+	)
+
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", `attachment; filename="booonus-my-data-`+time.Now().In(loc).Format("20060102")+`.zip"`)
+	c.Status(http.StatusOK)
+
+	// 响应头已发出，出错时只能中断输出
+	if err := writeMyDataArchive(c.Writer, userID, tables); err != nil {
+		logger.Error("Failed to write data archive: " + err.Error())
+	}
+}
+
+// writeMyDataArchive 写出个人数据压缩包
+func writeMyDataArchive(w io.Writer, userID int, tables []export.Table) error {
+	zw := zip.NewWriter(w)
+
+	f, err := zw.Create("data.json")
+	if err != nil {
+		return err
+	}
+	if err := export.Write(f, export.FormatJSON, tables); err != nil {
+		return err
+	}
+
+	rows, err := database.DB.Query("SELECT id, storage_key FROM attachments WHERE owner_id = ? ORDER BY id", userID)
+	if err != nil {
+		return err
+	}
+	type attachmentFile struct {
+		id  int
+		key string
+	}
+	var files []attachmentFile
+	for rows.Next() {
+		var file attachmentFile
+		if err := rows.Scan(&file.id, &file.key); err != nil {
+			rows.Close()
+			return err
+		}
+		files = append(files, file)
+	}
+	rows.Close()
+
+	for _, file := range files {
+		reader, err := storage.Blobs.Get(file.key)
+		if err == storage.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		f, err := zw.Create("attachments/" + strconv.Itoa(file.id))
+		if err == nil {
+			_, err = io.Copy(f, reader)
+		}
+		reader.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+// hasSharedHistory 用户是否与他人有共享的记录，有则注销时只能匿名化
+func hasSharedHistory(userID int) (bool, error) {
+	var shared bool
+	err := database.DB.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM household_members WHERE user_id = ?)
+		    OR EXISTS (SELECT 1 FROM events WHERE creator_id = ? OR target_id = ?)
+		    OR EXISTS (SELECT 1 FROM transactions WHERE buyer_id = ? OR seller_id = ?)
+		    OR EXISTS (SELECT 1 FROM points_history WHERE user_id = ? AND type IN ('rule', 'event') AND import_id IS NULL)
+		    OR EXISTS (SELECT 1 FROM rule_executions WHERE executed_by = ?)
+		    OR EXISTS (SELECT 1 FROM revert_actions ra JOIN points_history ph ON ra.history_id = ph.id
+		               WHERE ra.actor_id = ? AND ph.user_id != ?)`,
+		userID, userID, userID, userID, userID, userID, userID, userID, userID,
+	).Scan(&shared)
+	return shared, err
+}
+
+// userBlobKeys 用户在对象存储中的全部对象：附件和导出文件
+func userBlobKeys(userID int) ([]string, error) {
+	rows, err := database.DB.Query(`
+		SELECT storage_key FROM attachments WHERE owner_id = ?
+		UNION ALL
+		SELECT storage_key FROM export_jobs WHERE user_id = ? AND storage_key IS NOT NULL`,
+		userID, userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// removePersonalData 两种注销方式都要删除的个人数据
+func removePersonalData(tx *sql.Tx, userID int) error {
+	statements := []string{
+		// 附件原文件会被删除，伴侣历史中的照片引用一并去掉
+		"UPDATE rule_executions SET attachment_id = NULL WHERE attachment_id IN (SELECT id FROM attachments WHERE owner_id = ?)",
+		"DELETE FROM attachments WHERE owner_id = ?",
+		"DELETE FROM export_jobs WHERE user_id = ?",
+		"DELETE FROM pinned_rules WHERE user_id = ?",
+		"DELETE FROM balance_snapshots WHERE user_id = ?",
+		"DELETE FROM reactions WHERE user_id = ?",
+		"DELETE FROM notifications WHERE user_id = ?",
+		"DELETE FROM notification_preferences WHERE user_id = ?",
+		"DELETE FROM device_tokens WHERE user_id = ?",
+		"DELETE FROM reminder_events WHERE reminder_id IN (SELECT id FROM reminders WHERE ? IN (user_id, created_by))",
+		"DELETE FROM reminder_events WHERE user_id = ?",
+		"DELETE FROM reminders WHERE ? IN (user_id, created_by)",
+		"UPDATE users SET email = NULL, email_verified_at = NULL, pending_email = NULL, email_digest = FALSE WHERE id = ?",
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement, userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// anonymiseUser 保留共享记录，去除用户的个人信息并禁止登录
+// 两人的情侣关系保留，伴侣仍能看到共同的历史，对方显示为 former_partner_<id>；
+// 多于两人的家庭中按成员离开处理
+func anonymiseUser(tx *sql.Tx, userID int) error {
+	statements := []string{
+		"UPDATE comments SET content = '', deleted_at = COALESCE(deleted_at, CURRENT_TIMESTAMP) WHERE user_id = ?",
+		"UPDATE shop_items SET is_active = FALSE, updated_at = CURRENT_TIMESTAMP WHERE user_id = ?",
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement, userID); err != nil {
+			return err
+		}
+	}
+
+	var coupleID sql.NullInt64
+	if err := tx.QueryRow("SELECT couple_id FROM users WHERE id = ?", userID).Scan(&coupleID); err != nil {
+		return err
+	}
+	if coupleID.Valid {
+		count, err := activeHouseholdMemberCount(tx, int(coupleID.Int64))
+		if err != nil {
+			return err
+		}
+		if count > 2 {
+			if err := leaveHousehold(tx, int(coupleID.Int64), userID, userID, time.Now().UTC()); err != nil {
+				return err
+			}
+		}
+	}
+
+	// 密码置空后任何密码都无法通过校验
+	_, err := tx.Exec(`
+		UPDATE users
+		SET username = ?, password = '', avatar = NULL, calendar_token = NULL,
+		    deleted_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?`,
+		formerPartnerPrefix+strconv.Itoa(userID), userID,
+	)
+	return err
+}
+
+// eraseUser 删除没有共享记录的用户的全部数据
+func eraseUser(tx *sql.Tx, userID int) error {
+	statements := []string{
+		"DELETE FROM comments WHERE target_type = 'points_history' AND target_id IN (SELECT id FROM points_history WHERE user_id = ?)",
+		"DELETE FROM reactions WHERE target_type = 'points_history' AND target_id IN (SELECT id FROM points_history WHERE user_id = ?)",
+		"DELETE FROM revert_actions WHERE history_id IN (SELECT id FROM points_history WHERE user_id = ?)",
+		"DELETE FROM points_history WHERE user_id = ?",
+		"DELETE FROM comments WHERE user_id = ?",
+		"DELETE FROM shop_items WHERE user_id = ?",
+		"UPDATE rules SET import_id = NULL WHERE import_id IN (SELECT id FROM imports WHERE user_id = ?)",
+		"DELETE FROM imports WHERE user_id = ?",
+		"UPDATE rule_revisions SET changed_by = NULL WHERE changed_by = ?",
+		"UPDATE event_revisions SET changed_by = NULL WHERE changed_by = ?",
+		"UPDATE notifications SET actor_id = NULL WHERE actor_id = ?",
+		"UPDATE webhooks SET created_by = NULL WHERE created_by = ?",
+		"DELETE FROM users WHERE id = ?",
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement, userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isReservedUsername 注销用户使用的用户名前缀不能被注册
+func isReservedUsername(username string) bool {
+	return strings.HasPrefix(strings.ToLower(username), formerPartnerPrefix)
+}
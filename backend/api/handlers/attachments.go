@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"booonus-backend/internal/database"
+	"booonus-backend/internal/storage"
+	"booonus-backend/models"
+	"booonus-backend/pkg/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxAttachmentSize 单个附件的最大字节数
+const maxAttachmentSize = 10 << 20
+
+// UploadAttachment 上传照片附件
+func UploadAttachment(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	// 限制整个请求体，FormFile 会先解析完整个表单（超出内存部分写入临时文件）再检查文件大小
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxAttachmentSize+1<<20)
+	file, err := c.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "File is too large"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "File is required"})
+		return
+	}
+
+	if file.Size > maxAttachmentSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "File is too large"})
+		return
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		logger.Error("Failed to open uploaded file: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload attachment"})
+		return
+	}
+	defer src.Close()
+
+	data, err := io.ReadAll(io.LimitReader(src, maxAttachmentSize+1))
+	if err != nil {
+		logger.Error("Failed to read uploaded file: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload attachment"})
+		return
+	}
+	if len(data) > maxAttachmentSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "File is too large"})
+		return
+	}
+
+	// 只接受图片，类型以文件内容为准
+	contentType := http.DetectContentType(data)
+	if !strings.HasPrefix(contentType, "image/") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only image attachments are supported"})
+		return
+	}
+
+	key, err := newAttachmentKey(userID)
+	if err != nil {
+		logger.Error("Failed to generate attachment key: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload attachment"})
+		return
+	}
+
+	if err := storage.Blobs.Put(key, bytes.NewReader(data)); err != nil {
+		logger.Error("Failed to store attachment: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload attachment"})
+		return
+	}
+
+	result, err := database.DB.Exec(
+		"INSERT INTO attachments (owner_id, storage_key, content_type, size) VALUES (?, ?, ?, ?)",
+		userID, key, contentType, len(data),
+	)
+	if err != nil {
+		storage.Blobs.Delete(key)
+		logger.Error("Failed to create attachment: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload attachment"})
+		return
+	}
+
+	attachmentID, _ := result.LastInsertId()
+
+	logger.Info("Attachment uploaded: " + strconv.FormatInt(attachmentID, 10) + " by user " + strconv.Itoa(userID))
+	c.JSON(http.StatusCreated, gin.H{
+		"message":       "Attachment uploaded successfully",
+		"attachment_id": attachmentID,
+		"content_type":  contentType,
+		"size":          len(data),
+	})
+}
+
+// GetAttachment 下载附件内容
+func GetAttachment(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	attachmentID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid attachment ID"})
+		return
+	}
+
+	var attachment models.Attachment
+	err = database.DB.QueryRow(
+		"SELECT id, owner_id, storage_key, content_type, size FROM attachments WHERE id = ?",
+		attachmentID,
+	).Scan(&attachment.ID, &attachment.OwnerID, &attachment.StorageKey, &attachment.ContentType, &attachment.Size)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
+			return
+		}
+		logger.Error("Failed to get attachment: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	// 只有上传者和其情侣可以查看
+	if !canUserAccessTarget(userID, attachment.OwnerID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	reader, err := storage.Blobs.Get(attachment.StorageKey)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
+			return
+		}
+		logger.Error("Failed to read attachment: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	defer reader.Close()
+
+	c.DataFromReader(http.StatusOK, attachment.Size, attachment.ContentType, reader, nil)
+}
+
+// canUserUseAttachment 检查附件是否存在且属于该用户
+func canUserUseAttachment(userID, attachmentID int) bool {
+	var count int
+	err := database.DB.QueryRow(
+		"SELECT COUNT(*) FROM attachments WHERE id = ? AND owner_id = ?",
+		attachmentID, userID,
+	).Scan(&count)
+	return err == nil && count > 0
+}
+
+// newAttachmentKey 生成附件在对象存储中的key
+func newAttachmentKey(userID int) (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return "attachments/" + strconv.Itoa(userID) + "/" + hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"booonus-backend/internal/database"
+	"booonus-backend/internal/ledger"
+	"booonus-backend/pkg/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetBalanceAt 查询自己或情侣在某个时间点的积分余额
+// at 可以是 RFC3339 时间（包含该秒内的变化），或 YYYY-MM-DD 表示该日（按 tz 时区）结束时，默认当前时间
+func GetBalanceAt(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	targetUserID := userID
+	if s := c.Query("user_id"); s != "" {
+		id, err := strconv.Atoi(s)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+		targetUserID = id
+	}
+	if !canUserRevertHistory(userID, targetUserID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	at := time.Now()
+	if s := c.Query("at"); s != "" {
+		if t, err := time.Parse(time.RFC3339, s); err == nil {
+			// 数据库时间精确到秒
+			at = t.Truncate(time.Second).Add(time.Second)
+		} else {
+			loc, ok := requestLocation(c)
+			if !ok {
+				return
+			}
+			day, err := time.ParseInLocation(dateLayout, s, loc)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid at, expected RFC3339 time or YYYY-MM-DD"})
+				return
+			}
+			at = day.AddDate(0, 0, 1)
+		}
+	}
+
+	balance, err := ledger.BalanceBefore(targetUserID, at)
+	if err != nil {
+		logger.Error("Failed to compute balance: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get balance"})
+		return
+	}
+
+	var currentBalance int
+	if err := database.DB.QueryRow("SELECT points FROM users WHERE id = ?", targetUserID).Scan(&currentBalance); err != nil {
+		logger.Error("Failed to get user points: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get balance"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user_id":         targetUserID,
+		"at":              at.UTC(),
+		"balance":         balance,
+		"current_balance": currentBalance,
+	})
+}
+
+// GetStatement 情侣双方的月度对账单：期初期末余额、获得、花费和撤销调整
+// month 为 YYYY-MM，按 tz 时区划分月份，默认上个月
+func GetStatement(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	loc, ok := requestLocation(c)
+	if !ok {
+		return
+	}
+
+	now := time.Now().In(loc)
+	from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc).AddDate(0, -1, 0)
+	if month := c.Query("month"); month != "" {
+		t, err := time.ParseInLocation("2006-01", month, loc)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid month, expected YYYY-MM"})
+			return
+		}
+		from = t
+	}
+	to := from.AddDate(0, 1, 0)
+
+	memberIDs, err := coupleMemberIDs(userID)
+	if err != nil {
+		logger.Error("Failed to get couple members: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	statements := []ledger.Statement{}
+	for _, id := range memberIDs {
+		statement, err := ledger.StatementFor(id, from, to)
+		if err != nil {
+			logger.Error("Failed to compute statement: " + err.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get statement"})
+			return
+		}
+		statements = append(statements, statement)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"month":      from.Format("2006-01"),
+		"timezone":   loc.String(),
+		"complete":   !to.After(time.Now()),
+		"statements": statements,
+	})
+}
@@ -0,0 +1,441 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"booonus-backend/internal/database"
+	"booonus-backend/internal/ical"
+	"booonus-backend/internal/notifications"
+	"booonus-backend/internal/reminders"
+	"booonus-backend/pkg/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// calendarFeedDays 日历订阅包含的历史天数
+const calendarFeedDays = 365
+
+// calendarDay 日历中某一天的内容
+type calendarDay struct {
+	Date           string  `json:"date"`
+	Events         []gin.H `json:"events"`
+	RuleExecutions []gin.H `json:"rule_executions"`
+	Purchases      []gin.H `json:"purchases"`
+}
+
+// GetCalendar 获取日期范围内的事件、规则执行和购买记录，按用户时区分天
+func GetCalendar(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	loc, ok := requestLocation(c)
+	if !ok {
+		return
+	}
+	from, to, ok := parseDateRange(c, loc)
+	if !ok {
+		return
+	}
+
+	memberIDs, err := coupleMemberIDs(userID)
+	if err != nil {
+		logger.Error("Failed to get couple members: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	days := map[string]*calendarDay{}
+	day := func(t time.Time) *calendarDay {
+		date := t.In(loc).Format(dateLayout)
+		d, ok := days[date]
+		if !ok {
+			d = &calendarDay{Date: date, Events: []gin.H{}, RuleExecutions: []gin.H{}, Purchases: []gin.H{}}
+			days[date] = d
+		}
+		return d
+	}
+
+	inClause, args := memberInClause(memberIDs)
+	rangeArgs := append(args, dbTime(from), dbTime(to))
+
+	// 事件：创建者或目标是情侣双方之一
+	eventRows, err := database.DB.Query(`
+		SELECT e.id, e.creator_id, e.target_id, e.name, e.description, e.points, e.created_at,
+		       u1.username, u2.username
+		FROM events e
+		JOIN users u1 ON e.creator_id = u1.id
+		JOIN users u2 ON e.target_id = u2.id
+		WHERE e.target_id IN (`+inClause+`) AND e.deleted_at IS NULL
+		  AND e.created_at >= ? AND e.created_at < ?
+		ORDER BY e.created_at ASC`,
+		rangeArgs...,
+	)
+	if err != nil {
+		logger.Error("Failed to get calendar events: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get calendar"})
+		return
+	}
+	defer eventRows.Close()
+
+	for eventRows.Next() {
+		var id, creatorID, targetID, points int
+		var name, creatorName, targetName string
+		var description sql.NullString
+		var createdAt time.Time
+		err := eventRows.Scan(&id, &creatorID, &targetID, &name, &description, &points, &createdAt, &creatorName, &targetName)
+		if err != nil {
+			logger.Error("Failed to scan calendar event: " + err.Error())
+			continue
+		}
+		d := day(createdAt)
+		d.Events = append(d.Events, gin.H{
+			"id":           id,
+			"name":         name,
+			"description":  description.String,
+			"points":       points,
+			"creator_id":   creatorID,
+			"creator_name": creatorName,
+			"target_id":    targetID,
+			"target_name":  targetName,
+			"created_at":   createdAt,
+		})
+	}
+
+	// 规则执行
+	ruleRows, err := database.DB.Query(`
+		SELECT ph.id, ph.reference_id, ph.user_id, ph.points, ph.is_reverted, ph.execution_id, ph.created_at,
+		       r.name, u.username
+		FROM points_history ph
+		JOIN rules r ON ph.reference_id = r.id
+		JOIN users u ON ph.user_id = u.id
+		WHERE ph.type = 'rule' AND ph.user_id IN (`+inClause+`)
+		  AND ph.created_at >= ? AND ph.created_at < ?
+		ORDER BY ph.created_at ASC, ph.id ASC`,
+		rangeArgs...,
+	)
+	if err != nil {
+		logger.Error("Failed to get calendar rule executions: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get calendar"})
+		return
+	}
+	defer ruleRows.Close()
+
+	for ruleRows.Next() {
+		var historyID, ruleID, targetID, points int
+		var isReverted bool
+		var executionID sql.NullInt64
+		var createdAt time.Time
+		var ruleName, username string
+		err := ruleRows.Scan(&historyID, &ruleID, &targetID, &points, &isReverted, &executionID, &createdAt, &ruleName, &username)
+		if err != nil {
+			logger.Error("Failed to scan calendar rule execution: " + err.Error())
+			continue
+		}
+		entry := gin.H{
+			"history_id":  historyID,
+			"rule_id":     ruleID,
+			"rule_name":   ruleName,
+			"user_id":     targetID,
+			"username":    username,
+			"points":      points,
+			"is_reverted": isReverted,
+			"created_at":  createdAt,
+		}
+		if executionID.Valid {
+			entry["execution_id"] = executionID.Int64
+		}
+		d := day(createdAt)
+		d.RuleExecutions = append(d.RuleExecutions, entry)
+	}
+
+	// 购买记录
+	purchaseRows, err := database.DB.Query(`
+		SELECT t.id, t.shop_item_id, t.buyer_id, t.seller_id, t.points, t.status, t.created_at,
+		       s.name, u.username
+		FROM transactions t
+		JOIN shop_items s ON t.shop_item_id = s.id
+		JOIN users u ON t.buyer_id = u.id
+		WHERE t.buyer_id IN (`+inClause+`)
+		  AND t.created_at >= ? AND t.created_at < ?
+		ORDER BY t.created_at ASC`,
+		rangeArgs...,
+	)
+	if err != nil {
+		logger.Error("Failed to get calendar purchases: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get calendar"})
+		return
+	}
+	defer purchaseRows.Close()
+
+	for purchaseRows.Next() {
+		var id, itemID, buyerID, sellerID, points int
+		var status, itemName, buyerName string
+		var createdAt time.Time
+		err := purchaseRows.Scan(&id, &itemID, &buyerID, &sellerID, &points, &status, &createdAt, &itemName, &buyerName)
+		if err != nil {
+			logger.Error("Failed to scan calendar purchase: " + err.Error())
+			continue
+		}
+		d := day(createdAt)
+		d.Purchases = append(d.Purchases, gin.H{
+			"transaction_id": id,
+			"shop_item_id":   itemID,
+			"item_name":      itemName,
+			"buyer_id":       buyerID,
+			"buyer_name":     buyerName,
+			"seller_id":      sellerID,
+			"points":         points,
+			"status":         status,
+			"created_at":     createdAt,
+		})
+	}
+
+	// 只返回有内容的日期，按日期升序
+	result := make([]*calendarDay, 0, len(days))
+	for _, d := range days {
+		result = append(result, d)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Date < result[j].Date })
+
+	c.JSON(http.StatusOK, gin.H{
+		"from":     from.Format(dateLayout),
+		"to":       to.AddDate(0, 0, -1).Format(dateLayout),
+		"timezone": loc.String(),
+		"days":     result,
+	})
+}
+
+// GetCalendarFeed 获取当前用户的日历订阅链接，首次访问时生成私密令牌
+func GetCalendarFeed(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var token sql.NullString
+	err := database.DB.QueryRow("SELECT calendar_token FROM users WHERE id = ?", userID).Scan(&token)
+	if err != nil {
+		logger.Error("Failed to get calendar token: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	if !token.Valid {
+		token.String, err = resetCalendarToken(userID)
+		if err != nil {
+			logger.Error("Failed to create calendar token: " + err.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create calendar feed"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"url": calendarFeedURL(c, token.String),
+	})
+}
+
+// ResetCalendarFeed 重新生成日历订阅令牌，旧链接立即失效
+func ResetCalendarFeed(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	token, err := resetCalendarToken(userID)
+	if err != nil {
+		logger.Error("Failed to reset calendar token: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset calendar feed"})
+		return
+	}
+
+	logger.Info("Calendar feed reset for user: " + strconv.Itoa(userID))
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Calendar feed reset successfully",
+		"url":     calendarFeedURL(c, token),
+	})
+}
+
+// GetCalendarICS 通过私密令牌导出 iCalendar 订阅（无需登录）
+func GetCalendarICS(c *gin.Context) {
+	token := strings.TrimSuffix(c.Param("file"), ".ics")
+	if token == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Calendar not found"})
+		return
+	}
+
+	var userID int
+	var username string
+	err := database.DB.QueryRow("SELECT id, username FROM users WHERE calendar_token = ?", token).Scan(&userID, &username)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Calendar not found"})
+			return
+		}
+		logger.Error("Failed to get calendar user: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	cal, err := buildCalendarFeed(userID)
+	if err != nil {
+		logger.Error("Failed to build calendar feed: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build calendar"})
+		return
+	}
+	cal.Name = "Booonus - " + username
+
+	c.Header("Content-Type", "text/calendar; charset=utf-8")
+	c.Header("Cache-Control", "no-cache")
+	c.Status(http.StatusOK)
+	if err := cal.Write(c.Writer); err != nil {
+		logger.Error("Failed to write calendar feed: " + err.Error())
+	}
+}
+
+// buildCalendarFeed 生成日历订阅内容：近一年的事件和提醒自己的、还会再提醒的提醒
+func buildCalendarFeed(userID int) (*ical.Calendar, error) {
+	memberIDs, err := coupleMemberIDs(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	inClause, args := memberInClause(memberIDs)
+	since := time.Now().AddDate(0, 0, -calendarFeedDays)
+
+	rows, err := database.DB.Query(`
+		SELECT e.id, e.name, e.description, e.points, e.created_at, u1.username, u2.username
+		FROM events e
+		JOIN users u1 ON e.creator_id = u1.id
+		JOIN users u2 ON e.target_id = u2.id
+		WHERE e.target_id IN (`+inClause+`) AND e.deleted_at IS NULL AND e.created_at >= ?
+		ORDER BY e.created_at ASC`,
+		append(args, dbTime(since))...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cal := &ical.Calendar{}
+	for rows.Next() {
+		var id, points int
+		var name, creatorName, targetName string
+		var description sql.NullString
+		var createdAt time.Time
+		if err := rows.Scan(&id, &name, &description, &points, &createdAt, &creatorName, &targetName); err != nil {
+			return nil, err
+		}
+
+		details := creatorName + " → " + targetName + ": " + notifications.FormatPoints(points)
+		if description.String != "" {
+			details += "\n" + description.String
+		}
+
+		cal.Events = append(cal.Events, ical.Event{
+			UID:         "event-" + strconv.Itoa(id) + "@booonus",
+			Start:       createdAt,
+			Duration:    15 * time.Minute,
+			Summary:     name + " (" + notifications.FormatPoints(points) + ")",
+			Description: details,
+			Created:     createdAt,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	reminderEvents, err := calendarReminders(userID)
+	if err != nil {
+		return nil, err
+	}
+	cal.Events = append(cal.Events, reminderEvents...)
+	return cal, nil
+}
+
+// calendarReminders 将提醒自己的提醒转为日历条目，重复的提醒从第一次提醒开始按 RRULE 重复
+func calendarReminders(userID int) ([]ical.Event, error) {
+	rows, err := database.DB.Query(`
+		SELECT `+reminders.Columns+`
+		FROM reminders
+		WHERE user_id = ? AND is_active = TRUE AND next_run_at IS NOT NULL
+		ORDER BY id`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []ical.Event
+	for rows.Next() {
+		r, err := reminders.Scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		schedule, err := r.Schedule()
+		if err != nil {
+			continue
+		}
+		first, ok := schedule.First()
+		if !ok {
+			continue
+		}
+
+		events = append(events, ical.Event{
+			UID:         "reminder-" + strconv.Itoa(r.ID) + "@booonus",
+			Start:       first,
+			Duration:    15 * time.Minute,
+			Summary:     r.Title,
+			Description: r.Message,
+			Created:     r.CreatedAt,
+			RRule:       schedule.RRule(),
+			Location:    schedule.Location,
+		})
+	}
+	return events, rows.Err()
+}
+
+// resetCalendarToken 为用户生成新的日历令牌
+func resetCalendarToken(userID int) (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(buf)
+
+	_, err := database.DB.Exec("UPDATE users SET calendar_token = ? WHERE id = ?", token, userID)
+	return token, err
+}
+
+// calendarFeedURL 拼接订阅链接，优先使用 PUBLIC_BASE_URL 环境变量
+func calendarFeedURL(c *gin.Context, token string) string {
+	return publicBaseURL(c) + "/api/v1/calendar/ical/" + token + ".ics"
+}
+
+// publicBaseURL 对外访问的地址，优先使用 PUBLIC_BASE_URL 配置，未配置时按请求的地址拼接
+func publicBaseURL(c *gin.Context) string {
+	base := configuredBaseURL()
+	if base == "" {
+		scheme := "http"
+		if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+			scheme = "https"
+		}
+		base = scheme + "://" + c.Request.Host
+	}
+	return base
+}
+
+// configuredBaseURL PUBLIC_BASE_URL 配置的对外访问地址，未配置时为空
+func configuredBaseURL() string {
+	return strings.TrimSuffix(os.Getenv("PUBLIC_BASE_URL"), "/")
+}
+
+// memberInClause 生成 IN 子句的占位符和参数
+func memberInClause(ids []int) (string, []interface{}) {
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return strings.TrimSuffix(strings.Repeat("?,", len(ids)), ","), args
+}
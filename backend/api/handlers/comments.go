@@ -0,0 +1,338 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"booonus-backend/internal/database"
+	"booonus-backend/models"
+	"booonus-backend/pkg/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// interactionTarget 评论和表情回应挂载的对象
+type interactionTarget struct {
+	Type string `json:"target_type" form:"target_type" binding:"required,oneof=event points_history transaction"`
+	ID   int    `json:"target_id" form:"target_id" binding:"required"`
+}
+
+// GetComments 获取某个对象的评论列表
+func GetComments(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var target interactionTarget
+	if err := c.ShouldBindQuery(&target); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !checkInteractionTarget(c, userID, target) {
+		return
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT cm.id, cm.target_type, cm.target_id, cm.user_id, cm.content, cm.created_at,
+		       cm.updated_at, cm.deleted_at, u.username
+		FROM comments cm
+		JOIN users u ON cm.user_id = u.id
+		WHERE cm.target_type = ? AND cm.target_id = ?
+		ORDER BY cm.created_at ASC, cm.id ASC`,
+		target.Type, target.ID,
+	)
+	if err != nil {
+		logger.Error("Failed to get comments: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get comments"})
+		return
+	}
+	defer rows.Close()
+
+	type CommentWithUser struct {
+		models.Comment
+		Username string `json:"username"`
+	}
+
+	comments := []CommentWithUser{}
+	for rows.Next() {
+		var cm CommentWithUser
+		err := rows.Scan(
+			&cm.ID, &cm.TargetType, &cm.TargetID, &cm.UserID, &cm.Content, &cm.CreatedAt,
+			&cm.UpdatedAt, &cm.DeletedAt, &cm.Username,
+		)
+		if err != nil {
+			logger.Error("Failed to scan comment: " + err.Error())
+			continue
+		}
+
+		// 已删除的评论保留占位，不返回内容
+		if cm.DeletedAt != nil {
+			cm.Content = ""
+		}
+		comments = append(comments, cm)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"comments": comments,
+	})
+}
+
+// CreateComment 发表评论
+func CreateComment(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req struct {
+		interactionTarget
+		Content string `json:"content" binding:"required,max=1000"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	content := strings.TrimSpace(req.Content)
+	if content == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Comment cannot be empty"})
+		return
+	}
+
+	if !checkInteractionTarget(c, userID, req.interactionTarget) {
+		return
+	}
+
+	result, err := database.DB.Exec(
+		"INSERT INTO comments (target_type, target_id, user_id, content) VALUES (?, ?, ?, ?)",
+		req.Type, req.ID, userID, content,
+	)
+	if err != nil {
+		logger.Error("Failed to create comment: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create comment"})
+		return
+	}
+
+	commentID, _ := result.LastInsertId()
+
+	logger.Info("Comment created: " + strconv.FormatInt(commentID, 10) + " on " + req.Type + " " + strconv.Itoa(req.ID) + " by user " + strconv.Itoa(userID))
+	c.JSON(http.StatusCreated, gin.H{
+		"message":    "Comment created successfully",
+		"comment_id": commentID,
+	})
+}
+
+// UpdateComment 修改评论，只有作者可以修改
+func UpdateComment(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	commentID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid comment ID"})
+		return
+	}
+
+	var req struct {
+		Content string `json:"content" binding:"required,max=1000"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	content := strings.TrimSpace(req.Content)
+	if content == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Comment cannot be empty"})
+		return
+	}
+
+	if !checkCommentAuthor(c, commentID, userID) {
+		return
+	}
+
+	_, err = database.DB.Exec(
+		"UPDATE comments SET content = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?",
+		content, commentID,
+	)
+	if err != nil {
+		logger.Error("Failed to update comment: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update comment"})
+		return
+	}
+
+	logger.Info("Comment updated: " + strconv.Itoa(commentID) + " by user " + strconv.Itoa(userID))
+	c.JSON(http.StatusOK, gin.H{"message": "Comment updated successfully"})
+}
+
+// DeleteComment 删除评论（软删除），只有作者可以删除
+func DeleteComment(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	commentID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid comment ID"})
+		return
+	}
+
+	if !checkCommentAuthor(c, commentID, userID) {
+		return
+	}
+
+	_, err = database.DB.Exec("UPDATE comments SET deleted_at = CURRENT_TIMESTAMP WHERE id = ?", commentID)
+	if err != nil {
+		logger.Error("Failed to delete comment: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete comment"})
+		return
+	}
+
+	logger.Info("Comment deleted: " + strconv.Itoa(commentID) + " by user " + strconv.Itoa(userID))
+	c.JSON(http.StatusOK, gin.H{"message": "Comment deleted successfully"})
+}
+
+// checkCommentAuthor 检查评论存在、未删除且属于当前用户，失败时已写入响应
+func checkCommentAuthor(c *gin.Context, commentID, userID int) bool {
+	var authorID int
+	var deletedAt *time.Time
+	err := database.DB.QueryRow("SELECT user_id, deleted_at FROM comments WHERE id = ?", commentID).Scan(&authorID, &deletedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Comment not found"})
+			return false
+		}
+		logger.Error("Failed to get comment: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return false
+	}
+
+	if deletedAt != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Comment not found"})
+		return false
+	}
+
+	if authorID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return false
+	}
+
+	return true
+}
+
+// checkInteractionTarget 检查对象存在且属于当前用户的情侣关系，失败时已写入响应
+func checkInteractionTarget(c *gin.Context, userID int, target interactionTarget) bool {
+	// 对象涉及的用户，当前用户必须是其中之一或其伴侣
+	var ownerIDs []int
+	var err error
+	switch target.Type {
+	case "event":
+		var creatorID, targetID int
+		err = database.DB.QueryRow(
+			"SELECT creator_id, target_id FROM events WHERE id = ? AND deleted_at IS NULL",
+			target.ID,
+		).Scan(&creatorID, &targetID)
+		ownerIDs = []int{creatorID, targetID}
+	case "points_history":
+		var ownerID int
+		err = database.DB.QueryRow("SELECT user_id FROM points_history WHERE id = ?", target.ID).Scan(&ownerID)
+		ownerIDs = []int{ownerID}
+	case "transaction":
+		var buyerID, sellerID int
+		err = database.DB.QueryRow("SELECT buyer_id, seller_id FROM transactions WHERE id = ?", target.ID).Scan(&buyerID, &sellerID)
+		ownerIDs = []int{buyerID, sellerID}
+	}
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Target not found"})
+			return false
+		}
+		logger.Error("Failed to get interaction target: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return false
+	}
+
+	for _, ownerID := range ownerIDs {
+		if !canUserAccessTarget(userID, ownerID) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+			return false
+		}
+	}
+
+	return true
+}
+
+// interactionSummary 一组对象的表情回应汇总和评论数（内部函数）
+func interactionSummary(targetType string, targetIDs []int) (map[int][]models.ReactionCount, map[int]int, error) {
+	reactions := map[int][]models.ReactionCount{}
+	commentCounts := map[int]int{}
+	if len(targetIDs) == 0 {
+		return reactions, commentCounts, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(targetIDs)), ",")
+	args := []interface{}{targetType}
+	for _, id := range targetIDs {
+		args = append(args, id)
+	}
+
+	// 按最早回应时间排序，表情的显示顺序保持稳定
+	rows, err := database.DB.Query(`
+		SELECT target_id, emoji, user_id
+		FROM reactions
+		WHERE target_type = ? AND target_id IN (`+placeholders+`)
+		ORDER BY target_id, id`,
+		args...,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var targetID, userID int
+		var emoji string
+		if err := rows.Scan(&targetID, &emoji, &userID); err != nil {
+			return nil, nil, err
+		}
+
+		counts := reactions[targetID]
+		found := false
+		for i := range counts {
+			if counts[i].Emoji == emoji {
+				counts[i].Count++
+				counts[i].UserIDs = append(counts[i].UserIDs, userID)
+				found = true
+				break
+			}
+		}
+		if !found {
+			counts = append(counts, models.ReactionCount{Emoji: emoji, Count: 1, UserIDs: []int{userID}})
+		}
+		reactions[targetID] = counts
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	countRows, err := database.DB.Query(`
+		SELECT target_id, COUNT(*)
+		FROM comments
+		WHERE target_type = ? AND target_id IN (`+placeholders+`) AND deleted_at IS NULL
+		GROUP BY target_id`,
+		args...,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer countRows.Close()
+
+	for countRows.Next() {
+		var targetID, count int
+		if err := countRows.Scan(&targetID, &count); err != nil {
+			return nil, nil, err
+		}
+		commentCounts[targetID] = count
+	}
+
+	return reactions, commentCounts, countRows.Err()
+}
@@ -4,8 +4,11 @@ import (
 	"database/sql"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"booonus-backend/internal/database"
+	"booonus-backend/internal/notifications"
+	"booonus-backend/internal/permissions"
 	"booonus-backend/models"
 	"booonus-backend/pkg/logger"
 
@@ -42,7 +45,7 @@ func InviteCouple(c *gin.Context) {
 	// 查找目标用户
 	var targetUser models.User
 	err = database.DB.QueryRow(
-		"SELECT id, username, avatar, couple_id FROM users WHERE username = ?",
+		"SELECT id, username, avatar, couple_id FROM users WHERE username = ? AND deleted_at IS NULL",
 		req.Username,
 	).Scan(&targetUser.ID, &targetUser.Username, &targetUser.Avatar, &targetUser.CoupleID)
 
@@ -63,8 +66,44 @@ func InviteCouple(c *gin.Context) {
 		return
 	}
 
-	// 创建情侣关系
-	result, err := database.DB.Exec(
+	// 恢复期内的旧情侣重新邀请时恢复原来的情侣关系，保留规则和事件
+	archivedID, err := findRestorableCouple(userID, targetUser.ID)
+	if err != nil {
+		logger.Error("Failed to check archived couples: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	if archivedID != 0 {
+		if err := restoreCouple(archivedID, userID); err != nil {
+			logger.Error("Failed to restore couple relationship: " + err.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create couple relationship"})
+			return
+		}
+
+		logger.Info("Couple relationship restored: " + strconv.Itoa(archivedID) + " by user " + strconv.Itoa(userID))
+		c.JSON(http.StatusOK, gin.H{
+			"message":   "Couple relationship restored successfully",
+			"couple_id": archivedID,
+			"restored":  true,
+			"partner": gin.H{
+				"id":       targetUser.ID,
+				"username": targetUser.Username,
+				"avatar":   targetUser.Avatar,
+			},
+		})
+		return
+	}
+
+	// 创建情侣关系，即两人的家庭，邀请人为所有者，被邀请人为管理员
+	tx, err := database.DB.Begin()
+	if err != nil {
+		logger.Error("Failed to begin transaction: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
 		"INSERT INTO couples (user1_id, user2_id) VALUES (?, ?)",
 		userID, targetUser.ID,
 	)
@@ -76,14 +115,32 @@ func InviteCouple(c *gin.Context) {
 
 	coupleID, _ := result.LastInsertId()
 
-	// 更新两个用户的couple_id
-	_, err = database.DB.Exec("UPDATE users SET couple_id = ? WHERE id IN (?, ?)", coupleID, userID, targetUser.ID)
+	// 记录两个成员并更新各自的couple_id
+	if err = addHouseholdMember(tx, int(coupleID), userID, permissions.RoleOwner); err == nil {
+		err = addHouseholdMember(tx, int(coupleID), targetUser.ID, permissions.RoleAdmin)
+	}
 	if err != nil {
-		logger.Error("Failed to update users couple_id: " + err.Error())
+		logger.Error("Failed to add household members: " + err.Error())
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update couple relationship"})
 		return
 	}
 
+	householdID := int(coupleID)
+	sendNotification(tx, notifications.Notification{
+		UserID:        targetUser.ID,
+		Type:          notifications.TypeInvite,
+		ActorID:       &userID,
+		MessageKey:    notifications.MessageCoupleInvited,
+		ReferenceType: "household",
+		ReferenceID:   &householdID,
+	})
+
+	if err = tx.Commit(); err != nil {
+		logger.Error("Failed to commit transaction: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create couple relationship"})
+		return
+	}
+
 	logger.Info("Couple relationship created: " + strconv.Itoa(userID) + " and " + strconv.Itoa(targetUser.ID))
 	c.JSON(http.StatusCreated, gin.H{
 		"message":   "Couple relationship created successfully",
@@ -104,6 +161,9 @@ func AcceptCouple(c *gin.Context) {
 }
 
 // RemoveCouple 解除情侣关系
+// 情侣关系只归档（status = ended），规则、事件等记录保留，可在归档中查看；
+// coupleRestoreWindow 内任意一方可以恢复，到期后未兑现的兑换券退款，积分余额归各自所有。
+// 多人家庭中等同于自己退出家庭
 func RemoveCouple(c *gin.Context) {
 	userID := c.GetInt("user_id")
 
@@ -121,60 +181,49 @@ func RemoveCouple(c *gin.Context) {
 		return
 	}
 
-	// 开始事务
-	tx, err := database.DB.Begin()
-	if err != nil {
-		logger.Error("Failed to begin transaction: " + err.Error())
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
-		return
-	}
-	defer tx.Rollback()
-
-	// 清除用户的couple_id
-	_, err = tx.Exec("UPDATE users SET couple_id = NULL WHERE couple_id = ?", coupleID.Int64)
-	if err != nil {
-		logger.Error("Failed to update users: " + err.Error())
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove couple relationship"})
-		return
-	}
-
-	// 删除情侣关系记录
-	_, err = tx.Exec("DELETE FROM couples WHERE id = ?", coupleID.Int64)
+	endedAt, ended, err := removeFromHousehold(int(coupleID.Int64), userID, userID)
 	if err != nil {
-		logger.Error("Failed to delete couple record: " + err.Error())
+		logger.Error("Failed to remove couple relationship: " + err.Error())
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove couple relationship"})
 		return
 	}
 
-	// 提交事务
-	if err = tx.Commit(); err != nil {
-		logger.Error("Failed to commit transaction: " + err.Error())
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove couple relationship"})
+	if !ended {
+		logger.Info("User " + strconv.Itoa(userID) + " left household " + strconv.FormatInt(coupleID.Int64, 10))
+		c.JSON(http.StatusOK, gin.H{
+			"message":   "Left household successfully",
+			"couple_id": coupleID.Int64,
+		})
 		return
 	}
 
 	logger.Info("Couple relationship removed for user: " + strconv.Itoa(userID))
-	c.JSON(http.StatusOK, gin.H{"message": "Couple relationship removed successfully"})
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "Couple relationship removed successfully",
+		"couple_id":     coupleID.Int64,
+		"restore_until": endedAt.Add(coupleRestoreWindow),
+	})
 }
 
 // GetCouple 获取情侣信息
+// 伴侣为最早加入的另一位成员；多人家庭的全部成员在 members 中返回
 func GetCouple(c *gin.Context) {
 	userID := c.GetInt("user_id")
 
 	// 获取情侣信息
 	var couple models.Couple
+	var name sql.NullString
 	var partnerUser models.User
 
 	// 首先获取情侣关系信息
 	query := `
-		SELECT c.id, c.user1_id, c.user2_id, c.created_at
+		SELECT c.id, c.name, c.created_at
 		FROM couples c
-		WHERE c.user1_id = ? OR c.user2_id = ?
+		JOIN users u ON u.couple_id = c.id
+		WHERE u.id = ? AND c.status = 'active'
 	`
 
-	err := database.DB.QueryRow(query, userID, userID).Scan(
-		&couple.ID, &couple.User1ID, &couple.User2ID, &couple.CreatedAt,
-	)
+	err := database.DB.QueryRow(query, userID).Scan(&couple.ID, &name, &couple.CreatedAt)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -186,12 +235,24 @@ func GetCouple(c *gin.Context) {
 		return
 	}
 
+	members, err := loadHouseholdMembers(couple.ID)
+	if err != nil {
+		logger.Error("Failed to get household members: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get couple info"})
+		return
+	}
+
 	// 确定伴侣的ID
-	var partnerID int
-	if couple.User1ID == userID {
-		partnerID = couple.User2ID
-	} else {
-		partnerID = couple.User1ID
+	partnerID := 0
+	for _, member := range members {
+		if member.ID != userID {
+			partnerID = member.ID
+			break
+		}
+	}
+	if partnerID == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No couple relationship found"})
+		return
 	}
 
 	// 获取伴侣的用户信息
@@ -219,6 +280,7 @@ func GetCouple(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"couple": gin.H{
 			"id":         couple.ID,
+			"name":       name.String,
 			"created_at": couple.CreatedAt,
 			"partner": gin.H{
 				"id":         partnerUser.ID,
@@ -228,6 +290,104 @@ func GetCouple(c *gin.Context) {
 				"created_at": partnerUser.CreatedAt,
 				"updated_at": partnerUser.UpdatedAt,
 			},
+			"members": members,
 		},
 	})
 }
+
+// GetCoupleSettings 获取情侣设置
+func GetCoupleSettings(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var couple models.Couple
+	err := database.DB.QueryRow(
+		"SELECT c.id, c.event_edit_policy, c.revert_window_hours, c.revert_reason_required, c.revert_limit FROM couples c JOIN users u ON u.couple_id = c.id WHERE u.id = ? AND c.status = 'active'",
+		userID,
+	).Scan(&couple.ID, &couple.EventEditPolicy, &couple.RevertWindowHours, &couple.RevertReasonRequired, &couple.RevertLimit)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "No couple relationship found"})
+			return
+		}
+		logger.Error("Failed to get couple settings: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get couple settings"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"settings": gin.H{
+			"event_edit_policy":      couple.EventEditPolicy,
+			"revert_window_hours":    couple.RevertWindowHours,
+			"revert_reason_required": couple.RevertReasonRequired,
+			"revert_limit":           couple.RevertLimit,
+		},
+	})
+}
+
+// UpdateCoupleSettings 更新情侣设置，需要 settings.manage 权限
+func UpdateCoupleSettings(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req struct {
+		EventEditPolicy      string `json:"event_edit_policy" binding:"omitempty,oneof=creator both"`
+		RevertWindowHours    *int   `json:"revert_window_hours" binding:"omitempty,min=0,max=8760"`
+		RevertReasonRequired *bool  `json:"revert_reason_required"`
+		RevertLimit          *int   `json:"revert_limit" binding:"omitempty,min=0,max=100"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var coupleID int
+	err := database.DB.QueryRow(
+		"SELECT c.id FROM couples c JOIN users u ON u.couple_id = c.id WHERE u.id = ? AND c.status = 'active'",
+		userID,
+	).Scan(&coupleID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "No couple relationship found"})
+			return
+		}
+		logger.Error("Failed to get couple info: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	// 构建更新语句
+	var setParts []string
+	var args []interface{}
+	if req.EventEditPolicy != "" {
+		setParts = append(setParts, "event_edit_policy = ?")
+		args = append(args, req.EventEditPolicy)
+	}
+	if req.RevertWindowHours != nil {
+		setParts = append(setParts, "revert_window_hours = ?")
+		args = append(args, *req.RevertWindowHours)
+	}
+	if req.RevertReasonRequired != nil {
+		setParts = append(setParts, "revert_reason_required = ?")
+		args = append(args, *req.RevertReasonRequired)
+	}
+	if req.RevertLimit != nil {
+		setParts = append(setParts, "revert_limit = ?")
+		args = append(args, *req.RevertLimit)
+	}
+
+	if len(setParts) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No fields to update"})
+		return
+	}
+
+	args = append(args, coupleID)
+	_, err = database.DB.Exec("UPDATE couples SET "+strings.Join(setParts, ", ")+" WHERE id = ?", args...)
+	if err != nil {
+		logger.Error("Failed to update couple settings: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update couple settings"})
+		return
+	}
+
+	logger.Info("Couple settings updated: " + strconv.Itoa(coupleID) + " by user " + strconv.Itoa(userID))
+	c.JSON(http.StatusOK, gin.H{"message": "Couple settings updated successfully"})
+}
@@ -0,0 +1,506 @@
+package handlers
+
+import (
+	"database/sql"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"booonus-backend/internal/database"
+	"booonus-backend/internal/notifications"
+	"booonus-backend/pkg/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// coupleRestoreWindow 解除情侣关系后可以恢复的期限，到期后结算未兑现的兑换券
+const coupleRestoreWindow = 30 * 24 * time.Hour
+
+// archivedVoucherReason 到期结算时退款的撤销原因
+const archivedVoucherReason = "Couple ended, unredeemed voucher refunded"
+
+// GetArchivedCouples 获取已解除的情侣关系（家庭）列表
+func GetArchivedCouples(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	rows, err := database.DB.Query(`
+		SELECT c.id, c.name, c.created_at, c.ended_at, c.ended_by, c.settled_at,
+		       (SELECT COUNT(*) FROM rules r WHERE r.couple_id = c.id),
+		       (SELECT COUNT(*) FROM events e WHERE e.couple_id = c.id AND e.deleted_at IS NULL)
+		FROM couples c
+		JOIN household_members m ON m.couple_id = c.id
+		WHERE m.user_id = ? AND m.left_at IS NULL AND c.status = 'ended'
+		ORDER BY c.ended_at DESC, c.id DESC`,
+		userID,
+	)
+	if err != nil {
+		logger.Error("Failed to get archived couples: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get archived couples"})
+		return
+	}
+
+	couples := []gin.H{}
+	var coupleIDs []int
+	for rows.Next() {
+		var coupleID, endedBy, ruleCount, eventCount int
+		var name sql.NullString
+		var createdAt, endedAt time.Time
+		var settledAt *time.Time
+		err := rows.Scan(&coupleID, &name, &createdAt, &endedAt, &endedBy, &settledAt, &ruleCount, &eventCount)
+		if err != nil {
+			logger.Error("Failed to scan archived couple: " + err.Error())
+			continue
+		}
+		coupleIDs = append(coupleIDs, coupleID)
+		couples = append(couples, gin.H{
+			"id":            coupleID,
+			"name":          name.String,
+			"created_at":    createdAt,
+			"ended_at":      endedAt,
+			"ended_by":      endedBy,
+			"restore_until": restoreUntil(endedAt, settledAt),
+			"settled_at":    settledAt,
+			"rule_count":    ruleCount,
+			"event_count":   eventCount,
+		})
+	}
+	rows.Close()
+
+	// 成员在遍历结束后再查询，伴侣为最早加入的另一位成员
+	for i, coupleID := range coupleIDs {
+		members, err := loadHouseholdMembers(coupleID)
+		if err != nil {
+			logger.Error("Failed to get archived couple members: " + err.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get archived couples"})
+			return
+		}
+		couples[i]["members"] = members
+		for _, member := range members {
+			if member.ID != userID {
+				couples[i]["partner"] = gin.H{
+					"id":       member.ID,
+					"username": member.Username,
+					"avatar":   member.Avatar,
+				}
+				break
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"couples": couples})
+}
+
+// GetArchivedCouple 查看已解除的情侣关系中的规则和事件（只读）
+func GetArchivedCouple(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	coupleID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid couple ID"})
+		return
+	}
+
+	var endedBy int
+	var name sql.NullString
+	var createdAt, endedAt time.Time
+	var settledAt *time.Time
+	err = database.DB.QueryRow(
+		"SELECT name, created_at, ended_at, ended_by, settled_at FROM couples WHERE id = ? AND status = 'ended'",
+		coupleID,
+	).Scan(&name, &createdAt, &endedAt, &endedBy, &settledAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Archived couple not found"})
+			return
+		}
+		logger.Error("Failed to get archived couple: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	members, err := loadHouseholdMembers(coupleID)
+	if err != nil {
+		logger.Error("Failed to get archived couple members: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	isMember := false
+	for _, member := range members {
+		if member.ID == userID {
+			isMember = true
+		}
+	}
+	if !isMember {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Archived couple not found"})
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+
+	rules := []gin.H{}
+	ruleRows, err := database.DB.Query(
+		"SELECT id, name, description, points, target_type, target_user_id, is_active, created_at FROM rules WHERE couple_id = ? ORDER BY id",
+		coupleID,
+	)
+	if err != nil {
+		logger.Error("Failed to get archived rules: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get archived couple"})
+		return
+	}
+	defer ruleRows.Close()
+	for ruleRows.Next() {
+		var id, points int
+		var name, targetType string
+		var targetUserID *int
+		var description sql.NullString
+		var isActive bool
+		var ruleCreatedAt time.Time
+		if err := ruleRows.Scan(&id, &name, &description, &points, &targetType, &targetUserID, &isActive, &ruleCreatedAt); err != nil {
+			logger.Error("Failed to scan archived rule: " + err.Error())
+			continue
+		}
+		rules = append(rules, gin.H{
+			"id":             id,
+			"name":           name,
+			"description":    description.String,
+			"points":         points,
+			"target_type":    ruleTargetForUser(targetType, targetUserID, userID, len(members)),
+			"target_user_id": targetUserID,
+			"is_active":      isActive,
+			"created_at":     ruleCreatedAt,
+		})
+	}
+
+	events := []gin.H{}
+	eventRows, err := database.DB.Query(`
+		SELECT e.id, e.creator_id, e.target_id, e.name, e.description, e.points, e.created_at,
+		       u1.username, u2.username
+		FROM events e
+		JOIN users u1 ON e.creator_id = u1.id
+		JOIN users u2 ON e.target_id = u2.id
+		WHERE e.couple_id = ? AND e.deleted_at IS NULL
+		ORDER BY e.created_at DESC
+		LIMIT ? OFFSET ?`,
+		coupleID, limit, offset,
+	)
+	if err != nil {
+		logger.Error("Failed to get archived events: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get archived couple"})
+		return
+	}
+	defer eventRows.Close()
+	for eventRows.Next() {
+		var id, creatorID, targetID, points int
+		var name, creatorName, targetName string
+		var description sql.NullString
+		var eventCreatedAt time.Time
+		err := eventRows.Scan(&id, &creatorID, &targetID, &name, &description, &points, &eventCreatedAt, &creatorName, &targetName)
+		if err != nil {
+			logger.Error("Failed to scan archived event: " + err.Error())
+			continue
+		}
+		events = append(events, gin.H{
+			"id":           id,
+			"creator_id":   creatorID,
+			"target_id":    targetID,
+			"name":         name,
+			"description":  description.String,
+			"points":       points,
+			"created_at":   eventCreatedAt,
+			"creator_name": creatorName,
+			"target_name":  targetName,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"couple": gin.H{
+			"id":            coupleID,
+			"name":          name.String,
+			"members":       members,
+			"created_at":    createdAt,
+			"ended_at":      endedAt,
+			"ended_by":      endedBy,
+			"restore_until": restoreUntil(endedAt, settledAt),
+			"settled_at":    settledAt,
+		},
+		"rules":  rules,
+		"events": events,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+// RestoreCouple 在恢复期内恢复已解除的情侣关系（家庭），规则和事件原样恢复
+// 不指定 couple_id 时恢复最近解除的一个；所有成员都必须还没有新的家庭
+func RestoreCouple(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req struct {
+		CoupleID int `json:"couple_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var currentCoupleID sql.NullInt64
+	if err := database.DB.QueryRow("SELECT couple_id FROM users WHERE id = ?", userID).Scan(&currentCoupleID); err != nil {
+		logger.Error("Failed to check current user couple status: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	if currentCoupleID.Valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You already have a couple"})
+		return
+	}
+
+	query := `
+		SELECT c.id FROM couples c
+		JOIN household_members m ON m.couple_id = c.id
+		WHERE m.user_id = ? AND m.left_at IS NULL
+		  AND c.status = 'ended' AND c.settled_at IS NULL AND c.ended_at > ?`
+	args := []interface{}{userID, dbTime(time.Now().Add(-coupleRestoreWindow))}
+	if req.CoupleID != 0 {
+		query += " AND c.id = ?"
+		args = append(args, req.CoupleID)
+	}
+	query += " ORDER BY c.ended_at DESC, c.id DESC LIMIT 1"
+
+	var coupleID int
+	err := database.DB.QueryRow(query, args...).Scan(&coupleID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "No restorable couple relationship found"})
+			return
+		}
+		logger.Error("Failed to get archived couple: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	// 其他成员都不能已删除账号或加入了新的家庭
+	rows, err := database.DB.Query(`
+		SELECT u.id, u.username, u.avatar, u.couple_id, u.deleted_at
+		FROM household_members m
+		JOIN users u ON m.user_id = u.id
+		WHERE m.couple_id = ? AND m.left_at IS NULL AND u.id != ?
+		ORDER BY m.joined_at, m.id`,
+		coupleID, userID,
+	)
+	if err != nil {
+		logger.Error("Failed to get archived couple members: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	defer rows.Close()
+
+	var partner gin.H
+	for rows.Next() {
+		var memberID int
+		var username string
+		var avatar *string
+		var memberCoupleID sql.NullInt64
+		var deletedAt *time.Time
+		if err := rows.Scan(&memberID, &username, &avatar, &memberCoupleID, &deletedAt); err != nil {
+			logger.Error("Failed to scan archived couple member: " + err.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			return
+		}
+		if deletedAt != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Partner account has been deleted"})
+			return
+		}
+		if memberCoupleID.Valid {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Partner already has a couple"})
+			return
+		}
+		if partner == nil {
+			partner = gin.H{"id": memberID, "username": username, "avatar": avatar}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		logger.Error("Failed to get archived couple members: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	rows.Close()
+
+	if err := restoreCouple(coupleID, userID); err != nil {
+		logger.Error("Failed to restore couple relationship: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore couple relationship"})
+		return
+	}
+
+	logger.Info("Couple relationship restored: " + strconv.Itoa(coupleID) + " by user " + strconv.Itoa(userID))
+	c.JSON(http.StatusOK, gin.H{
+		"message":   "Couple relationship restored successfully",
+		"couple_id": coupleID,
+		"partner":   partner,
+	})
+}
+
+// findRestorableCouple 查找恰好由这两个用户组成、仍在恢复期内的已解除情侣关系，没有时返回 0
+func findRestorableCouple(userID, partnerID int) (int, error) {
+	var coupleID int
+	err := database.DB.QueryRow(`
+		SELECT c.id FROM couples c
+		WHERE c.status = 'ended' AND c.settled_at IS NULL AND c.ended_at > ?
+		  AND (SELECT COUNT(*) FROM household_members m WHERE m.couple_id = c.id AND m.left_at IS NULL) = 2
+		  AND (SELECT COUNT(*) FROM household_members m WHERE m.couple_id = c.id AND m.left_at IS NULL AND m.user_id IN (?, ?)) = 2
+		ORDER BY c.ended_at DESC, c.id DESC
+		LIMIT 1`,
+		dbTime(time.Now().Add(-coupleRestoreWindow)), userID, partnerID,
+	).Scan(&coupleID)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return coupleID, err
+}
+
+// restoreCouple 恢复已解除的情侣关系并通知其他成员（调用方已检查成员都没有家庭）
+func restoreCouple(coupleID, actorID int) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE couples SET status = 'active', ended_at = NULL, ended_by = NULL WHERE id = ?", coupleID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		"UPDATE users SET couple_id = ? WHERE id IN (SELECT user_id FROM household_members WHERE couple_id = ? AND left_at IS NULL)",
+		coupleID, coupleID,
+	)
+	if err != nil {
+		return err
+	}
+
+	rows, err := tx.Query("SELECT user_id FROM household_members WHERE couple_id = ? AND left_at IS NULL", coupleID)
+	if err != nil {
+		return err
+	}
+	var memberIDs []int
+	for rows.Next() {
+		var memberID int
+		if err := rows.Scan(&memberID); err != nil {
+			rows.Close()
+			return err
+		}
+		memberIDs = append(memberIDs, memberID)
+	}
+	rows.Close()
+
+	for _, memberID := range memberIDs {
+		sendNotification(tx, notifications.Notification{
+			UserID:        memberID,
+			Type:          notifications.TypeInvite,
+			ActorID:       &actorID,
+			MessageKey:    notifications.MessageCoupleRestored,
+			ReferenceType: "household",
+			ReferenceID:   &coupleID,
+		})
+	}
+
+	return tx.Commit()
+}
+
+// restoreUntil 恢复期的截止时间，已结算的返回 nil
+func restoreUntil(endedAt time.Time, settledAt *time.Time) *time.Time {
+	if settledAt != nil {
+		return nil
+	}
+	until := endedAt.Add(coupleRestoreWindow)
+	return &until
+}
+
+// SettleEndedCouples 结算恢复期已过的情侣关系（家庭）：
+// 成员之间未兑现的兑换券撤销并退款，积分余额归各自所有，之后不能再恢复
+func SettleEndedCouples() {
+	rows, err := database.DB.Query(
+		"SELECT id, ended_at, ended_by FROM couples WHERE status = 'ended' AND settled_at IS NULL AND ended_at <= ?",
+		dbTime(time.Now().Add(-coupleRestoreWindow)),
+	)
+	if err != nil {
+		logger.Error("Failed to get ended couples: " + err.Error())
+		return
+	}
+
+	type endedCouple struct {
+		id, endedBy int
+		endedAt     time.Time
+	}
+	var couples []endedCouple
+	for rows.Next() {
+		var ec endedCouple
+		if err := rows.Scan(&ec.id, &ec.endedAt, &ec.endedBy); err != nil {
+			logger.Error("Failed to scan ended couple: " + err.Error())
+			continue
+		}
+		couples = append(couples, ec)
+	}
+	rows.Close()
+
+	for _, ec := range couples {
+		refunded, err := settleEndedCouple(ec.id, ec.endedBy, ec.endedAt)
+		if err != nil {
+			logger.Error("Failed to settle couple " + strconv.Itoa(ec.id) + ": " + err.Error())
+			continue
+		}
+		logger.Info("Couple settled: " + strconv.Itoa(ec.id) + ", vouchers refunded: " + strconv.Itoa(refunded))
+	}
+}
+
+// settleEndedCouple 结算一个已解除的情侣关系，返回退款的兑换券数量
+// 退款按撤销处理，撤销人记为解除关系的一方
+func settleEndedCouple(coupleID, endedBy int, endedAt time.Time) (int, error) {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	members := "SELECT user_id FROM household_members WHERE couple_id = ? AND left_at IS NULL"
+	rows, err := tx.Query(`
+		SELECT id FROM transactions
+		WHERE status = 'completed' AND fulfilled_at IS NULL AND created_at <= ?
+		  AND buyer_id IN (`+members+`) AND seller_id IN (`+members+`)`,
+		dbTime(endedAt), coupleID, coupleID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	var transactionIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		transactionIDs = append(transactionIDs, id)
+	}
+	rows.Close()
+
+	for _, transactionID := range transactionIDs {
+		if err := revertTransactionState(tx, transactionID); err != nil {
+			return 0, err
+		}
+		historyIDs, err := revertRelatedTransactionRecord(tx, transactionID, 0)
+		if err != nil {
+			return 0, err
+		}
+		if err := recordRevertActions(tx, historyIDs, "revert", endedBy, archivedVoucherReason); err != nil {
+			return 0, err
+		}
+	}
+
+	if _, err := tx.Exec("UPDATE couples SET settled_at = CURRENT_TIMESTAMP WHERE id = ?", coupleID); err != nil {
+		return 0, err
+	}
+
+	return len(transactionIDs), tx.Commit()
+}
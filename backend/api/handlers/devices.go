@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"booonus-backend/internal/database"
+	"booonus-backend/internal/push"
+	"booonus-backend/pkg/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetDevices 获取当前用户登记的推送设备
+func GetDevices(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	rows, err := database.DB.Query(
+		"SELECT id, platform, token, name, created_at, last_seen_at FROM device_tokens WHERE user_id = ? ORDER BY id",
+		userID,
+	)
+	if err != nil {
+		logger.Error("Failed to get devices: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get devices"})
+		return
+	}
+	defer rows.Close()
+
+	devices := []gin.H{}
+	for rows.Next() {
+		var id int
+		var platform, token string
+		var name sql.NullString
+		var createdAt, lastSeenAt time.Time
+		if err := rows.Scan(&id, &platform, &token, &name, &createdAt, &lastSeenAt); err != nil {
+			logger.Error("Failed to scan device: " + err.Error())
+			continue
+		}
+		devices = append(devices, gin.H{
+			"id":           id,
+			"platform":     platform,
+			"token":        token,
+			"name":         name.String,
+			"created_at":   createdAt,
+			"last_seen_at": lastSeenAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"devices": devices})
+}
+
+// RegisterDevice 登记推送设备，同一令牌再次登记时更新所属用户和最后使用时间
+func RegisterDevice(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req struct {
+		Platform string `json:"platform" binding:"required"`
+		Token    string `json:"token" binding:"required"`
+		Name     string `json:"name"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !push.ValidPlatform(req.Platform) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid platform"})
+		return
+	}
+	if len(req.Token) > 4096 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Token is too long"})
+		return
+	}
+
+	// 设备换了账号登录时令牌转到新用户
+	_, err := database.DB.Exec(`
+		INSERT INTO device_tokens (user_id, platform, token, name) VALUES (?, ?, ?, ?)
+		ON CONFLICT (token) DO UPDATE SET
+			user_id = excluded.user_id, platform = excluded.platform,
+			name = excluded.name, last_seen_at = CURRENT_TIMESTAMP`,
+		userID, req.Platform, req.Token, req.Name,
+	)
+	if err != nil {
+		logger.Error("Failed to register device: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register device"})
+		return
+	}
+
+	var deviceID int
+	if err := database.DB.QueryRow("SELECT id FROM device_tokens WHERE token = ?", req.Token).Scan(&deviceID); err != nil {
+		logger.Error("Failed to get device: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register device"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":   "Device registered successfully",
+		"device_id": deviceID,
+	})
+}
+
+// DeleteDevice 删除推送设备，退出登录时调用
+func DeleteDevice(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	deviceID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid device ID"})
+		return
+	}
+
+	result, err := database.DB.Exec("DELETE FROM device_tokens WHERE id = ? AND user_id = ?", deviceID, userID)
+	if err != nil {
+		logger.Error("Failed to delete device: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete device"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Device deleted successfully"})
+}
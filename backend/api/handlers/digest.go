@@ -0,0 +1,281 @@
+package handlers
+
+import (
+	"database/sql"
+	"strconv"
+	"time"
+
+	"booonus-backend/internal/database"
+	"booonus-backend/internal/locale"
+	"booonus-backend/internal/mail"
+	"booonus-backend/pkg/logger"
+)
+
+// digestHour 周报在用户所在时区每周第一天几点之后发送
+const digestHour = 8
+
+// digestTopRules 周报中列出的规则数量
+const digestTopRules = 3
+
+// digestRecipient 周报收件人
+type digestRecipient struct {
+	UserID    int
+	Username  string
+	Email     string
+	Loc       *time.Location
+	WeekStart time.Weekday
+	Locale    string // 匹配到的支持语言，见 locale.Match
+}
+
+// digestRule 周报中执行最多的规则
+type digestRule struct {
+	Name       string
+	Executions int
+	Points     int
+}
+
+// digestVoucher 周报中已购买但尚未兑现的商品
+type digestVoucher struct {
+	ItemName   string
+	SellerName string
+	Points     int
+	BoughtAt   string
+}
+
+// digestPartner 周报中家人的积分动态
+type digestPartner struct {
+	Username   string
+	Earned     int
+	Spent      int
+	Activities int
+}
+
+// digestData 周报模板数据
+type digestData struct {
+	Username       string
+	From           string
+	To             string
+	Earned         int
+	Spent          int
+	Net            int
+	Balance        int
+	TopRules       []digestRule
+	Vouchers       []digestVoucher
+	Partners       []digestPartner
+	AppURL         string
+	UnsubscribeURL string
+}
+
+// SendWeeklyDigests 定时任务：给开启周报的用户发送上一周的摘要
+// 按用户偏好设置的时区和每周第一天计算，第一天 digestHour 点之后发送，last_digest_at 保证每周只发一次；
+// 定时任务中没有请求，邮件中的链接需要 PUBLIC_BASE_URL，未配置时不发送
+func SendWeeklyDigests() {
+	if !mail.Enabled() {
+		return
+	}
+	baseURL := configuredBaseURL()
+	if baseURL == "" {
+		logger.Error("PUBLIC_BASE_URL is not set, skipping weekly digests")
+		return
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT id, username, email, timezone, week_start, locale, last_digest_at
+		FROM users
+		WHERE email_digest = TRUE AND email IS NOT NULL AND email_verified_at IS NOT NULL AND deleted_at IS NULL`)
+	if err != nil {
+		logger.Error("Failed to get digest recipients: " + err.Error())
+		return
+	}
+
+	type pending struct {
+		recipient  digestRecipient
+		from, to   time.Time
+		lastDigest *time.Time
+	}
+	now := time.Now()
+	var due []pending
+	for rows.Next() {
+		var r digestRecipient
+		var timezone, tag sql.NullString
+		var lastDigest *time.Time
+		if err := rows.Scan(&r.UserID, &r.Username, &r.Email, &timezone, &r.WeekStart, &tag, &lastDigest); err != nil {
+			logger.Error("Failed to scan digest recipient: " + err.Error())
+			continue
+		}
+		r.Loc = userLocation(timezone)
+		r.Locale = locale.Match(tag.String)
+
+		from, to := digestPeriod(now, r.Loc, r.WeekStart)
+		if now.Before(to.Add(digestHour*time.Hour)) || (lastDigest != nil && !lastDigest.Before(to)) {
+			continue
+		}
+		due = append(due, pending{recipient: r, from: from, to: to, lastDigest: lastDigest})
+	}
+	rows.Close()
+
+	for _, p := range due {
+		if err := sendDigest(p.recipient, p.from, p.to, baseURL); err != nil {
+			logger.Error("Failed to send digest to user " + strconv.Itoa(p.recipient.UserID) + ": " + err.Error())
+			continue
+		}
+		if _, err := database.DB.Exec("UPDATE users SET last_digest_at = ? WHERE id = ?", dbTime(now), p.recipient.UserID); err != nil {
+			logger.Error("Failed to update last digest time: " + err.Error())
+		}
+	}
+	if len(due) > 0 {
+		logger.Info("Weekly digests processed: " + strconv.Itoa(len(due)))
+	}
+}
+
+// loadDigestRecipient 获取已验证邮箱的用户作为收件人，没有已验证邮箱时返回 sql.ErrNoRows
+func loadDigestRecipient(userID int) (digestRecipient, error) {
+	r := digestRecipient{UserID: userID}
+	var timezone, tag sql.NullString
+	err := database.DB.QueryRow(
+		"SELECT username, email, timezone, week_start, locale FROM users WHERE id = ? AND email IS NOT NULL AND email_verified_at IS NOT NULL",
+		userID,
+	).Scan(&r.Username, &r.Email, &timezone, &r.WeekStart, &tag)
+	if err != nil {
+		return r, err
+	}
+	r.Loc = userLocation(timezone)
+	r.Locale = locale.Match(tag.String)
+	return r, nil
+}
+
+// digestPeriod 返回 now 所在周之前的完整一周 [上周第一天零点, 本周第一天零点)，按 loc 时区计算
+func digestPeriod(now time.Time, loc *time.Location, weekStart time.Weekday) (time.Time, time.Time) {
+	to := bucketStart(now.In(loc), "week", weekStart)
+	return to.AddDate(0, 0, -7), to
+}
+
+// sendDigest 生成并发送一份周报
+func sendDigest(r digestRecipient, from, to time.Time, baseURL string) error {
+	data, err := buildDigest(r, from, to)
+	if err != nil {
+		return err
+	}
+	unsubscribe := baseURL + "/api/v1/email/unsubscribe?token=" + mail.SignLink(linkUnsubscribeDigest, r.UserID, "", time.Time{})
+	data.AppURL = baseURL
+	data.UnsubscribeURL = unsubscribe
+
+	text, html, err := mail.Render("digest", r.Locale, data)
+	if err != nil {
+		return err
+	}
+	return mail.Send(mail.Message{
+		To:      r.Email,
+		Subject: mailText(r.Locale, "digest_subject", data.From, data.To),
+		Text:    text,
+		HTML:    html,
+		Headers: map[string]string{
+			"List-Unsubscribe":      "<" + unsubscribe + ">",
+			"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+		},
+	})
+}
+
+// buildDigest 统计周报内容：本人的积分收支、家庭中执行最多的规则、待兑现的商品和家人的动态
+func buildDigest(r digestRecipient, from, to time.Time) (digestData, error) {
+	data := digestData{
+		Username: r.Username,
+		From:     from.Format(dateLayout),
+		To:       to.AddDate(0, 0, -1).Format(dateLayout),
+	}
+
+	memberIDs, err := coupleMemberIDs(r.UserID)
+	if err != nil {
+		return data, err
+	}
+
+	earned, spent, _, err := digestPointsSummary(r.UserID, from, to)
+	if err != nil {
+		return data, err
+	}
+	data.Earned, data.Spent, data.Net = earned, spent, earned-spent
+	if err := database.DB.QueryRow("SELECT points FROM users WHERE id = ?", r.UserID).Scan(&data.Balance); err != nil {
+		return data, err
+	}
+
+	inClause, args := memberInClause(memberIDs)
+	args = append(args, dbTime(from), dbTime(to), digestTopRules)
+	rows, err := database.DB.Query(`
+		SELECT r.name,
+		       COUNT(DISTINCT COALESCE(ph.execution_id, -ph.id)) AS executions,
+		       SUM(ph.points) AS total_points
+		FROM points_history ph
+		JOIN rules r ON ph.reference_id = r.id
+		WHERE ph.type = 'rule' AND ph.is_reverted = FALSE
+		  AND ph.user_id IN (`+inClause+`)
+		  AND ph.created_at >= ? AND ph.created_at < ?
+		GROUP BY ph.reference_id, r.name
+		ORDER BY executions DESC, total_points DESC, r.name
+		LIMIT ?`,
+		args...,
+	)
+	if err != nil {
+		return data, err
+	}
+	for rows.Next() {
+		var rule digestRule
+		if err := rows.Scan(&rule.Name, &rule.Executions, &rule.Points); err != nil {
+			rows.Close()
+			return data, err
+		}
+		data.TopRules = append(data.TopRules, rule)
+	}
+	rows.Close()
+
+	rows, err = database.DB.Query(`
+		SELECT si.name, u.username, t.points, t.created_at
+		FROM transactions t
+		JOIN shop_items si ON t.shop_item_id = si.id
+		JOIN users u ON t.seller_id = u.id
+		WHERE t.buyer_id = ? AND t.status = 'completed' AND t.fulfilled_at IS NULL
+		ORDER BY t.created_at`,
+		r.UserID,
+	)
+	if err != nil {
+		return data, err
+	}
+	for rows.Next() {
+		var voucher digestVoucher
+		var boughtAt time.Time
+		if err := rows.Scan(&voucher.ItemName, &voucher.SellerName, &voucher.Points, &boughtAt); err != nil {
+			rows.Close()
+			return data, err
+		}
+		voucher.BoughtAt = boughtAt.In(r.Loc).Format(dateLayout)
+		data.Vouchers = append(data.Vouchers, voucher)
+	}
+	rows.Close()
+
+	for _, memberID := range memberIDs[1:] {
+		partner := digestPartner{}
+		if err := database.DB.QueryRow("SELECT username FROM users WHERE id = ?", memberID).Scan(&partner.Username); err != nil {
+			return data, err
+		}
+		partner.Earned, partner.Spent, partner.Activities, err = digestPointsSummary(memberID, from, to)
+		if err != nil {
+			return data, err
+		}
+		data.Partners = append(data.Partners, partner)
+	}
+
+	return data, nil
+}
+
+// digestPointsSummary 统计用户在时间段内获得、花费的积分和积分记录数，不含已撤销的记录
+func digestPointsSummary(userID int, from, to time.Time) (int, int, int, error) {
+	var earned, spent, count int
+	err := database.DB.QueryRow(`
+		SELECT COALESCE(SUM(CASE WHEN points > 0 THEN points ELSE 0 END), 0),
+		       COALESCE(SUM(CASE WHEN points < 0 THEN -points ELSE 0 END), 0),
+		       COUNT(*)
+		FROM points_history
+		WHERE user_id = ? AND is_reverted = FALSE AND created_at >= ? AND created_at < ?`,
+		userID, dbTime(from), dbTime(to),
+	).Scan(&earned, &spent, &count)
+	return earned, spent, count, err
+}
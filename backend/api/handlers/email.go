@@ -0,0 +1,375 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"booonus-backend/internal/database"
+	"booonus-backend/internal/locale"
+	"booonus-backend/internal/mail"
+	"booonus-backend/pkg/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// emailVerifyLifetime 邮箱验证链接的有效期
+const emailVerifyLifetime = 24 * time.Hour
+
+// 邮件链接令牌的用途
+const (
+	linkVerifyEmail       = "verify_email"
+	linkUnsubscribeDigest = "unsubscribe_digest"
+)
+
+// mailStrings 邮件标题和结果页面的文案，按语言
+var mailStrings = map[string]map[string]string{
+	locale.Chinese: {
+		"verify_subject":       "验证你的 Booonus 邮箱",
+		"verify_expires_in":    "24 小时",
+		"digest_subject":       "Booonus 积分周报（%s 至 %s）",
+		"verify_failed":        "验证失败",
+		"verify_invalid":       "链接无效或已过期，请重新发送验证邮件。",
+		"verify_used":          "链接已失效，请重新发送验证邮件。",
+		"verify_taken":         "%s 已绑定到其他 Booonus 账号。",
+		"verified":             "邮箱已验证",
+		"verified_message":     "%s 已绑定到你的 Booonus 账号。",
+		"unsubscribe_failed":   "退订失败",
+		"unsubscribe_invalid":  "链接无效。",
+		"unsubscribed":         "已退订",
+		"unsubscribed_message": "你将不再收到 Booonus 每周摘要，可以在设置中重新开启。",
+		"server_error":         "服务器出错，请稍后再试。",
+	},
+	locale.English: {
+		"verify_subject":       "Verify your Booonus email",
+		"verify_expires_in":    "24 hours",
+		"digest_subject":       "Booonus weekly summary (%s to %s)",
+		"verify_failed":        "Verification failed",
+		"verify_invalid":       "The link is invalid or has expired. Please send a new verification email.",
+		"verify_used":          "The link is no longer valid. Please send a new verification email.",
+		"verify_taken":         "%s is already linked to another Booonus account.",
+		"verified":             "Email verified",
+		"verified_message":     "%s is now linked to your Booonus account.",
+		"unsubscribe_failed":   "Unsubscribe failed",
+		"unsubscribe_invalid":  "The link is invalid.",
+		"unsubscribed":         "Unsubscribed",
+		"unsubscribed_message": "You will no longer receive the Booonus weekly summary. You can turn it back on in settings.",
+		"server_error":         "Something went wrong on our side. Please try again later.",
+	},
+}
+
+// mailText 按语言获取文案，args 用于填充其中的 %s
+func mailText(lang, key string, args ...interface{}) string {
+	text, ok := mailStrings[lang][key]
+	if !ok {
+		text = mailStrings[locale.Default][key]
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(text, args...)
+	}
+	return text
+}
+
+// GetEmailSettings 获取邮箱、验证状态和每周摘要设置
+func GetEmailSettings(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var email, pendingEmail, timezone sql.NullString
+	var verifiedAt, lastDigestAt *time.Time
+	var digest bool
+	err := database.DB.QueryRow(
+		"SELECT email, email_verified_at, pending_email, email_digest, timezone, last_digest_at FROM users WHERE id = ?",
+		userID,
+	).Scan(&email, &verifiedAt, &pendingEmail, &digest, &timezone, &lastDigestAt)
+	if err != nil {
+		logger.Error("Failed to get email settings: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get email settings"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"email":          nullableString(email),
+		"verified":       verifiedAt != nil,
+		"verified_at":    verifiedAt,
+		"pending_email":  nullableString(pendingEmail),
+		"digest_enabled": digest,
+		"timezone":       userTimezoneName(timezone),
+		"last_digest_at": lastDigestAt,
+		"mail_enabled":   mail.Enabled(),
+	})
+}
+
+// UpdateEmail 设置新邮箱并发送验证邮件，验证前原邮箱保持不变
+func UpdateEmail(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req struct {
+		Email string `json:"email" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	email := strings.TrimSpace(req.Email)
+	if !mail.ValidAddress(email) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid email address"})
+		return
+	}
+	if !mail.Enabled() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Email is not configured"})
+		return
+	}
+
+	var username string
+	var tag sql.NullString
+	var current sql.NullString
+	var verifiedAt *time.Time
+	err := database.DB.QueryRow("SELECT username, email, email_verified_at, locale FROM users WHERE id = ?", userID).Scan(&username, &current, &verifiedAt, &tag)
+	if err != nil {
+		logger.Error("Failed to get user: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	if current.String == email && verifiedAt != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Email is already verified"})
+		return
+	}
+	inUse, err := emailInUse(email, userID)
+	if err != nil {
+		logger.Error("Failed to check email: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	if inUse {
+		c.JSON(http.StatusConflict, gin.H{"error": "Email is already in use"})
+		return
+	}
+
+	// 重新设置后旧的验证链接失效
+	if _, err := database.DB.Exec("UPDATE users SET pending_email = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", email, userID); err != nil {
+		logger.Error("Failed to update pending email: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update email"})
+		return
+	}
+
+	token := mail.SignLink(linkVerifyEmail, userID, email, time.Now().Add(emailVerifyLifetime))
+	lang := locale.Match(tag.String)
+	text, html, err := mail.Render("verify", lang, gin.H{
+		"Username":  username,
+		"Email":     email,
+		"VerifyURL": publicBaseURL(c) + "/api/v1/email/verify?token=" + token,
+		"ExpiresIn": mailText(lang, "verify_expires_in"),
+	})
+	if err != nil {
+		logger.Error("Failed to render verification email: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send verification email"})
+		return
+	}
+	if err := mail.Send(mail.Message{To: email, Subject: mailText(lang, "verify_subject"), Text: text, HTML: html}); err != nil {
+		logger.Error("Failed to send verification email: " + err.Error())
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to send verification email"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":       "Verification email sent",
+		"pending_email": email,
+	})
+}
+
+// DeleteEmail 删除邮箱，同时关闭每周摘要
+func DeleteEmail(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	_, err := database.DB.Exec(`
+		UPDATE users
+		SET email = NULL, email_verified_at = NULL, pending_email = NULL, email_digest = FALSE, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?`,
+		userID,
+	)
+	if err != nil {
+		logger.Error("Failed to delete email: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete email"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email deleted successfully"})
+}
+
+// UpdateDigestSettings 开启或关闭每周摘要，可同时设置发送所用的时区；开启前需要验证邮箱
+func UpdateDigestSettings(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req struct {
+		Enabled  *bool   `json:"enabled"`
+		Timezone *string `json:"timezone"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	sets := []string{"updated_at = CURRENT_TIMESTAMP"}
+	args := []interface{}{}
+	if req.Timezone != nil {
+		if !validTimezone(*req.Timezone) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid time zone"})
+			return
+		}
+		sets = append(sets, "timezone = ?")
+		args = append(args, *req.Timezone)
+	}
+	if req.Enabled != nil {
+		if *req.Enabled {
+			var verified bool
+			err := database.DB.QueryRow("SELECT email IS NOT NULL AND email_verified_at IS NOT NULL FROM users WHERE id = ?", userID).Scan(&verified)
+			if err != nil {
+				logger.Error("Failed to get email: " + err.Error())
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+				return
+			}
+			if !verified {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Verify an email address first"})
+				return
+			}
+		}
+		sets = append(sets, "email_digest = ?")
+		args = append(args, *req.Enabled)
+	}
+
+	_, err := database.DB.Exec("UPDATE users SET "+strings.Join(sets, ", ")+" WHERE id = ?", append(args, userID)...)
+	if err != nil {
+		logger.Error("Failed to update digest settings: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update digest settings"})
+		return
+	}
+
+	GetEmailSettings(c)
+}
+
+// SendDigestPreview 立即把上一周的摘要发送到已验证的邮箱，不影响定时发送
+func SendDigestPreview(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	if !mail.Enabled() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Email is not configured"})
+		return
+	}
+
+	recipient, err := loadDigestRecipient(userID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Verify an email address first"})
+		return
+	}
+	if err != nil {
+		logger.Error("Failed to get digest recipient: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	from, to := digestPeriod(time.Now(), recipient.Loc, recipient.WeekStart)
+	if err := sendDigest(recipient, from, to, publicBaseURL(c)); err != nil {
+		logger.Error("Failed to send digest preview: " + err.Error())
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to send digest"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "Digest sent",
+		"from":    from.Format(dateLayout),
+		"to":      to.AddDate(0, 0, -1).Format(dateLayout),
+	})
+}
+
+// VerifyEmail 打开验证邮件中的链接，验证通过后新邮箱生效
+func VerifyEmail(c *gin.Context) {
+	userID, email, err := mail.VerifyLink(c.Query("token"), linkVerifyEmail)
+	if err != nil {
+		renderMailPage(c, http.StatusBadRequest, "verify_failed", "verify_invalid")
+		return
+	}
+
+	// 发出验证邮件后其他账号可能已验证了同一个邮箱
+	inUse, err := emailInUse(email, userID)
+	if err != nil {
+		logger.Error("Failed to check email: " + err.Error())
+		renderMailPage(c, http.StatusInternalServerError, "verify_failed", "server_error")
+		return
+	}
+	if inUse {
+		renderMailPage(c, http.StatusConflict, "verify_failed", "verify_taken", email)
+		return
+	}
+
+	result, err := database.DB.Exec(`
+		UPDATE users
+		SET email = pending_email, pending_email = NULL, email_verified_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND pending_email = ? AND deleted_at IS NULL`,
+		userID, email,
+	)
+	if err != nil {
+		logger.Error("Failed to verify email: " + err.Error())
+		renderMailPage(c, http.StatusInternalServerError, "verify_failed", "server_error")
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		renderMailPage(c, http.StatusBadRequest, "verify_failed", "verify_used")
+		return
+	}
+
+	logger.Info("Email verified for user " + strconv.Itoa(userID))
+	renderMailPage(c, http.StatusOK, "verified", "verified_message", email)
+}
+
+// UnsubscribeDigest 打开摘要邮件中的退订链接，支持邮件客户端的一键退订（POST）
+func UnsubscribeDigest(c *gin.Context) {
+	userID, _, err := mail.VerifyLink(c.Query("token"), linkUnsubscribeDigest)
+	if err != nil {
+		renderMailPage(c, http.StatusBadRequest, "unsubscribe_failed", "unsubscribe_invalid")
+		return
+	}
+
+	if _, err := database.DB.Exec("UPDATE users SET email_digest = FALSE, updated_at = CURRENT_TIMESTAMP WHERE id = ?", userID); err != nil {
+		logger.Error("Failed to unsubscribe digest: " + err.Error())
+		renderMailPage(c, http.StatusInternalServerError, "unsubscribe_failed", "server_error")
+		return
+	}
+
+	logger.Info("Digest unsubscribed for user " + strconv.Itoa(userID))
+	renderMailPage(c, http.StatusOK, "unsubscribed", "unsubscribed_message")
+}
+
+// emailInUse 检查邮箱是否已绑定到其他账号，不区分大小写
+func emailInUse(email string, userID int) (bool, error) {
+	var inUse bool
+	err := database.DB.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM users WHERE email = ? COLLATE NOCASE AND id != ?)",
+		email, userID,
+	).Scan(&inUse)
+	return inUse, err
+}
+
+// renderMailPage 输出打开邮件链接后的结果页面，文案按浏览器的 Accept-Language 选择语言
+func renderMailPage(c *gin.Context, status int, titleKey, messageKey string, args ...interface{}) {
+	lang := locale.FromAcceptLanguage(c.GetHeader("Accept-Language"))
+	title := mailText(lang, titleKey)
+	message := mailText(lang, messageKey, args...)
+	page, err := mail.RenderPage(lang, title, message)
+	if err != nil {
+		logger.Error("Failed to render page: " + err.Error())
+		c.String(status, title+": "+message)
+		return
+	}
+	c.Data(status, "text/html; charset=utf-8", page)
+}
+
+// nullableString 空值返回 nil
+func nullableString(s sql.NullString) interface{} {
+	if !s.Valid {
+		return nil
+	}
+	return s.String
+}
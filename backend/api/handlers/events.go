@@ -6,6 +6,8 @@ import (
 	"strconv"
 
 	"booonus-backend/internal/database"
+	"booonus-backend/internal/notifications"
+	"booonus-backend/internal/permissions"
 	"booonus-backend/models"
 	"booonus-backend/pkg/logger"
 
@@ -40,11 +42,11 @@ func GetEvents(c *gin.Context) {
 	// 获取事件列表
 	query := `
 		SELECT e.id, e.couple_id, e.creator_id, e.target_id, e.name, e.description, e.points, e.created_at,
-		       u1.username as creator_name, u2.username as target_name
+		       e.updated_at, u1.username as creator_name, u2.username as target_name
 		FROM events e
 		JOIN users u1 ON e.creator_id = u1.id
 		JOIN users u2 ON e.target_id = u2.id
-		WHERE e.couple_id = ?
+		WHERE e.couple_id = ? AND e.deleted_at IS NULL
 		ORDER BY e.created_at DESC
 		LIMIT ? OFFSET ?
 	`
@@ -65,7 +67,7 @@ func GetEvents(c *gin.Context) {
 		err := rows.Scan(
 			&event.ID, &event.CoupleID, &event.CreatorID, &event.TargetID,
 			&event.Name, &event.Description, &event.Points, &event.CreatedAt,
-			&creatorName, &targetName,
+			&event.UpdatedAt, &creatorName, &targetName,
 		)
 		if err != nil {
 			logger.Error("Failed to scan event: " + err.Error())
@@ -83,12 +85,30 @@ func GetEvents(c *gin.Context) {
 			"description":  event.Description,
 			"points":       event.Points,
 			"created_at":   event.CreatedAt,
+			"updated_at":   event.UpdatedAt,
+			"is_edited":    event.UpdatedAt != nil,
 		})
 	}
 
+	// 附加表情回应汇总和评论数
+	eventIDs := make([]int, 0, len(events))
+	for _, event := range events {
+		eventIDs = append(eventIDs, event["id"].(int))
+	}
+	reactions, commentCounts, err := interactionSummary("event", eventIDs)
+	if err != nil {
+		logger.Error("Failed to get event reactions: " + err.Error())
+	} else {
+		for _, event := range events {
+			id := event["id"].(int)
+			event["reactions"] = reactionsOrEmpty(reactions[id])
+			event["comment_count"] = commentCounts[id]
+		}
+	}
+
 	// 获取总数
 	var total int
-	err = database.DB.QueryRow("SELECT COUNT(*) FROM events WHERE couple_id = ?", coupleID.Int64).Scan(&total)
+	err = database.DB.QueryRow("SELECT COUNT(*) FROM events WHERE couple_id = ? AND deleted_at IS NULL", coupleID.Int64).Scan(&total)
 	if err != nil {
 		logger.Error("Failed to get events count: " + err.Error())
 		total = len(events)
@@ -177,6 +197,40 @@ func CreateEvent(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create event"})
 		return
 	}
+	sendNotification(tx, notifications.Notification{
+		UserID:        req.TargetID,
+		Type:          notifications.TypePoints,
+		ActorID:       &userID,
+		MessageKey:    notifications.MessageEventCreated,
+		MessageArgs:   []string{req.Name},
+		Points:        &req.Points,
+		ReferenceType: "event",
+		ReferenceID:   &eventIDInt,
+	})
+
+	// 记录初始版本
+	if err = recordEventRevision(tx, eventIDInt, "create", userID); err != nil {
+		logger.Error("Failed to record event revision: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create event"})
+		return
+	}
+
+	eventEvent := gin.H{"event_id": eventID, "target_id": req.TargetID}
+	pointsEvent := gin.H{
+		"source":   "event",
+		"event_id": eventID,
+		"targets":  []ruleExecuteSplit{{UserID: req.TargetID, Points: req.Points}},
+	}
+	if err := queueWebhooks(tx, c, streamEventCreated, eventEvent); err != nil {
+		logger.Error("Failed to queue webhooks: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create event"})
+		return
+	}
+	if err := queueWebhooks(tx, c, streamPointsChanged, pointsEvent); err != nil {
+		logger.Error("Failed to queue webhooks: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create event"})
+		return
+	}
 
 	// 提交事务
 	if err = tx.Commit(); err != nil {
@@ -185,6 +239,9 @@ func CreateEvent(c *gin.Context) {
 		return
 	}
 
+	publishEvent(c, streamEventCreated, eventEvent)
+	publishEvent(c, streamPointsChanged, pointsEvent)
+
 	logger.Info("Event created: " + strconv.FormatInt(eventID, 10) + " by user " + strconv.Itoa(userID))
 	c.JSON(http.StatusCreated, gin.H{
 		"message":  "Event created successfully",
@@ -192,21 +249,496 @@ func CreateEvent(c *gin.Context) {
 	})
 }
 
-// canUserAccessTarget 检查用户是否可以对目标用户执行操作
-func canUserAccessTarget(userID, targetID int) bool {
-	// 可以对自己执行操作
-	if userID == targetID {
-		return true
+// GetEvent 获取单个事件及其修改历史和相关积分记录
+func GetEvent(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	eventID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID"})
+		return
 	}
 
-	// 检查是否是情侣关系
-	var count int
-	query := `
-		SELECT COUNT(*) FROM couples c
-		JOIN users u1 ON (c.user1_id = u1.id OR c.user2_id = u1.id)
-		JOIN users u2 ON (c.user1_id = u2.id OR c.user2_id = u2.id)
-		WHERE u1.id = ? AND u2.id = ?
-	`
-	err := database.DB.QueryRow(query, userID, targetID).Scan(&count)
-	return err == nil && count > 0
+	var event models.Event
+	var creatorName, targetName string
+	err = database.DB.QueryRow(`
+		SELECT e.id, e.couple_id, e.creator_id, e.target_id, e.name, e.description, e.points, e.created_at,
+		       e.updated_at, e.deleted_at, e.deleted_by, u1.username, u2.username
+		FROM events e
+		JOIN users u1 ON e.creator_id = u1.id
+		JOIN users u2 ON e.target_id = u2.id
+		WHERE e.id = ?`,
+		eventID,
+	).Scan(
+		&event.ID, &event.CoupleID, &event.CreatorID, &event.TargetID, &event.Name, &event.Description,
+		&event.Points, &event.CreatedAt, &event.UpdatedAt, &event.DeletedAt, &event.DeletedBy,
+		&creatorName, &targetName,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Event not found"})
+			return
+		}
+		logger.Error("Failed to get event: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	if !isUserInCouple(userID, event.CoupleID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	// 修改历史
+	rows, err := database.DB.Query(`
+		SELECT er.id, er.event_id, er.revision, er.name, er.description, er.points, er.change_type,
+		       er.changed_by, er.created_at
+		FROM event_revisions er
+		WHERE er.event_id = ?
+		ORDER BY er.revision DESC`,
+		eventID,
+	)
+	if err != nil {
+		logger.Error("Failed to get event revisions: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get event"})
+		return
+	}
+	defer rows.Close()
+
+	revisions := []models.EventRevision{}
+	for rows.Next() {
+		var rev models.EventRevision
+		var description sql.NullString
+		err := rows.Scan(
+			&rev.ID, &rev.EventID, &rev.Revision, &rev.Name, &description, &rev.Points, &rev.ChangeType,
+			&rev.ChangedBy, &rev.CreatedAt,
+		)
+		if err != nil {
+			logger.Error("Failed to scan event revision: " + err.Error())
+			continue
+		}
+		rev.Description = description.String
+		revisions = append(revisions, rev)
+	}
+
+	// 相关积分记录（原始记录及修改、删除产生的调整记录）
+	historyRows, err := database.DB.Query(`
+		SELECT `+pointsHistoryColumns+`
+		FROM points_history ph
+		`+pointsHistoryJoins+`
+		WHERE ph.type = 'event' AND ph.reference_id = ?
+		ORDER BY ph.id ASC`,
+		eventID,
+	)
+	if err != nil {
+		logger.Error("Failed to get event points history: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get event"})
+		return
+	}
+	defer historyRows.Close()
+
+	ledger := []models.PointsHistory{}
+	for historyRows.Next() {
+		h, err := scanPointsHistory(historyRows)
+		if err != nil {
+			logger.Error("Failed to scan event points history: " + err.Error())
+			continue
+		}
+		ledger = append(ledger, h)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"event": gin.H{
+			"id":           event.ID,
+			"couple_id":    event.CoupleID,
+			"creator_id":   event.CreatorID,
+			"creator_name": creatorName,
+			"target_id":    event.TargetID,
+			"target_name":  targetName,
+			"name":         event.Name,
+			"description":  event.Description,
+			"points":       event.Points,
+			"created_at":   event.CreatedAt,
+			"updated_at":   event.UpdatedAt,
+			"is_edited":    event.UpdatedAt != nil,
+			"deleted_at":   event.DeletedAt,
+			"deleted_by":   event.DeletedBy,
+		},
+		"revisions":      revisions,
+		"points_history": ledger,
+	})
+}
+
+// UpdateEvent 修改事件，积分变化通过新的调整记录体现
+func UpdateEvent(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	eventID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID"})
+		return
+	}
+
+	var req struct {
+		Name        string  `json:"name"`
+		Description *string `json:"description"`
+		Points      *int    `json:"points"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Name == "" && req.Description == nil && req.Points == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No fields to update"})
+		return
+	}
+	if req.Points != nil && *req.Points == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Points cannot be zero"})
+		return
+	}
+
+	event, ok := getEditableEvent(c, eventID, userID)
+	if !ok {
+		return
+	}
+
+	// 开始事务
+	tx, err := database.DB.Begin()
+	if err != nil {
+		logger.Error("Failed to begin transaction: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	defer tx.Rollback()
+
+	// 先写入事件，并发的修改和删除依次执行；之后在事务中重新读取事件内容
+	result, err := tx.Exec("UPDATE events SET updated_at = CURRENT_TIMESTAMP WHERE id = ? AND deleted_at IS NULL", eventID)
+	if err != nil {
+		logger.Error("Failed to update event: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update event"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n != 1 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Event has already been deleted"})
+		return
+	}
+	var currentDescription sql.NullString
+	err = tx.QueryRow("SELECT name, description, points FROM events WHERE id = ?", eventID).Scan(&event.Name, &currentDescription, &event.Points)
+	if err != nil {
+		logger.Error("Failed to get event: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update event"})
+		return
+	}
+	event.Description = currentDescription.String
+
+	name := event.Name
+	if req.Name != "" {
+		name = req.Name
+	}
+	description := event.Description
+	if req.Description != nil {
+		description = *req.Description
+	}
+	points := event.Points
+	if req.Points != nil {
+		points = *req.Points
+	}
+
+	// 积分有变化时，为差额写入一条调整记录
+	adjustment := 0
+	if points != event.Points {
+		applied, originalReverted, err := eventAppliedPoints(tx, eventID)
+		if err != nil {
+			logger.Error("Failed to get event applied points: " + err.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update event"})
+			return
+		}
+		if originalReverted {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot change points of a reverted event"})
+			return
+		}
+
+		adjustment = points - applied
+		if err = adjustEventPoints(tx, event, adjustment, "事件调整: "+name, notifications.MessageEventAdjusted, name, userID); err != nil {
+			logger.Error("Failed to adjust event points: " + err.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update event"})
+			return
+		}
+	}
+
+	_, err = tx.Exec(
+		"UPDATE events SET name = ?, description = ?, points = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?",
+		name, description, points, eventID,
+	)
+	if err != nil {
+		logger.Error("Failed to update event: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update event"})
+		return
+	}
+
+	if err = recordEventRevision(tx, eventID, "update", userID); err != nil {
+		logger.Error("Failed to record event revision: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update event"})
+		return
+	}
+
+	eventEvent := gin.H{"event_id": eventID, "target_id": event.TargetID}
+	pointsEvent := gin.H{
+		"source":   "event",
+		"event_id": eventID,
+		"targets":  []ruleExecuteSplit{{UserID: event.TargetID, Points: adjustment}},
+	}
+	if err := queueWebhooks(tx, c, streamEventUpdated, eventEvent); err != nil {
+		logger.Error("Failed to queue webhooks: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update event"})
+		return
+	}
+	if adjustment != 0 {
+		if err := queueWebhooks(tx, c, streamPointsChanged, pointsEvent); err != nil {
+			logger.Error("Failed to queue webhooks: " + err.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update event"})
+			return
+		}
+	}
+
+	// 提交事务
+	if err = tx.Commit(); err != nil {
+		logger.Error("Failed to commit transaction: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update event"})
+		return
+	}
+
+	publishEvent(c, streamEventUpdated, eventEvent)
+	if adjustment != 0 {
+		publishEvent(c, streamPointsChanged, pointsEvent)
+	}
+
+	logger.Info("Event updated: " + strconv.Itoa(eventID) + " by user " + strconv.Itoa(userID))
+	c.JSON(http.StatusOK, gin.H{
+		"message":           "Event updated successfully",
+		"points_adjustment": adjustment,
+	})
+}
+
+// DeleteEvent 删除事件（软删除），已生效的积分通过新的调整记录冲销
+func DeleteEvent(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	eventID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID"})
+		return
+	}
+
+	event, ok := getEditableEvent(c, eventID, userID)
+	if !ok {
+		return
+	}
+
+	// 开始事务
+	tx, err := database.DB.Begin()
+	if err != nil {
+		logger.Error("Failed to begin transaction: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	defer tx.Rollback()
+
+	// 先标记删除，并发删除同一事件时只有一个请求会冲销积分
+	result, err := tx.Exec(
+		"UPDATE events SET deleted_at = CURRENT_TIMESTAMP, deleted_by = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND deleted_at IS NULL",
+		userID, eventID,
+	)
+	if err != nil {
+		logger.Error("Failed to delete event: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete event"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n != 1 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Event has already been deleted"})
+		return
+	}
+
+	applied, _, err := eventAppliedPoints(tx, eventID)
+	if err != nil {
+		logger.Error("Failed to get event applied points: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete event"})
+		return
+	}
+
+	if err = adjustEventPoints(tx, event, -applied, "事件删除: "+event.Name, notifications.MessageEventDeleted, event.Name, userID); err != nil {
+		logger.Error("Failed to adjust event points: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete event"})
+		return
+	}
+
+	if err = recordEventRevision(tx, eventID, "delete", userID); err != nil {
+		logger.Error("Failed to record event revision: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete event"})
+		return
+	}
+
+	eventEvent := gin.H{"event_id": eventID, "target_id": event.TargetID}
+	pointsEvent := gin.H{
+		"source":   "event",
+		"event_id": eventID,
+		"targets":  []ruleExecuteSplit{{UserID: event.TargetID, Points: -applied}},
+	}
+	if err := queueWebhooks(tx, c, streamEventDeleted, eventEvent); err != nil {
+		logger.Error("Failed to queue webhooks: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete event"})
+		return
+	}
+	if applied != 0 {
+		if err := queueWebhooks(tx, c, streamPointsChanged, pointsEvent); err != nil {
+			logger.Error("Failed to queue webhooks: " + err.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete event"})
+			return
+		}
+	}
+
+	// 提交事务
+	if err = tx.Commit(); err != nil {
+		logger.Error("Failed to commit transaction: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete event"})
+		return
+	}
+
+	publishEvent(c, streamEventDeleted, eventEvent)
+	if applied != 0 {
+		publishEvent(c, streamPointsChanged, pointsEvent)
+	}
+
+	logger.Info("Event deleted: " + strconv.Itoa(eventID) + " by user " + strconv.Itoa(userID))
+	c.JSON(http.StatusOK, gin.H{
+		"message":           "Event deleted successfully",
+		"points_adjustment": -applied,
+	})
+}
+
+// getEditableEvent 获取未删除的事件并检查当前用户是否有权修改，失败时已写入响应
+func getEditableEvent(c *gin.Context, eventID, userID int) (models.Event, bool) {
+	var event models.Event
+	var description sql.NullString
+	var policy, coupleStatus string
+	err := database.DB.QueryRow(`
+		SELECT e.id, e.couple_id, e.creator_id, e.target_id, e.name, e.description, e.points, e.created_at,
+		       e.deleted_at, c.event_edit_policy, c.status
+		FROM events e
+		JOIN couples c ON e.couple_id = c.id
+		WHERE e.id = ?`,
+		eventID,
+	).Scan(
+		&event.ID, &event.CoupleID, &event.CreatorID, &event.TargetID, &event.Name, &description,
+		&event.Points, &event.CreatedAt, &event.DeletedAt, &policy, &coupleStatus,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Event not found"})
+			return event, false
+		}
+		logger.Error("Failed to get event: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return event, false
+	}
+	event.Description = description.String
+
+	if event.DeletedAt != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Event not found"})
+		return event, false
+	}
+
+	// 已解除的情侣关系中的事件只读
+	if coupleStatus != "active" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Couple relationship has ended"})
+		return event, false
+	}
+
+	// 创建者总是可以修改；家庭设置为 both 时其他成员也可以修改
+	if userID != event.CreatorID && !(policy == "both" && isUserInCouple(userID, event.CoupleID)) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return event, false
+	}
+
+	return event, true
+}
+
+// eventAppliedPoints 计算事件当前实际生效的积分（未撤销记录之和），并返回原始记录是否已被撤销
+func eventAppliedPoints(tx *sql.Tx, eventID int) (int, bool, error) {
+	var applied int
+	err := tx.QueryRow(
+		"SELECT COALESCE(SUM(points), 0) FROM points_history WHERE type = 'event' AND reference_id = ? AND is_reverted = FALSE",
+		eventID,
+	).Scan(&applied)
+	if err != nil {
+		return 0, false, err
+	}
+
+	var originalReverted bool
+	err = tx.QueryRow(
+		"SELECT is_reverted FROM points_history WHERE type = 'event' AND reference_id = ? ORDER BY id ASC LIMIT 1",
+		eventID,
+	).Scan(&originalReverted)
+	if err == sql.ErrNoRows {
+		return applied, false, nil
+	}
+	return applied, originalReverted, err
+}
+
+// adjustEventPoints 为事件写入调整记录并更新目标用户积分，通知目标用户
+// 事件一旦被调整，其所有积分记录都不能再单独撤销，否则会与事件本身不一致
+// 通知使用 messageKey 和事件名，按目标用户的语言显示
+func adjustEventPoints(tx *sql.Tx, event models.Event, adjustment int, description, messageKey, name string, actorID int) error {
+	_, err := tx.Exec("UPDATE points_history SET can_revert = FALSE WHERE type = 'event' AND reference_id = ?", event.ID)
+	if err != nil {
+		return err
+	}
+
+	if adjustment == 0 {
+		return nil
+	}
+
+	if err := updateUserPoints(tx, event.TargetID, adjustment); err != nil {
+		return err
+	}
+	if err := addPointsHistory(tx, event.TargetID, adjustment, "event", &event.ID, description, false); err != nil {
+		return err
+	}
+
+	sendNotification(tx, notifications.Notification{
+		UserID:        event.TargetID,
+		Type:          notifications.TypePoints,
+		ActorID:       &actorID,
+		MessageKey:    messageKey,
+		MessageArgs:   []string{name},
+		Points:        &adjustment,
+		ReferenceType: "event",
+		ReferenceID:   &event.ID,
+	})
+	return nil
+}
+
+// recordEventRevision 将事件当前状态保存为新版本（内部函数）
+func recordEventRevision(tx *sql.Tx, eventID int, changeType string, changedBy int) error {
+	_, err := tx.Exec(`
+		INSERT INTO event_revisions (event_id, revision, name, description, points, change_type, changed_by)
+		SELECT id, (SELECT COALESCE(MAX(revision), 0) + 1 FROM event_revisions WHERE event_id = ?),
+		       name, description, points, ?, ?
+		FROM events WHERE id = ?`,
+		eventID, changeType, changedBy, eventID,
+	)
+	return err
+}
+
+// isUserInCouple 检查用户是否是某个家庭的成员（包括已解除的家庭，其中的记录仍可查看）
+func isUserInCouple(userID, coupleID int) bool {
+	role, err := householdRole(coupleID, userID)
+	return err == nil && role != ""
+}
+
+// canUserAccessTarget 检查用户是否可以对目标用户执行操作（自己或同一家庭的成员）
+func canUserAccessTarget(userID, targetID int) bool {
+	return userID == targetID || permissions.SameHousehold(userID, targetID)
 }
@@ -0,0 +1,485 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"booonus-backend/internal/database"
+	"booonus-backend/internal/export"
+	"booonus-backend/internal/storage"
+	"booonus-backend/pkg/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// exportRetention 导出文件的保留时间
+const exportRetention = 7 * 24 * time.Hour
+
+// exportFormatVersion 导出文件的结构版本，列有不兼容变化时递增
+const exportFormatVersion = 2
+
+// exportJobTimeout 超过该时间仍未完成的任务视为失败（例如服务重启）
+const exportJobTimeout = time.Hour
+
+// ExportData 直接下载自己和情侣的全部数据
+// format: csv（CSV 压缩包，默认）、json、xlsx；时间按 tz 参数的时区输出
+func ExportData(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	format, loc, ok := parseExportParams(c)
+	if !ok {
+		return
+	}
+
+	tables, err := exportTables(userID, loc)
+	if err != nil {
+		logger.Error("Failed to prepare export: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export data"})
+		return
+	}
+
+	c.Header("Content-Type", export.ContentType(format))
+	c.Header("Content-Disposition", `attachment; filename="`+exportFileName(format, time.Now().In(loc))+`"`)
+	c.Status(http.StatusOK)
+
+	// 响应头已发出，出错时只能中断输出
+	if err := export.Write(c.Writer, format, tables); err != nil {
+		logger.Error("Failed to write export: " + err.Error())
+	}
+}
+
+// CreateExportJob 创建后台导出任务，适合数据量较大的情况，完成后通过下载链接获取
+func CreateExportJob(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	format, loc, ok := parseExportParams(c)
+	if !ok {
+		return
+	}
+
+	result, err := database.DB.Exec(
+		"INSERT INTO export_jobs (user_id, format, timezone) VALUES (?, ?, ?)",
+		userID, format, loc.String(),
+	)
+	if err != nil {
+		logger.Error("Failed to create export job: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create export job"})
+		return
+	}
+	jobID, _ := result.LastInsertId()
+
+	go runExportJob(int(jobID), userID, format, loc)
+
+	job, err := getExportJob(c, int(jobID))
+	if err != nil {
+		logger.Error("Failed to get export job: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	logger.Info("Export job created: " + strconv.Itoa(int(jobID)) + " by user " + strconv.Itoa(userID))
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "Export job created successfully",
+		"job":     job,
+	})
+}
+
+// GetExportJobs 获取自己的导出任务
+func GetExportJobs(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	rows, err := database.DB.Query(
+		"SELECT "+exportJobColumns+" FROM export_jobs WHERE user_id = ? ORDER BY id DESC LIMIT 20",
+		userID,
+	)
+	if err != nil {
+		logger.Error("Failed to get export jobs: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get export jobs"})
+		return
+	}
+	defer rows.Close()
+
+	jobs := []gin.H{}
+	for rows.Next() {
+		job, err := scanExportJob(c, rows)
+		if err != nil {
+			logger.Error("Failed to scan export job: " + err.Error())
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"jobs": jobs})
+}
+
+// GetExportJob 获取导出任务的状态，完成后包含下载链接
+func GetExportJob(c *gin.Context) {
+	jobID, ok := ownedExportJobID(c)
+	if !ok {
+		return
+	}
+
+	job, err := getExportJob(c, jobID)
+	if err != nil {
+		logger.Error("Failed to get export job: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"job": job})
+}
+
+// DownloadExportJob 下载已完成的导出文件
+func DownloadExportJob(c *gin.Context) {
+	jobID, ok := ownedExportJobID(c)
+	if !ok {
+		return
+	}
+
+	var format, status string
+	var storageKey sql.NullString
+	var createdAt time.Time
+	var timezone string
+	err := database.DB.QueryRow(
+		"SELECT format, status, storage_key, created_at, timezone FROM export_jobs WHERE id = ?",
+		jobID,
+	).Scan(&format, &status, &storageKey, &createdAt, &timezone)
+	if err != nil {
+		logger.Error("Failed to get export job: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	if status != "completed" || !storageKey.Valid {
+		c.JSON(http.StatusConflict, gin.H{"error": "Export is not ready"})
+		return
+	}
+
+	reader, err := storage.Blobs.Get(storageKey.String)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			c.JSON(http.StatusGone, gin.H{"error": "Export has expired"})
+			return
+		}
+		logger.Error("Failed to read export: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to download export"})
+		return
+	}
+	defer reader.Close()
+
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	c.Header("Content-Disposition", `attachment; filename="`+exportFileName(format, createdAt.In(loc))+`"`)
+	c.DataFromReader(http.StatusOK, -1, export.ContentType(format), reader, nil)
+}
+
+// CleanupExportJobs 删除过期的导出文件，并将长时间未完成的任务标记为失败
+func CleanupExportJobs() {
+	now := dbTime(time.Now())
+
+	rows, err := database.DB.Query(
+		"SELECT id, storage_key FROM export_jobs WHERE status = 'completed' AND expires_at <= ? AND storage_key IS NOT NULL",
+		now,
+	)
+	if err != nil {
+		logger.Error("Failed to get expired export jobs: " + err.Error())
+		return
+	}
+	type expiredJob struct {
+		id  int
+		key string
+	}
+	var expired []expiredJob
+	for rows.Next() {
+		var job expiredJob
+		if err := rows.Scan(&job.id, &job.key); err == nil {
+			expired = append(expired, job)
+		}
+	}
+	rows.Close()
+
+	for _, job := range expired {
+		if err := storage.Blobs.Delete(job.key); err != nil {
+			logger.Error("Failed to delete export: " + err.Error())
+			continue
+		}
+		database.DB.Exec("UPDATE export_jobs SET storage_key = NULL WHERE id = ?", job.id)
+	}
+
+	_, err = database.DB.Exec(
+		"UPDATE export_jobs SET status = 'failed', error = 'Export job timed out' WHERE status IN ('pending', 'running') AND created_at <= ?",
+		dbTime(time.Now().Add(-exportJobTimeout)),
+	)
+	if err != nil {
+		logger.Error("Failed to mark stale export jobs: " + err.Error())
+	}
+}
+
+// runExportJob 在后台生成导出文件并写入对象存储
+func runExportJob(jobID, userID int, format string, loc *time.Location) {
+	database.DB.Exec("UPDATE export_jobs SET status = 'running' WHERE id = ?", jobID)
+
+	fail := func(err error) {
+		logger.Error("Export job " + strconv.Itoa(jobID) + " failed: " + err.Error())
+		database.DB.Exec("UPDATE export_jobs SET status = 'failed', error = ? WHERE id = ?", err.Error(), jobID)
+	}
+
+	tables, err := exportTables(userID, loc)
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	key, err := newExportKey(userID, format)
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	// 边生成边写入存储，不在内存中保留整个文件
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(export.Write(pw, format, tables))
+	}()
+	if err := storage.Blobs.Put(key, pr); err != nil {
+		pr.CloseWithError(err)
+		fail(err)
+		return
+	}
+
+	_, err = database.DB.Exec(
+		"UPDATE export_jobs SET status = 'completed', storage_key = ?, completed_at = CURRENT_TIMESTAMP, expires_at = ? WHERE id = ?",
+		key, dbTime(time.Now().Add(exportRetention)), jobID,
+	)
+	if err != nil {
+		storage.Blobs.Delete(key)
+		fail(err)
+		return
+	}
+
+	logger.Info("Export job completed: " + strconv.Itoa(jobID))
+}
+
+// parseExportParams 解析导出格式和时区，失败时已写入响应
+func parseExportParams(c *gin.Context) (string, *time.Location, bool) {
+	format := c.DefaultQuery("format", export.FormatCSV)
+	if !export.ValidFormat(format) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid format, expected csv, json or xlsx"})
+		return "", nil, false
+	}
+
+	loc, ok := requestLocation(c)
+	if !ok {
+		return "", nil, false
+	}
+	return format, loc, true
+}
+
+// exportTables 用户导出的全部数据表；ID 与接口中的 ID 一致，时间按 loc 输出为 RFC3339
+func exportTables(userID int, loc *time.Location) ([]export.Table, error) {
+	memberIDs, err := coupleMemberIDs(userID)
+	if err != nil {
+		return nil, err
+	}
+	inClause, memberArgs := memberInClause(memberIDs)
+
+	var coupleID sql.NullInt64
+	err = database.DB.QueryRow("SELECT couple_id FROM users WHERE id = ?", userID).Scan(&coupleID)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	var coupleValue interface{}
+	if coupleID.Valid {
+		coupleValue = coupleID.Int64
+	}
+
+	info := export.Table{
+		Name:    "export_info",
+		Columns: []string{"user_id", "couple_id", "timezone", "exported_at", "format_version"},
+		Rows: func(emit func([]interface{}) error) error {
+			return emit([]interface{}{userID, coupleValue, loc.String(), time.Now().In(loc).Format(time.RFC3339), exportFormatVersion})
+		},
+	}
+
+	return []export.Table{
+		info,
+		queryExportTable("users", loc,
+			[]string{"id", "username", "points", "couple_id", "created_at", "updated_at"},
+			"FROM users WHERE id IN ("+inClause+") ORDER BY id", memberArgs...),
+		queryExportTable("couples", loc,
+			[]string{"id", "name", "user1_id", "user2_id", "created_at", "event_edit_policy", "revert_window_hours", "revert_reason_required", "revert_limit"},
+			"FROM couples WHERE id = ?", coupleID.Int64),
+		queryExportTable("household_members", loc,
+			[]string{"couple_id", "user_id", "role", "joined_at", "left_at"},
+			"FROM household_members WHERE couple_id = ? ORDER BY joined_at, id", coupleID.Int64),
+		queryExportTable("rules", loc,
+			[]string{"id", "couple_id", "name", "description", "points", "points_expression", "parameters", "target_type", "target_user_id", "is_active", "created_at", "updated_at"},
+			"FROM rules WHERE couple_id = ? ORDER BY id", coupleID.Int64),
+		queryExportTable("shop_items", loc,
+			[]string{"id", "user_id", "name", "description", "price", "stock", "is_active", "created_at", "updated_at"},
+			"FROM shop_items WHERE user_id IN ("+inClause+") ORDER BY id", memberArgs...),
+		queryExportTable("transactions", loc,
+			[]string{"id", "buyer_id", "seller_id", "shop_item_id", "points", "status", "created_at", "fulfilled_at", "fulfilled_by"},
+			"FROM transactions WHERE buyer_id IN ("+inClause+") OR seller_id IN ("+inClause+") ORDER BY id", append(memberArgs, memberArgs...)...),
+		queryExportTable("events", loc,
+			[]string{"id", "couple_id", "creator_id", "target_id", "name", "description", "points", "created_at", "updated_at", "deleted_at", "deleted_by"},
+			"FROM events WHERE couple_id = ? ORDER BY id", coupleID.Int64),
+		queryExportTable("points_history", loc,
+			[]string{"id", "user_id", "type", "reference_id", "points", "description", "can_revert", "is_reverted", "execution_id", "rule_revision_id", "created_at"},
+			"FROM points_history WHERE user_id IN ("+inClause+") ORDER BY id", memberArgs...),
+	}, nil
+}
+
+// queryExportTable 按列名查询一张表，查询在写出时才执行
+// 以 _at 结尾的列转换为 loc 时区的 RFC3339 时间，is_/can_ 开头的列转换为布尔值
+func queryExportTable(name string, loc *time.Location, columns []string, from string, args ...interface{}) export.Table {
+	return export.Table{
+		Name:    name,
+		Columns: columns,
+		Rows: func(emit func([]interface{}) error) error {
+			rows, err := database.DB.Query("SELECT "+strings.Join(columns, ", ")+" "+from, args...)
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+
+			values := make([]interface{}, len(columns))
+			pointers := make([]interface{}, len(columns))
+			for i := range values {
+				pointers[i] = &values[i]
+			}
+
+			for rows.Next() {
+				if err := rows.Scan(pointers...); err != nil {
+					return err
+				}
+				row := make([]interface{}, len(columns))
+				for i, column := range columns {
+					row[i] = exportValue(column, values[i], loc)
+				}
+				if err := emit(row); err != nil {
+					return err
+				}
+			}
+			return rows.Err()
+		},
+	}
+}
+
+// exportValue 将数据库中的值转换为导出的值
+func exportValue(column string, v interface{}, loc *time.Location) interface{} {
+	if b, ok := v.([]byte); ok {
+		v = string(b)
+	}
+	if v == nil {
+		return nil
+	}
+
+	switch {
+	case strings.HasSuffix(column, "_at"):
+		switch t := v.(type) {
+		case time.Time:
+			return t.In(loc).Format(time.RFC3339)
+		case string:
+			if parsed, err := time.Parse(database.TimeLayout, t); err == nil {
+				return parsed.In(loc).Format(time.RFC3339)
+			}
+		}
+	case strings.HasPrefix(column, "is_") || strings.HasPrefix(column, "can_") || column == "revert_reason_required":
+		if n, ok := v.(int64); ok {
+			return n != 0
+		}
+	}
+	return v
+}
+
+// exportJobColumns 查询导出任务的列，与 scanExportJob 对应
+const exportJobColumns = "id, format, timezone, status, error, created_at, completed_at, expires_at"
+
+// scanExportJob 扫描一行导出任务，已完成的任务附带下载链接
+func scanExportJob(c *gin.Context, row rowScanner) (gin.H, error) {
+	var id int
+	var format, timezone, status string
+	var errorMessage sql.NullString
+	var createdAt time.Time
+	var completedAt, expiresAt *time.Time
+	if err := row.Scan(&id, &format, &timezone, &status, &errorMessage, &createdAt, &completedAt, &expiresAt); err != nil {
+		return nil, err
+	}
+
+	job := gin.H{
+		"id":           id,
+		"format":       format,
+		"timezone":     timezone,
+		"status":       status,
+		"created_at":   createdAt,
+		"completed_at": completedAt,
+		"expires_at":   expiresAt,
+		"download_url": nil,
+	}
+	if errorMessage.Valid {
+		job["error"] = errorMessage.String
+	}
+	if status == "completed" && expiresAt != nil && expiresAt.After(time.Now()) {
+		job["download_url"] = publicBaseURL(c) + "/api/v1/export/jobs/" + strconv.Itoa(id) + "/download"
+	}
+	return job, nil
+}
+
+// getExportJob 获取单个导出任务
+func getExportJob(c *gin.Context, jobID int) (gin.H, error) {
+	row := database.DB.QueryRow("SELECT "+exportJobColumns+" FROM export_jobs WHERE id = ?", jobID)
+	return scanExportJob(c, row)
+}
+
+// ownedExportJobID 解析任务ID并检查任务属于当前用户，失败时已写入响应
+func ownedExportJobID(c *gin.Context) (int, bool) {
+	userID := c.GetInt("user_id")
+
+	jobID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid job ID"})
+		return 0, false
+	}
+
+	var ownerID int
+	err = database.DB.QueryRow("SELECT user_id FROM export_jobs WHERE id = ?", jobID).Scan(&ownerID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Export job not found"})
+			return 0, false
+		}
+		logger.Error("Failed to get export job: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return 0, false
+	}
+	if ownerID != userID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Export job not found"})
+		return 0, false
+	}
+
+	return jobID, true
+}
+
+// exportFileName 导出文件名，如 booonus-export-20240131.zip
+func exportFileName(format string, at time.Time) string {
+	return "booonus-export-" + at.Format("20060102") + "." + export.FileExtension(format)
+}
+
+// newExportKey 生成导出文件在对象存储中的key
+func newExportKey(userID int, format string) (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return "exports/" + strconv.Itoa(userID) + "/" + hex.EncodeToString(buf) + "." + export.FileExtension(format), nil
+}
@@ -0,0 +1,578 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"booonus-backend/internal/database"
+	"booonus-backend/internal/notifications"
+	"booonus-backend/internal/permissions"
+	"booonus-backend/pkg/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 家庭是情侣关系的扩展：couples 表的一行即一个家庭，成员记录在 household_members，
+// users.couple_id 指向用户当前所在的家庭。user1_id/user2_id 只保留创建时的两人。
+// 成员角色及其权限见 permissions 包，由路由上的中间件检查。
+
+// householdMember 家庭的一个成员
+type householdMember struct {
+	ID       int       `json:"id"`
+	Username string    `json:"username"`
+	Avatar   *string   `json:"avatar"`
+	Points   int       `json:"points"`
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+// GetHousehold 获取当前家庭及其成员
+func GetHousehold(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	coupleID, ok := currentHousehold(c, userID)
+	if !ok {
+		return
+	}
+
+	var name sql.NullString
+	var createdAt time.Time
+	err := database.DB.QueryRow("SELECT name, created_at FROM couples WHERE id = ?", coupleID).Scan(&name, &createdAt)
+	if err != nil {
+		logger.Error("Failed to get household: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get household"})
+		return
+	}
+
+	members, err := loadHouseholdMembers(coupleID)
+	if err != nil {
+		logger.Error("Failed to get household members: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get household"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"household": gin.H{
+			"id":         coupleID,
+			"name":       name.String,
+			"created_at": createdAt,
+			"members":    members,
+		},
+	})
+}
+
+// GetHouseholdPermissions 获取当前用户的角色、拥有的权限及完整的权限矩阵
+func GetHouseholdPermissions(c *gin.Context) {
+	if _, ok := currentHousehold(c, c.GetInt("user_id")); !ok {
+		return
+	}
+
+	role := c.GetString("household_role")
+	c.JSON(http.StatusOK, gin.H{
+		"role":        role,
+		"permissions": permissions.Matrix()[role],
+		"matrix":      permissions.Matrix(),
+	})
+}
+
+// UpdateHousehold 修改家庭名称
+func UpdateHousehold(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req struct {
+		Name string `json:"name" binding:"max=100"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	coupleID, ok := currentHousehold(c, userID)
+	if !ok {
+		return
+	}
+
+	var name interface{}
+	if req.Name != "" {
+		name = req.Name
+	}
+	if _, err := database.DB.Exec("UPDATE couples SET name = ? WHERE id = ?", name, coupleID); err != nil {
+		logger.Error("Failed to update household: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update household"})
+		return
+	}
+
+	logger.Info("Household updated: " + strconv.Itoa(coupleID) + " by user " + strconv.Itoa(userID))
+	c.JSON(http.StatusOK, gin.H{"message": "Household updated successfully"})
+}
+
+// AddHouseholdMember 将用户加入当前家庭，默认角色为 member，只能指定比自己低的角色；
+// 还没有家庭时使用 /couple/invite 创建
+func AddHouseholdMember(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req struct {
+		Username string `json:"username" binding:"required"`
+		Role     string `json:"role" binding:"omitempty,oneof=admin member child"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Role == "" {
+		req.Role = permissions.RoleMember
+	}
+
+	coupleID, ok := currentHousehold(c, userID)
+	if !ok {
+		return
+	}
+	if !permissions.Outranks(c.GetString("household_role"), req.Role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Cannot assign a role equal to or higher than your own"})
+		return
+	}
+
+	var targetID int
+	var targetCoupleID sql.NullInt64
+	err := database.DB.QueryRow(
+		"SELECT id, couple_id FROM users WHERE username = ? AND deleted_at IS NULL",
+		req.Username,
+	).Scan(&targetID, &targetCoupleID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if targetID == userID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot invite yourself"})
+		return
+	}
+	if targetCoupleID.Valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Target user already has a household"})
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		logger.Error("Failed to begin transaction: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	defer tx.Rollback()
+
+	if err = addHouseholdMember(tx, coupleID, targetID, req.Role); err != nil {
+		logger.Error("Failed to add household member: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add household member"})
+		return
+	}
+	sendNotification(tx, notifications.Notification{
+		UserID:        targetID,
+		Type:          notifications.TypeInvite,
+		ActorID:       &userID,
+		MessageKey:    notifications.MessageHouseholdAdded,
+		ReferenceType: "household",
+		ReferenceID:   &coupleID,
+	})
+	if err = tx.Commit(); err != nil {
+		logger.Error("Failed to commit transaction: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add household member"})
+		return
+	}
+
+	logger.Info("Household member added: " + strconv.Itoa(targetID) + " to " + strconv.Itoa(coupleID) + " by user " + strconv.Itoa(userID))
+	c.JSON(http.StatusCreated, gin.H{
+		"message":      "Household member added successfully",
+		"household_id": coupleID,
+		"user_id":      targetID,
+		"role":         req.Role,
+	})
+}
+
+// UpdateHouseholdMember 修改成员角色，只能修改比自己低的成员并授予比自己低的角色；
+// 所有者把其他成员设为所有者即转让，自己变为管理员
+func UpdateHouseholdMember(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	actorRole := c.GetString("household_role")
+
+	memberID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req struct {
+		Role string `json:"role" binding:"required,oneof=owner admin member child"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	coupleID, ok := currentHousehold(c, userID)
+	if !ok {
+		return
+	}
+
+	role, err := householdRole(coupleID, memberID)
+	if err != nil {
+		logger.Error("Failed to get household member: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	if role == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Household member not found"})
+		return
+	}
+	if memberID == userID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot change your own role"})
+		return
+	}
+	transfer := req.Role == permissions.RoleOwner
+	if transfer && actorRole != permissions.RoleOwner {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the household owner can transfer ownership"})
+		return
+	}
+	if !permissions.Outranks(actorRole, role) || (!transfer && !permissions.Outranks(actorRole, req.Role)) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Cannot assign a role equal to or higher than your own"})
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		logger.Error("Failed to begin transaction: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	defer tx.Rollback()
+
+	if transfer {
+		if err = setHouseholdRole(tx, coupleID, userID, permissions.RoleAdmin); err != nil {
+			logger.Error("Failed to update household role: " + err.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update household member"})
+			return
+		}
+	}
+	if err = setHouseholdRole(tx, coupleID, memberID, req.Role); err != nil {
+		logger.Error("Failed to update household role: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update household member"})
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		logger.Error("Failed to commit transaction: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update household member"})
+		return
+	}
+
+	logger.Info("Household member " + strconv.Itoa(memberID) + " set to " + req.Role + " by user " + strconv.Itoa(userID))
+	c.JSON(http.StatusOK, gin.H{"message": "Household member updated successfully"})
+}
+
+// RemoveHouseholdMember 移除比自己角色低的成员（需要 members.manage 权限）或自己退出家庭
+// 只剩两人时与解除情侣关系相同：家庭归档，可在恢复期内恢复
+func RemoveHouseholdMember(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	memberID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	coupleID, ok := currentHousehold(c, userID)
+	if !ok {
+		return
+	}
+
+	memberRole, err := householdRole(coupleID, memberID)
+	if err != nil {
+		logger.Error("Failed to get household member: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	if memberRole == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Household member not found"})
+		return
+	}
+
+	actorRole := c.GetString("household_role")
+	if memberID != userID && (!permissions.Allowed(actorRole, permissions.MembersManage) || !permissions.Outranks(actorRole, memberRole)) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied", "permission": permissions.MembersManage})
+		return
+	}
+
+	endedAt, ended, err := removeFromHousehold(coupleID, memberID, userID)
+	if err != nil {
+		logger.Error("Failed to remove household member: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove household member"})
+		return
+	}
+
+	logger.Info("Household member removed: " + strconv.Itoa(memberID) + " from " + strconv.Itoa(coupleID) + " by user " + strconv.Itoa(userID))
+	response := gin.H{
+		"message":      "Household member removed successfully",
+		"household_id": coupleID,
+		"ended":        ended,
+	}
+	if ended {
+		response["restore_until"] = endedAt.Add(coupleRestoreWindow)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// removeFromHousehold 成员离开家庭，返回家庭是否因此解除
+// 多于两人时只记录该成员离开（见 leaveHousehold）；只剩两人时归档整个家庭
+func removeFromHousehold(coupleID, memberID, actorID int) (time.Time, bool, error) {
+	now := time.Now().UTC()
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return now, false, err
+	}
+	defer tx.Rollback()
+
+	count, err := activeHouseholdMemberCount(tx, coupleID)
+	if err != nil {
+		return now, false, err
+	}
+
+	ended := count <= 2
+	if ended {
+		if _, err = tx.Exec("UPDATE users SET couple_id = NULL WHERE couple_id = ?", coupleID); err != nil {
+			return now, false, err
+		}
+		_, err = tx.Exec(
+			"UPDATE couples SET status = 'ended', ended_at = ?, ended_by = ? WHERE id = ?",
+			dbTime(now), actorID, coupleID,
+		)
+		if err != nil {
+			return now, false, err
+		}
+	} else if err = leaveHousehold(tx, coupleID, memberID, actorID, now); err != nil {
+		return now, false, err
+	}
+
+	return now, ended, tx.Commit()
+}
+
+// activeHouseholdMemberCount 家庭当前的成员数
+func activeHouseholdMemberCount(tx *sql.Tx, coupleID int) (int, error) {
+	var count int
+	err := tx.QueryRow("SELECT COUNT(*) FROM household_members WHERE couple_id = ? AND left_at IS NULL", coupleID).Scan(&count)
+	return count, err
+}
+
+// leaveHousehold 在多于两人的家庭中记录成员离开：停用以该成员为对象的规则（记录 deactivate 版本，操作人为 actorID），
+// 所有者离开时由角色最高、最早加入的成员接任
+func leaveHousehold(tx *sql.Tx, coupleID, memberID, actorID int, now time.Time) error {
+	var role string
+	err := tx.QueryRow(
+		"SELECT role FROM household_members WHERE couple_id = ? AND user_id = ?",
+		coupleID, memberID,
+	).Scan(&role)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		"UPDATE household_members SET left_at = ? WHERE couple_id = ? AND user_id = ?",
+		dbTime(now), coupleID, memberID,
+	)
+	if err != nil {
+		return err
+	}
+	if _, err = tx.Exec("UPDATE users SET couple_id = NULL WHERE id = ?", memberID); err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(
+		"SELECT id FROM rules WHERE couple_id = ? AND target_type = 'member' AND target_user_id = ? AND is_active = TRUE",
+		coupleID, memberID,
+	)
+	if err != nil {
+		return err
+	}
+	var ruleIDs []int
+	for rows.Next() {
+		var ruleID int
+		if err := rows.Scan(&ruleID); err != nil {
+			rows.Close()
+			return err
+		}
+		ruleIDs = append(ruleIDs, ruleID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, ruleID := range ruleIDs {
+		if _, err = tx.Exec("UPDATE rules SET is_active = FALSE, updated_at = CURRENT_TIMESTAMP WHERE id = ?", ruleID); err != nil {
+			return err
+		}
+		if _, err = recordRuleRevision(tx, ruleID, "deactivate", actorID, nil); err != nil {
+			return err
+		}
+	}
+
+	if role == permissions.RoleOwner {
+		_, err = tx.Exec(`
+			UPDATE household_members SET role = ?
+			WHERE id = (
+				SELECT id FROM household_members
+				WHERE couple_id = ? AND left_at IS NULL
+				ORDER BY CASE role WHEN ? THEN 0 WHEN ? THEN 1 ELSE 2 END, joined_at, id
+				LIMIT 1
+			)`,
+			permissions.RoleOwner, coupleID, permissions.RoleAdmin, permissions.RoleMember,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// currentHousehold 获取用户当前所在的家庭（由 HouseholdRole 中间件加载），失败时已写入响应
+func currentHousehold(c *gin.Context, userID int) (int, bool) {
+	coupleID := c.GetInt("household_id")
+	if coupleID == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No household found"})
+		return 0, false
+	}
+	return coupleID, true
+}
+
+// householdRole 返回用户在家庭中的角色，不是现有成员时返回空字符串
+func householdRole(coupleID, userID int) (string, error) {
+	var role string
+	err := database.DB.QueryRow(
+		"SELECT role FROM household_members WHERE couple_id = ? AND user_id = ? AND left_at IS NULL",
+		coupleID, userID,
+	).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return role, err
+}
+
+// setHouseholdRole 修改成员角色
+func setHouseholdRole(tx *sql.Tx, coupleID, userID int, role string) error {
+	_, err := tx.Exec(
+		"UPDATE household_members SET role = ? WHERE couple_id = ? AND user_id = ? AND left_at IS NULL",
+		role, coupleID, userID,
+	)
+	return err
+}
+
+// addHouseholdMember 加入家庭，曾经离开的成员重新加入
+func addHouseholdMember(tx *sql.Tx, coupleID, userID int, role string) error {
+	_, err := tx.Exec(`
+		INSERT INTO household_members (couple_id, user_id, role) VALUES (?, ?, ?)
+		ON CONFLICT (couple_id, user_id) DO UPDATE
+		SET role = excluded.role, joined_at = CURRENT_TIMESTAMP, left_at = NULL`,
+		coupleID, userID, role,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE users SET couple_id = ? WHERE id = ?", coupleID, userID)
+	return err
+}
+
+// loadHouseholdMembers 获取家庭的现有成员，按加入时间排序
+func loadHouseholdMembers(coupleID int) ([]householdMember, error) {
+	rows, err := database.DB.Query(`
+		SELECT u.id, u.username, u.avatar, u.points, m.role, m.joined_at
+		FROM household_members m
+		JOIN users u ON m.user_id = u.id
+		WHERE m.couple_id = ? AND m.left_at IS NULL
+		ORDER BY m.joined_at, m.id`,
+		coupleID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []householdMember{}
+	for rows.Next() {
+		var m householdMember
+		if err := rows.Scan(&m.ID, &m.Username, &m.Avatar, &m.Points, &m.Role, &m.JoinedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+// householdMemberIDs 获取家庭现有成员的ID，按加入时间排序
+func householdMemberIDs(coupleID int) ([]int, error) {
+	rows, err := database.DB.Query(
+		"SELECT user_id FROM household_members WHERE couple_id = ? AND left_at IS NULL ORDER BY joined_at, id",
+		coupleID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// coupleMemberIDs 返回当前用户及其家庭其他成员（如有）的ID，当前用户排在最前
+func coupleMemberIDs(userID int) ([]int, error) {
+	rows, err := database.DB.Query(`
+		SELECT m2.user_id
+		FROM household_members m1
+		JOIN household_members m2 ON m1.couple_id = m2.couple_id
+		JOIN couples c ON m1.couple_id = c.id
+		WHERE m1.user_id = ? AND m2.user_id != ? AND m1.left_at IS NULL AND m2.left_at IS NULL
+		  AND c.status = 'active'
+		ORDER BY m2.joined_at, m2.id`,
+		userID, userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int{userID}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// containsID 检查ID列表中是否包含指定ID
+func containsID(ids []int, id int) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+// containsString 检查字符串列表中是否包含指定字符串
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"booonus-backend/internal/importer"
+	"booonus-backend/internal/permissions"
+	"booonus-backend/pkg/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxImportFileSize 导入文件的最大大小
+const maxImportFileSize = 5 << 20
+
+// importPermissions 导入各类数据需要的家庭权限
+var importPermissions = map[string]permissions.Permission{
+	importer.KindRules:     permissions.RulesManage,
+	importer.KindShopItems: permissions.ShopManage,
+	importer.KindHistory:   permissions.EventsManage,
+}
+
+// ImportData 从 CSV/JSON 导入规则、商品或历史积分记录
+// 表单字段：file（必填）、kind（rules、shop_items、history）、format（csv、json，默认按扩展名）、
+// mapping（JSON 对象，字段名 -> 列名）、dry_run（true 时只校验并返回报告）；
+// 历史记录中不带时区的日期按 tz 参数的时区解析
+func ImportData(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	loc, ok := requestLocation(c)
+	if !ok {
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportFileSize+1<<20)
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "File is required"})
+		return
+	}
+	if fileHeader.Size > maxImportFileSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "File is too large"})
+		return
+	}
+
+	kind := c.PostForm("kind")
+	mapping := map[string]string{}
+	if raw := c.PostForm("mapping"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &mapping); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid mapping, expected a JSON object"})
+			return
+		}
+	}
+	if err := importer.ValidateMapping(kind, mapping); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if perm := importPermissions[kind]; !permissions.Allowed(c.GetString("household_role"), perm) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied", "permission": perm})
+		return
+	}
+
+	format := c.PostForm("format")
+	if format == "" {
+		format = importer.FormatFromFileName(fileHeader.Filename)
+	}
+
+	dryRun, _ := strconv.ParseBool(c.DefaultPostForm("dry_run", "false"))
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		logger.Error("Failed to open import file: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read file"})
+		return
+	}
+	defer file.Close()
+
+	report, err := importer.Run(importer.Options{
+		UserID:   userID,
+		Kind:     kind,
+		Format:   format,
+		Mapping:  mapping,
+		Location: loc,
+		DryRun:   dryRun,
+		Source:   fileHeader.Filename,
+	}, file)
+	if err != nil {
+		if isImportInputError(err) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		logger.Error("Failed to import data: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import data"})
+		return
+	}
+
+	switch {
+	case report.Errors > 0 && !dryRun:
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":  "Some rows are invalid, nothing was imported",
+			"report": report,
+		})
+	case report.Applied:
+		logger.Info("Data imported: " + kind + " import " + strconv.Itoa(*report.ImportID) + " by user " + strconv.Itoa(userID))
+		c.JSON(http.StatusCreated, gin.H{
+			"message": "Import completed successfully",
+			"report":  report,
+		})
+	default:
+		c.JSON(http.StatusOK, gin.H{"report": report})
+	}
+}
+
+// isImportInputError 导入文件或参数本身的问题
+func isImportInputError(err error) bool {
+	for _, target := range []error{
+		importer.ErrInvalidKind, importer.ErrInvalidFormat, importer.ErrTooManyRows,
+		importer.ErrNoCouple, importer.ErrEmptyFile,
+	} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	// CSV/JSON 解析错误
+	var parseErr *importer.ParseError
+	return errors.As(err, &parseErr)
+}
@@ -0,0 +1,328 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"booonus-backend/internal/database"
+	"booonus-backend/internal/notifications"
+	"booonus-backend/pkg/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetNotifications 获取通知列表及未读数量
+// 查询参数：unread=true 只返回未读，type 按类型过滤，limit/offset 分页
+func GetNotifications(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	where := "n.user_id = ?"
+	args := []interface{}{userID}
+	if unread, _ := strconv.ParseBool(c.Query("unread")); unread {
+		where += " AND n.read_at IS NULL"
+	}
+	if notificationType := c.Query("type"); notificationType != "" {
+		if !notifications.ValidType(notificationType) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid notification type"})
+			return
+		}
+		where += " AND n.type = ?"
+		args = append(args, notificationType)
+	}
+
+	var total int
+	if err := database.DB.QueryRow("SELECT COUNT(*) FROM notifications n WHERE "+where, args...).Scan(&total); err != nil {
+		logger.Error("Failed to count notifications: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get notifications"})
+		return
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT n.id, n.user_id, n.type, n.actor_id, u.username, n.message, n.points,
+		       n.reference_type, n.reference_id, n.read_at, n.created_at
+		FROM notifications n
+		LEFT JOIN users u ON n.actor_id = u.id
+		WHERE `+where+`
+		ORDER BY n.created_at DESC, n.id DESC
+		LIMIT ? OFFSET ?`,
+		append(args, limit, offset)...,
+	)
+	if err != nil {
+		logger.Error("Failed to get notifications: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get notifications"})
+		return
+	}
+	defer rows.Close()
+
+	list := []notifications.Notification{}
+	for rows.Next() {
+		var n notifications.Notification
+		var referenceType sql.NullString
+		err := rows.Scan(&n.ID, &n.UserID, &n.Type, &n.ActorID, &n.ActorName, &n.Message, &n.Points,
+			&referenceType, &n.ReferenceID, &n.ReadAt, &n.CreatedAt)
+		if err != nil {
+			logger.Error("Failed to scan notification: " + err.Error())
+			continue
+		}
+		n.ReferenceType = referenceType.String
+		list = append(list, n)
+	}
+	rows.Close()
+
+	unreadCount, unreadByType, err := unreadNotificationCounts(userID)
+	if err != nil {
+		logger.Error("Failed to count unread notifications: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get notifications"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"notifications":  list,
+		"total":          total,
+		"unread_count":   unreadCount,
+		"unread_by_type": unreadByType,
+		"limit":          limit,
+		"offset":         offset,
+	})
+}
+
+// MarkNotificationRead 将一条通知标记为已读
+func MarkNotificationRead(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	notificationID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid notification ID"})
+		return
+	}
+
+	result, err := database.DB.Exec(
+		"UPDATE notifications SET read_at = COALESCE(read_at, ?) WHERE id = ? AND user_id = ?",
+		dbTime(time.Now()), notificationID, userID,
+	)
+	if err != nil {
+		logger.Error("Failed to mark notification read: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark notification read"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Notification not found"})
+		return
+	}
+
+	unreadCount, _, err := unreadNotificationCounts(userID)
+	if err != nil {
+		logger.Error("Failed to count unread notifications: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark notification read"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "Notification marked as read",
+		"unread_count": unreadCount,
+	})
+}
+
+// MarkAllNotificationsRead 将所有未读通知标记为已读，可用 type 参数只标记一种类型
+func MarkAllNotificationsRead(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	query := "UPDATE notifications SET read_at = ? WHERE user_id = ? AND read_at IS NULL"
+	args := []interface{}{dbTime(time.Now()), userID}
+	if notificationType := c.Query("type"); notificationType != "" {
+		if !notifications.ValidType(notificationType) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid notification type"})
+			return
+		}
+		query += " AND type = ?"
+		args = append(args, notificationType)
+	}
+
+	result, err := database.DB.Exec(query, args...)
+	if err != nil {
+		logger.Error("Failed to mark notifications read: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark notifications read"})
+		return
+	}
+	marked, _ := result.RowsAffected()
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Notifications marked as read",
+		"marked":  marked,
+	})
+}
+
+// GetNotificationPreferences 获取每种通知类型的设置，未设置的类型默认开启
+func GetNotificationPreferences(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	preferences, err := loadNotificationPreferences(userID)
+	if err != nil {
+		logger.Error("Failed to get notification preferences: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get notification preferences"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"preferences": preferences})
+}
+
+// UpdateNotificationPreferences 修改通知类型的设置，请求体为类型到设置的映射，如 {"points": {"in_app": false, "push": true}}
+func UpdateNotificationPreferences(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req notificationPreferencesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !validateNotificationPreferences(c, req) {
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		logger.Error("Failed to begin transaction: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	defer tx.Rollback()
+
+	if err = saveNotificationPreferences(tx, userID, req); err != nil {
+		logger.Error("Failed to update notification preferences: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update notification preferences"})
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		logger.Error("Failed to commit transaction: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update notification preferences"})
+		return
+	}
+
+	preferences, err := loadNotificationPreferences(userID)
+	if err != nil {
+		logger.Error("Failed to get notification preferences: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update notification preferences"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "Notification preferences updated successfully",
+		"preferences": preferences,
+	})
+}
+
+// notificationPreferencesRequest 通知类型到设置的映射，未提供的设置保持不变
+type notificationPreferencesRequest map[string]struct {
+	InApp *bool `json:"in_app"`
+	Push  *bool `json:"push"`
+}
+
+// validateNotificationPreferences 检查通知类型，失败时已写入响应
+func validateNotificationPreferences(c *gin.Context, req notificationPreferencesRequest) bool {
+	for notificationType := range req {
+		if !notifications.ValidType(notificationType) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid notification type: " + notificationType})
+			return false
+		}
+	}
+	return true
+}
+
+// saveNotificationPreferences 在事务中保存通知设置
+func saveNotificationPreferences(tx *sql.Tx, userID int, req notificationPreferencesRequest) error {
+	for notificationType, preference := range req {
+		if preference.InApp == nil && preference.Push == nil {
+			continue
+		}
+		_, err := tx.Exec(`
+			INSERT INTO notification_preferences (user_id, type, in_app, push) VALUES (?, ?, COALESCE(?, TRUE), COALESCE(?, TRUE))
+			ON CONFLICT (user_id, type) DO UPDATE SET in_app = COALESCE(?, in_app), push = COALESCE(?, push)`,
+			userID, notificationType, preference.InApp, preference.Push, preference.InApp, preference.Push,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadNotificationPreferences 获取用户所有通知类型的设置
+func loadNotificationPreferences(userID int) (map[string]gin.H, error) {
+	preferences := map[string]gin.H{}
+	for _, notificationType := range notifications.Types {
+		preferences[notificationType] = gin.H{"in_app": true, "push": true}
+	}
+
+	rows, err := database.DB.Query("SELECT type, in_app, push FROM notification_preferences WHERE user_id = ?", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var notificationType string
+		var inApp, push bool
+		if err := rows.Scan(&notificationType, &inApp, &push); err != nil {
+			return nil, err
+		}
+		if preference, ok := preferences[notificationType]; ok {
+			preference["in_app"] = inApp
+			preference["push"] = push
+		}
+	}
+	return preferences, rows.Err()
+}
+
+// unreadNotificationCounts 未读通知总数及按类型的数量
+func unreadNotificationCounts(userID int) (int, map[string]int, error) {
+	byType := map[string]int{}
+	for _, notificationType := range notifications.Types {
+		byType[notificationType] = 0
+	}
+
+	rows, err := database.DB.Query(
+		"SELECT type, COUNT(*) FROM notifications WHERE user_id = ? AND read_at IS NULL GROUP BY type",
+		userID,
+	)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer rows.Close()
+
+	total := 0
+	for rows.Next() {
+		var notificationType string
+		var count int
+		if err := rows.Scan(&notificationType, &count); err != nil {
+			return 0, nil, err
+		}
+		byType[notificationType] = count
+		total += count
+	}
+	return total, byType, rows.Err()
+}
+
+// sendNotification 在事务中写入通知，失败只记录日志，不影响操作本身
+func sendNotification(tx *sql.Tx, n notifications.Notification) {
+	if err := notifications.Send(tx, n); err != nil {
+		logger.Error("Failed to send notification: " + err.Error())
+	}
+}
+
+// pushNotification 事务提交后把通知推送到手机，失败只记录日志
+func pushNotification(n notifications.Notification) {
+	if err := notifications.Push(n); err != nil {
+		logger.Error("Failed to push notification: " + err.Error())
+	}
+}
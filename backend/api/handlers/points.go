@@ -2,10 +2,13 @@ package handlers
 
 import (
 	"database/sql"
+	"io"
 	"net/http"
 	"strconv"
+	"time"
 
 	"booonus-backend/internal/database"
+	"booonus-backend/internal/permissions"
 	"booonus-backend/models"
 	"booonus-backend/pkg/logger"
 
@@ -48,70 +51,11 @@ func GetPoints(c *gin.Context) {
 }
 
 // GetPointsHistory 获取积分变化历史
+// 支持游标翻页（cursor）、过滤条件和增量同步（since），参数见 historyFilters 和 listPointsHistory
 func GetPointsHistory(c *gin.Context) {
 	userID := c.GetInt("user_id")
 
-	// 获取查询参数
-	limitStr := c.DefaultQuery("limit", "50")
-	offsetStr := c.DefaultQuery("offset", "0")
-
-	limit, _ := strconv.Atoi(limitStr)
-	offset, _ := strconv.Atoi(offsetStr)
-
-	// 查询积分历史
-	query := `
-		SELECT id, user_id, points, type, reference_id, description,
-		       can_revert, is_reverted, created_at
-		FROM points_history
-		WHERE user_id = ?
-		ORDER BY created_at DESC
-		LIMIT ? OFFSET ?
-	`
-
-	rows, err := database.DB.Query(query, userID, limit, offset)
-	if err != nil {
-		logger.Error("Failed to get points history: " + err.Error())
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get points history"})
-		return
-	}
-	defer rows.Close()
-
-	var history []models.PointsHistory
-	for rows.Next() {
-		var h models.PointsHistory
-		var referenceID sql.NullInt64
-
-		err := rows.Scan(
-			&h.ID, &h.UserID, &h.Points, &h.Type, &referenceID,
-			&h.Description, &h.CanRevert, &h.IsReverted, &h.CreatedAt,
-		)
-		if err != nil {
-			logger.Error("Failed to scan points history: " + err.Error())
-			continue
-		}
-
-		if referenceID.Valid {
-			refID := int(referenceID.Int64)
-			h.ReferenceID = refID
-		}
-
-		history = append(history, h)
-	}
-
-	// 获取总数
-	var total int
-	err = database.DB.QueryRow("SELECT COUNT(*) FROM points_history WHERE user_id = ?", userID).Scan(&total)
-	if err != nil {
-		logger.Error("Failed to get points history count: " + err.Error())
-		total = len(history)
-	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"history": history,
-		"total":   total,
-		"limit":   limit,
-		"offset":  offset,
-	})
+	listPointsHistory(c, userID)
 }
 
 // GetUserPointsHistory 获取指定用户的积分变化历史
@@ -131,112 +75,42 @@ func GetUserPointsHistory(c *gin.Context) {
 		return
 	}
 
-	// 获取查询参数
-	limitStr := c.DefaultQuery("limit", "50")
-	offsetStr := c.DefaultQuery("offset", "0")
-
-	limit, _ := strconv.Atoi(limitStr)
-	offset, _ := strconv.Atoi(offsetStr)
-
-	// 查询积分历史
-	query := `
-		SELECT id, user_id, points, type, reference_id, description,
-		       can_revert, is_reverted, created_at
-		FROM points_history
-		WHERE user_id = ?
-		ORDER BY created_at DESC
-		LIMIT ? OFFSET ?
-	`
-
-	rows, err := database.DB.Query(query, targetUserID, limit, offset)
-	if err != nil {
-		logger.Error("Failed to get user points history: " + err.Error())
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get points history"})
-		return
-	}
-	defer rows.Close()
-
-	var history []models.PointsHistory
-	for rows.Next() {
-		var h models.PointsHistory
-		var referenceID sql.NullInt64
-
-		err := rows.Scan(
-			&h.ID, &h.UserID, &h.Points, &h.Type, &referenceID,
-			&h.Description, &h.CanRevert, &h.IsReverted, &h.CreatedAt,
-		)
-		if err != nil {
-			logger.Error("Failed to scan user points history: " + err.Error())
-			continue
-		}
-
-		if referenceID.Valid {
-			refID := int(referenceID.Int64)
-			h.ReferenceID = refID
-		}
-
-		history = append(history, h)
-	}
-
-	// 获取总数
-	var total int
-	err = database.DB.QueryRow("SELECT COUNT(*) FROM points_history WHERE user_id = ?", targetUserID).Scan(&total)
-	if err != nil {
-		logger.Error("Failed to get user points history count: " + err.Error())
-		total = len(history)
-	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"history": history,
-		"total":   total,
-		"limit":   limit,
-		"offset":  offset,
-	})
+	listPointsHistory(c, targetUserID)
 }
 
-// GetCoupleRecentHistory 获取情侣双方的近期积分变化记录
+// GetCoupleRecentHistory 获取家庭所有成员的近期积分变化记录
 func GetCoupleRecentHistory(c *gin.Context) {
 	userID := c.GetInt("user_id")
 
-	// 获取情侣信息
-	var coupleID sql.NullInt64
-	var partnerID int
-
-	// 查询当前用户的情侣关系
-	query := `
-		SELECT c.id,
-		       CASE WHEN c.user1_id = ? THEN c.user2_id ELSE c.user1_id END as partner_id
-		FROM couples c
-		WHERE c.user1_id = ? OR c.user2_id = ?
-	`
-
-	err := database.DB.QueryRow(query, userID, userID, userID).Scan(&coupleID, &partnerID)
+	// 获取家庭成员
+	memberIDs, err := coupleMemberIDs(userID)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			c.JSON(http.StatusNotFound, gin.H{"error": "No couple relationship found"})
-			return
-		}
 		logger.Error("Failed to get couple info: " + err.Error())
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get couple info"})
 		return
 	}
+	if len(memberIDs) < 2 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No couple relationship found"})
+		return
+	}
 
 	// 获取查询参数，默认获取最近5条记录
 	limitStr := c.DefaultQuery("limit", "5")
 	limit, _ := strconv.Atoi(limitStr)
 
-	// 查询双方的积分历史，按时间倒序排列
+	// 查询所有成员的积分历史，按时间倒序排列
+	inClause, args := memberInClause(memberIDs)
 	historyQuery := `
-		SELECT ph.id, ph.user_id, ph.points, ph.type, ph.reference_id, ph.description,
-		       ph.can_revert, ph.is_reverted, ph.created_at, u.username
+		SELECT ` + pointsHistoryColumns + `, u.username
 		FROM points_history ph
+		` + pointsHistoryJoins + `
 		JOIN users u ON ph.user_id = u.id
-		WHERE ph.user_id IN (?, ?)
+		WHERE ph.user_id IN (` + inClause + `)
 		ORDER BY ph.created_at DESC
 		LIMIT ?
 	`
 
-	rows, err := database.DB.Query(historyQuery, userID, partnerID, limit)
+	rows, err := database.DB.Query(historyQuery, append(args, limit)...)
 	if err != nil {
 		logger.Error("Failed to get couple recent history: " + err.Error())
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get recent history"})
@@ -246,48 +120,170 @@ func GetCoupleRecentHistory(c *gin.Context) {
 
 	type HistoryWithUser struct {
 		models.PointsHistory
-		Username string `json:"username"`
+		Username     string                 `json:"username"`
+		Reactions    []models.ReactionCount `json:"reactions"`
+		CommentCount int                    `json:"comment_count"`
 	}
 
 	var history []HistoryWithUser
 	for rows.Next() {
 		var h HistoryWithUser
-		var referenceID sql.NullInt64
+		var err error
 
-		err := rows.Scan(
-			&h.ID, &h.UserID, &h.Points, &h.Type, &referenceID,
-			&h.Description, &h.CanRevert, &h.IsReverted, &h.CreatedAt, &h.Username,
-		)
+		h.PointsHistory, err = scanPointsHistory(rows, &h.Username)
 		if err != nil {
 			logger.Error("Failed to scan couple recent history: " + err.Error())
 			continue
 		}
 
-		if referenceID.Valid {
-			refID := int(referenceID.Int64)
-			h.ReferenceID = refID
-		}
-
 		history = append(history, h)
 	}
 
+	// 附加表情回应汇总和评论数
+	historyIDs := make([]int, 0, len(history))
+	for _, h := range history {
+		historyIDs = append(historyIDs, h.ID)
+	}
+	reactions, commentCounts, err := interactionSummary("points_history", historyIDs)
+	if err != nil {
+		logger.Error("Failed to get history reactions: " + err.Error())
+	} else {
+		for i := range history {
+			history[i].Reactions = reactionsOrEmpty(reactions[history[i].ID])
+			history[i].CommentCount = commentCounts[history[i].ID]
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"history": history,
 		"limit":   limit,
 	})
 }
 
-// addPointsHistory 添加积分历史记录（内部函数）
-func addPointsHistory(tx *sql.Tx, userID, points int, historyType string, referenceID *int, description string, canRevert bool) error {
-	var refID interface{}
-	if referenceID != nil {
-		refID = *referenceID
+// pointsHistoryColumns 查询积分历史时的公共列（表别名为 ph），与 scanPointsHistory 对应
+const pointsHistoryColumns = `ph.id, ph.user_id, ph.points, ph.type, ph.reference_id, ph.description,
+		       ph.can_revert, ph.is_reverted, ph.created_at, ph.rule_revision_id, ph.execution_id, ph.import_id,
+		       re.executed_by, re.note, re.attachment_id,
+		       ra.actor_id, rau.username, ra.reason, ra.created_at`
+
+// pointsHistoryJoins 查询 pointsHistoryColumns 时需要的关联，ra 为该记录最近一次撤销操作
+const pointsHistoryJoins = `LEFT JOIN rule_executions re ON ph.execution_id = re.id
+		LEFT JOIN revert_actions ra ON ra.id = (
+			SELECT MAX(id) FROM revert_actions WHERE history_id = ph.id AND action = 'revert'
+		)
+		LEFT JOIN users rau ON ra.actor_id = rau.id`
+
+// rowScanner 兼容 *sql.Row 和 *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanPointsHistory 扫描一行积分历史，extra 用于接收 pointsHistoryColumns 之后的额外列
+func scanPointsHistory(row rowScanner, extra ...interface{}) (models.PointsHistory, error) {
+	var h models.PointsHistory
+	var referenceID, ruleRevisionID, executionID, importID sql.NullInt64
+	var executedBy, attachmentID, revertedBy sql.NullInt64
+	var note, revertedByName, revertReason sql.NullString
+	var revertedAt *time.Time
+
+	dest := []interface{}{
+		&h.ID, &h.UserID, &h.Points, &h.Type, &referenceID,
+		&h.Description, &h.CanRevert, &h.IsReverted, &h.CreatedAt, &ruleRevisionID, &executionID, &importID,
+		&executedBy, &note, &attachmentID,
+		&revertedBy, &revertedByName, &revertReason, &revertedAt,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return h, err
+	}
+
+	if referenceID.Valid {
+		h.ReferenceID = int(referenceID.Int64)
+	}
+	if ruleRevisionID.Valid {
+		revisionID := int(ruleRevisionID.Int64)
+		h.RuleRevisionID = &revisionID
+	}
+	if executionID.Valid {
+		id := int(executionID.Int64)
+		h.ExecutionID = &id
+	}
+	if importID.Valid {
+		id := int(importID.Int64)
+		h.ImportID = &id
+	}
+	if executedBy.Valid {
+		id := int(executedBy.Int64)
+		h.ExecutedBy = &id
+	}
+	if note.Valid {
+		h.Note = &note.String
+	}
+	if attachmentID.Valid {
+		id := int(attachmentID.Int64)
+		h.AttachmentID = &id
+	}
+
+	// 撤销后又取消撤销的记录不显示撤销信息
+	if h.IsReverted && revertedBy.Valid {
+		id := int(revertedBy.Int64)
+		h.RevertedBy = &id
+		if revertedByName.Valid {
+			h.RevertedByName = &revertedByName.String
+		}
+		if revertReason.Valid && revertReason.String != "" {
+			h.RevertReason = &revertReason.String
+		}
+		h.RevertedAt = revertedAt
+	}
+
+	return h, nil
+}
+
+// pointsHistoryEntry 待写入的积分历史记录
+type pointsHistoryEntry struct {
+	UserID         int
+	Points         int
+	Type           string
+	ReferenceID    *int
+	Description    string
+	CanRevert      bool
+	RuleRevisionID *int
+	ExecutionID    *int
+}
+
+// insertPointsHistory 写入积分历史记录并返回记录ID（内部函数）
+func insertPointsHistory(tx *sql.Tx, entry pointsHistoryEntry) (int64, error) {
+	var refID, revisionID, executionID interface{}
+	if entry.ReferenceID != nil {
+		refID = *entry.ReferenceID
+	}
+	if entry.RuleRevisionID != nil {
+		revisionID = *entry.RuleRevisionID
+	}
+	if entry.ExecutionID != nil {
+		executionID = *entry.ExecutionID
 	}
 
-	_, err := tx.Exec(
-		"INSERT INTO points_history (user_id, points, type, reference_id, description, can_revert) VALUES (?, ?, ?, ?, ?, ?)",
-		userID, points, historyType, refID, description, canRevert,
+	result, err := tx.Exec(
+		"INSERT INTO points_history (user_id, points, type, reference_id, description, can_revert, rule_revision_id, execution_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		entry.UserID, entry.Points, entry.Type, refID, entry.Description, entry.CanRevert, revisionID, executionID,
 	)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// addPointsHistory 添加积分历史记录（内部函数）
+func addPointsHistory(tx *sql.Tx, userID, points int, historyType string, referenceID *int, description string, canRevert bool) error {
+	_, err := insertPointsHistory(tx, pointsHistoryEntry{
+		UserID:      userID,
+		Points:      points,
+		Type:        historyType,
+		ReferenceID: referenceID,
+		Description: description,
+		CanRevert:   canRevert,
+	})
 	return err
 }
 
@@ -308,39 +304,61 @@ func RevertOperation(c *gin.Context) {
 		return
 	}
 
+	var req revertRequest
+	if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	affectedIDs, ok := revertHistoryEntry(c, userID, historyID, req.Reason)
+	if !ok {
+		return
+	}
+
+	logger.Info("Operation reverted: " + strconv.Itoa(historyID) + " by user " + strconv.Itoa(userID))
+	c.JSON(http.StatusOK, gin.H{
+		"message":              "Operation reverted successfully",
+		"reverted_history_ids": affectedIDs,
+	})
+}
+
+// revertHistoryEntry 撤销一条积分记录及其关联记录，返回所有被撤销的记录ID，失败时已写入响应
+func revertHistoryEntry(c *gin.Context, userID, historyID int, reason string) ([]int, bool) {
 	// 获取历史记录
 	var history models.PointsHistory
 
-	err = database.DB.QueryRow(
-		"SELECT id, user_id, points, type, reference_id, description, can_revert, is_reverted FROM points_history WHERE id = ?",
+	var executionID sql.NullInt64
+
+	err := database.DB.QueryRow(
+		"SELECT id, user_id, points, type, COALESCE(reference_id, 0), description, can_revert, is_reverted, created_at, execution_id FROM points_history WHERE id = ?",
 		historyID,
-	).Scan(&history.ID, &history.UserID, &history.Points, &history.Type, &history.ReferenceID, &history.Description, &history.CanRevert, &history.IsReverted)
+	).Scan(&history.ID, &history.UserID, &history.Points, &history.Type, &history.ReferenceID, &history.Description, &history.CanRevert, &history.IsReverted, &history.CreatedAt, &executionID)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
 			c.JSON(http.StatusNotFound, gin.H{"error": "History record not found"})
-			return
+			return nil, false
 		}
 		logger.Error("Failed to get history record: " + err.Error())
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get history record"})
-		return
+		return nil, false
 	}
 
 	// 检查权限（只能撤销自己相关的记录或情侣的记录）
 	if !canUserRevertHistory(userID, history.UserID) {
 		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
-		return
+		return nil, false
 	}
 
 	// 检查是否可以撤销
 	if !history.CanRevert {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "This operation cannot be reverted"})
-		return
+		return nil, false
 	}
 
 	if history.IsReverted {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "This operation has already been reverted"})
-		return
+		return nil, false
 	}
 
 	// 开始事务
@@ -348,44 +366,95 @@ func RevertOperation(c *gin.Context) {
 	if err != nil {
 		logger.Error("Failed to begin transaction: " + err.Error())
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
-		return
+		return nil, false
 	}
 	defer tx.Rollback()
 
-	// 撤销积分变化
-	err = updateUserPoints(tx, history.UserID, -history.Points)
+	// 检查情侣的撤销设置（时间窗口、原因、次数）
+	if !checkRevertPolicy(c, tx, history, "revert", reason) {
+		return nil, false
+	}
+
+	// 先按条件标记为已撤销，并发的撤销请求只有一个能成功
+	result, err := tx.Exec("UPDATE points_history SET is_reverted = TRUE WHERE id = ? AND is_reverted = FALSE", historyID)
 	if err != nil {
-		logger.Error("Failed to revert points: " + err.Error())
+		logger.Error("Failed to mark as reverted: " + err.Error())
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revert operation"})
-		return
+		return nil, false
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "This operation has already been reverted"})
+		return nil, false
 	}
 
-	// 标记为已撤销
-	_, err = tx.Exec("UPDATE points_history SET is_reverted = TRUE WHERE id = ?", historyID)
+	// 购买记录需要同时撤销交易本身（状态、库存），已兑现的不能撤销
+	if history.Type == "transaction" && history.ReferenceID != 0 {
+		if err = revertTransactionState(tx, history.ReferenceID); err != nil {
+			respondTransactionStateError(c, err, "Failed to revert operation")
+			return nil, false
+		}
+	}
+
+	// 撤销积分变化
+	err = updateUserPoints(tx, history.UserID, -history.Points)
 	if err != nil {
-		logger.Error("Failed to mark as reverted: " + err.Error())
+		logger.Error("Failed to revert points: " + err.Error())
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revert operation"})
-		return
+		return nil, false
 	}
+
+	affectedIDs := []int{historyID}
+
 	// 如果是交易类型，需要同时撤销对方的记录
 	if history.Type == "transaction" && history.ReferenceID != 0 {
-		err = revertRelatedTransactionRecord(tx, history.ReferenceID, historyID)
+		relatedIDs, err := revertRelatedTransactionRecord(tx, history.ReferenceID, historyID)
 		if err != nil {
 			logger.Error("Failed to revert related transaction record: " + err.Error())
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revert operation"})
-			return
+			return nil, false
 		}
+		affectedIDs = append(affectedIDs, relatedIDs...)
+	}
+
+	// 如果是同时作用于双方的规则执行，需要同时撤销同一次执行的其他记录
+	if history.Type == "rule" && executionID.Valid {
+		relatedIDs, err := revertRelatedExecutionRecords(tx, int(executionID.Int64), historyID)
+		if err != nil {
+			logger.Error("Failed to revert related execution records: " + err.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revert operation"})
+			return nil, false
+		}
+		affectedIDs = append(affectedIDs, relatedIDs...)
+	}
+
+	// 记录审计日志，关联记录也各记一条，便于在双方的历史中显示
+	if err = recordRevertActions(tx, affectedIDs, "revert", userID, reason); err != nil {
+		logger.Error("Failed to record revert action: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revert operation"})
+		return nil, false
+	}
+
+	revertEvent := gin.H{
+		"action":      "revert",
+		"history_id":  historyID,
+		"history_ids": affectedIDs,
+	}
+	if err := queueWebhooks(tx, c, streamRevert, revertEvent); err != nil {
+		logger.Error("Failed to queue webhooks: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revert operation"})
+		return nil, false
 	}
 
 	// 提交事务
 	if err = tx.Commit(); err != nil {
 		logger.Error("Failed to commit transaction: " + err.Error())
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revert operation"})
-		return
+		return nil, false
 	}
 
-	logger.Info("Operation reverted: " + strconv.Itoa(historyID) + " by user " + strconv.Itoa(userID))
-	c.JSON(http.StatusOK, gin.H{"message": "Operation reverted successfully"})
+	publishEvent(c, streamRevert, revertEvent)
+
+	return affectedIDs, true
 }
 
 // CancelRevertOperation 取消撤销操作
@@ -405,26 +474,47 @@ func CancelRevertOperation(c *gin.Context) {
 		return
 	}
 
+	var req revertRequest
+	if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	affectedIDs, ok := cancelRevertHistoryEntry(c, userID.(int), historyID, req.Reason)
+	if !ok {
+		return
+	}
+
+	logger.Info("Operation revert cancelled successfully for history ID: " + strconv.Itoa(historyID))
+	c.JSON(http.StatusOK, gin.H{
+		"message":              "Operation revert cancelled successfully",
+		"restored_history_ids": affectedIDs,
+	})
+}
+
+// cancelRevertHistoryEntry 恢复一条已撤销的积分记录及其关联记录，返回所有被恢复的记录ID，失败时已写入响应
+func cancelRevertHistoryEntry(c *gin.Context, userID, historyID int, reason string) ([]int, bool) {
 	// 开始事务
 	tx, err := database.DB.Begin()
 	if err != nil {
 		logger.Error("Failed to begin transaction: " + err.Error())
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel revert operation"})
-		return
+		return nil, false
 	}
 	defer tx.Rollback()
 
 	// 获取历史记录
 	var history models.PointsHistory
+	var executionID sql.NullInt64
 	query := `
-		SELECT id, user_id, points, type, reference_id, description, can_revert, is_reverted, created_at
+		SELECT id, user_id, points, type, COALESCE(reference_id, 0), description, can_revert, is_reverted, created_at, execution_id
 		FROM points_history
 		WHERE id = ?
 	`
 	err = tx.QueryRow(query, historyID).Scan(
 		&history.ID, &history.UserID, &history.Points, &history.Type,
 		&history.ReferenceID, &history.Description, &history.CanRevert,
-		&history.IsReverted, &history.CreatedAt,
+		&history.IsReverted, &history.CreatedAt, &executionID,
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -433,65 +523,115 @@ func CancelRevertOperation(c *gin.Context) {
 			logger.Error("Failed to get history record: " + err.Error())
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel revert operation"})
 		}
-		return
+		return nil, false
 	}
 
 	// 检查权限
-	if !canUserRevertHistory(userID.(int), history.UserID) {
+	if !canUserRevertHistory(userID, history.UserID) {
 		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
-		return
+		return nil, false
 	}
 
 	// 检查是否可以取消撤销
 	if !history.CanRevert {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "This operation cannot be reverted"})
-		return
+		return nil, false
 	}
 
 	if !history.IsReverted {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "This operation has not been reverted"})
-		return
+		return nil, false
 	}
 
-	// 恢复积分变化（重新应用原来的积分变化）
-	err = updateUserPoints(tx, history.UserID, history.Points)
+	// 检查情侣的撤销设置（时间窗口、原因）
+	if !checkRevertPolicy(c, tx, history, "cancel_revert", reason) {
+		return nil, false
+	}
+
+	// 先按条件标记为未撤销，并发的取消撤销请求只有一个能成功
+	result, err := tx.Exec("UPDATE points_history SET is_reverted = FALSE WHERE id = ? AND is_reverted = TRUE", historyID)
 	if err != nil {
-		logger.Error("Failed to restore points: " + err.Error())
+		logger.Error("Failed to mark as not reverted: " + err.Error())
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel revert operation"})
-		return
+		return nil, false
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "This operation has not been reverted"})
+		return nil, false
+	}
+
+	// 购买记录需要同时恢复交易本身（状态、库存）
+	if history.Type == "transaction" && history.ReferenceID != 0 {
+		if err = restoreTransactionState(tx, history.ReferenceID); err != nil {
+			respondTransactionStateError(c, err, "Failed to cancel revert operation")
+			return nil, false
+		}
 	}
 
-	// 标记为未撤销
-	_, err = tx.Exec("UPDATE points_history SET is_reverted = FALSE WHERE id = ?", historyID)
+	// 恢复积分变化（重新应用原来的积分变化）
+	err = updateUserPoints(tx, history.UserID, history.Points)
 	if err != nil {
-		logger.Error("Failed to mark as not reverted: " + err.Error())
+		logger.Error("Failed to restore points: " + err.Error())
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel revert operation"})
-		return
+		return nil, false
 	}
 
+	affectedIDs := []int{historyID}
+
 	// 如果是交易类型，需要同时恢复对方的记录
 	if history.Type == "transaction" && history.ReferenceID != 0 {
-		err = cancelRevertRelatedTransactionRecord(tx, history.ReferenceID, historyID)
+		relatedIDs, err := cancelRevertRelatedTransactionRecord(tx, history.ReferenceID, historyID)
 		if err != nil {
 			logger.Error("Failed to cancel revert related transaction record: " + err.Error())
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel revert operation"})
-			return
+			return nil, false
 		}
+		affectedIDs = append(affectedIDs, relatedIDs...)
+	}
+
+	// 如果是同时作用于双方的规则执行，需要同时恢复同一次执行的其他记录
+	if history.Type == "rule" && executionID.Valid {
+		relatedIDs, err := cancelRevertRelatedExecutionRecords(tx, int(executionID.Int64), historyID)
+		if err != nil {
+			logger.Error("Failed to cancel revert related execution records: " + err.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel revert operation"})
+			return nil, false
+		}
+		affectedIDs = append(affectedIDs, relatedIDs...)
+	}
+
+	// 记录审计日志
+	if err = recordRevertActions(tx, affectedIDs, "cancel_revert", userID, reason); err != nil {
+		logger.Error("Failed to record revert action: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel revert operation"})
+		return nil, false
+	}
+
+	revertEvent := gin.H{
+		"action":      "cancel_revert",
+		"history_id":  historyID,
+		"history_ids": affectedIDs,
+	}
+	if err := queueWebhooks(tx, c, streamRevert, revertEvent); err != nil {
+		logger.Error("Failed to queue webhooks: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel revert operation"})
+		return nil, false
 	}
 
 	// 提交事务
 	if err = tx.Commit(); err != nil {
 		logger.Error("Failed to commit transaction: " + err.Error())
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel revert operation"})
-		return
+		return nil, false
 	}
 
-	logger.Info("Operation revert cancelled successfully for history ID: " + strconv.Itoa(historyID))
-	c.JSON(http.StatusOK, gin.H{"message": "Operation revert cancelled successfully"})
+	publishEvent(c, streamRevert, revertEvent)
+
+	return affectedIDs, true
 }
 
-// revertRelatedTransactionRecord 撤销相关的交易记录
-func revertRelatedTransactionRecord(tx *sql.Tx, transactionID, excludeHistoryID int) error {
+// revertRelatedTransactionRecord 撤销相关的交易记录，返回被撤销的记录ID
+func revertRelatedTransactionRecord(tx *sql.Tx, transactionID, excludeHistoryID int) ([]int, error) {
 	// 查找同一个交易的其他积分历史记录
 	query := `
 		SELECT id, user_id, points
@@ -504,37 +644,40 @@ func revertRelatedTransactionRecord(tx *sql.Tx, transactionID, excludeHistoryID
 
 	rows, err := tx.Query(query, transactionID, excludeHistoryID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer rows.Close()
 
+	var relatedIDs []int
+
 	for rows.Next() {
 		var relatedID, relatedUserID, relatedPoints int
 		err := rows.Scan(&relatedID, &relatedUserID, &relatedPoints)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		// 撤销相关用户的积分变化
 		err = updateUserPoints(tx, relatedUserID, -relatedPoints)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		// 标记相关记录为已撤销
 		_, err = tx.Exec("UPDATE points_history SET is_reverted = TRUE WHERE id = ?", relatedID)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
+		relatedIDs = append(relatedIDs, relatedID)
 		logger.Info("Related transaction record reverted: " + strconv.Itoa(relatedID))
 	}
 
-	return nil
+	return relatedIDs, rows.Err()
 }
 
-// cancelRevertRelatedTransactionRecord 取消撤销相关的交易记录
-func cancelRevertRelatedTransactionRecord(tx *sql.Tx, transactionID, excludeHistoryID int) error {
+// cancelRevertRelatedTransactionRecord 取消撤销相关的交易记录，返回被恢复的记录ID
+func cancelRevertRelatedTransactionRecord(tx *sql.Tx, transactionID, excludeHistoryID int) ([]int, error) {
 	// 查找同一个交易的其他积分历史记录
 	query := `
 		SELECT id, user_id, points
@@ -547,50 +690,130 @@ func cancelRevertRelatedTransactionRecord(tx *sql.Tx, transactionID, excludeHist
 
 	rows, err := tx.Query(query, transactionID, excludeHistoryID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer rows.Close()
 
+	var relatedIDs []int
+
 	for rows.Next() {
 		var relatedID, relatedUserID, relatedPoints int
 		err := rows.Scan(&relatedID, &relatedUserID, &relatedPoints)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		// 恢复相关用户的积分变化（重新应用原来的积分变化）
 		err = updateUserPoints(tx, relatedUserID, relatedPoints)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		// 标记相关记录为未撤销
 		_, err = tx.Exec("UPDATE points_history SET is_reverted = FALSE WHERE id = ?", relatedID)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
+		relatedIDs = append(relatedIDs, relatedID)
 		logger.Info("Related transaction record revert cancelled: " + strconv.Itoa(relatedID))
 	}
 
-	return nil
+	return relatedIDs, rows.Err()
 }
 
-// canUserRevertHistory 检查用户是否可以撤销某个历史记录
-func canUserRevertHistory(userID, targetUserID int) bool {
-	// 可以撤销自己的记录
-	if userID == targetUserID {
-		return true
+// revertRelatedExecutionRecords 撤销同一次规则执行产生的其他积分记录，返回被撤销的记录ID
+func revertRelatedExecutionRecords(tx *sql.Tx, executionID, excludeHistoryID int) ([]int, error) {
+	query := `
+		SELECT id, user_id, points
+		FROM points_history
+		WHERE type = 'rule'
+		AND execution_id = ?
+		AND id != ?
+		AND is_reverted = FALSE
+	`
+
+	rows, err := tx.Query(query, executionID, excludeHistoryID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var relatedIDs []int
+
+	for rows.Next() {
+		var relatedID, relatedUserID, relatedPoints int
+		err := rows.Scan(&relatedID, &relatedUserID, &relatedPoints)
+		if err != nil {
+			return nil, err
+		}
+
+		// 撤销相关用户的积分变化
+		err = updateUserPoints(tx, relatedUserID, -relatedPoints)
+		if err != nil {
+			return nil, err
+		}
+
+		// 标记相关记录为已撤销
+		_, err = tx.Exec("UPDATE points_history SET is_reverted = TRUE WHERE id = ?", relatedID)
+		if err != nil {
+			return nil, err
+		}
+
+		relatedIDs = append(relatedIDs, relatedID)
+		logger.Info("Related execution record reverted: " + strconv.Itoa(relatedID))
 	}
 
-	// 检查是否是情侣关系
-	var count int
+	return relatedIDs, rows.Err()
+}
+
+// cancelRevertRelatedExecutionRecords 取消撤销同一次规则执行产生的其他积分记录，返回被恢复的记录ID
+func cancelRevertRelatedExecutionRecords(tx *sql.Tx, executionID, excludeHistoryID int) ([]int, error) {
 	query := `
-		SELECT COUNT(*) FROM couples c
-		JOIN users u1 ON (c.user1_id = u1.id OR c.user2_id = u1.id)
-		JOIN users u2 ON (c.user1_id = u2.id OR c.user2_id = u2.id)
-		WHERE u1.id = ? AND u2.id = ?
+		SELECT id, user_id, points
+		FROM points_history
+		WHERE type = 'rule'
+		AND execution_id = ?
+		AND id != ?
+		AND is_reverted = TRUE
 	`
-	err := database.DB.QueryRow(query, userID, targetUserID).Scan(&count)
-	return err == nil && count > 0
+
+	rows, err := tx.Query(query, executionID, excludeHistoryID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var relatedIDs []int
+
+	for rows.Next() {
+		var relatedID, relatedUserID, relatedPoints int
+		err := rows.Scan(&relatedID, &relatedUserID, &relatedPoints)
+		if err != nil {
+			return nil, err
+		}
+
+		// 恢复相关用户的积分变化（重新应用原来的积分变化）
+		err = updateUserPoints(tx, relatedUserID, relatedPoints)
+		if err != nil {
+			return nil, err
+		}
+
+		// 标记相关记录为未撤销
+		_, err = tx.Exec("UPDATE points_history SET is_reverted = FALSE WHERE id = ?", relatedID)
+		if err != nil {
+			return nil, err
+		}
+
+		relatedIDs = append(relatedIDs, relatedID)
+		logger.Info("Related execution record revert cancelled: " + strconv.Itoa(relatedID))
+	}
+
+	return relatedIDs, rows.Err()
+}
+
+// canUserRevertHistory 检查用户是否可以撤销某个历史记录
+func canUserRevertHistory(userID, targetUserID int) bool {
+	// 可以撤销自己的记录，也可以撤销同一家庭成员的记录
+	return userID == targetUserID || permissions.SameHousehold(userID, targetUserID)
 }
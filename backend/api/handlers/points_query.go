@@ -0,0 +1,342 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"booonus-backend/internal/database"
+	"booonus-backend/models"
+	"booonus-backend/pkg/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 积分历史分页参数
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 200
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// historyCursor 分页游标，指向上一页最后一条记录的 (时间, ID)
+type historyCursor struct {
+	Time string // 数据库中的时间格式
+	ID   int
+}
+
+// encode 编码为不透明的字符串
+func (cur historyCursor) encode() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(cur.Time + "|" + strconv.Itoa(cur.ID)))
+}
+
+// decodeHistoryCursor 解析游标字符串
+func decodeHistoryCursor(s string) (historyCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return historyCursor{}, errInvalidCursor
+	}
+	parts := strings.SplitN(string(raw), "|", 2)
+	if len(parts) != 2 {
+		return historyCursor{}, errInvalidCursor
+	}
+	if _, err := time.Parse(database.TimeLayout, parts[0]); err != nil {
+		return historyCursor{}, errInvalidCursor
+	}
+	id, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return historyCursor{}, errInvalidCursor
+	}
+	return historyCursor{Time: parts[0], ID: id}, nil
+}
+
+// syncCursorPrefix 增量同步游标的前缀，之后是 points_history.change_seq
+const syncCursorPrefix = "seq|"
+
+// encodeSyncCursor 将变化序号编码为不透明的 next_since
+func encodeSyncCursor(seq int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(syncCursorPrefix + strconv.FormatInt(seq, 10)))
+}
+
+// parseSince 解析增量同步的起点，返回变化序号：上次返回的 next_since，或 RFC3339 时间
+// 早期版本返回的 next_since 为 (时间, ID) 游标，和 RFC3339 时间一样按时间换算
+func parseSince(s string, userID int) (int64, error) {
+	var since string
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		since = dbTime(t)
+	} else if raw, err := base64.RawURLEncoding.DecodeString(s); err == nil && strings.HasPrefix(string(raw), syncCursorPrefix) {
+		seq, err := strconv.ParseInt(strings.TrimPrefix(string(raw), syncCursorPrefix), 10, 64)
+		if err != nil || seq < 0 {
+			return 0, errInvalidCursor
+		}
+		return seq, nil
+	} else if cursor, err := decodeHistoryCursor(s); err == nil {
+		since = cursor.Time
+	} else {
+		return 0, errInvalidCursor
+	}
+	return changeSeqSince(userID, since)
+}
+
+// changeSeqSince 把时间换算为变化序号：返回的序号之后包含 since 及之后的所有变化（可能多包含几条同一时间附近的记录）
+func changeSeqSince(userID int, since string) (int64, error) {
+	var seq int64
+	err := database.DB.QueryRow(`
+		SELECT COALESCE(
+			(SELECT MIN(p.change_seq) - 1 FROM points_history p
+			 WHERE p.user_id = ?
+			   AND MAX(p.created_at, COALESCE((SELECT MAX(created_at) FROM revert_actions WHERE history_id = p.id), p.created_at)) >= ?),
+			(SELECT COALESCE(MAX(change_seq), 0) FROM points_history)
+		)`,
+		userID, since,
+	).Scan(&seq)
+	return seq, err
+}
+
+// historyFilters 根据查询参数生成积分历史的过滤条件（表别名为 ph），失败时已写入响应
+//   - type:         transaction、rule、event，可用逗号分隔多个
+//   - from, to:     日期范围（YYYY-MM-DD，包含两端），按 tz 参数的时区计算
+//   - sign:         gain（增加）或 loss（减少）
+//   - reverted:     true 或 false
+//   - reference_id: 关联记录ID
+//   - q:            描述中包含的文字
+func historyFilters(c *gin.Context) ([]string, []interface{}, bool) {
+	var clauses []string
+	var args []interface{}
+
+	if types := c.Query("type"); types != "" {
+		var placeholders []string
+		for _, t := range strings.Split(types, ",") {
+			t = strings.TrimSpace(t)
+			if t != "transaction" && t != "rule" && t != "event" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid type"})
+				return nil, nil, false
+			}
+			placeholders = append(placeholders, "?")
+			args = append(args, t)
+		}
+		clauses = append(clauses, "ph.type IN ("+strings.Join(placeholders, ",")+")")
+	}
+
+	if c.Query("from") != "" || c.Query("to") != "" {
+		loc, ok := requestLocation(c)
+		if !ok {
+			return nil, nil, false
+		}
+		if from := c.Query("from"); from != "" {
+			start, err := time.ParseInLocation(dateLayout, from, loc)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from date, expected YYYY-MM-DD"})
+				return nil, nil, false
+			}
+			clauses = append(clauses, "ph.created_at >= ?")
+			args = append(args, dbTime(start))
+		}
+		if to := c.Query("to"); to != "" {
+			end, err := time.ParseInLocation(dateLayout, to, loc)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to date, expected YYYY-MM-DD"})
+				return nil, nil, false
+			}
+			clauses = append(clauses, "ph.created_at < ?")
+			args = append(args, dbTime(end.AddDate(0, 0, 1)))
+		}
+	}
+
+	switch c.Query("sign") {
+	case "":
+	case "gain":
+		clauses = append(clauses, "ph.points > 0")
+	case "loss":
+		clauses = append(clauses, "ph.points < 0")
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid sign, expected gain or loss"})
+		return nil, nil, false
+	}
+
+	if reverted := c.Query("reverted"); reverted != "" {
+		value, err := strconv.ParseBool(reverted)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid reverted"})
+			return nil, nil, false
+		}
+		clauses = append(clauses, "ph.is_reverted = ?")
+		args = append(args, value)
+	}
+
+	if referenceID := c.Query("reference_id"); referenceID != "" {
+		id, err := strconv.Atoi(referenceID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid reference_id"})
+			return nil, nil, false
+		}
+		clauses = append(clauses, "ph.reference_id = ?")
+		args = append(args, id)
+	}
+
+	if q := strings.TrimSpace(c.Query("q")); q != "" {
+		escaper := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+		clauses = append(clauses, `ph.description LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+escaper.Replace(q)+"%")
+	}
+
+	return clauses, args, true
+}
+
+// listPointsHistory 查询某个用户的积分历史并写入响应
+// 默认按时间倒序，用 cursor 翻页（兼容旧的 offset 翻页）；传 since 时进入增量同步模式
+func listPointsHistory(c *gin.Context, targetUserID int) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultHistoryLimit)))
+	if limit <= 0 {
+		limit = defaultHistoryLimit
+	}
+	if limit > maxHistoryLimit {
+		limit = maxHistoryLimit
+	}
+
+	filters, filterArgs, ok := historyFilters(c)
+	if !ok {
+		return
+	}
+
+	if since := c.Query("since"); since != "" {
+		listPointsHistorySince(c, targetUserID, since, limit, filters, filterArgs)
+		return
+	}
+
+	clauses := append([]string{"ph.user_id = ?"}, filters...)
+	args := append([]interface{}{targetUserID}, filterArgs...)
+
+	// 总数只受过滤条件影响，与翻页位置无关
+	var total int
+	err := database.DB.QueryRow(
+		"SELECT COUNT(*) FROM points_history ph WHERE "+strings.Join(clauses, " AND "),
+		args...,
+	).Scan(&total)
+	if err != nil {
+		logger.Error("Failed to get points history count: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get points history"})
+		return
+	}
+
+	offset := 0
+	if cursorStr := c.Query("cursor"); cursorStr != "" {
+		cursor, err := decodeHistoryCursor(cursorStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return
+		}
+		clauses = append(clauses, "(ph.created_at < ? OR (ph.created_at = ? AND ph.id < ?))")
+		args = append(args, cursor.Time, cursor.Time, cursor.ID)
+	} else {
+		offset, _ = strconv.Atoi(c.DefaultQuery("offset", "0"))
+	}
+
+	// 多取一条用于判断是否还有下一页
+	rows, err := database.DB.Query(`
+		SELECT `+pointsHistoryColumns+`
+		FROM points_history ph
+		`+pointsHistoryJoins+`
+		WHERE `+strings.Join(clauses, " AND ")+`
+		ORDER BY ph.created_at DESC, ph.id DESC
+		LIMIT ? OFFSET ?`,
+		append(args, limit+1, offset)...,
+	)
+	if err != nil {
+		logger.Error("Failed to get points history: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get points history"})
+		return
+	}
+	defer rows.Close()
+
+	history := []models.PointsHistory{}
+	for rows.Next() {
+		h, err := scanPointsHistory(rows)
+		if err != nil {
+			logger.Error("Failed to scan points history: " + err.Error())
+			continue
+		}
+		history = append(history, h)
+	}
+
+	hasMore := len(history) > limit
+	var nextCursor *string
+	if hasMore {
+		history = history[:limit]
+		last := history[len(history)-1]
+		cursor := historyCursor{Time: dbTime(last.CreatedAt), ID: last.ID}.encode()
+		nextCursor = &cursor
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"history":     history,
+		"total":       total,
+		"limit":       limit,
+		"offset":      offset,
+		"has_more":    hasMore,
+		"next_cursor": nextCursor,
+	})
+}
+
+// listPointsHistorySince 增量同步：返回 since 之后新增或撤销状态有变化的记录，按变化顺序（change_seq）升序
+// 客户端保存返回的 next_since，下次同步时原样传回；has_more 为 true 时应立即继续拉取
+func listPointsHistorySince(c *gin.Context, targetUserID int, since string, limit int, filters []string, filterArgs []interface{}) {
+	seq, err := parseSince(since, targetUserID)
+	if err == errInvalidCursor {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid since"})
+		return
+	}
+	if err != nil {
+		logger.Error("Failed to resolve since: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get points history"})
+		return
+	}
+
+	clauses := append([]string{"ph.user_id = ?", "ph.change_seq > ?"}, filters...)
+	args := append([]interface{}{targetUserID, seq}, filterArgs...)
+
+	rows, err := database.DB.Query(`
+		SELECT `+pointsHistoryColumns+`, ph.change_seq
+		FROM points_history ph
+		`+pointsHistoryJoins+`
+		WHERE `+strings.Join(clauses, " AND ")+`
+		ORDER BY ph.change_seq ASC
+		LIMIT ?`,
+		append(args, limit+1)...,
+	)
+	if err != nil {
+		logger.Error("Failed to get points history changes: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get points history"})
+		return
+	}
+	defer rows.Close()
+
+	history := []models.PointsHistory{}
+	next := seq
+	hasMore := false
+	for rows.Next() {
+		if len(history) == limit {
+			hasMore = true
+			break
+		}
+		var changeSeq int64
+		h, err := scanPointsHistory(rows, &changeSeq)
+		if err != nil {
+			logger.Error("Failed to scan points history change: " + err.Error())
+			continue
+		}
+		history = append(history, h)
+		next = changeSeq
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"history":    history,
+		"limit":      limit,
+		"has_more":   hasMore,
+		"next_since": encodeSyncCursor(next),
+	})
+}
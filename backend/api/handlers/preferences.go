@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"strings"
+	"time"
+
+	"booonus-backend/internal/database"
+	"booonus-backend/internal/locale"
+	"booonus-backend/pkg/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// userPreferences 用户偏好设置，按天、按周的统计和计算都以此为准
+type userPreferences struct {
+	Timezone  string
+	Location  *time.Location
+	Locale    string
+	WeekStart time.Weekday
+}
+
+// loadUserPreferences 获取用户偏好设置，未设置的项使用默认值（UTC、zh-CN、周一）
+func loadUserPreferences(userID int) (userPreferences, error) {
+	prefs := userPreferences{Timezone: "UTC", Location: time.UTC, Locale: locale.Default, WeekStart: time.Monday}
+	if userID == 0 {
+		return prefs, nil
+	}
+
+	var timezone, tag sql.NullString
+	var weekStart int
+	err := database.DB.QueryRow("SELECT timezone, locale, week_start FROM users WHERE id = ?", userID).Scan(&timezone, &tag, &weekStart)
+	if err == sql.ErrNoRows {
+		return prefs, nil
+	}
+	if err != nil {
+		return prefs, err
+	}
+
+	prefs.Location = userLocation(timezone)
+	prefs.Timezone = prefs.Location.String()
+	if tag.Valid && tag.String != "" {
+		prefs.Locale = tag.String
+	}
+	if weekStart >= 0 && weekStart <= 6 {
+		prefs.WeekStart = time.Weekday(weekStart)
+	}
+	return prefs, nil
+}
+
+// GetPreferences 获取时区、语言、每周第一天和通知设置
+func GetPreferences(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	response, err := preferencesResponse(userID)
+	if err != nil {
+		logger.Error("Failed to get preferences: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get preferences"})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// UpdatePreferences 修改偏好设置，未提供的字段保持不变
+// week_start 为星期的英文名称，如 monday、sunday；notifications 的格式同通知设置接口
+func UpdatePreferences(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req struct {
+		Timezone      *string                        `json:"timezone"`
+		Locale        *string                        `json:"locale"`
+		WeekStart     *string                        `json:"week_start"`
+		Notifications notificationPreferencesRequest `json:"notifications"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	sets := []string{}
+	args := []interface{}{}
+	if req.Timezone != nil {
+		if !validTimezone(*req.Timezone) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid time zone"})
+			return
+		}
+		sets = append(sets, "timezone = ?")
+		args = append(args, *req.Timezone)
+	}
+	if req.Locale != nil {
+		tag, ok := locale.Parse(*req.Locale)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid locale"})
+			return
+		}
+		sets = append(sets, "locale = ?")
+		args = append(args, tag)
+	}
+	if req.WeekStart != nil {
+		weekday, ok := parseWeekday(*req.WeekStart)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid week start, expected a weekday name such as monday"})
+			return
+		}
+		sets = append(sets, "week_start = ?")
+		args = append(args, int(weekday))
+	}
+	if !validateNotificationPreferences(c, req.Notifications) {
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		logger.Error("Failed to begin transaction: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	defer tx.Rollback()
+
+	if len(sets) > 0 {
+		sets = append(sets, "updated_at = CURRENT_TIMESTAMP")
+		if _, err := tx.Exec("UPDATE users SET "+strings.Join(sets, ", ")+" WHERE id = ?", append(args, userID)...); err != nil {
+			logger.Error("Failed to update preferences: " + err.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update preferences"})
+			return
+		}
+	}
+	if err := saveNotificationPreferences(tx, userID, req.Notifications); err != nil {
+		logger.Error("Failed to update notification preferences: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update preferences"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		logger.Error("Failed to commit transaction: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update preferences"})
+		return
+	}
+
+	response, err := preferencesResponse(userID)
+	if err != nil {
+		logger.Error("Failed to get preferences: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update preferences"})
+		return
+	}
+	response["message"] = "Preferences updated successfully"
+	c.JSON(http.StatusOK, response)
+}
+
+// preferencesResponse 偏好设置接口的响应
+func preferencesResponse(userID int) (gin.H, error) {
+	prefs, err := loadUserPreferences(userID)
+	if err != nil {
+		return nil, err
+	}
+	notificationPrefs, err := loadNotificationPreferences(userID)
+	if err != nil {
+		return nil, err
+	}
+	return gin.H{
+		"timezone":      prefs.Timezone,
+		"locale":        prefs.Locale,
+		"week_start":    strings.ToLower(prefs.WeekStart.String()),
+		"notifications": notificationPrefs,
+	}, nil
+}
+
+// parseWeekday 解析星期的英文名称，不区分大小写
+func parseWeekday(name string) (time.Weekday, bool) {
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if strings.EqualFold(name, day.String()) {
+			return day, true
+		}
+	}
+	return 0, false
+}
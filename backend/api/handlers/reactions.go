@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"unicode"
+	"unicode/utf8"
+
+	"booonus-backend/internal/database"
+	"booonus-backend/models"
+	"booonus-backend/pkg/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxEmojiRunes 单个表情最多包含的字符数（组合表情由多个码点组成）
+const maxEmojiRunes = 8
+
+// GetReactions 获取某个对象的表情回应
+func GetReactions(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var target interactionTarget
+	if err := c.ShouldBindQuery(&target); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !checkInteractionTarget(c, userID, target) {
+		return
+	}
+
+	reactions, _, err := interactionSummary(target.Type, []int{target.ID})
+	if err != nil {
+		logger.Error("Failed to get reactions: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get reactions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"reactions": reactionsOrEmpty(reactions[target.ID]),
+	})
+}
+
+// AddReaction 添加表情回应，重复添加同一个表情不会产生新记录
+func AddReaction(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req struct {
+		interactionTarget
+		Emoji string `json:"emoji" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !isValidEmoji(req.Emoji) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid emoji"})
+		return
+	}
+
+	if !checkInteractionTarget(c, userID, req.interactionTarget) {
+		return
+	}
+
+	_, err := database.DB.Exec(
+		"INSERT OR IGNORE INTO reactions (target_type, target_id, user_id, emoji) VALUES (?, ?, ?, ?)",
+		req.Type, req.ID, userID, req.Emoji,
+	)
+	if err != nil {
+		logger.Error("Failed to add reaction: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add reaction"})
+		return
+	}
+
+	logger.Info("Reaction added on " + req.Type + " " + strconv.Itoa(req.ID) + " by user " + strconv.Itoa(userID))
+	c.JSON(http.StatusOK, gin.H{"message": "Reaction added successfully"})
+}
+
+// RemoveReaction 取消自己的表情回应
+func RemoveReaction(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req struct {
+		interactionTarget
+		Emoji string `form:"emoji" binding:"required"`
+	}
+
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !checkInteractionTarget(c, userID, req.interactionTarget) {
+		return
+	}
+
+	result, err := database.DB.Exec(
+		"DELETE FROM reactions WHERE target_type = ? AND target_id = ? AND user_id = ? AND emoji = ?",
+		req.Type, req.ID, userID, req.Emoji,
+	)
+	if err != nil {
+		logger.Error("Failed to remove reaction: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove reaction"})
+		return
+	}
+
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Reaction not found"})
+		return
+	}
+
+	logger.Info("Reaction removed on " + req.Type + " " + strconv.Itoa(req.ID) + " by user " + strconv.Itoa(userID))
+	c.JSON(http.StatusOK, gin.H{"message": "Reaction removed successfully"})
+}
+
+// isValidEmoji 粗略检查是否为单个表情：不含普通文字、数字和空白
+func isValidEmoji(s string) bool {
+	if s == "" || !utf8.ValidString(s) || utf8.RuneCountInString(s) > maxEmojiRunes {
+		return false
+	}
+	for _, r := range s {
+		if r < 0x2000 || unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// reactionsOrEmpty 没有回应时返回空数组而不是 null
+func reactionsOrEmpty(counts []models.ReactionCount) []models.ReactionCount {
+	if counts == nil {
+		return []models.ReactionCount{}
+	}
+	return counts
+}
@@ -0,0 +1,589 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"booonus-backend/internal/database"
+	"booonus-backend/internal/reminders"
+	"booonus-backend/pkg/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxSnooze 稍后提醒最多推迟的时间
+const maxSnooze = 7 * 24 * time.Hour
+
+// reminderRequest 创建或修改提醒的请求，修改时未提供的字段保持不变
+type reminderRequest struct {
+	Title      *string `json:"title"`
+	Message    *string `json:"message"`
+	UserID     *int    `json:"user_id"`     // 接收人，默认自己，可以是同一家庭的其他成员
+	TargetType *string `json:"target_type"` // rule 或 voucher，空字符串表示不关联
+	TargetID   *int    `json:"target_id"`
+	Repeat     *string `json:"repeat"` // once、daily、weekly、monthly
+	Interval   *int    `json:"interval"`
+	Weekdays   *[]int  `json:"weekdays"` // 1 为周一，7 为周日
+	Time       *string `json:"time"`     // HH:MM
+	StartDate  *string `json:"start_date"`
+	EndDate    *string `json:"end_date"` // 空字符串表示不结束
+	Timezone   *string `json:"timezone"` // IANA 时区，默认取邮箱设置中的时区
+	IsActive   *bool   `json:"is_active"`
+}
+
+// GetReminders 获取自己创建的和发给自己的提醒
+// 查询参数 scope：received 只返回发给自己的，created 只返回自己创建的
+func GetReminders(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	where := "(user_id = ? OR created_by = ?)"
+	args := []interface{}{userID, userID}
+	switch c.Query("scope") {
+	case "":
+	case "received":
+		where = "user_id = ?"
+		args = args[:1]
+	case "created":
+		where = "created_by = ?"
+		args = args[:1]
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid scope"})
+		return
+	}
+
+	rows, err := database.DB.Query(
+		"SELECT "+reminders.Columns+" FROM reminders WHERE "+where+" ORDER BY next_run_at IS NULL, next_run_at, id",
+		args...,
+	)
+	if err != nil {
+		logger.Error("Failed to get reminders: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get reminders"})
+		return
+	}
+	defer rows.Close()
+
+	list := []reminders.Reminder{}
+	for rows.Next() {
+		r, err := reminders.Scan(rows)
+		if err != nil {
+			logger.Error("Failed to scan reminder: " + err.Error())
+			continue
+		}
+		list = append(list, r)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"reminders": list})
+}
+
+// GetReminder 获取一条提醒
+func GetReminder(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	r, ok := userReminder(c, userID)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"reminder": r})
+}
+
+// CreateReminder 创建提醒
+func CreateReminder(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req reminderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Title == nil || req.Time == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Title and time are required"})
+		return
+	}
+
+	var timezone sql.NullString
+	if err := database.DB.QueryRow("SELECT timezone FROM users WHERE id = ?", userID).Scan(&timezone); err != nil {
+		logger.Error("Failed to get user time zone: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create reminder"})
+		return
+	}
+	r := reminders.Reminder{
+		CreatedBy: userID,
+		UserID:    userID,
+		Repeat:    reminders.RepeatOnce,
+		Interval:  1,
+		Weekdays:  []int{},
+		Timezone:  userTimezoneName(timezone),
+		IsActive:  true,
+	}
+	if req.StartDate == nil {
+		loc := userLocation(timezone)
+		if req.Timezone != nil {
+			if l, err := time.LoadLocation(*req.Timezone); err == nil {
+				loc = l
+			}
+		}
+		r.StartDate = time.Now().In(loc).Format(dateLayout)
+	}
+	if !applyReminderRequest(c, userID, &r, req) {
+		return
+	}
+
+	var coupleID interface{}
+	if id := c.GetInt("household_id"); id != 0 {
+		coupleID = id
+	}
+	result, err := database.DB.Exec(`
+		INSERT INTO reminders (couple_id, created_by, user_id, title, message, target_type, target_id,
+		                       repeat, repeat_interval, weekdays, time_of_day, start_date, end_date, timezone, next_run_at, is_active)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		coupleID, userID, r.UserID, r.Title, r.Message, reminderNullable(r.TargetType), r.TargetID,
+		r.Repeat, r.Interval, reminders.FormatWeekdays(r.Weekdays), r.TimeOfDay, r.StartDate, reminderNullable(r.EndDate),
+		r.Timezone, reminderDBTime(r.NextRunAt), r.IsActive,
+	)
+	if err != nil {
+		logger.Error("Failed to create reminder: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create reminder"})
+		return
+	}
+	reminderID, _ := result.LastInsertId()
+
+	created, err := reminders.Scan(database.DB.QueryRow("SELECT "+reminders.Columns+" FROM reminders WHERE id = ?", reminderID))
+	if err != nil {
+		logger.Error("Failed to get reminder: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create reminder"})
+		return
+	}
+
+	logger.Info("Reminder created: " + strconv.FormatInt(reminderID, 10) + " by user " + strconv.Itoa(userID))
+	c.JSON(http.StatusCreated, gin.H{
+		"message":  "Reminder created successfully",
+		"reminder": created,
+	})
+}
+
+// UpdateReminder 修改提醒，只有创建人可以修改；修改后从现在重新计算下一次提醒时间
+func UpdateReminder(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	r, ok := userReminder(c, userID)
+	if !ok {
+		return
+	}
+	if r.CreatedBy != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the creator can modify this reminder"})
+		return
+	}
+
+	var req reminderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !applyReminderRequest(c, userID, &r, req) {
+		return
+	}
+
+	_, err := database.DB.Exec(`
+		UPDATE reminders
+		SET user_id = ?, title = ?, message = ?, target_type = ?, target_id = ?, repeat = ?, repeat_interval = ?,
+		    weekdays = ?, time_of_day = ?, start_date = ?, end_date = ?, timezone = ?, next_run_at = ?, is_active = ?,
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?`,
+		r.UserID, r.Title, r.Message, reminderNullable(r.TargetType), r.TargetID, r.Repeat, r.Interval,
+		reminders.FormatWeekdays(r.Weekdays), r.TimeOfDay, r.StartDate, reminderNullable(r.EndDate), r.Timezone,
+		reminderDBTime(r.NextRunAt), r.IsActive, r.ID,
+	)
+	if err != nil {
+		logger.Error("Failed to update reminder: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update reminder"})
+		return
+	}
+
+	updated, err := reminders.Scan(database.DB.QueryRow("SELECT "+reminders.Columns+" FROM reminders WHERE id = ?", r.ID))
+	if err != nil {
+		logger.Error("Failed to get reminder: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update reminder"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Reminder updated successfully",
+		"reminder": updated,
+	})
+}
+
+// DeleteReminder 删除提醒及其记录，只有创建人可以删除
+func DeleteReminder(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	r, ok := userReminder(c, userID)
+	if !ok {
+		return
+	}
+	if r.CreatedBy != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the creator can delete this reminder"})
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		logger.Error("Failed to begin transaction: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete reminder"})
+		return
+	}
+	defer tx.Rollback()
+
+	for _, statement := range []string{
+		"DELETE FROM reminder_events WHERE reminder_id = ?",
+		"DELETE FROM reminders WHERE id = ?",
+	} {
+		if _, err := tx.Exec(statement, r.ID); err != nil {
+			logger.Error("Failed to delete reminder: " + err.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete reminder"})
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		logger.Error("Failed to commit transaction: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete reminder"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Reminder deleted successfully"})
+}
+
+// SnoozeReminder 稍后提醒，只有接收人可以操作
+// 请求体：minutes（推迟的分钟数）或 until（RFC 3339 时间），最多推迟 7 天
+func SnoozeReminder(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	r, ok := userReminder(c, userID)
+	if !ok {
+		return
+	}
+	if r.UserID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the recipient can snooze this reminder"})
+		return
+	}
+	if !r.IsActive {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Reminder is not active"})
+		return
+	}
+
+	var req struct {
+		Minutes int        `json:"minutes"`
+		Until   *time.Time `json:"until"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	now := time.Now()
+	var until time.Time
+	switch {
+	case req.Until != nil:
+		until = *req.Until
+	case req.Minutes > 0:
+		until = now.Add(time.Duration(req.Minutes) * time.Minute)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "minutes or until is required"})
+		return
+	}
+	if !until.After(now) || until.After(now.Add(maxSnooze)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Snooze time must be within the next 7 days"})
+		return
+	}
+
+	if _, err := database.DB.Exec(
+		"UPDATE reminders SET snoozed_until = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?",
+		dbTime(until), r.ID,
+	); err != nil {
+		logger.Error("Failed to snooze reminder: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to snooze reminder"})
+		return
+	}
+	if err := reminders.RecordEvent(r.ID, userID, reminders.ActionSnoozed, r.LastSentAt, &until, "", ""); err != nil {
+		logger.Error("Failed to record reminder event: " + err.Error())
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "Reminder snoozed",
+		"snoozed_until": until.UTC(),
+	})
+}
+
+// AcknowledgeReminder 确认收到提醒，取消稍后提醒并把对应的通知标记为已读，只有接收人可以操作
+func AcknowledgeReminder(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	r, ok := userReminder(c, userID)
+	if !ok {
+		return
+	}
+	if r.UserID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the recipient can acknowledge this reminder"})
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		logger.Error("Failed to begin transaction: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to acknowledge reminder"})
+		return
+	}
+	defer tx.Rollback()
+
+	for _, statement := range []string{
+		"UPDATE reminders SET snoozed_until = NULL, updated_at = CURRENT_TIMESTAMP WHERE id = ?",
+		"UPDATE notifications SET read_at = CURRENT_TIMESTAMP WHERE reference_type = 'reminder' AND reference_id = ? AND read_at IS NULL",
+	} {
+		if _, err := tx.Exec(statement, r.ID); err != nil {
+			logger.Error("Failed to acknowledge reminder: " + err.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to acknowledge reminder"})
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		logger.Error("Failed to commit transaction: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to acknowledge reminder"})
+		return
+	}
+	if err := reminders.RecordEvent(r.ID, userID, reminders.ActionAcknowledged, r.LastSentAt, nil, "", ""); err != nil {
+		logger.Error("Failed to record reminder event: " + err.Error())
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Reminder acknowledged"})
+}
+
+// GetReminderEvents 获取提醒的投递、稍后提醒和确认记录，最新的在前
+func GetReminderEvents(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	r, ok := userReminder(c, userID)
+	if !ok {
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT id, user_id, action, occurrence_at, snoozed_until, channel, error, created_at
+		FROM reminder_events
+		WHERE reminder_id = ?
+		ORDER BY id DESC
+		LIMIT ?`,
+		r.ID, limit,
+	)
+	if err != nil {
+		logger.Error("Failed to get reminder events: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get reminder events"})
+		return
+	}
+	defer rows.Close()
+
+	events := []gin.H{}
+	for rows.Next() {
+		var id, eventUserID int
+		var action string
+		var occurrenceAt, snoozedUntil *time.Time
+		var channel, errText sql.NullString
+		var createdAt time.Time
+		if err := rows.Scan(&id, &eventUserID, &action, &occurrenceAt, &snoozedUntil, &channel, &errText, &createdAt); err != nil {
+			logger.Error("Failed to scan reminder event: " + err.Error())
+			continue
+		}
+		events = append(events, gin.H{
+			"id":            id,
+			"user_id":       eventUserID,
+			"action":        action,
+			"occurrence_at": occurrenceAt,
+			"snoozed_until": snoozedUntil,
+			"channel":       nullableString(channel),
+			"error":         nullableString(errText),
+			"created_at":    createdAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"events": events})
+}
+
+// userReminder 解析路径中的提醒 ID，只能访问自己创建的或发给自己的提醒，失败时已写入响应
+func userReminder(c *gin.Context, userID int) (reminders.Reminder, bool) {
+	reminderID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid reminder ID"})
+		return reminders.Reminder{}, false
+	}
+
+	r, err := reminders.Scan(database.DB.QueryRow(
+		"SELECT "+reminders.Columns+" FROM reminders WHERE id = ? AND (user_id = ? OR created_by = ?)",
+		reminderID, userID, userID,
+	))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Reminder not found"})
+		return r, false
+	}
+	if err != nil {
+		logger.Error("Failed to get reminder: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return r, false
+	}
+	return r, true
+}
+
+// applyReminderRequest 把请求中的字段合并到提醒中并校验，同时计算下一次提醒时间，失败时已写入响应
+func applyReminderRequest(c *gin.Context, userID int, r *reminders.Reminder, req reminderRequest) bool {
+	if req.Title != nil {
+		r.Title = strings.TrimSpace(*req.Title)
+	}
+	if req.Message != nil {
+		r.Message = strings.TrimSpace(*req.Message)
+	}
+	if req.UserID != nil {
+		r.UserID = *req.UserID
+	}
+	if req.TargetType != nil {
+		r.TargetType = *req.TargetType
+		r.TargetID = req.TargetID
+	} else if req.TargetID != nil {
+		r.TargetID = req.TargetID
+	}
+	if req.Repeat != nil {
+		r.Repeat = *req.Repeat
+	}
+	if req.Interval != nil {
+		r.Interval = *req.Interval
+	}
+	if req.Weekdays != nil {
+		r.Weekdays = *req.Weekdays
+	}
+	if req.Time != nil {
+		r.TimeOfDay = *req.Time
+	}
+	if req.StartDate != nil {
+		r.StartDate = *req.StartDate
+	}
+	if req.EndDate != nil {
+		r.EndDate = *req.EndDate
+	}
+	if req.Timezone != nil {
+		r.Timezone = *req.Timezone
+	}
+	if req.IsActive != nil {
+		r.IsActive = *req.IsActive
+	}
+
+	if r.Title == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Title is required"})
+		return false
+	}
+	if len(r.Title) > 200 || len(r.Message) > 1000 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Title or message is too long"})
+		return false
+	}
+	if r.Repeat != reminders.RepeatWeekly {
+		r.Weekdays = []int{}
+	}
+	if !validTimezone(r.Timezone) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid time zone"})
+		return false
+	}
+	schedule, _ := r.Schedule()
+	if err := schedule.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+
+	if !validateReminderRecipient(c, userID, r.UserID) || !validateReminderTarget(c, userID, r) {
+		return false
+	}
+
+	// 只修改标题等字段时，已经结束的提醒保持结束
+	scheduleChanged := req.Repeat != nil || req.Interval != nil || req.Weekdays != nil || req.Time != nil ||
+		req.StartDate != nil || req.EndDate != nil || req.Timezone != nil
+	r.NextRunAt = nil
+	if at, ok := schedule.Next(time.Now()); ok {
+		r.NextRunAt = &at
+	} else if scheduleChanged {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Reminder has no upcoming occurrence"})
+		return false
+	}
+	return true
+}
+
+// validateReminderRecipient 接收人必须是自己或同一家庭的现有成员，失败时已写入响应
+func validateReminderRecipient(c *gin.Context, userID, recipientID int) bool {
+	memberIDs, err := coupleMemberIDs(userID)
+	if err != nil {
+		logger.Error("Failed to get household members: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return false
+	}
+	if !containsID(memberIDs, recipientID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Recipient must be yourself or a household member"})
+		return false
+	}
+	return true
+}
+
+// validateReminderTarget 关联的规则必须属于当前家庭，关联的商品必须是家庭成员购买或出售的，失败时已写入响应
+func validateReminderTarget(c *gin.Context, userID int, r *reminders.Reminder) bool {
+	if r.TargetType == "" {
+		r.TargetID = nil
+		return true
+	}
+	if r.TargetID == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "target_id is required"})
+		return false
+	}
+
+	var exists bool
+	var err error
+	switch r.TargetType {
+	case reminders.TargetRule:
+		err = database.DB.QueryRow(
+			"SELECT EXISTS(SELECT 1 FROM rules WHERE id = ? AND couple_id = ? AND is_active = TRUE)",
+			*r.TargetID, c.GetInt("household_id"),
+		).Scan(&exists)
+	case reminders.TargetVoucher:
+		var memberIDs []int
+		memberIDs, err = coupleMemberIDs(userID)
+		if err == nil {
+			inClause, args := memberInClause(memberIDs)
+			args = append([]interface{}{*r.TargetID}, append(args, args...)...)
+			err = database.DB.QueryRow(
+				"SELECT EXISTS(SELECT 1 FROM transactions WHERE id = ? AND (buyer_id IN ("+inClause+") OR seller_id IN ("+inClause+")))",
+				args...,
+			).Scan(&exists)
+		}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid target type"})
+		return false
+	}
+	if err != nil {
+		logger.Error("Failed to check reminder target: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return false
+	}
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Target not found"})
+		return false
+	}
+	return true
+}
+
+// reminderNullable 空字符串存为 NULL
+func reminderNullable(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// reminderDBTime 转换为数据库中的存储格式，nil 时为 NULL
+func reminderDBTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return dbTime(*t)
+}
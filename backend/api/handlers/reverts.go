@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"booonus-backend/internal/database"
+	"booonus-backend/internal/notifications"
+	"booonus-backend/models"
+	"booonus-backend/pkg/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 没有情侣关系时使用的默认撤销设置，与 couples 表的默认值一致
+const (
+	defaultRevertWindowHours = 0
+	defaultRevertLimit       = 3
+)
+
+// revertRequest 撤销和取消撤销的请求体（可省略）
+type revertRequest struct {
+	Reason string `json:"reason" binding:"max=500"`
+}
+
+// revertPolicy 情侣的撤销设置
+type revertPolicy struct {
+	WindowHours    int
+	ReasonRequired bool
+	Limit          int
+}
+
+// loadRevertPolicy 获取记录所属用户的情侣撤销设置
+func loadRevertPolicy(userID int) (revertPolicy, error) {
+	policy := revertPolicy{WindowHours: defaultRevertWindowHours, Limit: defaultRevertLimit}
+	err := database.DB.QueryRow(`
+		SELECT c.revert_window_hours, c.revert_reason_required, c.revert_limit
+		FROM couples c JOIN users u ON u.couple_id = c.id
+		WHERE u.id = ?`,
+		userID,
+	).Scan(&policy.WindowHours, &policy.ReasonRequired, &policy.Limit)
+	if err == sql.ErrNoRows {
+		return policy, nil
+	}
+	return policy, err
+}
+
+// checkRevertPolicy 按情侣设置检查是否允许撤销或取消撤销，失败时已写入响应
+// 撤销次数在 tx 中统计，与随后写入的审计日志保持一致
+func checkRevertPolicy(c *gin.Context, tx *sql.Tx, history models.PointsHistory, action, reason string) bool {
+	policy, err := loadRevertPolicy(history.UserID)
+	if err != nil {
+		logger.Error("Failed to get revert policy: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return false
+	}
+
+	if policy.ReasonRequired && strings.TrimSpace(reason) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A reason is required"})
+		return false
+	}
+
+	if policy.WindowHours > 0 && time.Since(history.CreatedAt) > time.Duration(policy.WindowHours)*time.Hour {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The revert window for this operation has expired"})
+		return false
+	}
+
+	// 次数限制只针对撤销，取消撤销总是可以把记录恢复原状
+	if action == "revert" && policy.Limit > 0 {
+		var count int
+		err := tx.QueryRow(
+			"SELECT COUNT(*) FROM revert_actions WHERE history_id = ? AND action = 'revert'",
+			history.ID,
+		).Scan(&count)
+		if err != nil {
+			logger.Error("Failed to count revert actions: " + err.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			return false
+		}
+		if count >= policy.Limit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "This operation has been reverted too many times"})
+			return false
+		}
+	}
+
+	return true
+}
+
+// recordRevertActions 为每条受影响的记录写入审计日志，并通知记录所属的用户
+func recordRevertActions(tx *sql.Tx, historyIDs []int, action string, actorID int, reason string) error {
+	reason = strings.TrimSpace(reason)
+	for _, historyID := range historyIDs {
+		_, err := tx.Exec(
+			"INSERT INTO revert_actions (history_id, action, actor_id, reason) VALUES (?, ?, ?, ?)",
+			historyID, action, actorID, reason,
+		)
+		if err != nil {
+			return err
+		}
+
+		var ownerID, points int
+		var description string
+		err = tx.QueryRow("SELECT user_id, points, description FROM points_history WHERE id = ?", historyID).Scan(&ownerID, &points, &description)
+		if err != nil {
+			return err
+		}
+
+		// 撤销时积分变化与原记录相反
+		messageKey := notifications.MessageRevertCancelled
+		if action == "revert" {
+			points = -points
+			messageKey = notifications.MessageReverted
+		}
+		id := historyID
+		sendNotification(tx, notifications.Notification{
+			UserID:        ownerID,
+			Type:          notifications.TypeRevert,
+			ActorID:       &actorID,
+			MessageKey:    messageKey,
+			MessageArgs:   []string{description},
+			Points:        &points,
+			ReferenceType: "points_history",
+			ReferenceID:   &id,
+		})
+	}
+	return nil
+}
+
+// GetRevertActions 获取某条积分记录的撤销审计日志
+func GetRevertActions(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	historyID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid history ID"})
+		return
+	}
+
+	var ownerID int
+	err = database.DB.QueryRow("SELECT user_id FROM points_history WHERE id = ?", historyID).Scan(&ownerID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "History record not found"})
+			return
+		}
+		logger.Error("Failed to get history record: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get history record"})
+		return
+	}
+
+	if !canUserRevertHistory(userID, ownerID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT ra.id, ra.history_id, ra.action, ra.actor_id, ra.reason, ra.created_at, u.username
+		FROM revert_actions ra
+		JOIN users u ON ra.actor_id = u.id
+		WHERE ra.history_id = ?
+		ORDER BY ra.id ASC`,
+		historyID,
+	)
+	if err != nil {
+		logger.Error("Failed to get revert actions: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get revert actions"})
+		return
+	}
+	defer rows.Close()
+
+	type RevertActionWithActor struct {
+		models.RevertAction
+		ActorName string `json:"actor_name"`
+	}
+
+	actions := []RevertActionWithActor{}
+	for rows.Next() {
+		var a RevertActionWithActor
+		var reason sql.NullString
+		err := rows.Scan(&a.ID, &a.HistoryID, &a.Action, &a.ActorID, &reason, &a.CreatedAt, &a.ActorName)
+		if err != nil {
+			logger.Error("Failed to scan revert action: " + err.Error())
+			continue
+		}
+		a.Reason = reason.String
+		actions = append(actions, a)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"actions": actions,
+	})
+}
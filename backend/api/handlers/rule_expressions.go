@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"booonus-backend/internal/expr"
+)
+
+// ruleExpressionTimeout 单次规则表达式求值的超时时间
+const ruleExpressionTimeout = 50 * time.Millisecond
+
+// ruleBuiltinVars 规则表达式可直接使用的内置变量
+//   - points:  规则配置的基础积分
+//   - streak:  目标用户连续执行该规则的天数（按目标用户的时区分天，截至今天，不含本次）
+//   - weekday: 目标用户时区中今天是星期几，0 表示星期日
+//   - balance: 目标用户当前的积分余额
+var ruleBuiltinVars = map[string]expr.Type{
+	"points":  expr.TypeNumber,
+	"streak":  expr.TypeNumber,
+	"weekday": expr.TypeNumber,
+	"balance": expr.TypeNumber,
+}
+
+// compileRuleExpression 校验规则的输入参数声明并编译积分表达式
+func compileRuleExpression(expression string, params []expr.Param) (*expr.Program, error) {
+	if err := expr.ValidateParams(params, ruleBuiltinVars); err != nil {
+		return nil, err
+	}
+	return expr.Compile(expression, expr.VarTypes(params, ruleBuiltinVars))
+}
+
+// parseRuleParams 解析数据库中保存的参数声明
+func parseRuleParams(raw sql.NullString) ([]expr.Param, error) {
+	var params []expr.Param
+	if !raw.Valid || raw.String == "" {
+		return params, nil
+	}
+	err := json.Unmarshal([]byte(raw.String), &params)
+	return params, err
+}
+
+// encodeRuleParams 将参数声明编码为数据库存储格式，无参数时为NULL
+func encodeRuleParams(params []expr.Param) (interface{}, error) {
+	if len(params) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// evaluateRulePoints 计算表达式规则对某个目标用户的积分
+func evaluateRulePoints(tx *sql.Tx, program *expr.Program, ruleID, basePoints, targetUserID int, inputs map[string]interface{}) (int, error) {
+	var balance int
+	var timezone sql.NullString
+	if err := tx.QueryRow("SELECT points, timezone FROM users WHERE id = ?", targetUserID).Scan(&balance, &timezone); err != nil {
+		return 0, err
+	}
+
+	// 连续天数和星期按目标用户的时区计算
+	now := time.Now().In(userLocation(timezone))
+	streak, err := ruleStreak(tx, ruleID, targetUserID, now)
+	if err != nil {
+		return 0, err
+	}
+
+	vars := make(map[string]interface{}, len(inputs)+len(ruleBuiltinVars))
+	for name, value := range inputs {
+		vars[name] = value
+	}
+	vars["points"] = int64(basePoints)
+	vars["streak"] = int64(streak)
+	vars["weekday"] = int64(now.Weekday())
+	vars["balance"] = int64(balance)
+
+	ctx, cancel := context.WithTimeout(context.Background(), ruleExpressionTimeout)
+	defer cancel()
+
+	result, err := program.Eval(ctx, vars)
+	if err != nil {
+		return 0, err
+	}
+	return int(result), nil
+}
+
+// ruleStreak 计算目标用户连续执行某规则的天数，按 now 所在的时区分天
+// 今天已执行则从今天往前数，否则从昨天往前数；已撤销的执行不计入
+func ruleStreak(tx *sql.Tx, ruleID, targetUserID int, now time.Time) (int, error) {
+	rows, err := tx.Query(`
+		SELECT created_at
+		FROM points_history
+		WHERE type = 'rule' AND reference_id = ? AND user_id = ? AND is_reverted = FALSE
+		ORDER BY created_at DESC, id DESC`,
+		ruleID, targetUserID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	loc := now.Location()
+	expected := now.Format(dateLayout)
+	yesterday := now.AddDate(0, 0, -1).Format(dateLayout)
+	streak := 0
+	last := ""
+	for rows.Next() && streak < 366 {
+		var createdAt time.Time
+		if err := rows.Scan(&createdAt); err != nil {
+			return 0, err
+		}
+		// 同一天的多次执行只计一次
+		day := createdAt.In(loc).Format(dateLayout)
+		if day == last {
+			continue
+		}
+		last = day
+
+		if streak == 0 && day != expected && day == yesterday {
+			expected = yesterday
+		}
+		if day != expected {
+			break
+		}
+		streak++
+		t, _ := time.Parse(dateLayout, expected)
+		expected = t.AddDate(0, 0, -1).Format(dateLayout)
+	}
+
+	return streak, rows.Err()
+}
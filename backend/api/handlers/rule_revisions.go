@@ -0,0 +1,335 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"reflect"
+	"strconv"
+
+	"booonus-backend/internal/database"
+	"booonus-backend/models"
+	"booonus-backend/pkg/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetRuleRevisions 获取规则的版本历史
+func GetRuleRevisions(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	ruleIDStr := c.Param("id")
+
+	ruleID, err := strconv.Atoi(ruleIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rule ID"})
+		return
+	}
+
+	// 检查规则权限
+	if !canUserAccessRule(userID, ruleID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	// 家庭成员数决定target_type的显示方式
+	var memberCount int
+	err = database.DB.QueryRow(
+		"SELECT COUNT(*) FROM rules r JOIN household_members m ON m.couple_id = r.couple_id WHERE r.id = ? AND m.left_at IS NULL",
+		ruleID,
+	).Scan(&memberCount)
+	if err != nil {
+		logger.Error("Failed to get household members: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	query := `
+		SELECT rr.id, rr.rule_id, rr.revision, rr.name, rr.description, rr.points, rr.target_type,
+		       rr.target_user_id, rr.is_active, rr.change_type, rr.restored_from, rr.changed_by, rr.created_at,
+		       rr.points_expression, rr.parameters, u.username
+		FROM rule_revisions rr
+		LEFT JOIN users u ON rr.changed_by = u.id
+		WHERE rr.rule_id = ?
+		ORDER BY rr.revision ASC
+	`
+
+	rows, err := database.DB.Query(query, ruleID)
+	if err != nil {
+		logger.Error("Failed to get rule revisions: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get rule revisions"})
+		return
+	}
+	defer rows.Close()
+
+	var revisions []gin.H
+	var previous *models.RuleRevision
+	for rows.Next() {
+		var rev models.RuleRevision
+		var description, changedByName, pointsExpression, parameters sql.NullString
+		var restoredFrom, changedBy sql.NullInt64
+
+		err := rows.Scan(
+			&rev.ID, &rev.RuleID, &rev.Revision, &rev.Name, &description, &rev.Points, &rev.TargetType,
+			&rev.TargetUserID, &rev.IsActive, &rev.ChangeType, &restoredFrom, &changedBy, &rev.CreatedAt,
+			&pointsExpression, &parameters, &changedByName,
+		)
+		if err != nil {
+			logger.Error("Failed to scan rule revision: " + err.Error())
+			continue
+		}
+
+		if pointsExpression.Valid {
+			rev.PointsExpression = &pointsExpression.String
+		}
+		rev.Parameters, err = parseRuleParams(parameters)
+		if err != nil {
+			logger.Error("Failed to parse rule revision parameters: " + err.Error())
+			continue
+		}
+
+		rev.Description = description.String
+		if restoredFrom.Valid {
+			from := int(restoredFrom.Int64)
+			rev.RestoredFrom = &from
+		}
+		if changedBy.Valid {
+			by := int(changedBy.Int64)
+			rev.ChangedBy = &by
+		}
+
+		revisionData := gin.H{
+			"id":            rev.ID,
+			"rule_id":       rev.RuleID,
+			"revision":      rev.Revision,
+			"name":          rev.Name,
+			"description":   rev.Description,
+			"points":        rev.Points,
+			"target_type":   ruleTargetForUser(rev.TargetType, rev.TargetUserID, userID, memberCount),
+			"is_active":     rev.IsActive,
+			"change_type":   rev.ChangeType,
+			"restored_from": rev.RestoredFrom,
+			"changed_by":    rev.ChangedBy,
+			"created_at":    rev.CreatedAt,
+			"changes":       diffRuleRevisions(previous, &rev, userID, memberCount),
+
+			"target_user_id": rev.TargetUserID,
+
+			"points_expression": rev.PointsExpression,
+			"parameters":        rev.Parameters,
+		}
+		if changedByName.Valid {
+			revisionData["changed_by_name"] = changedByName.String
+		}
+
+		revisions = append(revisions, revisionData)
+		previous = &rev
+	}
+
+	// 最新版本排在最前面
+	for i, j := 0, len(revisions)-1; i < j; i, j = i+1, j-1 {
+		revisions[i], revisions[j] = revisions[j], revisions[i]
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"revisions": revisions,
+	})
+}
+
+// RestoreRuleRevision 将规则恢复到指定版本
+func RestoreRuleRevision(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	ruleID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rule ID"})
+		return
+	}
+
+	revisionNumber, err := strconv.Atoi(c.Param("revision"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid revision"})
+		return
+	}
+
+	// 检查规则权限
+	if !canUserAccessRule(userID, ruleID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	// 开始事务
+	tx, err := database.DB.Begin()
+	if err != nil {
+		logger.Error("Failed to begin transaction: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	defer tx.Rollback()
+
+	// 获取要恢复的版本
+	var rev models.RuleRevision
+	var description, pointsExpression, parameters sql.NullString
+	err = tx.QueryRow(
+		"SELECT name, description, points, target_type, target_user_id, points_expression, parameters FROM rule_revisions WHERE rule_id = ? AND revision = ?",
+		ruleID, revisionNumber,
+	).Scan(&rev.Name, &description, &rev.Points, &rev.TargetType, &rev.TargetUserID, &pointsExpression, &parameters)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Rule revision not found"})
+			return
+		}
+		logger.Error("Failed to get rule revision: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	// 指定成员的版本只能在该成员仍在家庭中时恢复，离开家庭的成员的规则已被停用
+	if rev.TargetType == "member" && rev.TargetUserID != nil {
+		var isMember bool
+		err = tx.QueryRow(`
+			SELECT EXISTS(
+				SELECT 1 FROM rules r
+				JOIN household_members m ON m.couple_id = r.couple_id
+				WHERE r.id = ? AND m.user_id = ? AND m.left_at IS NULL
+			)`,
+			ruleID, *rev.TargetUserID,
+		).Scan(&isMember)
+		if err != nil {
+			logger.Error("Failed to check target member: " + err.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			return
+		}
+		if !isMember {
+			c.JSON(http.StatusConflict, gin.H{"error": "The target member of this revision is no longer in the household"})
+			return
+		}
+	}
+
+	// 恢复规则内容，已删除的规则同时重新启用
+	_, err = tx.Exec(
+		"UPDATE rules SET name = ?, description = ?, points = ?, target_type = ?, target_user_id = ?, points_expression = ?, parameters = ?, is_active = TRUE, updated_at = CURRENT_TIMESTAMP WHERE id = ?",
+		rev.Name, description.String, rev.Points, rev.TargetType, rev.TargetUserID, pointsExpression, parameters, ruleID,
+	)
+	if err != nil {
+		logger.Error("Failed to restore rule: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore rule"})
+		return
+	}
+
+	newRevision, err := recordRuleRevision(tx, ruleID, "restore", userID, &revisionNumber)
+	if err != nil {
+		logger.Error("Failed to record rule revision: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore rule"})
+		return
+	}
+
+	ruleEvent := gin.H{"rule_id": ruleID, "restored_from": revisionNumber}
+	if err := queueWebhooks(tx, c, streamRuleUpdated, ruleEvent); err != nil {
+		logger.Error("Failed to queue webhooks: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore rule"})
+		return
+	}
+
+	// 提交事务
+	if err = tx.Commit(); err != nil {
+		logger.Error("Failed to commit transaction: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore rule"})
+		return
+	}
+
+	publishEvent(c, streamRuleUpdated, ruleEvent)
+
+	logger.Info("Rule restored: " + strconv.Itoa(ruleID) + " to revision " + strconv.Itoa(revisionNumber) + " by user " + strconv.Itoa(userID))
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Rule restored successfully",
+		"revision": newRevision,
+	})
+}
+
+// recordRuleRevision 将规则当前状态保存为新版本，返回新版本号（内部函数）
+func recordRuleRevision(tx *sql.Tx, ruleID int, changeType string, changedBy int, restoredFrom *int) (int, error) {
+	var nextRevision int
+	err := tx.QueryRow("SELECT COALESCE(MAX(revision), 0) + 1 FROM rule_revisions WHERE rule_id = ?", ruleID).Scan(&nextRevision)
+	if err != nil {
+		return 0, err
+	}
+
+	var from interface{}
+	if restoredFrom != nil {
+		from = *restoredFrom
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO rule_revisions
+			(rule_id, revision, name, description, points, target_type, target_user_id, is_active, points_expression, parameters, change_type, restored_from, changed_by)
+		SELECT id, ?, name, description, points, target_type, target_user_id, is_active, points_expression, parameters, ?, ?, ?
+		FROM rules WHERE id = ?`,
+		nextRevision, changeType, from, changedBy, ruleID,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return nextRevision, nil
+}
+
+// currentRuleRevisionID 获取规则最新版本的记录ID（内部函数）
+func currentRuleRevisionID(tx *sql.Tx, ruleID int) (int, error) {
+	var revisionID int
+	err := tx.QueryRow(
+		"SELECT id FROM rule_revisions WHERE rule_id = ? ORDER BY revision DESC LIMIT 1",
+		ruleID,
+	).Scan(&revisionID)
+	return revisionID, err
+}
+
+// diffRuleRevisions 比较相邻两个版本，返回发生变化的字段及新旧值
+func diffRuleRevisions(previous, current *models.RuleRevision, userID, memberCount int) gin.H {
+	changes := gin.H{}
+	if previous == nil {
+		return changes
+	}
+
+	if previous.Name != current.Name {
+		changes["name"] = gin.H{"old": previous.Name, "new": current.Name}
+	}
+	if previous.Description != current.Description {
+		changes["description"] = gin.H{"old": previous.Description, "new": current.Description}
+	}
+	if previous.Points != current.Points {
+		changes["points"] = gin.H{"old": previous.Points, "new": current.Points}
+	}
+	if previous.TargetType != current.TargetType || intValue(previous.TargetUserID) != intValue(current.TargetUserID) {
+		changes["target_type"] = gin.H{
+			"old": ruleTargetForUser(previous.TargetType, previous.TargetUserID, userID, memberCount),
+			"new": ruleTargetForUser(current.TargetType, current.TargetUserID, userID, memberCount),
+		}
+		changes["target_user_id"] = gin.H{"old": previous.TargetUserID, "new": current.TargetUserID}
+	}
+	if previous.IsActive != current.IsActive {
+		changes["is_active"] = gin.H{"old": previous.IsActive, "new": current.IsActive}
+	}
+	if stringValue(previous.PointsExpression) != stringValue(current.PointsExpression) {
+		changes["points_expression"] = gin.H{"old": previous.PointsExpression, "new": current.PointsExpression}
+	}
+	if !reflect.DeepEqual(previous.Parameters, current.Parameters) {
+		changes["parameters"] = gin.H{"old": previous.Parameters, "new": current.Parameters}
+	}
+
+	return changes
+}
+
+// stringValue 返回字符串指针的值，nil 视为空字符串
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// intValue 返回整数指针的值，nil 视为 0
+func intValue(i *int) int {
+	if i == nil {
+		return 0
+	}
+	return *i
+}
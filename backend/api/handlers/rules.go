@@ -2,10 +2,15 @@ package handlers
 
 import (
 	"database/sql"
+	"encoding/json"
+	"io"
 	"net/http"
 	"strconv"
 
 	"booonus-backend/internal/database"
+	"booonus-backend/internal/expr"
+	"booonus-backend/internal/notifications"
+	"booonus-backend/internal/permissions"
 	"booonus-backend/models"
 	"booonus-backend/pkg/logger"
 
@@ -30,22 +35,18 @@ func GetRules(c *gin.Context) {
 		return
 	}
 
-	// 获取情侣关系信息以确定用户位置
-	var user1ID, user2ID int
-	err = database.DB.QueryRow("SELECT user1_id, user2_id FROM couples WHERE id = ?", coupleID.Int64).Scan(&user1ID, &user2ID)
+	// 家庭成员数决定目标的显示方式
+	memberIDs, err := householdMemberIDs(int(coupleID.Int64))
 	if err != nil {
-		logger.Error("Failed to get couple users: " + err.Error())
+		logger.Error("Failed to get household members: " + err.Error())
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
 		return
 	}
 
-	// 确定当前用户是user1还是user2
-	isCurrentUserUser1 := (userID == user1ID)
-
 	// 获取规则列表，包含置顶信息
 	query := `
-		SELECT r.id, r.couple_id, r.name, r.description, r.points, r.target_type, r.is_active,
-		       r.created_at, r.updated_at,
+		SELECT r.id, r.couple_id, r.name, r.description, r.points, r.target_type, r.target_user_id, r.is_active,
+		       r.created_at, r.updated_at, r.points_expression, r.parameters,
 		       CASE WHEN pr.rule_id IS NOT NULL THEN 1 ELSE 0 END as is_pinned,
 		       pr.pinned_at
 		FROM rules r
@@ -70,10 +71,12 @@ func GetRules(c *gin.Context) {
 		var rule models.Rule
 		var isPinned int
 		var pinnedAt sql.NullTime
+		var pointsExpression, parameters sql.NullString
 
 		err := rows.Scan(
 			&rule.ID, &rule.CoupleID, &rule.Name, &rule.Description, &rule.Points,
-			&rule.TargetType, &rule.IsActive, &rule.CreatedAt, &rule.UpdatedAt,
+			&rule.TargetType, &rule.TargetUserID, &rule.IsActive, &rule.CreatedAt, &rule.UpdatedAt,
+			&pointsExpression, &parameters,
 			&isPinned, &pinnedAt,
 		)
 		if err != nil {
@@ -81,6 +84,16 @@ func GetRules(c *gin.Context) {
 			continue
 		}
 
+		// 设置积分表达式信息
+		if pointsExpression.Valid {
+			rule.PointsExpression = &pointsExpression.String
+		}
+		rule.Parameters, err = parseRuleParams(parameters)
+		if err != nil {
+			logger.Error("Failed to parse rule parameters: " + err.Error())
+			continue
+		}
+
 		// 设置置顶信息
 		isPinnedBool := isPinned == 1
 		rule.IsPinned = &isPinnedBool
@@ -88,37 +101,23 @@ func GetRules(c *gin.Context) {
 			rule.PinnedAt = &pinnedAt.Time
 		}
 
-		// 转换target_type为前端友好的格式
-		var targetTypeForFrontend string
-		switch rule.TargetType {
-		case "user1":
-			if isCurrentUserUser1 {
-				targetTypeForFrontend = "current_user"
-			} else {
-				targetTypeForFrontend = "partner"
-			}
-		case "user2":
-			if isCurrentUserUser1 {
-				targetTypeForFrontend = "partner"
-			} else {
-				targetTypeForFrontend = "current_user"
-			}
-		case "both":
-			targetTypeForFrontend = "both"
-		default:
-			targetTypeForFrontend = rule.TargetType
-		}
+		// 转换target_type为相对当前用户的格式
+		targetTypeForFrontend := ruleTargetForUser(rule.TargetType, rule.TargetUserID, userID, len(memberIDs))
 
 		ruleData := gin.H{
-			"id":          rule.ID,
-			"couple_id":   rule.CoupleID,
-			"name":        rule.Name,
-			"description": rule.Description,
-			"points":      rule.Points,
-			"target_type": targetTypeForFrontend,
-			"is_active":   rule.IsActive,
-			"created_at":  rule.CreatedAt,
-			"updated_at":  rule.UpdatedAt,
+			"id":             rule.ID,
+			"couple_id":      rule.CoupleID,
+			"name":           rule.Name,
+			"description":    rule.Description,
+			"points":         rule.Points,
+			"target_type":    targetTypeForFrontend,
+			"target_user_id": rule.TargetUserID,
+			"is_active":      rule.IsActive,
+			"created_at":     rule.CreatedAt,
+			"updated_at":     rule.UpdatedAt,
+
+			"points_expression": rule.PointsExpression,
+			"parameters":        rule.Parameters,
 		}
 
 		// 添加置顶信息
@@ -142,10 +141,13 @@ func CreateRule(c *gin.Context) {
 	userID := c.GetInt("user_id")
 
 	var req struct {
-		Name        string `json:"name" binding:"required"`
-		Description string `json:"description"`
-		Points      int    `json:"points" binding:"required"`
-		TargetType  string `json:"target_type" binding:"required,oneof=current_user partner both"`
+		Name             string       `json:"name" binding:"required"`
+		Description      string       `json:"description"`
+		Points           int          `json:"points"`
+		TargetType       string       `json:"target_type" binding:"required,oneof=current_user partner both member any all"`
+		TargetUserID     *int         `json:"target_user_id"`
+		PointsExpression string       `json:"points_expression"`
+		Parameters       []expr.Param `json:"parameters"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -153,9 +155,35 @@ func CreateRule(c *gin.Context) {
 		return
 	}
 
+	// 固定积分规则必须提供积分；表达式规则在保存时检查表达式
+	if req.PointsExpression == "" {
+		if req.Points == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Points is required"})
+			return
+		}
+		if len(req.Parameters) > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Parameters require a points expression"})
+			return
+		}
+	} else if _, err := compileRuleExpression(req.PointsExpression, req.Parameters); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid points expression: " + err.Error()})
+		return
+	}
+
+	parameters, err := encodeRuleParams(req.Parameters)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var pointsExpression interface{}
+	if req.PointsExpression != "" {
+		pointsExpression = req.PointsExpression
+	}
+
 	// 获取用户的情侣关系
 	var coupleID sql.NullInt64
-	err := database.DB.QueryRow("SELECT couple_id FROM users WHERE id = ?", userID).Scan(&coupleID)
+	err = database.DB.QueryRow("SELECT couple_id FROM users WHERE id = ?", userID).Scan(&coupleID)
 	if err != nil {
 		logger.Error("Failed to get user couple info: " + err.Error())
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
@@ -167,40 +195,32 @@ func CreateRule(c *gin.Context) {
 		return
 	}
 
-	// 获取情侣关系信息以确定用户位置
-	var user1ID, user2ID int
-	err = database.DB.QueryRow("SELECT user1_id, user2_id FROM couples WHERE id = ?", coupleID.Int64).Scan(&user1ID, &user2ID)
+	// 转换前端的target_type为数据库格式
+	memberIDs, err := householdMemberIDs(int(coupleID.Int64))
 	if err != nil {
-		logger.Error("Failed to get couple users: " + err.Error())
+		logger.Error("Failed to get household members: " + err.Error())
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
 		return
 	}
+	dbTargetType, targetUserID, errMsg := resolveRuleTarget(req.TargetType, req.TargetUserID, userID, memberIDs)
+	if errMsg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+		return
+	}
 
-	// 转换前端的target_type为数据库格式
-	var dbTargetType string
-	switch req.TargetType {
-	case "current_user":
-		if userID == user1ID {
-			dbTargetType = "user1"
-		} else {
-			dbTargetType = "user2"
-		}
-	case "partner":
-		if userID == user1ID {
-			dbTargetType = "user2"
-		} else {
-			dbTargetType = "user1"
-		}
-	case "both":
-		dbTargetType = "both"
-	default:
-		dbTargetType = req.TargetType
+	// 开始事务
+	tx, err := database.DB.Begin()
+	if err != nil {
+		logger.Error("Failed to begin transaction: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
 	}
+	defer tx.Rollback()
 
 	// 创建规则
-	result, err := database.DB.Exec(
-		"INSERT INTO rules (couple_id, name, description, points, target_type) VALUES (?, ?, ?, ?, ?)",
-		coupleID.Int64, req.Name, req.Description, req.Points, dbTargetType,
+	result, err := tx.Exec(
+		"INSERT INTO rules (couple_id, name, description, points, target_type, target_user_id, points_expression, parameters) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		coupleID.Int64, req.Name, req.Description, req.Points, dbTargetType, targetUserID, pointsExpression, parameters,
 	)
 	if err != nil {
 		logger.Error("Failed to create rule: " + err.Error())
@@ -210,6 +230,29 @@ func CreateRule(c *gin.Context) {
 
 	ruleID, _ := result.LastInsertId()
 
+	// 记录初始版本
+	if _, err = recordRuleRevision(tx, int(ruleID), "create", userID, nil); err != nil {
+		logger.Error("Failed to record rule revision: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create rule"})
+		return
+	}
+
+	ruleEvent := gin.H{"rule_id": ruleID}
+	if err := queueWebhooks(tx, c, streamRuleCreated, ruleEvent); err != nil {
+		logger.Error("Failed to queue webhooks: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create rule"})
+		return
+	}
+
+	// 提交事务
+	if err = tx.Commit(); err != nil {
+		logger.Error("Failed to commit transaction: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create rule"})
+		return
+	}
+
+	publishEvent(c, streamRuleCreated, ruleEvent)
+
 	logger.Info("Rule created: " + strconv.FormatInt(ruleID, 10) + " by user " + strconv.Itoa(userID))
 	c.JSON(http.StatusCreated, gin.H{
 		"message": "Rule created successfully",
@@ -228,12 +271,16 @@ func UpdateRule(c *gin.Context) {
 		return
 	}
 
+	// points_expression 传空字符串表示改回固定积分规则
 	var req struct {
-		Name        string `json:"name"`
-		Description string `json:"description"`
-		Points      int    `json:"points"`
-		TargetType  string `json:"target_type" binding:"omitempty,oneof=current_user partner both"`
-		IsActive    *bool  `json:"is_active"`
+		Name             string        `json:"name"`
+		Description      string        `json:"description"`
+		Points           int           `json:"points"`
+		TargetType       string        `json:"target_type" binding:"omitempty,oneof=current_user partner both member any all"`
+		TargetUserID     *int          `json:"target_user_id"`
+		IsActive         *bool         `json:"is_active"`
+		PointsExpression *string       `json:"points_expression"`
+		Parameters       *[]expr.Param `json:"parameters"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -249,10 +296,10 @@ func UpdateRule(c *gin.Context) {
 
 	// 如果需要更新target_type，需要转换为数据库格式
 	var dbTargetType string
+	var targetUserID *int
 	if req.TargetType != "" {
-		// 获取用户的情侣关系信息以进行转换
+		// 获取用户的家庭成员以进行转换
 		var coupleID sql.NullInt64
-		var user1ID, user2ID int
 		err := database.DB.QueryRow("SELECT couple_id FROM users WHERE id = ?", userID).Scan(&coupleID)
 		if err != nil {
 			logger.Error("Failed to get user couple info: " + err.Error())
@@ -265,31 +312,65 @@ func UpdateRule(c *gin.Context) {
 			return
 		}
 
-		err = database.DB.QueryRow("SELECT user1_id, user2_id FROM couples WHERE id = ?", coupleID.Int64).Scan(&user1ID, &user2ID)
+		memberIDs, err := householdMemberIDs(int(coupleID.Int64))
 		if err != nil {
-			logger.Error("Failed to get couple info: " + err.Error())
+			logger.Error("Failed to get household members: " + err.Error())
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
 			return
 		}
 
 		// 转换前端的target_type为数据库格式
-		switch req.TargetType {
-		case "current_user":
-			if userID == user1ID {
-				dbTargetType = "user1"
-			} else {
-				dbTargetType = "user2"
+		var errMsg string
+		dbTargetType, targetUserID, errMsg = resolveRuleTarget(req.TargetType, req.TargetUserID, userID, memberIDs)
+		if errMsg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+			return
+		}
+	} else if req.TargetUserID != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "target_user_id requires target_type"})
+		return
+	}
+
+	// 如果修改了积分表达式或参数，按修改后的完整配置重新检查
+	var newExpression, newParameters interface{}
+	if req.PointsExpression != nil || req.Parameters != nil {
+		var currentExpression, currentParameters sql.NullString
+		err := database.DB.QueryRow("SELECT points_expression, parameters FROM rules WHERE id = ?", ruleID).Scan(&currentExpression, &currentParameters)
+		if err != nil {
+			logger.Error("Failed to get rule expression: " + err.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			return
+		}
+
+		expression := currentExpression.String
+		if req.PointsExpression != nil {
+			expression = *req.PointsExpression
+		}
+		var params []expr.Param
+		if req.Parameters != nil {
+			params = *req.Parameters
+		} else if params, err = parseRuleParams(currentParameters); err != nil {
+			logger.Error("Failed to parse rule parameters: " + err.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			return
+		}
+
+		if expression == "" {
+			if len(params) > 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Parameters require a points expression"})
+				return
 			}
-		case "partner":
-			if userID == user1ID {
-				dbTargetType = "user2"
-			} else {
-				dbTargetType = "user1"
+		} else {
+			if _, err := compileRuleExpression(expression, params); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid points expression: " + err.Error()})
+				return
 			}
-		case "both":
-			dbTargetType = "both"
-		default:
-			dbTargetType = req.TargetType
+			newExpression = expression
+		}
+
+		if newParameters, err = encodeRuleParams(params); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
 		}
 	}
 
@@ -310,13 +391,17 @@ func UpdateRule(c *gin.Context) {
 		args = append(args, req.Points)
 	}
 	if req.TargetType != "" {
-		updates = append(updates, "target_type = ?")
-		args = append(args, dbTargetType)
+		updates = append(updates, "target_type = ?", "target_user_id = ?")
+		args = append(args, dbTargetType, targetUserID)
 	}
 	if req.IsActive != nil {
 		updates = append(updates, "is_active = ?")
 		args = append(args, *req.IsActive)
 	}
+	if req.PointsExpression != nil || req.Parameters != nil {
+		updates = append(updates, "points_expression = ?", "parameters = ?")
+		args = append(args, newExpression, newParameters)
+	}
 
 	if len(updates) == 0 {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "No fields to update"})
@@ -328,13 +413,45 @@ func UpdateRule(c *gin.Context) {
 
 	query := "UPDATE rules SET " + joinStrings(updates, ", ") + " WHERE id = ?"
 
-	_, err = database.DB.Exec(query, args...)
+	// 开始事务
+	tx, err := database.DB.Begin()
+	if err != nil {
+		logger.Error("Failed to begin transaction: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(query, args...)
 	if err != nil {
 		logger.Error("Failed to update rule: " + err.Error())
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update rule"})
 		return
 	}
 
+	// 记录修改后的版本快照
+	if _, err = recordRuleRevision(tx, ruleID, "update", userID, nil); err != nil {
+		logger.Error("Failed to record rule revision: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update rule"})
+		return
+	}
+
+	ruleEvent := gin.H{"rule_id": ruleID}
+	if err := queueWebhooks(tx, c, streamRuleUpdated, ruleEvent); err != nil {
+		logger.Error("Failed to queue webhooks: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update rule"})
+		return
+	}
+
+	// 提交事务
+	if err = tx.Commit(); err != nil {
+		logger.Error("Failed to commit transaction: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update rule"})
+		return
+	}
+
+	publishEvent(c, streamRuleUpdated, ruleEvent)
+
 	logger.Info("Rule updated: " + strconv.Itoa(ruleID) + " by user " + strconv.Itoa(userID))
 	c.JSON(http.StatusOK, gin.H{"message": "Rule updated successfully"})
 }
@@ -356,14 +473,46 @@ func DeleteRule(c *gin.Context) {
 		return
 	}
 
+	// 开始事务
+	tx, err := database.DB.Begin()
+	if err != nil {
+		logger.Error("Failed to begin transaction: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	defer tx.Rollback()
+
 	// 软删除（设置为不活跃）
-	_, err = database.DB.Exec("UPDATE rules SET is_active = FALSE, updated_at = CURRENT_TIMESTAMP WHERE id = ?", ruleID)
+	_, err = tx.Exec("UPDATE rules SET is_active = FALSE, updated_at = CURRENT_TIMESTAMP WHERE id = ?", ruleID)
 	if err != nil {
 		logger.Error("Failed to delete rule: " + err.Error())
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete rule"})
 		return
 	}
 
+	// 删除也作为一个版本记录下来
+	if _, err = recordRuleRevision(tx, ruleID, "delete", userID, nil); err != nil {
+		logger.Error("Failed to record rule revision: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete rule"})
+		return
+	}
+
+	ruleEvent := gin.H{"rule_id": ruleID}
+	if err := queueWebhooks(tx, c, streamRuleDeleted, ruleEvent); err != nil {
+		logger.Error("Failed to queue webhooks: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete rule"})
+		return
+	}
+
+	// 提交事务
+	if err = tx.Commit(); err != nil {
+		logger.Error("Failed to commit transaction: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete rule"})
+		return
+	}
+
+	publishEvent(c, streamRuleDeleted, ruleEvent)
+
 	logger.Info("Rule deleted: " + strconv.Itoa(ruleID) + " by user " + strconv.Itoa(userID))
 	c.JSON(http.StatusOK, gin.H{"message": "Rule deleted successfully"})
 }
@@ -379,18 +528,35 @@ func ExecuteRule(c *gin.Context) {
 		return
 	}
 
-	// 获取请求体中的目标用户ID（可选，用于"both"类型的规则）
+	// 获取请求体中的目标用户ID（可选，用于"any"类型的规则）
+	// apply_to_all 为 true 时对所有成员同时执行，splits 可按人分配规则积分；apply_to_both 为旧的别名
 	var req struct {
-		TargetUserID *int `json:"target_user_id"`
+		TargetUserID *int                   `json:"target_user_id"`
+		ApplyToAll   bool                   `json:"apply_to_all"`
+		ApplyToBoth  bool                   `json:"apply_to_both"`
+		Splits       []ruleExecuteSplit     `json:"splits"`
+		Note         string                 `json:"note" binding:"max=500"`
+		AttachmentID *int                   `json:"attachment_id"`
+		Inputs       map[string]interface{} `json:"inputs"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 附件必须由执行人自己上传
+	if req.AttachmentID != nil && !canUserUseAttachment(userID, *req.AttachmentID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid attachment ID"})
+		return
 	}
-	c.ShouldBindJSON(&req)
 
 	// 获取规则信息
 	var rule models.Rule
+	var pointsExpression, parameters sql.NullString
 	err = database.DB.QueryRow(
-		"SELECT id, couple_id, name, description, points, target_type, is_active FROM rules WHERE id = ?",
+		"SELECT id, couple_id, name, description, points, target_type, target_user_id, is_active, points_expression, parameters FROM rules WHERE id = ?",
 		ruleID,
-	).Scan(&rule.ID, &rule.CoupleID, &rule.Name, &rule.Description, &rule.Points, &rule.TargetType, &rule.IsActive)
+	).Scan(&rule.ID, &rule.CoupleID, &rule.Name, &rule.Description, &rule.Points, &rule.TargetType, &rule.TargetUserID, &rule.IsActive, &pointsExpression, &parameters)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -413,36 +579,102 @@ func ExecuteRule(c *gin.Context) {
 		return
 	}
 
-	// 获取情侣双方的用户ID
-	var user1ID, user2ID int
-	err = database.DB.QueryRow("SELECT user1_id, user2_id FROM couples WHERE id = ?", rule.CoupleID).Scan(&user1ID, &user2ID)
+	// 表达式规则：校验输入参数并编译表达式，积分在事务中按目标用户分别计算
+	var program *expr.Program
+	var inputs map[string]interface{}
+	var inputsJSON interface{}
+	if pointsExpression.Valid && pointsExpression.String != "" {
+		rule.Parameters, err = parseRuleParams(parameters)
+		if err == nil {
+			program, err = compileRuleExpression(pointsExpression.String, rule.Parameters)
+		}
+		if err != nil {
+			logger.Error("Failed to compile rule expression: " + err.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			return
+		}
+
+		inputs, err = expr.BindInputs(rule.Parameters, req.Inputs)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if len(req.Inputs) > 0 {
+			data, _ := json.Marshal(req.Inputs)
+			inputsJSON = string(data)
+		}
+
+		if len(req.Splits) > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Splits are not supported for rules with a points expression"})
+			return
+		}
+	} else if len(req.Inputs) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "This rule does not accept inputs"})
+		return
+	}
+
+	// 获取家庭成员的用户ID
+	memberIDs, err := householdMemberIDs(rule.CoupleID)
 	if err != nil {
-		logger.Error("Failed to get couple users: " + err.Error())
+		logger.Error("Failed to get household members: " + err.Error())
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
 		return
 	}
+	applyToAll := req.ApplyToAll || req.ApplyToBoth
 
-	// 确定目标用户
-	var targetUsers []int
+	// 确定目标用户及各自获得的积分
+	var targets []ruleExecuteSplit
+	mode := "single"
 	switch rule.TargetType {
-	case "user1":
-		targetUsers = []int{user1ID}
-	case "user2":
-		targetUsers = []int{user2ID}
-	case "both":
-		// 对于"both"类型的规则，需要指定具体的目标用户
+	case "member":
+		if rule.TargetUserID == nil || !containsID(memberIDs, *rule.TargetUserID) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Rule target is no longer a household member"})
+			return
+		}
+		targets = []ruleExecuteSplit{{UserID: *rule.TargetUserID, Points: rule.Points}}
+	case "all":
+		applyToAll = true
+		fallthrough
+	case "any":
+		if applyToAll {
+			var errMsg string
+			targets, errMsg = resolveAllTargets(rule.Points, memberIDs, req.Splits)
+			if errMsg != "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+				return
+			}
+			mode = "all"
+			break
+		}
+
+		// 对于"any"类型的规则，需要指定具体的目标用户
 		if req.TargetUserID == nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Target user ID is required for 'both' type rules"})
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Target user ID is required for 'any' type rules"})
 			return
 		}
 
-		// 验证目标用户ID是否有效（必须是情侣中的一方）
-		if *req.TargetUserID != user1ID && *req.TargetUserID != user2ID {
+		// 验证目标用户ID是否有效（必须是家庭成员）
+		if !containsID(memberIDs, *req.TargetUserID) {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid target user ID"})
 			return
 		}
 
-		targetUsers = []int{*req.TargetUserID}
+		targets = []ruleExecuteSplit{{UserID: *req.TargetUserID, Points: rule.Points}}
+	}
+
+	if applyToAll && mode != "all" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "apply_to_all is only allowed for 'any' and 'all' type rules"})
+		return
+	}
+
+	// 没有 rules.execute_others 权限的成员（如孩子）只能对自己执行规则
+	if !permissions.Allowed(c.GetString("household_role"), permissions.RulesExecuteOthers) {
+		for _, target := range targets {
+			if target.UserID != userID {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied", "permission": permissions.RulesExecuteOthers})
+				return
+			}
+		}
 	}
 
 	// 开始事务
@@ -454,10 +686,50 @@ func ExecuteRule(c *gin.Context) {
 	}
 	defer tx.Rollback()
 
-	// 为每个目标用户执行规则
-	for _, targetUserID := range targetUsers {
+	// 获取本次执行所使用的规则版本
+	revisionID, err := currentRuleRevisionID(tx, ruleID)
+	if err != nil {
+		logger.Error("Failed to get rule revision: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to execute rule"})
+		return
+	}
+
+	// 创建执行记录，同一次执行产生的积分历史通过它关联，撤销时一起撤销
+	var note, attachmentID interface{}
+	if req.Note != "" {
+		note = req.Note
+	}
+	if req.AttachmentID != nil {
+		attachmentID = *req.AttachmentID
+	}
+
+	result, err := tx.Exec(
+		"INSERT INTO rule_executions (rule_id, mode, executed_by, note, attachment_id, inputs) VALUES (?, ?, ?, ?, ?, ?)",
+		ruleID, mode, userID, note, attachmentID, inputsJSON,
+	)
+	if err != nil {
+		logger.Error("Failed to create rule execution: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to execute rule"})
+		return
+	}
+	executionID64, _ := result.LastInsertId()
+	executionID := int(executionID64)
+
+	// 为每个目标用户执行规则，提交后再推送通知
+	var pushes []notifications.Notification
+	for i, target := range targets {
+		// 表达式规则按目标用户计算实际积分
+		if program != nil {
+			target.Points, err = evaluateRulePoints(tx, program, ruleID, rule.Points, target.UserID, inputs)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to evaluate points expression: " + err.Error()})
+				return
+			}
+			targets[i] = target
+		}
+
 		// 更新用户积分
-		err = updateUserPoints(tx, targetUserID, rule.Points)
+		err = updateUserPoints(tx, target.UserID, target.Points)
 		if err != nil {
 			logger.Error("Failed to update user points: " + err.Error())
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to execute rule"})
@@ -465,13 +737,47 @@ func ExecuteRule(c *gin.Context) {
 		}
 
 		// 添加积分历史记录
-		description := "执行规则: " + rule.Name
-		err = addPointsHistory(tx, targetUserID, rule.Points, "rule", &ruleID, description, true)
+		_, err = insertPointsHistory(tx, pointsHistoryEntry{
+			UserID:         target.UserID,
+			Points:         target.Points,
+			Type:           "rule",
+			ReferenceID:    &ruleID,
+			Description:    "执行规则: " + rule.Name,
+			CanRevert:      true,
+			RuleRevisionID: &revisionID,
+			ExecutionID:    &executionID,
+		})
 		if err != nil {
 			logger.Error("Failed to add points history: " + err.Error())
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to execute rule"})
 			return
 		}
+
+		points := target.Points
+		notification := notifications.Notification{
+			UserID:        target.UserID,
+			Type:          notifications.TypePoints,
+			ActorID:       &userID,
+			MessageKey:    notifications.MessageRuleExecuted,
+			MessageArgs:   []string{rule.Name},
+			Points:        &points,
+			ReferenceType: "rule",
+			ReferenceID:   &ruleID,
+		}
+		sendNotification(tx, notification)
+		pushes = append(pushes, notification)
+	}
+
+	pointsEvent := gin.H{
+		"source":       "rule",
+		"rule_id":      ruleID,
+		"execution_id": executionID,
+		"targets":      targets,
+	}
+	if err := queueWebhooks(tx, c, streamPointsChanged, pointsEvent); err != nil {
+		logger.Error("Failed to queue webhooks: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to execute rule"})
+		return
 	}
 
 	// 提交事务
@@ -481,13 +787,65 @@ func ExecuteRule(c *gin.Context) {
 		return
 	}
 
+	publishEvent(c, streamPointsChanged, pointsEvent)
+	for _, notification := range pushes {
+		pushNotification(notification)
+	}
+
 	logger.Info("Rule executed: " + strconv.Itoa(ruleID) + " by user " + strconv.Itoa(userID))
 	c.JSON(http.StatusOK, gin.H{
 		"message":        "Rule executed successfully",
-		"affected_users": len(targetUsers),
+		"affected_users": len(targets),
+		"execution_id":   executionID,
+		"mode":           mode,
+		"targets":        targets,
 	})
 }
 
+// ruleExecuteSplit 单个目标用户在本次规则执行中获得的积分
+type ruleExecuteSplit struct {
+	UserID int `json:"user_id"`
+	Points int `json:"points"`
+}
+
+// resolveAllTargets 确定规则同时作用于所有成员时每人获得的积分
+// 不指定splits时每人获得完整的规则积分；指定时按splits分配，且合计必须等于规则积分
+func resolveAllTargets(rulePoints int, memberIDs []int, splits []ruleExecuteSplit) ([]ruleExecuteSplit, string) {
+	if len(splits) == 0 {
+		targets := make([]ruleExecuteSplit, 0, len(memberIDs))
+		for _, memberID := range memberIDs {
+			targets = append(targets, ruleExecuteSplit{UserID: memberID, Points: rulePoints})
+		}
+		return targets, ""
+	}
+
+	if len(splits) != len(memberIDs) {
+		return nil, "Splits must contain exactly one entry for each member"
+	}
+
+	seen := map[int]bool{}
+	total := 0
+	for _, split := range splits {
+		if !containsID(memberIDs, split.UserID) {
+			return nil, "Invalid target user ID in splits"
+		}
+		if seen[split.UserID] {
+			return nil, "Duplicate target user ID in splits"
+		}
+		if (rulePoints > 0 && split.Points < 0) || (rulePoints < 0 && split.Points > 0) {
+			return nil, "Split points must have the same sign as the rule points"
+		}
+		seen[split.UserID] = true
+		total += split.Points
+	}
+
+	if total != rulePoints {
+		return nil, "Split points must add up to the rule points"
+	}
+
+	return splits, ""
+}
+
 // PinRule 置顶规则
 func PinRule(c *gin.Context) {
 	userID := c.GetInt("user_id")
@@ -558,9 +916,67 @@ func canUserAccessRule(userID, ruleID int) bool {
 	query := `
 		SELECT COUNT(*) FROM rules r
 		JOIN couples c ON r.couple_id = c.id
-		JOIN users u ON (c.user1_id = u.id OR c.user2_id = u.id)
-		WHERE r.id = ? AND u.id = ?
+		JOIN household_members m ON m.couple_id = r.couple_id
+		WHERE r.id = ? AND m.user_id = ? AND m.left_at IS NULL AND c.status = 'active'
 	`
 	err := database.DB.QueryRow(query, ruleID, userID).Scan(&count)
 	return err == nil && count > 0
 }
+
+// resolveRuleTarget 将请求中的target_type转换为数据库格式
+// current_user/partner 转换为指定成员，both 等同于 any；返回错误信息时请求无效
+func resolveRuleTarget(targetType string, targetUserID *int, userID int, memberIDs []int) (string, *int, string) {
+	if targetUserID != nil && targetType != "member" {
+		return "", nil, "target_user_id is only allowed for 'member' target type"
+	}
+
+	switch targetType {
+	case "current_user":
+		return "member", &userID, ""
+	case "partner":
+		if len(memberIDs) != 2 {
+			return "", nil, "Use 'member' with target_user_id in households with more than two members"
+		}
+		for _, memberID := range memberIDs {
+			if memberID != userID {
+				partnerID := memberID
+				return "member", &partnerID, ""
+			}
+		}
+		return "", nil, "Partner not found"
+	case "member":
+		if targetUserID == nil {
+			return "", nil, "target_user_id is required for 'member' target type"
+		}
+		if !containsID(memberIDs, *targetUserID) {
+			return "", nil, "Invalid target user ID"
+		}
+		return "member", targetUserID, ""
+	case "both", "any":
+		return "any", nil, ""
+	default:
+		return targetType, nil, ""
+	}
+}
+
+// ruleTargetForUser 将数据库中的target_type转换为相对当前用户的格式
+// 两人家庭沿用 current_user/partner/both，多人家庭返回 member/any
+func ruleTargetForUser(targetType string, targetUserID *int, userID, memberCount int) string {
+	switch targetType {
+	case "member":
+		if targetUserID != nil && *targetUserID == userID {
+			return "current_user"
+		}
+		if memberCount == 2 {
+			return "partner"
+		}
+		return "member"
+	case "any":
+		if memberCount <= 2 {
+			return "both"
+		}
+		return "any"
+	default:
+		return targetType
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"strconv"
 
 	"booonus-backend/internal/database"
+	"booonus-backend/internal/notifications"
+	"booonus-backend/internal/permissions"
 	"booonus-backend/models"
 	"booonus-backend/pkg/logger"
 
@@ -36,7 +38,7 @@ func GetShopItems(c *gin.Context) {
 		}
 
 		query = `
-			SELECT s.id, s.user_id, s.name, s.description, s.price, s.is_active, s.created_at, s.updated_at,
+			SELECT s.id, s.user_id, s.name, s.description, s.price, s.stock, s.is_active, s.created_at, s.updated_at,
 			       u.username
 			FROM shop_items s
 			JOIN users u ON s.user_id = u.id
@@ -45,18 +47,23 @@ func GetShopItems(c *gin.Context) {
 		`
 		args = []interface{}{ownerID}
 	} else {
-		// 获取情侣的所有商品
+		// 获取家庭所有成员的商品
+		memberIDs, err := coupleMemberIDs(userID)
+		if err != nil {
+			logger.Error("Failed to get couple members: " + err.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			return
+		}
+		var inClause string
+		inClause, args = memberInClause(memberIDs)
 		query = `
-			SELECT s.id, s.user_id, s.name, s.description, s.price, s.is_active, s.created_at, s.updated_at,
+			SELECT s.id, s.user_id, s.name, s.description, s.price, s.stock, s.is_active, s.created_at, s.updated_at,
 			       u.username
 			FROM shop_items s
 			JOIN users u ON s.user_id = u.id
-			JOIN couples c ON (c.user1_id = u.id OR c.user2_id = u.id)
-			JOIN users u2 ON (c.user1_id = u2.id OR c.user2_id = u2.id)
-			WHERE u2.id = ? AND s.is_active = TRUE
+			WHERE s.user_id IN (` + inClause + `) AND s.is_active = TRUE
 			ORDER BY s.created_at DESC
 		`
-		args = []interface{}{userID}
 	}
 
 	rows, err := database.DB.Query(query, args...)
@@ -73,7 +80,7 @@ func GetShopItems(c *gin.Context) {
 		var username string
 
 		err := rows.Scan(
-			&item.ID, &item.UserID, &item.Name, &item.Description, &item.Price,
+			&item.ID, &item.UserID, &item.Name, &item.Description, &item.Price, &item.Stock,
 			&item.IsActive, &item.CreatedAt, &item.UpdatedAt, &username,
 		)
 		if err != nil {
@@ -88,6 +95,7 @@ func GetShopItems(c *gin.Context) {
 			"name":        item.Name,
 			"description": item.Description,
 			"price":       item.Price,
+			"stock":       item.Stock,
 			"is_active":   item.IsActive,
 			"created_at":  item.CreatedAt,
 			"updated_at":  item.UpdatedAt,
@@ -107,6 +115,7 @@ func CreateShopItem(c *gin.Context) {
 		Name        string `json:"name" binding:"required"`
 		Description string `json:"description"`
 		Price       int    `json:"price" binding:"required,min=1"`
+		Stock       *int   `json:"stock" binding:"omitempty,min=0"` // 不填表示不限量
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -116,8 +125,8 @@ func CreateShopItem(c *gin.Context) {
 
 	// 创建商品
 	result, err := database.DB.Exec(
-		"INSERT INTO shop_items (user_id, name, description, price) VALUES (?, ?, ?, ?)",
-		userID, req.Name, req.Description, req.Price,
+		"INSERT INTO shop_items (user_id, name, description, price, stock) VALUES (?, ?, ?, ?, ?)",
+		userID, req.Name, req.Description, req.Price, req.Stock,
 	)
 	if err != nil {
 		logger.Error("Failed to create shop item: " + err.Error())
@@ -148,7 +157,8 @@ func UpdateShopItem(c *gin.Context) {
 	var req struct {
 		Name        string `json:"name"`
 		Description string `json:"description"`
-		Price       int    `json:"price" binding:"min=1"`
+		Price       int    `json:"price" binding:"omitempty,min=1"`
+		Stock       *int   `json:"stock" binding:"omitempty,min=-1"` // -1 表示改为不限量
 		IsActive    *bool  `json:"is_active"`
 	}
 
@@ -191,6 +201,14 @@ func UpdateShopItem(c *gin.Context) {
 		updates = append(updates, "price = ?")
 		args = append(args, req.Price)
 	}
+	if req.Stock != nil {
+		updates = append(updates, "stock = ?")
+		if *req.Stock < 0 {
+			args = append(args, nil)
+		} else {
+			args = append(args, *req.Stock)
+		}
+	}
 	if req.IsActive != nil {
 		updates = append(updates, "is_active = ?")
 		args = append(args, *req.IsActive)
@@ -272,9 +290,9 @@ func BuyShopItem(c *gin.Context) {
 	// 获取商品信息
 	var item models.Shop
 	err = database.DB.QueryRow(
-		"SELECT id, user_id, name, price, is_active FROM shop_items WHERE id = ?",
+		"SELECT id, user_id, name, price, stock, is_active FROM shop_items WHERE id = ?",
 		itemID,
-	).Scan(&item.ID, &item.UserID, &item.Name, &item.Price, &item.IsActive)
+	).Scan(&item.ID, &item.UserID, &item.Name, &item.Price, &item.Stock, &item.IsActive)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -291,6 +309,11 @@ func BuyShopItem(c *gin.Context) {
 		return
 	}
 
+	if item.Stock != nil && *item.Stock <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Shop item is out of stock"})
+		return
+	}
+
 	// 不能购买自己的商品
 	if item.UserID == userID {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot buy your own item"})
@@ -339,6 +362,12 @@ func BuyShopItem(c *gin.Context) {
 
 	transactionID, _ := result.LastInsertId()
 
+	// 占用库存（并发购买时以事务内的检查为准）
+	if err = takeShopItemStock(tx, itemID); err != nil {
+		respondTransactionStateError(c, err, "Failed to process transaction")
+		return
+	}
+
 	// 扣除买家积分
 	err = updateUserPoints(tx, userID, -item.Price)
 	if err != nil {
@@ -374,6 +403,40 @@ func BuyShopItem(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process transaction"})
 		return
 	}
+	notification := notifications.Notification{
+		UserID:        item.UserID,
+		Type:          notifications.TypePurchase,
+		ActorID:       &userID,
+		MessageKey:    notifications.MessageItemSold,
+		MessageArgs:   []string{item.Name},
+		Points:        &sellerPoints,
+		ReferenceType: "transaction",
+		ReferenceID:   &transactionIDInt,
+	}
+	sendNotification(tx, notification)
+
+	purchaseEvent := gin.H{
+		"transaction_id": transactionID,
+		"shop_item_id":   itemID,
+		"buyer_id":       userID,
+		"seller_id":      item.UserID,
+		"price":          item.Price,
+	}
+	pointsEvent := gin.H{
+		"source":         "transaction",
+		"transaction_id": transactionID,
+		"targets":        []ruleExecuteSplit{{UserID: userID, Points: -item.Price}, {UserID: item.UserID, Points: sellerPoints}},
+	}
+	if err := queueWebhooks(tx, c, streamPurchase, purchaseEvent); err != nil {
+		logger.Error("Failed to queue webhooks: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process transaction"})
+		return
+	}
+	if err := queueWebhooks(tx, c, streamPointsChanged, pointsEvent); err != nil {
+		logger.Error("Failed to queue webhooks: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process transaction"})
+		return
+	}
 
 	// 提交事务
 	if err = tx.Commit(); err != nil {
@@ -382,6 +445,10 @@ func BuyShopItem(c *gin.Context) {
 		return
 	}
 
+	publishEvent(c, streamPurchase, purchaseEvent)
+	publishEvent(c, streamPointsChanged, pointsEvent)
+	pushNotification(notification)
+
 	logger.Info("Transaction completed: " + strconv.FormatInt(transactionID, 10))
 	c.JSON(http.StatusOK, gin.H{
 		"message":        "Purchase completed successfully",
@@ -391,21 +458,8 @@ func BuyShopItem(c *gin.Context) {
 
 // canUserAccessShop 检查用户是否可以访问某个用户的小卖部
 func canUserAccessShop(userID, shopOwnerID int) bool {
-	// 可以访问自己的小卖部
-	if userID == shopOwnerID {
-		return true
-	}
-
-	// 检查是否是情侣关系
-	var count int
-	query := `
-		SELECT COUNT(*) FROM couples c
-		JOIN users u1 ON (c.user1_id = u1.id OR c.user2_id = u1.id)
-		JOIN users u2 ON (c.user1_id = u2.id OR c.user2_id = u2.id)
-		WHERE u1.id = ? AND u2.id = ?
-	`
-	err := database.DB.QueryRow(query, userID, shopOwnerID).Scan(&count)
-	return err == nil && count > 0
+	// 可以访问自己和同一家庭成员的小卖部
+	return userID == shopOwnerID || permissions.SameHousehold(userID, shopOwnerID)
 }
 
 // joinStrings 连接字符串数组（简单实现）
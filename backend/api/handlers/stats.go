@@ -0,0 +1,542 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"booonus-backend/internal/cache"
+	"booonus-backend/internal/database"
+	"booonus-backend/internal/ledger"
+	"booonus-backend/pkg/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// statsCache 统计结果缓存，键中包含数据版本，数据变化后旧结果自然不再命中
+var statsCache = cache.New(10*time.Minute, 1000)
+
+// slotMinutes 数据库聚合的时间粒度；所有时区的偏移都是 15 分钟的整数倍
+const slotMinutes = 15
+
+// defaultStatsTopLimit 排行榜默认条数
+const defaultStatsTopLimit = 5
+
+// statsMember 参与统计的用户
+type statsMember struct {
+	ID       int
+	Username string
+}
+
+// statsRequest 统计接口的公共参数
+type statsRequest struct {
+	Members     []statsMember
+	Loc         *time.Location
+	From, To    time.Time // [From, To)
+	Granularity string    // "day", "week", "month"
+	WeekStart   time.Weekday
+}
+
+// pointsSlot 某个用户在一个 15 分钟时间段内的积分变化
+type pointsSlot struct {
+	UserID int
+	Start  time.Time
+	Earned int
+	Spent  int
+}
+
+// GetPointsStats 按天/周/月统计情侣双方的获得、花费和净积分
+func GetPointsStats(c *gin.Context) {
+	req, ok := parseStatsRequest(c, true)
+	if !ok {
+		return
+	}
+
+	result, err := cachedStats("points", req, func() (gin.H, error) {
+		slots, err := loadPointsSlots(req)
+		if err != nil {
+			return nil, err
+		}
+
+		var users []gin.H
+		for _, m := range req.Members {
+			index := map[string]int{}
+			var buckets []gin.H
+			for _, start := range statsBuckets(req) {
+				index[bucketLabel(start, req.Granularity)] = len(buckets)
+				buckets = append(buckets, gin.H{"period": bucketLabel(start, req.Granularity), "earned": 0, "spent": 0, "net": 0})
+			}
+
+			totalEarned, totalSpent := 0, 0
+			for _, slot := range slots {
+				if slot.UserID != m.ID {
+					continue
+				}
+				label := bucketLabel(bucketStart(slot.Start.In(req.Loc), req.Granularity, req.WeekStart), req.Granularity)
+				i, ok := index[label]
+				if !ok {
+					continue
+				}
+				buckets[i]["earned"] = buckets[i]["earned"].(int) + slot.Earned
+				buckets[i]["spent"] = buckets[i]["spent"].(int) + slot.Spent
+				buckets[i]["net"] = buckets[i]["net"].(int) + slot.Earned - slot.Spent
+				totalEarned += slot.Earned
+				totalSpent += slot.Spent
+			}
+
+			users = append(users, gin.H{
+				"user_id":  m.ID,
+				"username": m.Username,
+				"buckets":  buckets,
+				"total": gin.H{
+					"earned": totalEarned,
+					"spent":  totalSpent,
+					"net":    totalEarned - totalSpent,
+				},
+			})
+		}
+
+		return gin.H{"users": users}, nil
+	})
+	if err != nil {
+		logger.Error("Failed to compute points stats: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get stats"})
+		return
+	}
+
+	c.JSON(http.StatusOK, statsResponse(req, result))
+}
+
+// GetBalanceStats 情侣双方在每个时间段结束时的积分余额
+func GetBalanceStats(c *gin.Context) {
+	req, ok := parseStatsRequest(c, true)
+	if !ok {
+		return
+	}
+
+	result, err := cachedStats("balance", req, func() (gin.H, error) {
+		var users []gin.H
+		for _, m := range req.Members {
+			// 余额按当时的撤销状态重建，之后的撤销不影响过去的余额
+			opening, err := ledger.BalanceBefore(m.ID, req.From)
+			if err != nil {
+				return nil, err
+			}
+
+			var series []gin.H
+			for _, start := range statsBuckets(req) {
+				end := nextBucket(start, req.Granularity)
+				if end.After(req.To) {
+					end = req.To
+				}
+				balance, err := ledger.BalanceBefore(m.ID, end)
+				if err != nil {
+					return nil, err
+				}
+				series = append(series, gin.H{"period": bucketLabel(start, req.Granularity), "balance": balance})
+			}
+
+			users = append(users, gin.H{
+				"user_id":         m.ID,
+				"username":        m.Username,
+				"opening_balance": opening,
+				"series":          series,
+			})
+		}
+
+		return gin.H{"users": users}, nil
+	})
+	if err != nil {
+		logger.Error("Failed to compute balance stats: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get stats"})
+		return
+	}
+
+	c.JSON(http.StatusOK, statsResponse(req, result))
+}
+
+// GetRuleStats 执行次数最多和积分最多的规则
+func GetRuleStats(c *gin.Context) {
+	req, ok := parseStatsRequest(c, false)
+	if !ok {
+		return
+	}
+	limit := statsTopLimit(c)
+
+	result, err := cachedStats("rules:"+strconv.Itoa(limit), req, func() (gin.H, error) {
+		inClause, args := statsMemberClause(req)
+		args = append(args, dbTime(req.From), dbTime(req.To))
+
+		// 同时作用于双方的一次执行产生两条记录，按执行记录去重计数
+		rows, err := database.DB.Query(`
+			SELECT ph.reference_id, r.name,
+			       COUNT(DISTINCT COALESCE(ph.execution_id, -ph.id)) AS executions,
+			       SUM(ph.points) AS total_points
+			FROM points_history ph
+			JOIN rules r ON ph.reference_id = r.id
+			WHERE ph.type = 'rule' AND ph.is_reverted = FALSE
+			  AND ph.user_id IN (`+inClause+`)
+			  AND ph.created_at >= ? AND ph.created_at < ?
+			GROUP BY ph.reference_id, r.name`,
+			args...,
+		)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		var all []gin.H
+		for rows.Next() {
+			var ruleID, executions, points int
+			var name string
+			if err := rows.Scan(&ruleID, &name, &executions, &points); err != nil {
+				return nil, err
+			}
+			all = append(all, gin.H{"rule_id": ruleID, "name": name, "executions": executions, "points": points})
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+
+		return gin.H{
+			"by_executions": topN(all, "executions", limit),
+			"by_points":     topN(all, "points", limit),
+		}, nil
+	})
+	if err != nil {
+		logger.Error("Failed to compute rule stats: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get stats"})
+		return
+	}
+
+	c.JSON(http.StatusOK, statsResponse(req, result))
+}
+
+// GetShopStats 购买次数最多的商品和每人的平均购买金额
+func GetShopStats(c *gin.Context) {
+	req, ok := parseStatsRequest(c, false)
+	if !ok {
+		return
+	}
+	limit := statsTopLimit(c)
+
+	result, err := cachedStats("shop:"+strconv.Itoa(limit), req, func() (gin.H, error) {
+		inClause, args := statsMemberClause(req)
+		args = append(args, dbTime(req.From), dbTime(req.To))
+
+		rows, err := database.DB.Query(`
+			SELECT t.shop_item_id, s.name, COUNT(*) AS purchases, SUM(t.points) AS total_points
+			FROM transactions t
+			JOIN shop_items s ON t.shop_item_id = s.id
+			WHERE t.status = 'completed'
+			  AND t.buyer_id IN (`+inClause+`)
+			  AND t.created_at >= ? AND t.created_at < ?
+			GROUP BY t.shop_item_id, s.name
+			ORDER BY purchases DESC, total_points DESC
+			LIMIT ?`,
+			append(args, limit)...,
+		)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		topItems := []gin.H{}
+		for rows.Next() {
+			var itemID, purchases, points int
+			var name string
+			if err := rows.Scan(&itemID, &name, &purchases, &points); err != nil {
+				return nil, err
+			}
+			topItems = append(topItems, gin.H{"shop_item_id": itemID, "name": name, "purchases": purchases, "points": points})
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+
+		var buyers []gin.H
+		for _, m := range req.Members {
+			var purchases, total int
+			err := database.DB.QueryRow(`
+				SELECT COUNT(*), COALESCE(SUM(points), 0)
+				FROM transactions
+				WHERE status = 'completed' AND buyer_id = ? AND created_at >= ? AND created_at < ?`,
+				m.ID, dbTime(req.From), dbTime(req.To),
+			).Scan(&purchases, &total)
+			if err != nil {
+				return nil, err
+			}
+
+			average := 0.0
+			if purchases > 0 {
+				average = float64(total) / float64(purchases)
+			}
+			buyers = append(buyers, gin.H{
+				"user_id":          m.ID,
+				"username":         m.Username,
+				"purchases":        purchases,
+				"points":           total,
+				"average_purchase": average,
+			})
+		}
+
+		return gin.H{
+			"top_items": topItems,
+			"buyers":    buyers,
+		}, nil
+	})
+	if err != nil {
+		logger.Error("Failed to compute shop stats: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get stats"})
+		return
+	}
+
+	c.JSON(http.StatusOK, statsResponse(req, result))
+}
+
+// parseStatsRequest 解析统计接口的公共参数，失败时已写入响应
+// withGranularity 为 true 时解析 granularity 参数（day、week、month，默认 day）
+// 时区和每周第一天默认取用户偏好设置，可以用 tz、week_start 参数覆盖
+func parseStatsRequest(c *gin.Context, withGranularity bool) (statsRequest, bool) {
+	userID := c.GetInt("user_id")
+	req := statsRequest{Granularity: "day"}
+
+	loc, ok := requestLocation(c)
+	if !ok {
+		return req, false
+	}
+	req.Loc = loc
+
+	prefs, err := loadUserPreferences(userID)
+	if err != nil {
+		logger.Error("Failed to get user preferences: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return req, false
+	}
+	req.WeekStart = prefs.WeekStart
+	if name := c.Query("week_start"); name != "" {
+		if req.WeekStart, ok = parseWeekday(name); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid week start, expected a weekday name such as monday"})
+			return req, false
+		}
+	}
+
+	if withGranularity {
+		req.Granularity = c.DefaultQuery("granularity", "day")
+		if req.Granularity != "day" && req.Granularity != "week" && req.Granularity != "month" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid granularity, expected day, week or month"})
+			return req, false
+		}
+	}
+
+	// 默认统计最近 30 天
+	if c.Query("from") == "" && c.Query("to") == "" {
+		today := bucketStart(time.Now().In(loc), "day", req.WeekStart)
+		req.From = today.AddDate(0, 0, -29)
+		req.To = today.AddDate(0, 0, 1)
+	} else {
+		req.From, req.To, ok = parseDateRange(c, loc)
+		if !ok {
+			return req, false
+		}
+	}
+
+	memberIDs, err := coupleMemberIDs(userID)
+	if err != nil {
+		logger.Error("Failed to get couple members: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return req, false
+	}
+	for _, id := range memberIDs {
+		var username string
+		if err := database.DB.QueryRow("SELECT username FROM users WHERE id = ?", id).Scan(&username); err != nil {
+			logger.Error("Failed to get username: " + err.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			return req, false
+		}
+		req.Members = append(req.Members, statsMember{ID: id, Username: username})
+	}
+
+	return req, true
+}
+
+// statsTopLimit 排行榜条数（limit 参数，1-50）
+func statsTopLimit(c *gin.Context) int {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultStatsTopLimit)))
+	if err != nil || limit <= 0 {
+		return defaultStatsTopLimit
+	}
+	if limit > 50 {
+		return 50
+	}
+	return limit
+}
+
+// statsResponse 在统计结果中附上查询参数
+func statsResponse(req statsRequest, result gin.H) gin.H {
+	response := gin.H{
+		"from":     req.From.Format(dateLayout),
+		"to":       req.To.AddDate(0, 0, -1).Format(dateLayout),
+		"timezone": req.Loc.String(),
+	}
+	for k, v := range result {
+		response[k] = v
+	}
+	if _, ok := result["users"]; ok {
+		response["granularity"] = req.Granularity
+	}
+	return response
+}
+
+// cachedStats 按查询参数和数据版本缓存统计结果
+func cachedStats(kind string, req statsRequest, compute func() (gin.H, error)) (gin.H, error) {
+	version, err := statsDataVersion(req)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, len(req.Members))
+	for i, m := range req.Members {
+		ids[i] = strconv.Itoa(m.ID)
+	}
+	key := strings.Join([]string{
+		kind, strings.Join(ids, ","), req.Loc.String(), dbTime(req.From), dbTime(req.To),
+		req.Granularity, req.WeekStart.String(), version,
+	}, "|")
+
+	if cached, ok := statsCache.Get(key); ok {
+		return cached.(gin.H), nil
+	}
+
+	result, err := compute()
+	if err != nil {
+		return nil, err
+	}
+	statsCache.Set(key, result)
+	return result, nil
+}
+
+// statsDataVersion 统计数据的版本：相关用户最新的积分记录和撤销操作
+// 新增记录、撤销和取消撤销都会改变版本，购买记录总是伴随积分记录产生
+func statsDataVersion(req statsRequest) (string, error) {
+	inClause, args := statsMemberClause(req)
+
+	var maxHistoryID, maxRevertID sql.NullInt64
+	err := database.DB.QueryRow(`
+		SELECT (SELECT MAX(id) FROM points_history WHERE user_id IN (`+inClause+`)),
+		       (SELECT MAX(ra.id) FROM revert_actions ra
+		        JOIN points_history ph ON ra.history_id = ph.id
+		        WHERE ph.user_id IN (`+inClause+`))`,
+		append(args, args...)...,
+	).Scan(&maxHistoryID, &maxRevertID)
+	if err != nil {
+		return "", err
+	}
+
+	return strconv.FormatInt(maxHistoryID.Int64, 10) + ":" + strconv.FormatInt(maxRevertID.Int64, 10), nil
+}
+
+// statsMemberClause 参与统计的用户 IN 子句
+func statsMemberClause(req statsRequest) (string, []interface{}) {
+	ids := make([]int, len(req.Members))
+	for i, m := range req.Members {
+		ids[i] = m.ID
+	}
+	return memberInClause(ids)
+}
+
+// loadPointsSlots 在数据库中按用户和 15 分钟时间段聚合未撤销的积分变化
+func loadPointsSlots(req statsRequest) ([]pointsSlot, error) {
+	inClause, args := statsMemberClause(req)
+	args = append(args, dbTime(req.From), dbTime(req.To))
+
+	rows, err := database.DB.Query(`
+		SELECT user_id,
+		       strftime('%Y-%m-%d %H:', created_at) || printf('%02d', (CAST(strftime('%M', created_at) AS INTEGER) / `+strconv.Itoa(slotMinutes)+`) * `+strconv.Itoa(slotMinutes)+`) AS slot,
+		       SUM(CASE WHEN points > 0 THEN points ELSE 0 END),
+		       SUM(CASE WHEN points < 0 THEN -points ELSE 0 END)
+		FROM points_history
+		WHERE user_id IN (`+inClause+`) AND is_reverted = FALSE
+		  AND created_at >= ? AND created_at < ?
+		GROUP BY user_id, slot`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var slots []pointsSlot
+	for rows.Next() {
+		var slot pointsSlot
+		var start string
+		if err := rows.Scan(&slot.UserID, &start, &slot.Earned, &slot.Spent); err != nil {
+			return nil, err
+		}
+		slot.Start, err = time.Parse("2006-01-02 15:04", start)
+		if err != nil {
+			return nil, err
+		}
+		slots = append(slots, slot)
+	}
+
+	return slots, rows.Err()
+}
+
+// statsBuckets 返回统计范围内每个时间段的开始时间
+func statsBuckets(req statsRequest) []time.Time {
+	var buckets []time.Time
+	for start := bucketStart(req.From, req.Granularity, req.WeekStart); start.Before(req.To); start = nextBucket(start, req.Granularity) {
+		buckets = append(buckets, start)
+	}
+	return buckets
+}
+
+// bucketStart 时间 t 所在时间段的开始时间（按 t 的时区）
+func bucketStart(t time.Time, granularity string, weekStart time.Weekday) time.Time {
+	y, m, d := t.Date()
+	day := time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+	switch granularity {
+	case "week":
+		offset := (int(day.Weekday()) - int(weekStart) + 7) % 7
+		return day.AddDate(0, 0, -offset)
+	case "month":
+		return time.Date(y, m, 1, 0, 0, 0, 0, t.Location())
+	}
+	return day
+}
+
+// nextBucket 下一个时间段的开始时间
+func nextBucket(start time.Time, granularity string) time.Time {
+	switch granularity {
+	case "week":
+		return start.AddDate(0, 0, 7)
+	case "month":
+		return start.AddDate(0, 1, 0)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// bucketLabel 时间段的显示名称：日和周为开始日期，月为 YYYY-MM
+func bucketLabel(start time.Time, granularity string) string {
+	if granularity == "month" {
+		return start.Format("2006-01")
+	}
+	return start.Format(dateLayout)
+}
+
+// topN 按指定字段降序取前 n 条
+func topN(items []gin.H, field string, n int) []gin.H {
+	sorted := make([]gin.H, len(items))
+	copy(sorted, items)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i][field].(int) > sorted[j][field].(int)
+	})
+	if len(sorted) > n {
+		sorted = sorted[:n]
+	}
+	return sorted
+}
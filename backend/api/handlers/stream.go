@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"booonus-backend/internal/permissions"
+	"booonus-backend/internal/realtime"
+	"booonus-backend/pkg/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 实时事件类型
+const (
+	streamPointsChanged = "points.changed"
+	streamPurchase      = "purchase.created"
+	streamRuleCreated   = "rule.created"
+	streamRuleUpdated   = "rule.updated"
+	streamRuleDeleted   = "rule.deleted"
+	streamRevert        = "revert"
+	streamEventCreated  = "event.created"
+	streamEventUpdated  = "event.updated"
+	streamEventDeleted  = "event.deleted"
+)
+
+// streamEventTypes 所有实时事件类型，也用于 webhook 的事件过滤
+var streamEventTypes = []string{
+	streamPointsChanged, streamPurchase, streamRuleCreated, streamRuleUpdated, streamRuleDeleted,
+	streamRevert, streamEventCreated, streamEventUpdated, streamEventDeleted,
+}
+
+// streamHeartbeat 心跳间隔，同时重新检查用户是否仍在家庭中
+const streamHeartbeat = 25 * time.Second
+
+// streamHub 所有家庭共用的发布/订阅中心，保留最近 1000 个事件用于续传
+var streamHub = realtime.NewHub(1000)
+
+// StreamEvents 以 Server-Sent Events 推送当前家庭的实时事件
+// 断线重连时通过 Last-Event-ID 请求头或 last_event_id 参数补发错过的事件，
+// 无法补发（事件已淘汰或服务重启过）时先发送 reset 事件，客户端应重新拉取数据
+func StreamEvents(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	coupleID := c.GetInt("household_id")
+	if coupleID == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No household found"})
+		return
+	}
+
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("last_event_id")
+	}
+
+	sub, replay, ok := streamHub.Subscribe(coupleID, lastEventID)
+	defer sub.Close()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	if !ok {
+		writeStreamMessage(c, "", "reset", gin.H{"reason": "Missed events are no longer available"})
+	}
+	for _, event := range replay {
+		writeStreamEvent(c, event)
+	}
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case event, open := <-sub.C:
+			if !open {
+				// 连接跟不上事件速度被中心断开，客户端会带上 Last-Event-ID 重连
+				return
+			}
+			writeStreamEvent(c, event)
+			c.Writer.Flush()
+		case <-heartbeat.C:
+			currentID, _, err := permissions.Lookup(userID)
+			if err != nil {
+				logger.Error("Failed to check household membership: " + err.Error())
+				return
+			}
+			if currentID != coupleID {
+				writeStreamMessage(c, "", "reset", gin.H{"reason": "Household changed"})
+				c.Writer.Flush()
+				return
+			}
+			fmt.Fprint(c.Writer, ": heartbeat\n\n")
+			c.Writer.Flush()
+		}
+	}
+}
+
+// writeStreamEvent 写出一个实时事件
+func writeStreamEvent(c *gin.Context, event realtime.Event) {
+	writeStreamMessage(c, event.ID, event.Type, event)
+}
+
+// writeStreamMessage 按 SSE 格式写出一条消息，id 为空时不改变客户端记录的事件ID
+func writeStreamMessage(c *gin.Context, id, eventType string, data interface{}) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		logger.Error("Failed to encode stream event: " + err.Error())
+		return
+	}
+	if id != "" {
+		fmt.Fprintf(c.Writer, "id: %s\n", id)
+	}
+	fmt.Fprintf(c.Writer, "event: %s\ndata: %s\n\n", eventType, payload)
+}
+
+// publishEvent 向当前用户所在家庭的在线连接发布事件，应在事务提交后调用
+func publishEvent(c *gin.Context, eventType string, data gin.H) {
+	streamHub.Publish(c.GetInt("household_id"), c.GetInt("user_id"), eventType, data)
+}
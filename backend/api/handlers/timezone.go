@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"booonus-backend/internal/database"
+	"booonus-backend/pkg/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// dateLayout 接口中日期参数的格式
+const dateLayout = "2006-01-02"
+
+// maxDateRangeDays 按天查询时允许的最大天数
+const maxDateRangeDays = 366
+
+// requestLocation 获取请求使用的时区（tz 参数，IANA 名称，默认为用户偏好设置中的时区），失败时已写入响应
+func requestLocation(c *gin.Context) (*time.Location, bool) {
+	name := c.Query("tz")
+	if name == "" {
+		prefs, err := loadUserPreferences(c.GetInt("user_id"))
+		if err != nil {
+			logger.Error("Failed to get user preferences: " + err.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			return nil, false
+		}
+		return prefs.Location, true
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid time zone"})
+		return nil, false
+	}
+	return loc, true
+}
+
+// parseDateRange 解析 from/to 日期参数（包含两端），返回 [from 当天零点, to 次日零点)，失败时已写入响应
+func parseDateRange(c *gin.Context, loc *time.Location) (time.Time, time.Time, bool) {
+	from, err := time.ParseInLocation(dateLayout, c.Query("from"), loc)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from date, expected YYYY-MM-DD"})
+		return time.Time{}, time.Time{}, false
+	}
+	to, err := time.ParseInLocation(dateLayout, c.Query("to"), loc)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to date, expected YYYY-MM-DD"})
+		return time.Time{}, time.Time{}, false
+	}
+	if to.Before(from) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "to must not be before from"})
+		return time.Time{}, time.Time{}, false
+	}
+
+	end := to.AddDate(0, 0, 1)
+	if end.After(from.AddDate(0, 0, maxDateRangeDays)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Date range is too large"})
+		return time.Time{}, time.Time{}, false
+	}
+
+	return from, end, true
+}
+
+// dbTime 将时间转换为数据库中的存储格式，用于和 created_at 等列比较
+func dbTime(t time.Time) string {
+	return t.UTC().Format(database.TimeLayout)
+}
+
+// validTimezone 检查是否是有效的 IANA 时区名称，不接受服务器本地时区
+func validTimezone(name string) bool {
+	if name == "" || name == "Local" {
+		return false
+	}
+	_, err := time.LoadLocation(name)
+	return err == nil
+}
+
+// userLocation 用户设置的时区，未设置或无效时为 UTC
+func userLocation(timezone sql.NullString) *time.Location {
+	if timezone.Valid && timezone.String != "" {
+		if loc, err := time.LoadLocation(timezone.String); err == nil {
+			return loc
+		}
+	}
+	return time.UTC
+}
+
+// userTimezoneName 用户设置的时区名称，未设置时为 UTC
+func userTimezoneName(timezone sql.NullString) string {
+	return userLocation(timezone).String()
+}
@@ -0,0 +1,361 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"booonus-backend/internal/database"
+	"booonus-backend/models"
+	"booonus-backend/pkg/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 交易状态变更的业务错误
+var (
+	errTransactionFulfilled    = errors.New("transaction has already been fulfilled")
+	errTransactionNotCompleted = errors.New("transaction is not completed")
+	errTransactionNotCancelled = errors.New("transaction is not cancelled")
+	errShopItemOutOfStock      = errors.New("shop item is out of stock")
+)
+
+// GetTransactions 获取情侣双方的购买记录
+func GetTransactions(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	status := c.Query("status")
+	if status != "" && status != "completed" && status != "cancelled" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status"})
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+
+	memberIDs, err := coupleMemberIDs(userID)
+	if err != nil {
+		logger.Error("Failed to get couple members: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	inClause, args := memberInClause(memberIDs)
+	where := "t.buyer_id IN (" + inClause + ")"
+	if status != "" {
+		where += " AND t.status = ?"
+		args = append(args, status)
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT `+transactionColumns+`
+		FROM transactions t
+		`+transactionJoins+`
+		WHERE `+where+`
+		ORDER BY t.created_at DESC, t.id DESC
+		LIMIT ? OFFSET ?`,
+		append(args, limit, offset)...,
+	)
+	if err != nil {
+		logger.Error("Failed to get transactions: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get transactions"})
+		return
+	}
+	defer rows.Close()
+
+	transactions := []gin.H{}
+	for rows.Next() {
+		t, err := scanTransaction(rows)
+		if err != nil {
+			logger.Error("Failed to scan transaction: " + err.Error())
+			continue
+		}
+		transactions = append(transactions, t)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"transactions": transactions,
+		"limit":        limit,
+		"offset":       offset,
+	})
+}
+
+// FulfillTransaction 卖家兑现已购买的商品
+func FulfillTransaction(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	transactionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid transaction ID"})
+		return
+	}
+
+	var transaction models.Transaction
+	err = database.DB.QueryRow(
+		"SELECT id, seller_id, status, fulfilled_at FROM transactions WHERE id = ?",
+		transactionID,
+	).Scan(&transaction.ID, &transaction.SellerID, &transaction.Status, &transaction.FulfilledAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Transaction not found"})
+			return
+		}
+		logger.Error("Failed to get transaction: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	// 只有卖家可以确认兑现
+	if transaction.SellerID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	if transaction.Status != "completed" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only completed transactions can be fulfilled"})
+		return
+	}
+
+	if transaction.FulfilledAt != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Transaction has already been fulfilled"})
+		return
+	}
+
+	// 条件更新：检查之后交易可能已被兑现或撤销
+	result, err := database.DB.Exec(
+		"UPDATE transactions SET fulfilled_at = CURRENT_TIMESTAMP, fulfilled_by = ? WHERE id = ? AND status = 'completed' AND fulfilled_at IS NULL",
+		userID, transactionID,
+	)
+	if err != nil {
+		logger.Error("Failed to fulfill transaction: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fulfill transaction"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Transaction has already been fulfilled or cancelled"})
+		return
+	}
+
+	transactionData, err := getTransactionDetail(transactionID)
+	if err != nil {
+		logger.Error("Failed to get transaction: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	logger.Info("Transaction fulfilled: " + strconv.Itoa(transactionID) + " by user " + strconv.Itoa(userID))
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "Transaction fulfilled successfully",
+		"transaction": transactionData,
+	})
+}
+
+// RevertTransaction 撤销一次购买：退回积分、交易状态改为 cancelled、恢复库存
+func RevertTransaction(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	transactionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid transaction ID"})
+		return
+	}
+
+	var req revertRequest
+	if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 以买家的积分记录作为撤销入口，卖家的记录作为关联记录一起撤销
+	var historyID int
+	err = database.DB.QueryRow(`
+		SELECT ph.id
+		FROM points_history ph
+		JOIN transactions t ON ph.reference_id = t.id AND ph.user_id = t.buyer_id
+		WHERE ph.type = 'transaction' AND t.id = ?
+		ORDER BY ph.id ASC
+		LIMIT 1`,
+		transactionID,
+	).Scan(&historyID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Transaction not found"})
+			return
+		}
+		logger.Error("Failed to get transaction history: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	affectedIDs, ok := revertHistoryEntry(c, userID, historyID, req.Reason)
+	if !ok {
+		return
+	}
+
+	transactionData, err := getTransactionDetail(transactionID)
+	if err != nil {
+		logger.Error("Failed to get transaction: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	logger.Info("Transaction reverted: " + strconv.Itoa(transactionID) + " by user " + strconv.Itoa(userID))
+	c.JSON(http.StatusOK, gin.H{
+		"message":              "Transaction reverted successfully",
+		"transaction":          transactionData,
+		"reverted_history_ids": affectedIDs,
+	})
+}
+
+// revertTransactionState 将交易标记为已取消并恢复商品库存
+func revertTransactionState(tx *sql.Tx, transactionID int) error {
+	var status string
+	var shopItemID int
+	var fulfilledAt *time.Time
+	err := tx.QueryRow(
+		"SELECT status, shop_item_id, fulfilled_at FROM transactions WHERE id = ?",
+		transactionID,
+	).Scan(&status, &shopItemID, &fulfilledAt)
+	if err != nil {
+		return err
+	}
+
+	if fulfilledAt != nil {
+		return errTransactionFulfilled
+	}
+	if status != "completed" {
+		return errTransactionNotCompleted
+	}
+
+	if _, err = tx.Exec("UPDATE transactions SET status = 'cancelled' WHERE id = ?", transactionID); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		"UPDATE shop_items SET stock = stock + 1, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND stock IS NOT NULL",
+		shopItemID,
+	)
+	return err
+}
+
+// restoreTransactionState 取消撤销时恢复交易，重新占用库存
+func restoreTransactionState(tx *sql.Tx, transactionID int) error {
+	var status string
+	var shopItemID int
+	err := tx.QueryRow(
+		"SELECT status, shop_item_id FROM transactions WHERE id = ?",
+		transactionID,
+	).Scan(&status, &shopItemID)
+	if err != nil {
+		return err
+	}
+
+	if status != "cancelled" {
+		return errTransactionNotCancelled
+	}
+
+	if err = takeShopItemStock(tx, shopItemID); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE transactions SET status = 'completed' WHERE id = ?", transactionID)
+	return err
+}
+
+// takeShopItemStock 占用一件库存，不限量的商品不受影响
+func takeShopItemStock(tx *sql.Tx, shopItemID int) error {
+	result, err := tx.Exec(
+		"UPDATE shop_items SET stock = stock - 1, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND stock IS NOT NULL AND stock > 0",
+		shopItemID,
+	)
+	if err != nil {
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected > 0 {
+		return nil
+	}
+
+	// 没有更新到行：要么不限量，要么已售罄
+	var stock sql.NullInt64
+	if err := tx.QueryRow("SELECT stock FROM shop_items WHERE id = ?", shopItemID).Scan(&stock); err != nil {
+		return err
+	}
+	if stock.Valid {
+		return errShopItemOutOfStock
+	}
+	return nil
+}
+
+// respondTransactionStateError 将交易状态变更的错误转换为响应
+func respondTransactionStateError(c *gin.Context, err error, failure string) {
+	switch err {
+	case errTransactionFulfilled:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The purchased item has already been fulfilled and cannot be reverted"})
+	case errTransactionNotCompleted:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "This transaction has already been reverted"})
+	case errTransactionNotCancelled:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "This transaction has not been reverted"})
+	case errShopItemOutOfStock:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Shop item is out of stock"})
+	default:
+		logger.Error("Failed to update transaction state: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": failure})
+	}
+}
+
+// transactionColumns 查询交易时的公共列（表别名为 t），与 scanTransaction 对应
+const transactionColumns = `t.id, t.buyer_id, t.seller_id, t.shop_item_id, t.points, t.status, t.created_at,
+		       t.fulfilled_at, t.fulfilled_by, s.name, ub.username, us.username`
+
+// transactionJoins 查询 transactionColumns 时需要的关联
+const transactionJoins = `JOIN shop_items s ON t.shop_item_id = s.id
+		JOIN users ub ON t.buyer_id = ub.id
+		JOIN users us ON t.seller_id = us.id`
+
+// scanTransaction 扫描一行交易记录
+func scanTransaction(row rowScanner) (gin.H, error) {
+	var t models.Transaction
+	var itemName, buyerName, sellerName string
+	var fulfilledBy sql.NullInt64
+	err := row.Scan(
+		&t.ID, &t.BuyerID, &t.SellerID, &t.ShopItemID, &t.Points, &t.Status, &t.CreatedAt,
+		&t.FulfilledAt, &fulfilledBy, &itemName, &buyerName, &sellerName,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if fulfilledBy.Valid {
+		id := int(fulfilledBy.Int64)
+		t.FulfilledBy = &id
+	}
+
+	return gin.H{
+		"id":           t.ID,
+		"buyer_id":     t.BuyerID,
+		"buyer_name":   buyerName,
+		"seller_id":    t.SellerID,
+		"seller_name":  sellerName,
+		"shop_item_id": t.ShopItemID,
+		"item_name":    itemName,
+		"points":       t.Points,
+		"status":       t.Status,
+		"created_at":   t.CreatedAt,
+		"fulfilled_at": t.FulfilledAt,
+		"fulfilled_by": t.FulfilledBy,
+	}, nil
+}
+
+// getTransactionDetail 获取单个交易的详情
+func getTransactionDetail(transactionID int) (gin.H, error) {
+	row := database.DB.QueryRow(`
+		SELECT `+transactionColumns+`
+		FROM transactions t
+		`+transactionJoins+`
+		WHERE t.id = ?`,
+		transactionID,
+	)
+	return scanTransaction(row)
+}
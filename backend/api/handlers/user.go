@@ -33,6 +33,11 @@ func Register(c *gin.Context) {
 		return
 	}
 
+	if isReservedUsername(req.Username) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Username is reserved"})
+		return
+	}
+
 	// 检查用户名是否已存在
 	var existingUser models.User
 	err := database.DB.QueryRow("SELECT id FROM users WHERE username = ?", req.Username).Scan(&existingUser.ID)
@@ -172,6 +177,11 @@ func UpdateProfile(c *gin.Context) {
 		return
 	}
 
+	if isReservedUsername(req.Username) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Username is reserved"})
+		return
+	}
+
 	// 检查用户名是否已被其他用户使用（如果提供了用户名）
 	if req.Username != "" {
 		var existingUserID int
@@ -0,0 +1,600 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"booonus-backend/internal/database"
+	"booonus-backend/internal/webhooks"
+	"booonus-backend/pkg/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// webhookRequest 创建或修改 webhook 的请求，修改时未提供的字段保持不变
+type webhookRequest struct {
+	URL          *string   `json:"url"`
+	EventTypes   *[]string `json:"event_types"` // 为空表示全部事件
+	Description  *string   `json:"description"`
+	IsActive     *bool     `json:"is_active"`
+	RotateSecret bool      `json:"rotate_secret"` // 仅修改时有效，生成新的签名密钥
+}
+
+// GetWebhooks 获取当前家庭的 webhook 列表，密钥只在创建和重新生成时返回
+func GetWebhooks(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	coupleID, ok := currentHousehold(c, userID)
+	if !ok {
+		return
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT w.id, w.url, w.event_types, w.description, w.is_active, w.created_by, w.created_at, w.updated_at,
+		       (SELECT COUNT(*) FROM webhook_deliveries d WHERE d.webhook_id = w.id AND d.status = 'pending'),
+		       (SELECT COUNT(*) FROM webhook_deliveries d WHERE d.webhook_id = w.id AND d.status = 'failed')
+		FROM webhooks w
+		WHERE w.couple_id = ?
+		ORDER BY w.id ASC`,
+		coupleID,
+	)
+	if err != nil {
+		logger.Error("Failed to get webhooks: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get webhooks"})
+		return
+	}
+	defer rows.Close()
+
+	list := []gin.H{}
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			logger.Error("Failed to scan webhook: " + err.Error())
+			continue
+		}
+		list = append(list, webhook)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"webhooks":    list,
+		"event_types": streamEventTypes,
+	})
+}
+
+// CreateWebhook 创建 webhook，响应中包含签名密钥
+func CreateWebhook(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	coupleID, ok := currentHousehold(c, userID)
+	if !ok {
+		return
+	}
+
+	var req webhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.URL == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "URL is required"})
+		return
+	}
+	if !validateWebhookRequest(c, req) {
+		return
+	}
+
+	secret, err := webhooks.NewSecret()
+	if err != nil {
+		logger.Error("Failed to generate webhook secret: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create webhook"})
+		return
+	}
+
+	eventTypes := ""
+	if req.EventTypes != nil {
+		eventTypes = strings.Join(*req.EventTypes, ",")
+	}
+	isActive := true
+	if req.IsActive != nil {
+		isActive = *req.IsActive
+	}
+
+	result, err := database.DB.Exec(
+		"INSERT INTO webhooks (couple_id, url, secret, event_types, description, is_active, created_by) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		coupleID, *req.URL, secret, eventTypes, req.Description, isActive, userID,
+	)
+	if err != nil {
+		logger.Error("Failed to create webhook: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create webhook"})
+		return
+	}
+	webhookID, _ := result.LastInsertId()
+
+	webhook, err := getWebhook(coupleID, int(webhookID))
+	if err != nil {
+		logger.Error("Failed to get webhook: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create webhook"})
+		return
+	}
+	webhook["secret"] = secret
+
+	logger.Info("Webhook created: " + strconv.FormatInt(webhookID, 10) + " by user " + strconv.Itoa(userID))
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Webhook created successfully",
+		"webhook": webhook,
+	})
+}
+
+// UpdateWebhook 修改 webhook，rotate_secret 为 true 时返回新的签名密钥
+func UpdateWebhook(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	coupleID, ok := currentHousehold(c, userID)
+	if !ok {
+		return
+	}
+	webhookID, ok := householdWebhookID(c, coupleID)
+	if !ok {
+		return
+	}
+
+	var req webhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !validateWebhookRequest(c, req) {
+		return
+	}
+
+	sets := []string{"updated_at = CURRENT_TIMESTAMP"}
+	args := []interface{}{}
+	if req.URL != nil {
+		sets = append(sets, "url = ?")
+		args = append(args, *req.URL)
+	}
+	if req.EventTypes != nil {
+		sets = append(sets, "event_types = ?")
+		args = append(args, strings.Join(*req.EventTypes, ","))
+	}
+	if req.Description != nil {
+		sets = append(sets, "description = ?")
+		args = append(args, *req.Description)
+	}
+	if req.IsActive != nil {
+		sets = append(sets, "is_active = ?")
+		args = append(args, *req.IsActive)
+	}
+	var secret string
+	if req.RotateSecret {
+		var err error
+		if secret, err = webhooks.NewSecret(); err != nil {
+			logger.Error("Failed to generate webhook secret: " + err.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update webhook"})
+			return
+		}
+		sets = append(sets, "secret = ?")
+		args = append(args, secret)
+	}
+
+	_, err := database.DB.Exec("UPDATE webhooks SET "+strings.Join(sets, ", ")+" WHERE id = ?", append(args, webhookID)...)
+	if err != nil {
+		logger.Error("Failed to update webhook: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update webhook"})
+		return
+	}
+
+	webhook, err := getWebhook(coupleID, webhookID)
+	if err != nil {
+		logger.Error("Failed to get webhook: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update webhook"})
+		return
+	}
+	if secret != "" {
+		webhook["secret"] = secret
+	}
+
+	logger.Info("Webhook updated: " + strconv.Itoa(webhookID) + " by user " + strconv.Itoa(userID))
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Webhook updated successfully",
+		"webhook": webhook,
+	})
+}
+
+// DeleteWebhook 删除 webhook 及其投递记录
+func DeleteWebhook(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	coupleID, ok := currentHousehold(c, userID)
+	if !ok {
+		return
+	}
+	webhookID, ok := householdWebhookID(c, coupleID)
+	if !ok {
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		logger.Error("Failed to begin transaction: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	defer tx.Rollback()
+
+	statements := []string{
+		"DELETE FROM webhook_delivery_attempts WHERE delivery_id IN (SELECT id FROM webhook_deliveries WHERE webhook_id = ?)",
+		"UPDATE webhook_deliveries SET redelivery_of = NULL WHERE webhook_id = ?",
+		"DELETE FROM webhook_deliveries WHERE webhook_id = ?",
+		"DELETE FROM webhooks WHERE id = ?",
+	}
+	for _, statement := range statements {
+		if _, err = tx.Exec(statement, webhookID); err != nil {
+			logger.Error("Failed to delete webhook: " + err.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete webhook"})
+			return
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		logger.Error("Failed to commit transaction: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete webhook"})
+		return
+	}
+
+	logger.Info("Webhook deleted: " + strconv.Itoa(webhookID) + " by user " + strconv.Itoa(userID))
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook deleted successfully"})
+}
+
+// PingWebhook 向 webhook 发送一条测试事件
+func PingWebhook(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	coupleID, ok := currentHousehold(c, userID)
+	if !ok {
+		return
+	}
+	webhookID, ok := householdWebhookID(c, coupleID)
+	if !ok {
+		return
+	}
+
+	deliveryID, err := webhooks.Ping(webhookID, coupleID, userID)
+	if err != nil {
+		logger.Error("Failed to queue webhook ping: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to ping webhook"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":     "Ping queued",
+		"delivery_id": deliveryID,
+	})
+}
+
+// GetWebhookDeliveries 获取 webhook 的投递记录，可用 status 过滤，limit/offset 分页
+func GetWebhookDeliveries(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	coupleID, ok := currentHousehold(c, userID)
+	if !ok {
+		return
+	}
+	webhookID, ok := householdWebhookID(c, coupleID)
+	if !ok {
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	where := "webhook_id = ?"
+	args := []interface{}{webhookID}
+	if status := c.Query("status"); status != "" {
+		if status != "pending" && status != "succeeded" && status != "failed" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status"})
+			return
+		}
+		where += " AND status = ?"
+		args = append(args, status)
+	}
+
+	var total int
+	if err := database.DB.QueryRow("SELECT COUNT(*) FROM webhook_deliveries WHERE "+where, args...).Scan(&total); err != nil {
+		logger.Error("Failed to count webhook deliveries: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get webhook deliveries"})
+		return
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT id, event_id, event_type, status, attempts, next_attempt_at, last_status_code, last_error,
+		       redelivery_of, created_at, delivered_at
+		FROM webhook_deliveries
+		WHERE `+where+`
+		ORDER BY id DESC
+		LIMIT ? OFFSET ?`,
+		append(args, limit, offset)...,
+	)
+	if err != nil {
+		logger.Error("Failed to get webhook deliveries: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get webhook deliveries"})
+		return
+	}
+	defer rows.Close()
+
+	deliveries := []gin.H{}
+	for rows.Next() {
+		delivery, err := scanWebhookDelivery(rows)
+		if err != nil {
+			logger.Error("Failed to scan webhook delivery: " + err.Error())
+			continue
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"deliveries": deliveries,
+		"total":      total,
+		"limit":      limit,
+		"offset":     offset,
+	})
+}
+
+// GetWebhookDelivery 获取一条投递记录的请求体和每次尝试的结果
+func GetWebhookDelivery(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	coupleID, ok := currentHousehold(c, userID)
+	if !ok {
+		return
+	}
+	webhookID, ok := householdWebhookID(c, coupleID)
+	if !ok {
+		return
+	}
+	deliveryID, ok := webhookDeliveryID(c, webhookID)
+	if !ok {
+		return
+	}
+
+	delivery, err := scanWebhookDelivery(database.DB.QueryRow(`
+		SELECT id, event_id, event_type, status, attempts, next_attempt_at, last_status_code, last_error,
+		       redelivery_of, created_at, delivered_at
+		FROM webhook_deliveries
+		WHERE id = ?`,
+		deliveryID,
+	))
+	if err != nil {
+		logger.Error("Failed to get webhook delivery: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get webhook delivery"})
+		return
+	}
+
+	var payload string
+	if err := database.DB.QueryRow("SELECT payload FROM webhook_deliveries WHERE id = ?", deliveryID).Scan(&payload); err != nil {
+		logger.Error("Failed to get webhook delivery payload: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get webhook delivery"})
+		return
+	}
+	delivery["payload"] = payload
+
+	rows, err := database.DB.Query(`
+		SELECT attempt, status_code, error, response_body, duration_ms, attempted_at
+		FROM webhook_delivery_attempts
+		WHERE delivery_id = ?
+		ORDER BY attempt ASC`,
+		deliveryID,
+	)
+	if err != nil {
+		logger.Error("Failed to get webhook delivery attempts: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get webhook delivery"})
+		return
+	}
+	defer rows.Close()
+
+	attempts := []gin.H{}
+	for rows.Next() {
+		var attempt, durationMS int
+		var statusCode sql.NullInt64
+		var errText, responseBody sql.NullString
+		var attemptedAt time.Time
+		if err := rows.Scan(&attempt, &statusCode, &errText, &responseBody, &durationMS, &attemptedAt); err != nil {
+			logger.Error("Failed to scan webhook delivery attempt: " + err.Error())
+			continue
+		}
+		item := gin.H{
+			"attempt":       attempt,
+			"status_code":   nil,
+			"error":         nil,
+			"response_body": responseBody.String,
+			"duration_ms":   durationMS,
+			"attempted_at":  attemptedAt,
+		}
+		if statusCode.Valid {
+			item["status_code"] = statusCode.Int64
+		}
+		if errText.Valid {
+			item["error"] = errText.String
+		}
+		attempts = append(attempts, item)
+	}
+	delivery["attempt_log"] = attempts
+
+	c.JSON(http.StatusOK, gin.H{"delivery": delivery})
+}
+
+// RedeliverWebhookDelivery 重新投递一条记录，生成新的投递记录，事件ID和请求体不变
+func RedeliverWebhookDelivery(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	coupleID, ok := currentHousehold(c, userID)
+	if !ok {
+		return
+	}
+	webhookID, ok := householdWebhookID(c, coupleID)
+	if !ok {
+		return
+	}
+	deliveryID, ok := webhookDeliveryID(c, webhookID)
+	if !ok {
+		return
+	}
+
+	newID, err := webhooks.Redeliver(deliveryID)
+	if err != nil {
+		logger.Error("Failed to redeliver webhook: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to redeliver webhook"})
+		return
+	}
+
+	logger.Info("Webhook delivery " + strconv.Itoa(deliveryID) + " redelivered by user " + strconv.Itoa(userID))
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":     "Redelivery queued",
+		"delivery_id": newID,
+	})
+}
+
+// validateWebhookRequest 检查地址和事件类型，失败时已写入响应
+func validateWebhookRequest(c *gin.Context, req webhookRequest) bool {
+	if req.URL != nil {
+		if err := webhooks.ValidateURL(c.Request.Context(), *req.URL); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return false
+		}
+	}
+	if req.EventTypes != nil {
+		for _, eventType := range *req.EventTypes {
+			if !containsString(streamEventTypes, eventType) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event type: " + eventType})
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// householdWebhookID 解析路径中的 webhook ID 并检查属于当前家庭，失败时已写入响应
+func householdWebhookID(c *gin.Context, coupleID int) (int, bool) {
+	webhookID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook ID"})
+		return 0, false
+	}
+
+	var exists bool
+	err = database.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM webhooks WHERE id = ? AND couple_id = ?)", webhookID, coupleID).Scan(&exists)
+	if err != nil {
+		logger.Error("Failed to get webhook: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return 0, false
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return 0, false
+	}
+	return webhookID, true
+}
+
+// webhookDeliveryID 解析路径中的投递记录 ID 并检查属于该 webhook，失败时已写入响应
+func webhookDeliveryID(c *gin.Context, webhookID int) (int, bool) {
+	deliveryID, err := strconv.Atoi(c.Param("delivery_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid delivery ID"})
+		return 0, false
+	}
+
+	var exists bool
+	err = database.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM webhook_deliveries WHERE id = ? AND webhook_id = ?)", deliveryID, webhookID).Scan(&exists)
+	if err != nil {
+		logger.Error("Failed to get webhook delivery: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return 0, false
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Delivery not found"})
+		return 0, false
+	}
+	return deliveryID, true
+}
+
+// getWebhook 获取一个 webhook，不包含密钥
+func getWebhook(coupleID, webhookID int) (gin.H, error) {
+	return scanWebhook(database.DB.QueryRow(`
+		SELECT w.id, w.url, w.event_types, w.description, w.is_active, w.created_by, w.created_at, w.updated_at,
+		       (SELECT COUNT(*) FROM webhook_deliveries d WHERE d.webhook_id = w.id AND d.status = 'pending'),
+		       (SELECT COUNT(*) FROM webhook_deliveries d WHERE d.webhook_id = w.id AND d.status = 'failed')
+		FROM webhooks w
+		WHERE w.id = ? AND w.couple_id = ?`,
+		webhookID, coupleID,
+	))
+}
+
+// scanWebhook 读取一行 webhook
+func scanWebhook(row rowScanner) (gin.H, error) {
+	var id, pending, failed int
+	var webhookURL, eventTypes string
+	var description sql.NullString
+	var isActive bool
+	var createdBy *int
+	var createdAt, updatedAt time.Time
+	err := row.Scan(&id, &webhookURL, &eventTypes, &description, &isActive, &createdBy, &createdAt, &updatedAt, &pending, &failed)
+	if err != nil {
+		return nil, err
+	}
+
+	types := []string{}
+	if eventTypes != "" {
+		types = strings.Split(eventTypes, ",")
+	}
+	return gin.H{
+		"id":                 id,
+		"url":                webhookURL,
+		"event_types":        types,
+		"description":        description.String,
+		"is_active":          isActive,
+		"created_by":         createdBy,
+		"created_at":         createdAt,
+		"updated_at":         updatedAt,
+		"pending_deliveries": pending,
+		"failed_deliveries":  failed,
+	}, nil
+}
+
+// scanWebhookDelivery 读取一行投递记录
+func scanWebhookDelivery(row rowScanner) (gin.H, error) {
+	var id, attempts int
+	var eventID, eventType, status string
+	var nextAttemptAt, deliveredAt *time.Time
+	var lastStatusCode, redeliveryOf *int
+	var lastError sql.NullString
+	var createdAt time.Time
+	err := row.Scan(&id, &eventID, &eventType, &status, &attempts, &nextAttemptAt, &lastStatusCode, &lastError,
+		&redeliveryOf, &createdAt, &deliveredAt)
+	if err != nil {
+		return nil, err
+	}
+
+	delivery := gin.H{
+		"id":               id,
+		"event_id":         eventID,
+		"event_type":       eventType,
+		"status":           status,
+		"attempts":         attempts,
+		"next_attempt_at":  nextAttemptAt,
+		"last_status_code": lastStatusCode,
+		"last_error":       nil,
+		"redelivery_of":    redeliveryOf,
+		"created_at":       createdAt,
+		"delivered_at":     deliveredAt,
+	}
+	if lastError.Valid {
+		delivery["last_error"] = lastError.String
+	}
+	return delivery, nil
+}
+
+// queueWebhooks 在事务中为当前家庭的 webhook 写入待投递事件，与操作一起提交；失败时调用方应放弃整个操作，事件不会丢失
+func queueWebhooks(tx *sql.Tx, c *gin.Context, eventType string, data gin.H) error {
+	return webhooks.Enqueue(tx, c.GetInt("household_id"), c.GetInt("user_id"), eventType, data)
+}
@@ -5,6 +5,7 @@ import (
 	"strings"
 
 	"booonus-backend/internal/auth"
+	"booonus-backend/internal/database"
 
 	"github.com/gin-gonic/gin"
 )
@@ -35,6 +36,15 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		// 已注销的用户之前签发的token不再有效
+		var active bool
+		err = database.DB.QueryRow("SELECT deleted_at IS NULL FROM users WHERE id = ?", userID).Scan(&active)
+		if err != nil || !active {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			c.Abort()
+			return
+		}
+
 		// 将用户ID存储在上下文中
 		c.Set("user_id", userID)
 		c.Next()
@@ -46,7 +56,7 @@ func CORS() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", "*")
 		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Authorization")
+		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Authorization, Last-Event-ID")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(http.StatusNoContent)
@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"net/http"
+
+	"booonus-backend/internal/permissions"
+	"booonus-backend/pkg/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HouseholdRole 加载当前用户所在的家庭和角色，存入上下文的 household_id 和 household_role
+// 必须在 AuthMiddleware 之后使用；不在家庭中时两者为零值
+func HouseholdRole() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		coupleID, role, err := permissions.Lookup(c.GetInt("user_id"))
+		if err != nil {
+			logger.Error("Failed to get household role: " + err.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			c.Abort()
+			return
+		}
+
+		c.Set("household_id", coupleID)
+		c.Set("household_role", role)
+		c.Next()
+	}
+}
+
+// RequirePermission 要求当前用户的家庭角色拥有指定权限，必须在 HouseholdRole 之后使用
+func RequirePermission(perm permissions.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !permissions.Allowed(c.GetString("household_role"), perm) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied", "permission": perm})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -3,6 +3,7 @@ package routes
 import (
 	"booonus-backend/api/handlers"
 	"booonus-backend/api/middleware"
+	"booonus-backend/internal/permissions"
 
 	"github.com/gin-gonic/gin"
 )
@@ -20,51 +21,168 @@ func SetupRoutes() *gin.Engine {
 		public.GET("/health", handlers.HealthCheck)
 		public.POST("/register", handlers.Register)
 		public.POST("/login", handlers.Login)
+		public.GET("/calendar/ical/:file", handlers.GetCalendarICS)
+		public.GET("/email/verify", handlers.VerifyEmail)
+		public.GET("/email/unsubscribe", handlers.UnsubscribeDigest)
+		public.POST("/email/unsubscribe", handlers.UnsubscribeDigest)
 	}
 
 	// 需要认证的路由
 	protected := router.Group("/api/v1")
-	protected.Use(middleware.AuthMiddleware())
+	protected.Use(middleware.AuthMiddleware(), middleware.HouseholdRole())
 	{
+		// 按家庭角色检查权限
+		rulesManage := middleware.RequirePermission(permissions.RulesManage)
+		rulesExecute := middleware.RequirePermission(permissions.RulesExecute)
+		eventsManage := middleware.RequirePermission(permissions.EventsManage)
+		revert := middleware.RequirePermission(permissions.Revert)
+		shopManage := middleware.RequirePermission(permissions.ShopManage)
+		settingsManage := middleware.RequirePermission(permissions.SettingsManage)
+		membersManage := middleware.RequirePermission(permissions.MembersManage)
+
 		// 用户相关
 		protected.GET("/profile", handlers.GetProfile)
 		protected.PUT("/profile", handlers.UpdateProfile)
+		protected.DELETE("/profile", handlers.DeleteAccount)
+		protected.GET("/profile/data", handlers.DownloadMyData)
+		protected.GET("/profile/preferences", handlers.GetPreferences)
+		protected.PUT("/profile/preferences", handlers.UpdatePreferences)
+		protected.GET("/profile/email", handlers.GetEmailSettings)
+		protected.PUT("/profile/email", handlers.UpdateEmail)
+		protected.DELETE("/profile/email", handlers.DeleteEmail)
+		protected.PUT("/profile/email/digest", handlers.UpdateDigestSettings)
+		protected.POST("/profile/email/digest/preview", handlers.SendDigestPreview)
 
 		// 情侣关系
 		protected.POST("/couple/invite", handlers.InviteCouple)
 		protected.POST("/couple/accept", handlers.AcceptCouple)
 		protected.DELETE("/couple", handlers.RemoveCouple)
 		protected.GET("/couple", handlers.GetCouple)
+		protected.POST("/couple/restore", handlers.RestoreCouple)
+		protected.GET("/couple/archives", handlers.GetArchivedCouples)
+		protected.GET("/couple/archives/:id", handlers.GetArchivedCouple)
+		protected.GET("/couple/settings", handlers.GetCoupleSettings)
+		protected.PUT("/couple/settings", settingsManage, handlers.UpdateCoupleSettings)
+
+		// 家庭
+		protected.GET("/household", handlers.GetHousehold)
+		protected.GET("/household/permissions", handlers.GetHouseholdPermissions)
+		protected.PUT("/household", settingsManage, handlers.UpdateHousehold)
+		protected.POST("/household/members", membersManage, handlers.AddHouseholdMember)
+		protected.PUT("/household/members/:user_id", membersManage, handlers.UpdateHouseholdMember)
+		protected.DELETE("/household/members/:user_id", handlers.RemoveHouseholdMember)
+
+		// 通知
+		protected.GET("/notifications", handlers.GetNotifications)
+		protected.POST("/notifications/:id/read", handlers.MarkNotificationRead)
+		protected.POST("/notifications/read-all", handlers.MarkAllNotificationsRead)
+		protected.GET("/notifications/preferences", handlers.GetNotificationPreferences)
+		protected.PUT("/notifications/preferences", handlers.UpdateNotificationPreferences)
+
+		// 推送设备
+		protected.GET("/devices", handlers.GetDevices)
+		protected.POST("/devices", handlers.RegisterDevice)
+		protected.DELETE("/devices/:id", handlers.DeleteDevice)
+
+		// 提醒
+		protected.GET("/reminders", handlers.GetReminders)
+		protected.POST("/reminders", handlers.CreateReminder)
+		protected.GET("/reminders/:id", handlers.GetReminder)
+		protected.PUT("/reminders/:id", handlers.UpdateReminder)
+		protected.DELETE("/reminders/:id", handlers.DeleteReminder)
+		protected.POST("/reminders/:id/snooze", handlers.SnoozeReminder)
+		protected.POST("/reminders/:id/ack", handlers.AcknowledgeReminder)
+		protected.GET("/reminders/:id/events", handlers.GetReminderEvents)
+
+		// 实时事件
+		protected.GET("/stream", handlers.StreamEvents)
+
+		// Webhook
+		protected.GET("/webhooks", settingsManage, handlers.GetWebhooks)
+		protected.POST("/webhooks", settingsManage, handlers.CreateWebhook)
+		protected.PUT("/webhooks/:id", settingsManage, handlers.UpdateWebhook)
+		protected.DELETE("/webhooks/:id", settingsManage, handlers.DeleteWebhook)
+		protected.POST("/webhooks/:id/ping", settingsManage, handlers.PingWebhook)
+		protected.GET("/webhooks/:id/deliveries", settingsManage, handlers.GetWebhookDeliveries)
+		protected.GET("/webhooks/:id/deliveries/:delivery_id", settingsManage, handlers.GetWebhookDelivery)
+		protected.POST("/webhooks/:id/deliveries/:delivery_id/redeliver", settingsManage, handlers.RedeliverWebhookDelivery)
 
 		// 积分相关
 		protected.GET("/points", handlers.GetPoints)
 		protected.GET("/points/history", handlers.GetPointsHistory)
 		protected.GET("/points/history/:user_id", handlers.GetUserPointsHistory)
 		protected.GET("/points/couple-recent", handlers.GetCoupleRecentHistory)
+		protected.GET("/points/balance", handlers.GetBalanceAt)
+		protected.GET("/points/statement", handlers.GetStatement)
 
 		// 小卖部
 		protected.GET("/shop", handlers.GetShopItems)
-		protected.POST("/shop", handlers.CreateShopItem)
-		protected.PUT("/shop/:id", handlers.UpdateShopItem)
-		protected.DELETE("/shop/:id", handlers.DeleteShopItem)
+		protected.POST("/shop", shopManage, handlers.CreateShopItem)
+		protected.PUT("/shop/:id", shopManage, handlers.UpdateShopItem)
+		protected.DELETE("/shop/:id", shopManage, handlers.DeleteShopItem)
 		protected.POST("/shop/:id/buy", handlers.BuyShopItem)
 
+		// 购买记录
+		protected.GET("/transactions", handlers.GetTransactions)
+		protected.POST("/transactions/:id/fulfill", handlers.FulfillTransaction)
+		protected.POST("/transactions/:id/revert", revert, handlers.RevertTransaction)
+
 		// 规则
 		protected.GET("/rules", handlers.GetRules)
-		protected.POST("/rules", handlers.CreateRule)
-		protected.PUT("/rules/:id", handlers.UpdateRule)
-		protected.DELETE("/rules/:id", handlers.DeleteRule)
-		protected.POST("/rules/:id/execute", handlers.ExecuteRule)
+		protected.POST("/rules", rulesManage, handlers.CreateRule)
+		protected.PUT("/rules/:id", rulesManage, handlers.UpdateRule)
+		protected.DELETE("/rules/:id", rulesManage, handlers.DeleteRule)
+		protected.POST("/rules/:id/execute", rulesExecute, handlers.ExecuteRule)
 		protected.POST("/rules/:id/pin", handlers.PinRule)
 		protected.DELETE("/rules/:id/pin", handlers.UnpinRule)
+		protected.GET("/rules/:id/revisions", handlers.GetRuleRevisions)
+		protected.POST("/rules/:id/revisions/:revision/restore", rulesManage, handlers.RestoreRuleRevision)
 
 		// 事件
 		protected.GET("/events", handlers.GetEvents)
-		protected.POST("/events", handlers.CreateEvent)
+		protected.POST("/events", eventsManage, handlers.CreateEvent)
+		protected.GET("/events/:id", handlers.GetEvent)
+		protected.PUT("/events/:id", eventsManage, handlers.UpdateEvent)
+		protected.DELETE("/events/:id", eventsManage, handlers.DeleteEvent)
+
+		// 日历
+		protected.GET("/calendar", handlers.GetCalendar)
+		protected.GET("/calendar/feed", handlers.GetCalendarFeed)
+		protected.POST("/calendar/feed/reset", handlers.ResetCalendarFeed)
+
+		// 数据导出
+		protected.GET("/export", handlers.ExportData)
+		protected.POST("/export/jobs", handlers.CreateExportJob)
+		protected.GET("/export/jobs", handlers.GetExportJobs)
+		protected.GET("/export/jobs/:id", handlers.GetExportJob)
+		protected.GET("/export/jobs/:id/download", handlers.DownloadExportJob)
+
+		// 数据导入
+		protected.POST("/import", handlers.ImportData)
+
+		// 统计
+		protected.GET("/stats/points", handlers.GetPointsStats)
+		protected.GET("/stats/balance", handlers.GetBalanceStats)
+		protected.GET("/stats/rules", handlers.GetRuleStats)
+		protected.GET("/stats/shop", handlers.GetShopStats)
+
+		// 评论和表情回应
+		protected.GET("/comments", handlers.GetComments)
+		protected.POST("/comments", handlers.CreateComment)
+		protected.PUT("/comments/:id", handlers.UpdateComment)
+		protected.DELETE("/comments/:id", handlers.DeleteComment)
+		protected.GET("/reactions", handlers.GetReactions)
+		protected.POST("/reactions", handlers.AddReaction)
+		protected.DELETE("/reactions", handlers.RemoveReaction)
+
+		// 附件
+		protected.POST("/attachments", handlers.UploadAttachment)
+		protected.GET("/attachments/:id", handlers.GetAttachment)
 
 		// 撤销操作
-		protected.POST("/revert/:id", handlers.RevertOperation)
-		protected.POST("/cancel-revert/:id", handlers.CancelRevertOperation)
+		protected.POST("/revert/:id", revert, handlers.RevertOperation)
+		protected.POST("/cancel-revert/:id", revert, handlers.CancelRevertOperation)
+		protected.GET("/revert/:id/actions", handlers.GetRevertActions)
 	}
 
 	return router
@@ -3,9 +3,21 @@ package main
 import (
 	"log"
 	"os"
+	"time"
+	_ "time/tzdata" // 运行镜像中没有时区数据，内嵌到二进制中
 
+	"booonus-backend/api/handlers"
 	"booonus-backend/api/routes"
 	"booonus-backend/internal/database"
+	"booonus-backend/internal/importer"
+	"booonus-backend/internal/jobs"
+	"booonus-backend/internal/ledger"
+	"booonus-backend/internal/mail"
+	"booonus-backend/internal/notifications"
+	"booonus-backend/internal/push"
+	"booonus-backend/internal/reminders"
+	"booonus-backend/internal/storage"
+	"booonus-backend/internal/webhooks"
 	"booonus-backend/pkg/logger"
 
 	"github.com/gin-gonic/gin"
@@ -19,7 +31,58 @@ func main() {
 	if err := database.Init(); err != nil {
 		log.Fatal("Failed to initialize database:", err)
 	}
+
+	// 初始化对象存储
+	if err := storage.Init(); err != nil {
+		log.Fatal("Failed to initialize blob storage:", err)
+	}
+
+	// 初始化手机推送
+	if err := push.Init(); err != nil {
+		log.Fatal("Failed to initialize push notifications:", err)
+	}
+
+	// 初始化邮件发送
+	if err := mail.Init(); err != nil {
+		log.Fatal("Failed to initialize mail:", err)
+	}
+
+	// 读取 webhook 投递配置
+	webhooks.Init()
 	
+	// 命令行导入：main import ...
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		if err := importer.RunCommand(os.Args[2:]); err != nil {
+			log.Fatal("Import failed: ", err)
+		}
+		return
+	}
+
+	// 定时生成余额快照
+	jobs.Every("balance-snapshots", time.Hour, ledger.TakeSnapshots)
+
+	// 清理过期的导出文件
+	jobs.Every("export-cleanup", time.Hour, handlers.CleanupExportJobs)
+
+	// 结算恢复期已过的解除情侣关系
+	jobs.Every("couple-settlement", time.Hour, handlers.SettleEndedCouples)
+
+	// 清理超过保留期限的通知
+	jobs.Every("notification-cleanup", time.Hour, notifications.Cleanup)
+
+	// 投递 webhook 发件箱中到期的事件，并清理过期的投递记录
+	jobs.Every("webhook-delivery", 5*time.Second, webhooks.DeliverPending)
+	jobs.Every("webhook-cleanup", time.Hour, webhooks.Cleanup)
+
+	// 批量发送推送队列中的消息
+	jobs.Every("push-delivery", 2*time.Second, push.Flush)
+
+	// 按用户时区发送每周摘要邮件
+	jobs.Every("weekly-digest", 15*time.Minute, handlers.SendWeeklyDigests)
+
+	// 投递到期的提醒
+	jobs.Every("reminders", time.Minute, reminders.DeliverDue)
+
 	// 设置Gin模式
 	if os.Getenv("GIN_MODE") == "" {
 		gin.SetMode(gin.DebugMode)
@@ -0,0 +1,71 @@
+// Package cache 提供简单的进程内缓存，带过期时间和容量上限
+package cache
+
+import (
+	"sync"
+	"time"
+)
+
+type entry struct {
+	value     interface{}
+	expiresAt time.Time
+}
+
+// Cache 并发安全的键值缓存
+type Cache struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	items      map[string]entry
+}
+
+// New 创建缓存，ttl 为每个条目的有效期，maxEntries 为最多保存的条目数
+func New(ttl time.Duration, maxEntries int) *Cache {
+	return &Cache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		items:      make(map[string]entry),
+	}
+}
+
+// Get 获取未过期的条目
+func (c *Cache) Get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(e.expiresAt) {
+		delete(c.items, key)
+		return nil, false
+	}
+	return e.value, true
+}
+
+// Set 写入条目，容量已满时先清理过期条目，仍然不够则淘汰最早过期的条目
+func (c *Cache) Set(key string, value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if _, exists := c.items[key]; !exists && len(c.items) >= c.maxEntries {
+		var oldestKey string
+		var oldest time.Time
+		for k, e := range c.items {
+			if now.After(e.expiresAt) {
+				delete(c.items, k)
+				continue
+			}
+			if oldestKey == "" || e.expiresAt.Before(oldest) {
+				oldestKey, oldest = k, e.expiresAt
+			}
+		}
+		if len(c.items) >= c.maxEntries && oldestKey != "" {
+			delete(c.items, oldestKey)
+		}
+	}
+
+	c.items[key] = entry{value: value, expiresAt: now.Add(c.ttl)}
+}
@@ -2,9 +2,11 @@ package database
 
 import (
 	"booonus-backend/pkg/logger"
+	"context"
 	"database/sql"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	_ "github.com/ncruces/go-sqlite3/driver"
@@ -13,6 +15,9 @@ import (
 
 var DB *sql.DB
 
+// TimeLayout DATETIME 列的存储格式，统一使用 UTC
+const TimeLayout = "2006-01-02 15:04:05"
+
 // Init 初始化数据库连接
 func Init() error {
 	// 确保数据库目录存在
@@ -91,11 +96,13 @@ func createTables() error {
 			name TEXT NOT NULL,
 			description TEXT,
 			points INTEGER NOT NULL,
-			target_type TEXT NOT NULL CHECK (target_type IN ('user1', 'user2', 'both')),
+			target_type TEXT NOT NULL CHECK (target_type IN ('member', 'any', 'all')),
+			target_user_id INTEGER,
 			is_active BOOLEAN DEFAULT TRUE,
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			FOREIGN KEY (couple_id) REFERENCES couples(id)
+			FOREIGN KEY (couple_id) REFERENCES couples(id),
+			FOREIGN KEY (target_user_id) REFERENCES users(id)
 		)`,
 
 		`CREATE TABLE IF NOT EXISTS events (
@@ -147,6 +154,282 @@ func createTables() error {
 			FOREIGN KEY (rule_id) REFERENCES rules(id),
 			UNIQUE(user_id, rule_id)
 		)`,
+
+		`CREATE TABLE IF NOT EXISTS rule_revisions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			rule_id INTEGER NOT NULL,
+			revision INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			description TEXT,
+			points INTEGER NOT NULL,
+			target_type TEXT NOT NULL,
+			is_active BOOLEAN NOT NULL,
+			change_type TEXT NOT NULL CHECK (change_type IN ('create', 'update', 'delete', 'restore', 'deactivate')),
+			restored_from INTEGER,
+			changed_by INTEGER,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (rule_id) REFERENCES rules(id),
+			FOREIGN KEY (changed_by) REFERENCES users(id),
+			UNIQUE(rule_id, revision)
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS event_revisions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			event_id INTEGER NOT NULL,
+			revision INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			description TEXT,
+			points INTEGER NOT NULL,
+			change_type TEXT NOT NULL CHECK (change_type IN ('create', 'update', 'delete')),
+			changed_by INTEGER,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (event_id) REFERENCES events(id),
+			FOREIGN KEY (changed_by) REFERENCES users(id),
+			UNIQUE(event_id, revision)
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS attachments (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			owner_id INTEGER NOT NULL,
+			storage_key TEXT UNIQUE NOT NULL,
+			content_type TEXT NOT NULL,
+			size INTEGER NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (owner_id) REFERENCES users(id)
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS rule_executions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			rule_id INTEGER NOT NULL,
+			mode TEXT NOT NULL DEFAULT 'single' CHECK (mode IN ('single', 'all')),
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (rule_id) REFERENCES rules(id)
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS revert_actions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			history_id INTEGER NOT NULL,
+			action TEXT NOT NULL CHECK (action IN ('revert', 'cancel_revert')),
+			actor_id INTEGER NOT NULL,
+			reason TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (history_id) REFERENCES points_history(id),
+			FOREIGN KEY (actor_id) REFERENCES users(id)
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_revert_actions_history ON revert_actions(history_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_revert_actions_created ON revert_actions(created_at)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_points_history_user_created ON points_history(user_id, created_at, id)`,
+
+		`CREATE TABLE IF NOT EXISTS comments (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			target_type TEXT NOT NULL CHECK (target_type IN ('event', 'points_history', 'transaction')),
+			target_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL,
+			content TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME,
+			deleted_at DATETIME,
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_comments_target ON comments(target_type, target_id)`,
+
+		`CREATE TABLE IF NOT EXISTS reactions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			target_type TEXT NOT NULL CHECK (target_type IN ('event', 'points_history', 'transaction')),
+			target_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL,
+			emoji TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id),
+			UNIQUE(target_type, target_id, user_id, emoji)
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS balance_snapshots (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			snapshot_at DATETIME NOT NULL,
+			balance INTEGER NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id),
+			UNIQUE(user_id, snapshot_at)
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS export_jobs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			format TEXT NOT NULL CHECK (format IN ('csv', 'json', 'xlsx')),
+			timezone TEXT NOT NULL,
+			status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'running', 'completed', 'failed')),
+			storage_key TEXT,
+			error TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			completed_at DATETIME,
+			expires_at DATETIME,
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS household_members (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			couple_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL,
+			role TEXT NOT NULL DEFAULT 'member',
+			joined_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			left_at DATETIME,
+			FOREIGN KEY (couple_id) REFERENCES couples(id),
+			FOREIGN KEY (user_id) REFERENCES users(id),
+			UNIQUE(couple_id, user_id)
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_household_members_user ON household_members(user_id)`,
+
+		`CREATE TABLE IF NOT EXISTS notifications (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			type TEXT NOT NULL,
+			actor_id INTEGER,
+			message TEXT NOT NULL,
+			points INTEGER,
+			reference_type TEXT,
+			reference_id INTEGER,
+			read_at DATETIME,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id),
+			FOREIGN KEY (actor_id) REFERENCES users(id)
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_notifications_user ON notifications(user_id, read_at)`,
+
+		`CREATE TABLE IF NOT EXISTS notification_preferences (
+			user_id INTEGER NOT NULL,
+			type TEXT NOT NULL,
+			in_app BOOLEAN NOT NULL DEFAULT TRUE,
+			PRIMARY KEY (user_id, type),
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS webhooks (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			couple_id INTEGER NOT NULL,
+			url TEXT NOT NULL,
+			secret TEXT NOT NULL,
+			event_types TEXT NOT NULL DEFAULT '',
+			description TEXT,
+			is_active BOOLEAN NOT NULL DEFAULT TRUE,
+			created_by INTEGER,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (couple_id) REFERENCES couples(id),
+			FOREIGN KEY (created_by) REFERENCES users(id)
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			webhook_id INTEGER NOT NULL,
+			event_id TEXT NOT NULL,
+			event_type TEXT NOT NULL,
+			payload TEXT NOT NULL,
+			status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'succeeded', 'failed')),
+			attempts INTEGER NOT NULL DEFAULT 0,
+			next_attempt_at DATETIME,
+			last_status_code INTEGER,
+			last_error TEXT,
+			redelivery_of INTEGER,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			delivered_at DATETIME,
+			FOREIGN KEY (webhook_id) REFERENCES webhooks(id),
+			FOREIGN KEY (redelivery_of) REFERENCES webhook_deliveries(id)
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_pending ON webhook_deliveries(status, next_attempt_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook ON webhook_deliveries(webhook_id, created_at)`,
+
+		`CREATE TABLE IF NOT EXISTS webhook_delivery_attempts (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			delivery_id INTEGER NOT NULL,
+			attempt INTEGER NOT NULL,
+			status_code INTEGER,
+			error TEXT,
+			response_body TEXT,
+			duration_ms INTEGER NOT NULL,
+			attempted_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (delivery_id) REFERENCES webhook_deliveries(id)
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_webhook_delivery_attempts_delivery ON webhook_delivery_attempts(delivery_id)`,
+
+		`CREATE TABLE IF NOT EXISTS device_tokens (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			platform TEXT NOT NULL CHECK (platform IN ('android', 'ios')),
+			token TEXT NOT NULL UNIQUE,
+			name TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			last_seen_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_device_tokens_user ON device_tokens(user_id)`,
+
+		`CREATE TABLE IF NOT EXISTS imports (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			kind TEXT NOT NULL CHECK (kind IN ('rules', 'shop_items', 'history')),
+			source TEXT,
+			total_rows INTEGER NOT NULL,
+			imported_rows INTEGER NOT NULL,
+			skipped_rows INTEGER NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS reminders (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			couple_id INTEGER,
+			created_by INTEGER NOT NULL,
+			user_id INTEGER NOT NULL,
+			title TEXT NOT NULL,
+			message TEXT,
+			target_type TEXT CHECK (target_type IN ('rule', 'voucher')),
+			target_id INTEGER,
+			repeat TEXT NOT NULL DEFAULT 'once' CHECK (repeat IN ('once', 'daily', 'weekly', 'monthly')),
+			repeat_interval INTEGER NOT NULL DEFAULT 1,
+			weekdays TEXT,
+			time_of_day TEXT NOT NULL,
+			start_date TEXT NOT NULL,
+			end_date TEXT,
+			timezone TEXT NOT NULL DEFAULT 'UTC',
+			next_run_at DATETIME,
+			snoozed_until DATETIME,
+			last_sent_at DATETIME,
+			is_active BOOLEAN DEFAULT TRUE,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (couple_id) REFERENCES couples(id),
+			FOREIGN KEY (created_by) REFERENCES users(id),
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_reminders_due ON reminders(is_active, next_run_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_reminders_user ON reminders(user_id)`,
+
+		`CREATE TABLE IF NOT EXISTS reminder_events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			reminder_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL,
+			action TEXT NOT NULL CHECK (action IN ('delivered', 'snoozed', 'acknowledged')),
+			occurrence_at DATETIME,
+			snoozed_until DATETIME,
+			channel TEXT,
+			error TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (reminder_id) REFERENCES reminders(id),
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_reminder_events_reminder ON reminder_events(reminder_id)`,
 	}
 
 	for _, query := range queries {
@@ -162,44 +445,205 @@ func createTables() error {
 // runMigrations 运行数据库迁移
 func runMigrations() error {
 	// 检查avatar字段是否存在，如果不存在则添加
-	var columnExists bool
-	err := DB.QueryRow("PRAGMA table_info(users)").Scan()
-	if err != nil {
-		// 检查avatar列是否存在
-		rows, err := DB.Query("PRAGMA table_info(users)")
-		if err != nil {
+	if err := addColumnIfMissing("users", "avatar", "TEXT"); err != nil {
+		return err
+	}
+
+	// 积分历史关联执行时使用的规则版本
+	if err := addColumnIfMissing("points_history", "rule_revision_id", "INTEGER REFERENCES rule_revisions(id)"); err != nil {
+		return err
+	}
+
+	// 积分历史关联规则执行记录，用于同一次执行的多条记录一起撤销
+	if err := addColumnIfMissing("points_history", "execution_id", "INTEGER REFERENCES rule_executions(id)"); err != nil {
+		return err
+	}
+
+	// 规则执行的触发人、备注和照片附件
+	if err := addColumnIfMissing("rule_executions", "executed_by", "INTEGER REFERENCES users(id)"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing("rule_executions", "note", "TEXT"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing("rule_executions", "attachment_id", "INTEGER REFERENCES attachments(id)"); err != nil {
+		return err
+	}
+
+	// 规则积分表达式及其输入参数声明（JSON）
+	for _, table := range []string{"rules", "rule_revisions"} {
+		if err := addColumnIfMissing(table, "points_expression", "TEXT"); err != nil {
 			return err
 		}
-		defer rows.Close()
-
-		for rows.Next() {
-			var cid int
-			var name, dataType string
-			var notNull, pk int
-			var defaultValue interface{}
-
-			err := rows.Scan(&cid, &name, &dataType, &notNull, &defaultValue, &pk)
-			if err != nil {
-				return err
-			}
-
-			if name == "avatar" {
-				columnExists = true
-				break
-			}
+		if err := addColumnIfMissing(table, "parameters", "TEXT"); err != nil {
+			return err
 		}
+	}
+	if err := addColumnIfMissing("rule_executions", "inputs", "TEXT"); err != nil {
+		return err
+	}
+
+	// 事件的修改和删除
+	if err := addColumnIfMissing("events", "updated_at", "DATETIME"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing("events", "deleted_at", "DATETIME"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing("events", "deleted_by", "INTEGER REFERENCES users(id)"); err != nil {
+		return err
+	}
+
+	// 情侣设置：谁可以修改或删除事件（creator: 仅创建者，both: 双方）
+	if err := addColumnIfMissing("couples", "event_edit_policy", "TEXT NOT NULL DEFAULT 'creator'"); err != nil {
+		return err
+	}
 
-		if !columnExists {
-			// 添加avatar字段
-			_, err = DB.Exec("ALTER TABLE users ADD COLUMN avatar TEXT")
-			if err != nil {
-				logger.Error("Failed to add avatar column: " + err.Error())
-				return err
-			}
-			logger.Info("Added avatar column to users table")
+	// 情侣设置：撤销的时间窗口、是否必须填写原因、每条记录最多撤销次数
+	if err := addColumnIfMissing("couples", "revert_window_hours", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing("couples", "revert_reason_required", "BOOLEAN NOT NULL DEFAULT FALSE"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing("couples", "revert_limit", "INTEGER NOT NULL DEFAULT 3"); err != nil {
+		return err
+	}
+
+	// 解除的情侣关系只归档：status 为 ended，恢复期过后结算兑换券并记录 settled_at
+	if err := addColumnIfMissing("couples", "status", "TEXT NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'ended'))"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing("couples", "ended_at", "DATETIME"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing("couples", "ended_by", "INTEGER REFERENCES users(id)"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing("couples", "settled_at", "DATETIME"); err != nil {
+		return err
+	}
+
+	// 商品库存（为空表示不限量）和购买记录的兑现状态
+	if err := addColumnIfMissing("shop_items", "stock", "INTEGER"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing("transactions", "fulfilled_at", "DATETIME"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing("transactions", "fulfilled_by", "INTEGER REFERENCES users(id)"); err != nil {
+		return err
+	}
+
+	// 以前撤销购买只修改积分记录，交易状态仍是 completed，这里补正
+	_, err := DB.Exec(`
+		UPDATE transactions SET status = 'cancelled'
+		WHERE status = 'completed' AND id IN (
+			SELECT reference_id FROM points_history WHERE type = 'transaction' AND is_reverted = TRUE
+		)`)
+	if err != nil {
+		logger.Error("Failed to backfill reverted transactions: " + err.Error())
+		return err
+	}
+
+	// 日历订阅链接的私密令牌
+	if err := addColumnIfMissing("users", "calendar_token", "TEXT"); err != nil {
+		return err
+	}
+	if _, err := DB.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_users_calendar_token ON users(calendar_token)"); err != nil {
+		logger.Error("Failed to create calendar token index: " + err.Error())
+		return err
+	}
+
+	// 注销时间，已注销的用户保留匿名记录但不能登录
+	if err := addColumnIfMissing("users", "deleted_at", "DATETIME"); err != nil {
+		return err
+	}
+
+	// 导入的数据关联导入批次
+	for _, table := range []string{"rules", "shop_items", "points_history"} {
+		if err := addColumnIfMissing(table, "import_id", "INTEGER REFERENCES imports(id)"); err != nil {
+			return err
+		}
+	}
+
+	// 情侣关系扩展为多人家庭：成员记录在 household_members，规则可以指定成员、任一成员或所有成员
+	if err := addColumnIfMissing("couples", "name", "TEXT"); err != nil {
+		return err
+	}
+	if err := backfillHouseholdMembers(); err != nil {
+		return err
+	}
+	if err := migrateRuleTargets(); err != nil {
+		return err
+	}
+
+	// 成员离开家庭时停用以其为对象的规则，版本记录的修改类型为 deactivate
+	err = rebuildTable("rule_revisions",
+		"CHECK (change_type IN ('create', 'update', 'delete', 'restore'))",
+		"CHECK (change_type IN ('create', 'update', 'delete', 'restore', 'deactivate'))",
+		nil,
+	)
+	if err != nil {
+		return err
+	}
+
+	// 通知类型可以单独关闭手机推送
+	if err := addColumnIfMissing("notification_preferences", "push", "BOOLEAN NOT NULL DEFAULT TRUE"); err != nil {
+		return err
+	}
+
+	// 邮箱及每周邮件摘要；pending_email 为等待验证的新邮箱，timezone 决定摘要的发送时间
+	userColumns := []struct{ name, definition string }{
+		{"email", "TEXT"},
+		{"email_verified_at", "DATETIME"},
+		{"pending_email", "TEXT"},
+		{"email_digest", "BOOLEAN NOT NULL DEFAULT FALSE"},
+		{"last_digest_at", "DATETIME"},
+		{"timezone", "TEXT"},
+	}
+	for _, column := range userColumns {
+		if err := addColumnIfMissing("users", column.name, column.definition); err != nil {
+			return err
 		}
 	}
 
+	// 一个邮箱只能绑定一个账号（不区分大小写）；早期可能已有重复，保留最早的账号，其余的解除绑定
+	_, err = DB.Exec(`
+		UPDATE users SET email = NULL, email_verified_at = NULL, email_digest = FALSE
+		WHERE email IS NOT NULL AND id NOT IN (SELECT MIN(id) FROM users WHERE email IS NOT NULL GROUP BY email COLLATE NOCASE)`)
+	if err != nil {
+		logger.Error("Failed to remove duplicate emails: " + err.Error())
+		return err
+	}
+	if _, err := DB.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email ON users(email COLLATE NOCASE)"); err != nil {
+		logger.Error("Failed to create email index: " + err.Error())
+		return err
+	}
+
+	// 用户偏好设置：语言和每周第一天（0 为周日，默认周一），时区复用上面的 timezone
+	if err := addColumnIfMissing("users", "locale", "TEXT"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing("users", "week_start", "INTEGER NOT NULL DEFAULT 1"); err != nil {
+		return err
+	}
+
+	// 积分历史的变化序号，用于增量同步
+	if err := migratePointsHistoryChangeSeq(); err != nil {
+		return err
+	}
+
+	// 为没有版本记录的旧事件补充初始版本
+	if err := backfillEventRevisions(); err != nil {
+		return err
+	}
+
+	// 为没有版本记录的旧规则补充初始版本
+	if err := backfillRuleRevisions(); err != nil {
+		return err
+	}
+
 	// 注意：不再需要迁移 points_history 表，因为撤销操作使用原始类型
 	// if err := migratePointsHistoryTable(); err != nil {
 	// 	return err
@@ -208,6 +652,305 @@ func runMigrations() error {
 	return nil
 }
 
+// columnExists 检查表中是否存在指定列
+func columnExists(table, column string) (bool, error) {
+	rows, err := DB.Query("PRAGMA table_info(" + table + ")")
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid int
+		var name, dataType string
+		var notNull, pk int
+		var defaultValue interface{}
+
+		if err := rows.Scan(&cid, &name, &dataType, &notNull, &defaultValue, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+
+	return false, rows.Err()
+}
+
+// addColumnIfMissing 如果列不存在则添加
+func addColumnIfMissing(table, column, definition string) error {
+	exists, err := columnExists(table, column)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	if _, err := DB.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition); err != nil {
+		logger.Error("Failed to add " + column + " column to " + table + ": " + err.Error())
+		return err
+	}
+	logger.Info("Added " + column + " column to " + table + " table")
+	return nil
+}
+
+// migratePointsHistoryChangeSeq 为积分历史添加变化序号 change_seq，新增记录或撤销状态变化时由触发器设为当前最大值加一
+// 时间只精确到秒，无法区分同一秒内的变化；SQLite 同一时间只有一个写事务，序号按提交顺序递增，
+// 客户端读到某个序号后不会再出现更小序号的新变化。新增列时已有记录按创建和最近一次撤销的时间编号
+func migratePointsHistoryChangeSeq() error {
+	exists, err := columnExists("points_history", "change_seq")
+	if err != nil {
+		return err
+	}
+	if !exists {
+		if err := addColumnIfMissing("points_history", "change_seq", "INTEGER"); err != nil {
+			return err
+		}
+		_, err = DB.Exec(`
+			UPDATE points_history SET change_seq = s.seq
+			FROM (
+				SELECT p.id, ROW_NUMBER() OVER (
+					ORDER BY MAX(p.created_at, COALESCE((SELECT MAX(created_at) FROM revert_actions WHERE history_id = p.id), p.created_at)), p.id
+				) AS seq
+				FROM points_history p
+			) s
+			WHERE points_history.id = s.id`)
+		if err != nil {
+			logger.Error("Failed to backfill points history change sequence: " + err.Error())
+			return err
+		}
+	}
+
+	statements := []string{
+		`CREATE INDEX IF NOT EXISTS idx_points_history_change_seq ON points_history(change_seq)`,
+		`CREATE INDEX IF NOT EXISTS idx_points_history_user_change_seq ON points_history(user_id, change_seq)`,
+		`CREATE TRIGGER IF NOT EXISTS points_history_change_seq_insert AFTER INSERT ON points_history
+		BEGIN
+			UPDATE points_history SET change_seq = (SELECT COALESCE(MAX(change_seq), 0) + 1 FROM points_history) WHERE id = NEW.id;
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS points_history_change_seq_revert AFTER UPDATE OF is_reverted ON points_history
+		WHEN OLD.is_reverted IS NOT NEW.is_reverted
+		BEGIN
+			UPDATE points_history SET change_seq = (SELECT COALESCE(MAX(change_seq), 0) + 1 FROM points_history) WHERE id = NEW.id;
+		END`,
+	}
+	for _, statement := range statements {
+		if _, err := DB.Exec(statement); err != nil {
+			logger.Error("Failed to set up points history change sequence: " + err.Error())
+			return err
+		}
+	}
+	return nil
+}
+
+// backfillHouseholdMembers 为已有的情侣关系补充成员记录，user1 为所有者，user2 为管理员（原来双方权限相同）
+// 已离开的成员保留 left_at，不会被重新加入
+func backfillHouseholdMembers() error {
+	result, err := DB.Exec(`INSERT OR IGNORE INTO household_members (couple_id, user_id, role, joined_at)
+		SELECT id, user1_id, 'owner', created_at FROM couples
+		UNION ALL
+		SELECT id, user2_id, 'admin', created_at FROM couples`)
+	if err != nil {
+		logger.Error("Failed to backfill household members: " + err.Error())
+		return err
+	}
+
+	if n, _ := result.RowsAffected(); n > 0 {
+		logger.Info("Backfilled household members for existing couples")
+	}
+	return nil
+}
+
+// migrateRuleTargets 将规则的 user1/user2/both 目标迁移为 member/any/all
+// user1/user2 改为 member 并记录 target_user_id，both 改为 any；多人执行的模式 both 改为 all
+func migrateRuleTargets() error {
+	for _, table := range []string{"rules", "rule_revisions"} {
+		if err := addColumnIfMissing(table, "target_user_id", "INTEGER REFERENCES users(id)"); err != nil {
+			return err
+		}
+	}
+
+	err := rebuildTable("rules",
+		"CHECK (target_type IN ('user1', 'user2', 'both'))",
+		"CHECK (target_type IN ('member', 'any', 'all'))",
+		map[string]string{
+			"target_type": "CASE target_type WHEN 'both' THEN 'any' ELSE 'member' END",
+			"target_user_id": `CASE target_type
+				WHEN 'user1' THEN (SELECT user1_id FROM couples WHERE id = rules.couple_id)
+				WHEN 'user2' THEN (SELECT user2_id FROM couples WHERE id = rules.couple_id)
+			END`,
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	err = rebuildTable("rule_executions",
+		"CHECK (mode IN ('single', 'both'))",
+		"CHECK (mode IN ('single', 'all'))",
+		map[string]string{"mode": "CASE mode WHEN 'both' THEN 'all' ELSE mode END"},
+	)
+	if err != nil {
+		return err
+	}
+
+	// 版本记录没有约束，直接更新
+	_, err = DB.Exec(`UPDATE rule_revisions
+		SET target_user_id = (
+			SELECT CASE rule_revisions.target_type WHEN 'user1' THEN c.user1_id ELSE c.user2_id END
+			FROM rules r JOIN couples c ON r.couple_id = c.id
+			WHERE r.id = rule_revisions.rule_id
+		), target_type = 'member'
+		WHERE target_type IN ('user1', 'user2')`)
+	if err == nil {
+		_, err = DB.Exec("UPDATE rule_revisions SET target_type = 'any' WHERE target_type = 'both'")
+	}
+	if err != nil {
+		logger.Error("Failed to migrate rule revision targets: " + err.Error())
+		return err
+	}
+	return nil
+}
+
+// rebuildTable 按 SQLite 推荐的步骤重建表以修改 CHECK 约束：
+// 建表语句中的 oldCheck 替换为 newCheck，复制数据时 exprs 中的列使用对应表达式；
+// 建表语句中已没有 oldCheck 时不做任何操作
+func rebuildTable(table, oldCheck, newCheck string, exprs map[string]string) error {
+	var schema string
+	if err := DB.QueryRow("SELECT sql FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&schema); err != nil {
+		logger.Error("Failed to get " + table + " table schema: " + err.Error())
+		return err
+	}
+	if !strings.Contains(schema, oldCheck) {
+		return nil
+	}
+
+	logger.Info("Rebuilding " + table + " table")
+
+	// 外键检查只能在事务外关闭，需要固定使用同一个连接
+	ctx := context.Background()
+	conn, err := DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, "PRAGMA foreign_keys = ON")
+
+	columns, err := tableColumns(table)
+	if err != nil {
+		return err
+	}
+	selects := make([]string, len(columns))
+	for i, column := range columns {
+		selects[i] = column
+		if expr, ok := exprs[column]; ok {
+			selects[i] = expr
+		}
+	}
+
+	newTable := table + "_new"
+	newSchema := strings.Replace(schema, oldCheck, newCheck, 1)
+	newSchema = strings.Replace(newSchema, table, newTable, 1)
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statements := []string{
+		newSchema,
+		"INSERT INTO " + newTable + " (" + strings.Join(columns, ", ") + ") SELECT " + strings.Join(selects, ", ") + " FROM " + table,
+		"DROP TABLE " + table,
+		"ALTER TABLE " + newTable + " RENAME TO " + table,
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			logger.Error("Failed to rebuild " + table + " table: " + err.Error())
+			return err
+		}
+	}
+
+	// 旧版本解除情侣关系时直接删除记录，可能留下引用不存在的情侣的规则，这里只记录不阻止迁移
+	var violations int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM pragma_foreign_key_check(?)", table).Scan(&violations); err != nil {
+		return err
+	}
+	if violations > 0 {
+		logger.Warn(strconv.Itoa(violations) + " rows in " + table + " reference missing records")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	logger.Info("Successfully rebuilt " + table + " table")
+	return nil
+}
+
+// tableColumns 返回表的列名
+func tableColumns(table string) ([]string, error) {
+	rows, err := DB.Query("PRAGMA table_info(" + table + ")")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []string
+	for rows.Next() {
+		var cid int
+		var name, dataType string
+		var notNull, pk int
+		var defaultValue interface{}
+
+		if err := rows.Scan(&cid, &name, &dataType, &notNull, &defaultValue, &pk); err != nil {
+			return nil, err
+		}
+		columns = append(columns, name)
+	}
+	return columns, rows.Err()
+}
+
+// backfillRuleRevisions 为已有规则创建初始版本记录
+func backfillRuleRevisions() error {
+	result, err := DB.Exec(`INSERT INTO rule_revisions
+		(rule_id, revision, name, description, points, target_type, is_active, points_expression, parameters, change_type, created_at)
+		SELECT r.id, 1, r.name, r.description, r.points, r.target_type, r.is_active, r.points_expression, r.parameters, 'create', r.updated_at
+		FROM rules r
+		WHERE NOT EXISTS (SELECT 1 FROM rule_revisions rr WHERE rr.rule_id = r.id)`)
+	if err != nil {
+		logger.Error("Failed to backfill rule revisions: " + err.Error())
+		return err
+	}
+
+	if n, _ := result.RowsAffected(); n > 0 {
+		logger.Info("Backfilled initial revisions for existing rules")
+	}
+	return nil
+}
+
+// backfillEventRevisions 为已有事件创建初始版本记录
+func backfillEventRevisions() error {
+	result, err := DB.Exec(`INSERT INTO event_revisions
+		(event_id, revision, name, description, points, change_type, changed_by, created_at)
+		SELECT e.id, 1, e.name, e.description, e.points, 'create', e.creator_id, e.created_at
+		FROM events e
+		WHERE NOT EXISTS (SELECT 1 FROM event_revisions er WHERE er.event_id = e.id)`)
+	if err != nil {
+		logger.Error("Failed to backfill event revisions: " + err.Error())
+		return err
+	}
+
+	if n, _ := result.RowsAffected(); n > 0 {
+		logger.Info("Backfilled initial revisions for existing events")
+	}
+	return nil
+}
+
 // migratePointsHistoryTable 迁移 points_history 表以支持 'revert' 类型
 func migratePointsHistoryTable() error {
 	// 设置数据库参数以避免锁定
@@ -0,0 +1,42 @@
+package export
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"io"
+)
+
+// writeCSVZip 每张表写成 zip 中的一个 CSV 文件
+func writeCSVZip(w io.Writer, tables []Table) error {
+	zw := zip.NewWriter(w)
+
+	for _, table := range tables {
+		f, err := zw.Create(table.Name + ".csv")
+		if err != nil {
+			return err
+		}
+
+		cw := csv.NewWriter(f)
+		if err := cw.Write(table.Columns); err != nil {
+			return err
+		}
+
+		record := make([]string, len(table.Columns))
+		err = table.Rows(func(values []interface{}) error {
+			for i, v := range values {
+				record[i] = formatText(v)
+			}
+			return cw.Write(record)
+		})
+		if err != nil {
+			return err
+		}
+
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
@@ -0,0 +1,86 @@
+// Package export 将多张数据表流式写出为 CSV 压缩包、JSON 或 XLSX
+package export
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// 支持的导出格式
+const (
+	FormatCSV  = "csv"  // 每张表一个 CSV 文件，打包为 zip
+	FormatJSON = "json" // {"表名": [{列: 值}, ...], ...}
+	FormatXLSX = "xlsx" // 每张表一个工作表
+)
+
+// ErrUnknownFormat 不支持的导出格式
+var ErrUnknownFormat = errors.New("unknown export format")
+
+// Table 一张导出的数据表
+type Table struct {
+	Name    string
+	Columns []string
+	// Rows 依次对每一行调用 emit，值的顺序与 Columns 对应
+	// 值可以是 nil、string、bool、int、int64、float64
+	Rows func(emit func(values []interface{}) error) error
+}
+
+// ValidFormat 检查格式是否支持
+func ValidFormat(format string) bool {
+	return format == FormatCSV || format == FormatJSON || format == FormatXLSX
+}
+
+// ContentType 导出文件的 MIME 类型
+func ContentType(format string) string {
+	switch format {
+	case FormatCSV:
+		return "application/zip"
+	case FormatJSON:
+		return "application/json"
+	case FormatXLSX:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+	return "application/octet-stream"
+}
+
+// FileExtension 导出文件的扩展名
+func FileExtension(format string) string {
+	if format == FormatCSV {
+		return "zip"
+	}
+	return format
+}
+
+// Write 按格式写出所有表
+func Write(w io.Writer, format string, tables []Table) error {
+	switch format {
+	case FormatCSV:
+		return writeCSVZip(w, tables)
+	case FormatJSON:
+		return writeJSON(w, tables)
+	case FormatXLSX:
+		return writeXLSX(w, tables)
+	}
+	return ErrUnknownFormat
+}
+
+// formatText 单元格的文本形式，nil 为空字符串
+func formatText(v interface{}) string {
+	switch value := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case bool:
+		return strconv.FormatBool(value)
+	case int:
+		return strconv.Itoa(value)
+	case int64:
+		return strconv.FormatInt(value, 10)
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
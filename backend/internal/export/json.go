@@ -0,0 +1,63 @@
+package export
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+)
+
+// writeJSON 写出 {"表名": [{列: 值}, ...]}，逐行编码，列顺序与 Columns 一致
+func writeJSON(w io.Writer, tables []Table) error {
+	bw := bufio.NewWriter(w)
+
+	bw.WriteString("{")
+	for i, table := range tables {
+		if i > 0 {
+			bw.WriteString(",")
+		}
+		if err := writeJSONValue(bw, table.Name); err != nil {
+			return err
+		}
+		bw.WriteString(":[")
+
+		first := true
+		err := table.Rows(func(values []interface{}) error {
+			if !first {
+				bw.WriteString(",")
+			}
+			first = false
+
+			bw.WriteString("{")
+			for j, column := range table.Columns {
+				if j > 0 {
+					bw.WriteString(",")
+				}
+				if err := writeJSONValue(bw, column); err != nil {
+					return err
+				}
+				bw.WriteString(":")
+				if err := writeJSONValue(bw, values[j]); err != nil {
+					return err
+				}
+			}
+			_, err := bw.WriteString("}")
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		bw.WriteString("]")
+	}
+	bw.WriteString("}\n")
+
+	return bw.Flush()
+}
+
+func writeJSONValue(w *bufio.Writer, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
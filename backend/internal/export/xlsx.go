@@ -0,0 +1,166 @@
+package export
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// maxSheetNameLength Excel 工作表名称的最大长度
+const maxSheetNameLength = 31
+
+const xlsxContentTypesHead = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+`
+
+const xlsxRootRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>
+</Relationships>
+`
+
+// writeXLSX 每张表写成一个工作表，字符串使用内联字符串，不需要共享字符串表，可以边查边写
+func writeXLSX(w io.Writer, tables []Table) error {
+	zw := zip.NewWriter(w)
+
+	for i, table := range tables {
+		f, err := zw.Create("xl/worksheets/sheet" + strconv.Itoa(i+1) + ".xml")
+		if err != nil {
+			return err
+		}
+		if err := writeSheet(f, table); err != nil {
+			return err
+		}
+	}
+
+	files := []struct {
+		name    string
+		content string
+	}{
+		{"[Content_Types].xml", xlsxContentTypes(len(tables))},
+		{"_rels/.rels", xlsxRootRels},
+		{"xl/workbook.xml", xlsxWorkbook(tables)},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels(len(tables))},
+	}
+	for _, file := range files {
+		f, err := zw.Create(file.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, file.content); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+// writeSheet 写出一个工作表，第一行为列名
+func writeSheet(w io.Writer, table Table) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n")
+	bw.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+
+	header := make([]interface{}, len(table.Columns))
+	for i, column := range table.Columns {
+		header[i] = column
+	}
+	writeRow(bw, 1, header)
+
+	rowNumber := 1
+	err := table.Rows(func(values []interface{}) error {
+		rowNumber++
+		return writeRow(bw, rowNumber, values)
+	})
+	if err != nil {
+		return err
+	}
+
+	bw.WriteString(`</sheetData></worksheet>`)
+	return bw.Flush()
+}
+
+// writeRow 写出一行单元格，数字写成数值，其他写成内联字符串
+func writeRow(w *bufio.Writer, rowNumber int, values []interface{}) error {
+	row := strconv.Itoa(rowNumber)
+	w.WriteString(`<row r="` + row + `">`)
+	for i, v := range values {
+		if v == nil {
+			continue
+		}
+		ref := columnName(i) + row
+		switch v.(type) {
+		case int, int64, float64:
+			w.WriteString(`<c r="` + ref + `"><v>` + formatText(v) + `</v></c>`)
+		case bool:
+			value := "0"
+			if v.(bool) {
+				value = "1"
+			}
+			w.WriteString(`<c r="` + ref + `" t="b"><v>` + value + `</v></c>`)
+		default:
+			w.WriteString(`<c r="` + ref + `" t="inlineStr"><is><t xml:space="preserve">`)
+			if err := xml.EscapeText(w, []byte(formatText(v))); err != nil {
+				return err
+			}
+			w.WriteString(`</t></is></c>`)
+		}
+	}
+	_, err := w.WriteString(`</row>`)
+	return err
+}
+
+// columnName 列序号（从 0 开始）对应的列名：A、B、…、Z、AA、…
+func columnName(index int) string {
+	name := ""
+	for index >= 0 {
+		name = string(rune('A'+index%26)) + name
+		index = index/26 - 1
+	}
+	return name
+}
+
+func xlsxContentTypes(sheets int) string {
+	s := xlsxContentTypesHead
+	for i := 1; i <= sheets; i++ {
+		s += `<Override PartName="/xl/worksheets/sheet` + strconv.Itoa(i) + `.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` + "\n"
+	}
+	return s + "</Types>\n"
+}
+
+func xlsxWorkbook(tables []Table) string {
+	s := `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n" +
+		`<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>`
+	for i, table := range tables {
+		name := table.Name
+		if len(name) > maxSheetNameLength {
+			name = name[:maxSheetNameLength]
+		}
+		id := strconv.Itoa(i + 1)
+		s += `<sheet name="` + xmlAttr(name) + `" sheetId="` + id + `" r:id="rId` + id + `"/>`
+	}
+	return s + "</sheets></workbook>\n"
+}
+
+func xlsxWorkbookRels(sheets int) string {
+	s := `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n" +
+		`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`
+	for i := 1; i <= sheets; i++ {
+		id := strconv.Itoa(i)
+		s += `<Relationship Id="rId` + id + `" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet` + id + `.xml"/>`
+	}
+	return s + "</Relationships>\n"
+}
+
+// xmlAttr 转义属性值
+func xmlAttr(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
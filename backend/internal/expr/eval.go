@@ -0,0 +1,321 @@
+package expr
+
+import (
+	"context"
+	"fmt"
+)
+
+// function 内置函数定义
+type function struct {
+	minArgs, maxArgs int // maxArgs 为 -1 表示不限
+	check            func(args []Type) (Type, error)
+	call             func(args []interface{}) (interface{}, error)
+}
+
+// allNumbers 检查参数是否全是数字
+func allNumbers(name string) func(args []Type) (Type, error) {
+	return func(args []Type) (Type, error) {
+		for _, t := range args {
+			if t != TypeNumber {
+				return "", fmt.Errorf("%s() expects number arguments", name)
+			}
+		}
+		return TypeNumber, nil
+	}
+}
+
+// functions 内置函数表
+var functions = map[string]function{
+	"min": {minArgs: 1, maxArgs: -1, check: allNumbers("min"), call: func(args []interface{}) (interface{}, error) {
+		result := args[0].(int64)
+		for _, a := range args[1:] {
+			if v := a.(int64); v < result {
+				result = v
+			}
+		}
+		return result, nil
+	}},
+	"max": {minArgs: 1, maxArgs: -1, check: allNumbers("max"), call: func(args []interface{}) (interface{}, error) {
+		result := args[0].(int64)
+		for _, a := range args[1:] {
+			if v := a.(int64); v > result {
+				result = v
+			}
+		}
+		return result, nil
+	}},
+	"abs": {minArgs: 1, maxArgs: 1, check: allNumbers("abs"), call: func(args []interface{}) (interface{}, error) {
+		v := args[0].(int64)
+		if v < 0 {
+			v = -v
+		}
+		return v, nil
+	}},
+	"clamp": {minArgs: 3, maxArgs: 3, check: allNumbers("clamp"), call: func(args []interface{}) (interface{}, error) {
+		v, lo, hi := args[0].(int64), args[1].(int64), args[2].(int64)
+		if lo > hi {
+			return nil, fmt.Errorf("clamp() lower bound is greater than upper bound")
+		}
+		if v < lo {
+			return lo, nil
+		}
+		if v > hi {
+			return hi, nil
+		}
+		return v, nil
+	}},
+}
+
+// check 类型检查，返回节点的结果类型
+func check(n node, vars map[string]Type) (Type, error) {
+	switch n := n.(type) {
+	case *numberNode:
+		return TypeNumber, nil
+	case *boolNode:
+		return TypeBoolean, nil
+	case *varNode:
+		t, ok := vars[n.name]
+		if !ok {
+			return "", fmt.Errorf("unknown variable %q", n.name)
+		}
+		return t, nil
+	case *unaryNode:
+		t, err := check(n.operand, vars)
+		if err != nil {
+			return "", err
+		}
+		if n.op == "-" && t != TypeNumber {
+			return "", fmt.Errorf("operator - expects a number")
+		}
+		if n.op == "!" && t != TypeBoolean {
+			return "", fmt.Errorf("operator ! expects a boolean")
+		}
+		return t, nil
+	case *binaryNode:
+		lt, err := check(n.left, vars)
+		if err != nil {
+			return "", err
+		}
+		rt, err := check(n.right, vars)
+		if err != nil {
+			return "", err
+		}
+		switch n.op {
+		case "&&", "||":
+			if lt != TypeBoolean || rt != TypeBoolean {
+				return "", fmt.Errorf("operator %s expects booleans", n.op)
+			}
+			return TypeBoolean, nil
+		case "==", "!=":
+			if lt != rt {
+				return "", fmt.Errorf("operator %s compares values of different types", n.op)
+			}
+			return TypeBoolean, nil
+		case "<", "<=", ">", ">=":
+			if lt != TypeNumber || rt != TypeNumber {
+				return "", fmt.Errorf("operator %s expects numbers", n.op)
+			}
+			return TypeBoolean, nil
+		default:
+			if lt != TypeNumber || rt != TypeNumber {
+				return "", fmt.Errorf("operator %s expects numbers", n.op)
+			}
+			return TypeNumber, nil
+		}
+	case *condNode:
+		ct, err := check(n.cond, vars)
+		if err != nil {
+			return "", err
+		}
+		if ct != TypeBoolean {
+			return "", fmt.Errorf("condition of ?: must be a boolean")
+		}
+		tt, err := check(n.then, vars)
+		if err != nil {
+			return "", err
+		}
+		ot, err := check(n.otherwise, vars)
+		if err != nil {
+			return "", err
+		}
+		if tt != ot {
+			return "", fmt.Errorf("both branches of ?: must have the same type")
+		}
+		return tt, nil
+	case *callNode:
+		fn, ok := functions[n.name]
+		if !ok {
+			return "", fmt.Errorf("unknown function %q", n.name)
+		}
+		if len(n.args) < fn.minArgs || (fn.maxArgs >= 0 && len(n.args) > fn.maxArgs) {
+			return "", fmt.Errorf("wrong number of arguments for %s()", n.name)
+		}
+		types := make([]Type, len(n.args))
+		for i, arg := range n.args {
+			t, err := check(arg, vars)
+			if err != nil {
+				return "", err
+			}
+			types[i] = t
+		}
+		return fn.check(types)
+	}
+	return "", fmt.Errorf("invalid expression")
+}
+
+// evaluator 求值器，记录步数并定期检查超时
+type evaluator struct {
+	ctx   context.Context
+	vars  map[string]interface{}
+	steps int
+}
+
+func (e *evaluator) step() error {
+	e.steps++
+	if e.steps > MaxSteps {
+		return ErrTooManySteps
+	}
+	if e.steps%checkEvery == 0 && e.ctx != nil {
+		if err := e.ctx.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// number 检查中间结果是否在允许范围内
+func number(v int64) (interface{}, error) {
+	if v > maxIntermediate || v < -maxIntermediate {
+		return nil, ErrOutOfRange
+	}
+	return v, nil
+}
+
+func (e *evaluator) eval(n node) (interface{}, error) {
+	if err := e.step(); err != nil {
+		return nil, err
+	}
+
+	switch n := n.(type) {
+	case *numberNode:
+		return n.value, nil
+	case *boolNode:
+		return n.value, nil
+	case *varNode:
+		v, ok := e.vars[n.name]
+		if !ok {
+			return nil, fmt.Errorf("missing value for variable %q", n.name)
+		}
+		switch v := v.(type) {
+		case int64:
+			return number(v)
+		case bool:
+			return v, nil
+		}
+		return nil, fmt.Errorf("invalid value for variable %q", n.name)
+	case *unaryNode:
+		v, err := e.eval(n.operand)
+		if err != nil {
+			return nil, err
+		}
+		if n.op == "-" {
+			return number(-v.(int64))
+		}
+		return !v.(bool), nil
+	case *binaryNode:
+		return e.evalBinary(n)
+	case *condNode:
+		cond, err := e.eval(n.cond)
+		if err != nil {
+			return nil, err
+		}
+		if cond.(bool) {
+			return e.eval(n.then)
+		}
+		return e.eval(n.otherwise)
+	case *callNode:
+		args := make([]interface{}, len(n.args))
+		for i, arg := range n.args {
+			v, err := e.eval(arg)
+			if err != nil {
+				return nil, err
+			}
+			args[i] = v
+		}
+		return functions[n.name].call(args)
+	}
+	return nil, fmt.Errorf("invalid expression")
+}
+
+func (e *evaluator) evalBinary(n *binaryNode) (interface{}, error) {
+	left, err := e.eval(n.left)
+	if err != nil {
+		return nil, err
+	}
+
+	// 逻辑运算短路求值
+	switch n.op {
+	case "&&":
+		if !left.(bool) {
+			return false, nil
+		}
+		return e.eval(n.right)
+	case "||":
+		if left.(bool) {
+			return true, nil
+		}
+		return e.eval(n.right)
+	}
+
+	right, err := e.eval(n.right)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==":
+		return left == right, nil
+	case "!=":
+		return left != right, nil
+	}
+
+	l, r := left.(int64), right.(int64)
+	switch n.op {
+	case "<":
+		return l < r, nil
+	case "<=":
+		return l <= r, nil
+	case ">":
+		return l > r, nil
+	case ">=":
+		return l >= r, nil
+	case "+":
+		return number(l + r)
+	case "-":
+		return number(l - r)
+	case "*":
+		// 两个操作数都不超过 2^40，先检查再相乘避免 int64 溢出
+		if l != 0 && r != 0 && (abs64(l) > maxIntermediate/abs64(r)) {
+			return nil, ErrOutOfRange
+		}
+		return number(l * r)
+	case "/":
+		if r == 0 {
+			return nil, ErrDivByZero
+		}
+		return l / r, nil
+	case "%":
+		if r == 0 {
+			return nil, ErrDivByZero
+		}
+		return l % r, nil
+	}
+	return nil, fmt.Errorf("unknown operator %s", n.op)
+}
+
+func abs64(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
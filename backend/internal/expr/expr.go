@@ -0,0 +1,100 @@
+// Package expr 实现规则积分计算使用的小型表达式语言
+//
+// 表达式只支持整数和布尔值、四则运算、比较、逻辑运算、三元运算符以及少量内置函数，
+// 不包含循环、赋值或任何外部访问，因此求值结果只取决于传入的变量，且步数有上限。
+package expr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// 表达式限制
+const (
+	MaxSourceLength = 500     // 表达式源码最大长度
+	MaxNodes        = 200     // 语法树最大节点数
+	MaxDepth        = 32      // 语法树最大嵌套深度
+	MaxSteps        = 10000   // 单次求值最大步数
+	MaxResult       = 1000000 // 结果积分的绝对值上限
+	maxIntermediate = 1 << 40 // 中间结果的绝对值上限，防止溢出
+	checkEvery      = 64      // 每隔多少步检查一次超时
+)
+
+// Type 表达式值类型
+type Type string
+
+const (
+	TypeNumber  Type = "number"
+	TypeBoolean Type = "boolean"
+)
+
+// 常见错误
+var (
+	ErrTooManySteps  = errors.New("expression evaluation exceeded step limit")
+	ErrDivByZero     = errors.New("division by zero")
+	ErrOutOfRange    = errors.New("value out of range")
+	ErrResultNotInt  = errors.New("expression must evaluate to a number")
+	ErrResultTooHigh = fmt.Errorf("result must be between -%d and %d", MaxResult, MaxResult)
+)
+
+// Program 已编译并通过类型检查的表达式
+type Program struct {
+	source string
+	root   node
+}
+
+// Source 返回表达式源码
+func (p *Program) Source() string {
+	return p.source
+}
+
+// Compile 解析表达式并按给定的变量类型做类型检查，结果必须为数字
+func Compile(source string, vars map[string]Type) (*Program, error) {
+	if len(source) > MaxSourceLength {
+		return nil, fmt.Errorf("expression is longer than %d characters", MaxSourceLength)
+	}
+
+	p := &parser{lex: newLexer(source)}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	root, err := p.parseExpr(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, p.errorf("unexpected %q", p.tok.text)
+	}
+	if p.nodes > MaxNodes {
+		return nil, fmt.Errorf("expression is too complex (more than %d nodes)", MaxNodes)
+	}
+
+	t, err := check(root, vars)
+	if err != nil {
+		return nil, err
+	}
+	if t != TypeNumber {
+		return nil, ErrResultNotInt
+	}
+
+	return &Program{source: source, root: root}, nil
+}
+
+// Eval 使用给定变量求值，变量值只能是 int64 或 bool
+func (p *Program) Eval(ctx context.Context, vars map[string]interface{}) (int64, error) {
+	e := &evaluator{ctx: ctx, vars: vars}
+	v, err := e.eval(p.root)
+	if err != nil {
+		return 0, err
+	}
+
+	n, ok := v.(int64)
+	if !ok {
+		return 0, ErrResultNotInt
+	}
+	if n > MaxResult || n < -MaxResult {
+		return 0, ErrResultTooHigh
+	}
+	return n, nil
+}
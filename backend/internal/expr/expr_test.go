@@ -0,0 +1,234 @@
+package expr
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+var testVars = map[string]Type{
+	"count":   TypeNumber,
+	"streak":  TypeNumber,
+	"weekend": TypeBoolean,
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"empty", "", "unexpected end of expression"},
+		{"trailing operator", "1 +", "unexpected end of expression"},
+		{"unclosed paren", "(1 + 2", "expected ')'"},
+		{"trailing comma", "min(1,)", "unexpected ')'"},
+		{"leading comma", "min(,1)", "unexpected \",\""},
+		{"double comma", "min(1,,2)", "unexpected \",\""},
+		{"missing colon", "weekend ? 1", "expected ':'"},
+		{"unexpected character", "1 $ 2", "unexpected character"},
+		{"trailing tokens", "1 2", "unexpected \"2\""},
+		{"unknown variable", "points * 2", "unknown variable \"points\""},
+		{"unknown function", "pow(2, 3)", "unknown function \"pow\""},
+		{"too few arguments", "clamp(1, 2)", "wrong number of arguments for clamp()"},
+		{"too many arguments", "abs(1, 2)", "wrong number of arguments for abs()"},
+		{"no arguments", "min()", "wrong number of arguments for min()"},
+		{"boolean argument", "max(1, weekend)", "max() expects number arguments"},
+		{"boolean result", "count > 1", "expression must evaluate to a number"},
+		{"negate boolean", "-weekend ? 1 : 2", "operator - expects a number"},
+		{"not number", "!count", "operator ! expects a boolean"},
+		{"logic on numbers", "count && 1 ? 1 : 0", "operator && expects booleans"},
+		{"compare mixed types", "count == weekend ? 1 : 0", "compares values of different types"},
+		{"condition not boolean", "count ? 1 : 2", "condition of ?: must be a boolean"},
+		{"branch types differ", "weekend ? 1 : false", "both branches of ?: must have the same type"},
+		{"number too large", "1099511627777", "number 1099511627777 is too large"},
+		{"number overflows int64", "99999999999999999999", "is too large"},
+		{"source too long", strings.Repeat("1", MaxSourceLength+1), "longer than 500 characters"},
+		{"too deep", strings.Repeat("(", MaxDepth) + "1" + strings.Repeat(")", MaxDepth), "nested too deeply"},
+		{"too deep unary", strings.Repeat("-", MaxDepth+1) + "1", "nested too deeply"},
+		{"too many nodes", "1" + strings.Repeat("+1", MaxNodes/2), "too complex"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Compile(tt.source, testVars)
+			if err == nil {
+				t.Fatalf("Compile(%q) succeeded, want error containing %q", tt.source, tt.want)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Compile(%q) error = %q, want it to contain %q", tt.source, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		vars   map[string]interface{}
+		want   int64
+	}{
+		{"literal", "42", nil, 42},
+		{"precedence", "1 + 2 * 3", nil, 7},
+		{"parentheses", "(1 + 2) * 3", nil, 9},
+		{"left associative", "10 - 4 - 3", nil, 3},
+		{"division truncates", "7 / 2", nil, 3},
+		{"modulo", "7 % 3", nil, 1},
+		{"negative division", "-7 / 2", nil, -3},
+		{"unary minus", "-(2 + 3)", nil, -5},
+		{"double negation", "--5", nil, 5},
+		{"variables", "count * 10 + streak", map[string]interface{}{"count": int64(3), "streak": int64(2)}, 32},
+		{"ternary", "weekend ? count * 2 : count", map[string]interface{}{"count": int64(5), "weekend": true}, 10},
+		{"ternary false", "weekend ? count * 2 : count", map[string]interface{}{"count": int64(5), "weekend": false}, 5},
+		{"nested ternary", "count > 10 ? 3 : count > 5 ? 2 : 1", map[string]interface{}{"count": int64(7)}, 2},
+		{"comparison and logic", "count >= 3 && !weekend || streak == 0 ? 1 : 0", map[string]interface{}{"count": int64(3), "weekend": false, "streak": int64(9)}, 1},
+		{"boolean equality", "weekend == false ? 1 : 0", map[string]interface{}{"weekend": false}, 1},
+		{"min", "min(5, 3, 8)", nil, 3},
+		{"max", "max(5, 3, 8)", nil, 8},
+		{"abs", "abs(-4)", nil, 4},
+		{"clamp low", "clamp(-5, 0, 10)", nil, 0},
+		{"clamp high", "clamp(50, 0, 10)", nil, 10},
+		{"clamp inside", "clamp(count, 0, 10)", map[string]interface{}{"count": int64(4)}, 4},
+		{"whitespace", " \t1\n+\r2 ", nil, 3},
+		{"max result", "1000000", nil, MaxResult},
+		{"min result", "-1000000", nil, -MaxResult},
+		// 短路求值：右侧除零不会执行
+		{"short circuit and", "weekend && 1 / 0 == 0 ? 1 : 2", map[string]interface{}{"weekend": false}, 2},
+		{"short circuit or", "weekend || 1 / 0 == 0 ? 1 : 2", map[string]interface{}{"weekend": true}, 1},
+		{"untaken branch", "weekend ? 1 / 0 : 7", map[string]interface{}{"weekend": false}, 7},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			program, err := Compile(tt.source, testVars)
+			if err != nil {
+				t.Fatalf("Compile(%q) error = %v", tt.source, err)
+			}
+			got, err := program.Eval(context.Background(), tt.vars)
+			if err != nil {
+				t.Fatalf("Eval(%q) error = %v", tt.source, err)
+			}
+			if got != tt.want {
+				t.Fatalf("Eval(%q) = %d, want %d", tt.source, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		vars   map[string]interface{}
+		want   error
+	}{
+		{"division by zero", "1 / (count - 1)", map[string]interface{}{"count": int64(1)}, ErrDivByZero},
+		{"modulo by zero", "5 % count", map[string]interface{}{"count": int64(0)}, ErrDivByZero},
+		{"multiplication overflow", "1048576 * 1048576 * 2", nil, ErrOutOfRange},
+		{"multiplication near int64", "1099511627776 * 1099511627776", nil, ErrOutOfRange},
+		{"addition overflow", "1099511627776 + 1", nil, ErrOutOfRange},
+		{"subtraction overflow", "-1099511627776 - 1", nil, ErrOutOfRange},
+		{"variable out of range", "count", map[string]interface{}{"count": int64(1) << 41}, ErrOutOfRange},
+		{"result too high", "1000001", nil, ErrResultTooHigh},
+		{"result too low", "-count * 1000", map[string]interface{}{"count": int64(1001)}, ErrResultTooHigh},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			program, err := Compile(tt.source, testVars)
+			if err != nil {
+				t.Fatalf("Compile(%q) error = %v", tt.source, err)
+			}
+			_, err = program.Eval(context.Background(), tt.vars)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Eval(%q) error = %v, want %v", tt.source, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvalInvalidVariables(t *testing.T) {
+	program, err := Compile("count + 1", testVars)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := program.Eval(context.Background(), nil); err == nil || !strings.Contains(err.Error(), "missing value") {
+		t.Fatalf("Eval without variables error = %v, want missing value", err)
+	}
+	if _, err := program.Eval(context.Background(), map[string]interface{}{"count": 1}); err == nil || !strings.Contains(err.Error(), "invalid value") {
+		t.Fatalf("Eval with int variable error = %v, want invalid value", err)
+	}
+}
+
+func TestEvalClampBounds(t *testing.T) {
+	program, err := Compile("clamp(count, 10, 0)", testVars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := program.Eval(context.Background(), map[string]interface{}{"count": int64(5)}); err == nil {
+		t.Fatal("clamp() with lower bound above upper bound succeeded")
+	}
+}
+
+func TestEvalStepLimit(t *testing.T) {
+	// 编译时的节点上限使正常表达式达不到步数上限，这里直接构造语法树
+	var root node = &numberNode{value: 1}
+	for i := 0; i < MaxSteps; i++ {
+		root = &unaryNode{op: "-", operand: root}
+	}
+	program := &Program{root: root}
+
+	if _, err := program.Eval(context.Background(), nil); !errors.Is(err, ErrTooManySteps) {
+		t.Fatalf("Eval error = %v, want %v", err, ErrTooManySteps)
+	}
+
+	root = root.(*unaryNode).operand
+	if _, err := (&Program{root: root}).Eval(context.Background(), nil); err != nil {
+		t.Fatalf("Eval with exactly %d steps error = %v", MaxSteps, err)
+	}
+}
+
+func TestEvalTimeout(t *testing.T) {
+	// 超时每 checkEvery 步检查一次，表达式的步数需要超过 checkEvery
+	source := "1" + strings.Repeat("+1", checkEvery)
+	program, err := Compile(source, testVars)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := program.Eval(ctx, nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Eval with cancelled context error = %v, want %v", err, context.Canceled)
+	}
+
+	got, err := program.Eval(context.Background(), nil)
+	if err != nil || got != checkEvery+1 {
+		t.Fatalf("Eval = %d, %v, want %d", got, err, checkEvery+1)
+	}
+
+	// 步数不足 checkEvery 的表达式不检查超时
+	short, err := Compile("1 + 1", testVars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := short.Eval(ctx, nil); err != nil || got != 2 {
+		t.Fatalf("short Eval = %d, %v, want 2", got, err)
+	}
+}
+
+func TestCompileLimitsBoundary(t *testing.T) {
+	// 刚好达到上限的表达式可以编译
+	sources := []string{
+		strings.Repeat("(", MaxDepth-1) + "1" + strings.Repeat(")", MaxDepth-1),
+		"1" + strings.Repeat("+1", (MaxNodes-1)/2),
+		"1099511627776",
+	}
+	for _, source := range sources {
+		if _, err := Compile(source, testVars); err != nil {
+			t.Errorf("Compile(%q) error = %v", source, err)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+)
+
+// MaxParams 单个规则最多声明的输入参数个数
+const MaxParams = 10
+
+// Param 规则声明的输入参数
+type Param struct {
+	Name     string `json:"name"`
+	Type     Type   `json:"type"` // "number" 或 "boolean"
+	Label    string `json:"label,omitempty"`
+	Required bool   `json:"required"`
+	Min      *int64 `json:"min,omitempty"` // 仅 number 类型
+	Max      *int64 `json:"max,omitempty"` // 仅 number 类型
+}
+
+// ValidateParams 检查参数声明，reserved 为内置变量，参数名不能与之重复
+func ValidateParams(params []Param, reserved map[string]Type) error {
+	if len(params) > MaxParams {
+		return fmt.Errorf("a rule can declare at most %d parameters", MaxParams)
+	}
+
+	seen := map[string]bool{}
+	for _, p := range params {
+		if !isIdentifier(p.Name) {
+			return fmt.Errorf("invalid parameter name %q", p.Name)
+		}
+		if p.Name == "true" || p.Name == "false" {
+			return fmt.Errorf("parameter name %q is reserved", p.Name)
+		}
+		if _, ok := reserved[p.Name]; ok {
+			return fmt.Errorf("parameter name %q is reserved", p.Name)
+		}
+		if _, ok := functions[p.Name]; ok {
+			return fmt.Errorf("parameter name %q is reserved", p.Name)
+		}
+		if seen[p.Name] {
+			return fmt.Errorf("duplicate parameter %q", p.Name)
+		}
+		seen[p.Name] = true
+
+		switch p.Type {
+		case TypeNumber:
+			if p.Min != nil && p.Max != nil && *p.Min > *p.Max {
+				return fmt.Errorf("parameter %q has min greater than max", p.Name)
+			}
+		case TypeBoolean:
+			if p.Min != nil || p.Max != nil {
+				return fmt.Errorf("parameter %q is boolean and cannot have min or max", p.Name)
+			}
+		default:
+			return fmt.Errorf("parameter %q has invalid type %q", p.Name, p.Type)
+		}
+	}
+
+	return nil
+}
+
+// VarTypes 合并参数和内置变量的类型，用于 Compile
+func VarTypes(params []Param, builtins map[string]Type) map[string]Type {
+	vars := make(map[string]Type, len(params)+len(builtins))
+	for name, t := range builtins {
+		vars[name] = t
+	}
+	for _, p := range params {
+		vars[p.Name] = p.Type
+	}
+	return vars
+}
+
+// BindInputs 按参数声明校验输入并转换为求值用的变量值
+// 输入来自JSON解码，数字为 float64；未提供的可选参数取零值
+func BindInputs(params []Param, inputs map[string]interface{}) (map[string]interface{}, error) {
+	declared := map[string]bool{}
+	values := make(map[string]interface{}, len(params))
+
+	for _, p := range params {
+		declared[p.Name] = true
+
+		raw, ok := inputs[p.Name]
+		if !ok || raw == nil {
+			if p.Required {
+				return nil, fmt.Errorf("input %q is required", p.Name)
+			}
+			if p.Type == TypeBoolean {
+				values[p.Name] = false
+			} else {
+				values[p.Name] = int64(0)
+			}
+			continue
+		}
+
+		switch p.Type {
+		case TypeNumber:
+			f, ok := raw.(float64)
+			if !ok || f != math.Trunc(f) || math.Abs(f) > maxIntermediate {
+				return nil, fmt.Errorf("input %q must be an integer", p.Name)
+			}
+			n := int64(f)
+			if p.Min != nil && n < *p.Min {
+				return nil, fmt.Errorf("input %q must be at least %d", p.Name, *p.Min)
+			}
+			if p.Max != nil && n > *p.Max {
+				return nil, fmt.Errorf("input %q must be at most %d", p.Name, *p.Max)
+			}
+			values[p.Name] = n
+		case TypeBoolean:
+			b, ok := raw.(bool)
+			if !ok {
+				return nil, fmt.Errorf("input %q must be a boolean", p.Name)
+			}
+			values[p.Name] = b
+		}
+	}
+
+	for name := range inputs {
+		if !declared[name] {
+			return nil, fmt.Errorf("unknown input %q", name)
+		}
+	}
+
+	return values, nil
+}
+
+func isIdentifier(s string) bool {
+	if s == "" || len(s) > 32 || !isIdentStart(s[0]) {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		if !isIdentStart(s[i]) && !isDigit(s[i]) {
+			return false
+		}
+	}
+	return true
+}
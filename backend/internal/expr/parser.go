@@ -0,0 +1,313 @@
+package expr
+
+import (
+	"fmt"
+	"strconv"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNumber
+	tokIdent
+	tokOp
+	tokLParen
+	tokRParen
+	tokComma
+	tokQuestion
+	tokColon
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// lexer 词法分析器
+type lexer struct {
+	src string
+	pos int
+}
+
+func newLexer(src string) *lexer {
+	return &lexer{src: src}
+}
+
+// twoCharOps 双字符运算符
+var twoCharOps = map[string]bool{
+	"==": true, "!=": true, "<=": true, ">=": true, "&&": true, "||": true,
+}
+
+func (l *lexer) next() (token, error) {
+	for l.pos < len(l.src) && isSpace(l.src[l.pos]) {
+		l.pos++
+	}
+	if l.pos >= len(l.src) {
+		return token{kind: tokEOF, pos: l.pos}, nil
+	}
+
+	start := l.pos
+	ch := l.src[l.pos]
+
+	switch {
+	case isDigit(ch):
+		for l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+			l.pos++
+		}
+		return token{kind: tokNumber, text: l.src[start:l.pos], pos: start}, nil
+	case isIdentStart(ch):
+		for l.pos < len(l.src) && (isIdentStart(l.src[l.pos]) || isDigit(l.src[l.pos])) {
+			l.pos++
+		}
+		return token{kind: tokIdent, text: l.src[start:l.pos], pos: start}, nil
+	}
+
+	if l.pos+1 < len(l.src) && twoCharOps[l.src[l.pos:l.pos+2]] {
+		l.pos += 2
+		return token{kind: tokOp, text: l.src[start:l.pos], pos: start}, nil
+	}
+
+	l.pos++
+	switch ch {
+	case '+', '-', '*', '/', '%', '<', '>', '!':
+		return token{kind: tokOp, text: string(ch), pos: start}, nil
+	case '(':
+		return token{kind: tokLParen, text: "(", pos: start}, nil
+	case ')':
+		return token{kind: tokRParen, text: ")", pos: start}, nil
+	case ',':
+		return token{kind: tokComma, text: ",", pos: start}, nil
+	case '?':
+		return token{kind: tokQuestion, text: "?", pos: start}, nil
+	case ':':
+		return token{kind: tokColon, text: ":", pos: start}, nil
+	}
+
+	return token{}, fmt.Errorf("unexpected character %q at position %d", ch, start)
+}
+
+func isSpace(ch byte) bool { return ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r' }
+func isDigit(ch byte) bool { return ch >= '0' && ch <= '9' }
+func isIdentStart(ch byte) bool {
+	return ch == '_' || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z')
+}
+
+// 语法树节点
+type node interface{}
+
+type numberNode struct{ value int64 }
+
+type boolNode struct{ value bool }
+
+type varNode struct{ name string }
+
+type unaryNode struct {
+	op      string
+	operand node
+}
+
+type binaryNode struct {
+	op          string
+	left, right node
+}
+
+type condNode struct {
+	cond, then, otherwise node
+}
+
+type callNode struct {
+	name string
+	args []node
+}
+
+// binaryPrecedence 二元运算符优先级，数字越大优先级越高
+var binaryPrecedence = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"==": 3, "!=": 3,
+	"<": 4, "<=": 4, ">": 4, ">=": 4,
+	"+": 5, "-": 5,
+	"*": 6, "/": 6, "%": 6,
+}
+
+// parser 递归下降语法分析器
+type parser struct {
+	lex   *lexer
+	tok   token
+	nodes int
+	depth int
+}
+
+func (p *parser) advance() error {
+	tok, err := p.lex.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf(format+" at position %d", append(args, p.tok.pos)...)
+}
+
+func (p *parser) newNode(n node) node {
+	p.nodes++
+	return n
+}
+
+// parseExpr 解析表达式：二元运算 [? expr : expr]
+func (p *parser) parseExpr(minPrec int) (node, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > MaxDepth {
+		return nil, fmt.Errorf("expression is nested too deeply (more than %d levels)", MaxDepth)
+	}
+
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.tok.kind == tokOp {
+		op := p.tok.text
+		prec, ok := binaryPrecedence[op]
+		if !ok || prec <= minPrec {
+			break
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseExpr(prec)
+		if err != nil {
+			return nil, err
+		}
+		left = p.newNode(&binaryNode{op: op, left: left, right: right})
+	}
+
+	if minPrec == 0 && p.tok.kind == tokQuestion {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		then, err := p.parseExpr(0)
+		if err != nil {
+			return nil, err
+		}
+		if p.tok.kind != tokColon {
+			return nil, p.errorf("expected ':'")
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		otherwise, err := p.parseExpr(0)
+		if err != nil {
+			return nil, err
+		}
+		left = p.newNode(&condNode{cond: left, then: then, otherwise: otherwise})
+	}
+
+	return left, nil
+}
+
+// parseUnary 解析一元运算
+func (p *parser) parseUnary() (node, error) {
+	if p.tok.kind == tokOp && (p.tok.text == "-" || p.tok.text == "!") {
+		op := p.tok.text
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		p.depth++
+		defer func() { p.depth-- }()
+		if p.depth > MaxDepth {
+			return nil, fmt.Errorf("expression is nested too deeply (more than %d levels)", MaxDepth)
+		}
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return p.newNode(&unaryNode{op: op, operand: operand}), nil
+	}
+	return p.parsePrimary()
+}
+
+// parsePrimary 解析字面量、变量、函数调用和括号
+func (p *parser) parsePrimary() (node, error) {
+	tok := p.tok
+	switch tok.kind {
+	case tokNumber:
+		value, err := strconv.ParseInt(tok.text, 10, 64)
+		if err != nil || value > maxIntermediate {
+			return nil, p.errorf("number %s is too large", tok.text)
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		return p.newNode(&numberNode{value: value}), nil
+
+	case tokIdent:
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		switch tok.text {
+		case "true":
+			return p.newNode(&boolNode{value: true}), nil
+		case "false":
+			return p.newNode(&boolNode{value: false}), nil
+		}
+		if p.tok.kind != tokLParen {
+			return p.newNode(&varNode{name: tok.text}), nil
+		}
+
+		// 函数调用
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		var args []node
+		for p.tok.kind != tokRParen {
+			arg, err := p.parseExpr(0)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if p.tok.kind == tokComma {
+				if err := p.advance(); err != nil {
+					return nil, err
+				}
+				if p.tok.kind == tokRParen {
+					return nil, p.errorf("unexpected ')'")
+				}
+				continue
+			}
+			if p.tok.kind != tokRParen {
+				return nil, p.errorf("expected ',' or ')'")
+			}
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		return p.newNode(&callNode{name: tok.text, args: args}), nil
+
+	case tokLParen:
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		inner, err := p.parseExpr(0)
+		if err != nil {
+			return nil, err
+		}
+		if p.tok.kind != tokRParen {
+			return nil, p.errorf("expected ')'")
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		return inner, nil
+
+	case tokEOF:
+		return nil, p.errorf("unexpected end of expression")
+	}
+
+	return nil, p.errorf("unexpected %q", tok.text)
+}
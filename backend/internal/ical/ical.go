@@ -0,0 +1,118 @@
+// Package ical 生成 iCalendar（RFC 5545）格式的日历订阅内容
+package ical
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// 时间格式
+const (
+	timeLayout      = "20060102T150405Z" // UTC 时间
+	localTimeLayout = "20060102T150405"  // 带 TZID 的当地时间
+)
+
+// maxLineOctets 每行最多字节数，超出部分需要折行
+const maxLineOctets = 75
+
+// Event 日历中的一个条目
+type Event struct {
+	UID         string
+	Start       time.Time
+	Duration    time.Duration // 为 0 时表示时间点
+	Summary     string
+	Description string
+	Created     time.Time
+	// RRule 重复规则（如 "FREQ=WEEKLY;BYDAY=MO"），为空表示不重复
+	RRule string
+	// Location 开始和结束时间所在的时区，重复的条目按当地时间重复，夏令时切换前后仍在同一时刻；
+	// 为空或 UTC 时按 UTC 输出。TZID 使用 IANA 时区名称，主流日历客户端无需 VTIMEZONE 即可识别
+	Location *time.Location
+}
+
+// Calendar 日历
+type Calendar struct {
+	Name   string
+	Events []Event
+}
+
+// Write 将日历写入 w
+func (cal *Calendar) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	now := time.Now().UTC().Format(timeLayout)
+
+	writeLine(bw, "BEGIN:VCALENDAR")
+	writeLine(bw, "VERSION:2.0")
+	writeLine(bw, "PRODID:-//booonus//calendar//ZH")
+	writeLine(bw, "CALSCALE:GREGORIAN")
+	writeLine(bw, "METHOD:PUBLISH")
+	if cal.Name != "" {
+		writeLine(bw, "X-WR-CALNAME:"+escapeText(cal.Name))
+	}
+
+	for _, e := range cal.Events {
+		writeLine(bw, "BEGIN:VEVENT")
+		writeLine(bw, "UID:"+e.UID)
+		writeLine(bw, "DTSTAMP:"+now)
+		writeLine(bw, "DTSTART"+formatTime(e.Start, e.Location))
+		if e.Duration > 0 {
+			writeLine(bw, "DTEND"+formatTime(e.Start.Add(e.Duration), e.Location))
+		}
+		if e.RRule != "" {
+			writeLine(bw, "RRULE:"+e.RRule)
+		}
+		if !e.Created.IsZero() {
+			writeLine(bw, "CREATED:"+e.Created.UTC().Format(timeLayout))
+		}
+		writeLine(bw, "SUMMARY:"+escapeText(e.Summary))
+		if e.Description != "" {
+			writeLine(bw, "DESCRIPTION:"+escapeText(e.Description))
+		}
+		writeLine(bw, "END:VEVENT")
+	}
+
+	writeLine(bw, "END:VCALENDAR")
+	return bw.Flush()
+}
+
+// formatTime 输出时间属性的参数和值，如 ":20261019T010000Z" 或 ";TZID=Asia/Shanghai:20261019T090000"
+func formatTime(t time.Time, loc *time.Location) string {
+	if loc == nil || loc == time.UTC {
+		return ":" + t.UTC().Format(timeLayout)
+	}
+	return ";TZID=" + loc.String() + ":" + t.In(loc).Format(localTimeLayout)
+}
+
+// escapeText 按 RFC 5545 转义文本值
+func escapeText(s string) string {
+	r := strings.NewReplacer(
+		`\`, `\\`,
+		";", `\;`,
+		",", `\,`,
+		"\r\n", `\n`,
+		"\n", `\n`,
+		"\r", `\n`,
+	)
+	return r.Replace(s)
+}
+
+// writeLine 写入一行内容，超长时按字节折行且不拆开多字节字符
+func writeLine(w *bufio.Writer, line string) {
+	limit := maxLineOctets
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		w.WriteString(line[:cut])
+		w.WriteString("\r\n ")
+		line = line[cut:]
+		// 续行开头的空格占一个字节
+		limit = maxLineOctets - 1
+	}
+	w.WriteString(line)
+	w.WriteString("\r\n")
+}
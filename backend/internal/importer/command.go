@@ -0,0 +1,111 @@
+package importer
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"booonus-backend/internal/database"
+)
+
+// RunCommand 命令行导入：main import -user alice -kind history -file points.csv [-map date=日期,points=分数] [-tz Asia/Shanghai] [-dry-run]
+// 报告以 JSON 输出到标准输出，有错误行时返回 error
+func RunCommand(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	username := fs.String("user", "", "username to import for")
+	kind := fs.String("kind", "", "rules, shop_items or history")
+	file := fs.String("file", "", "path to the CSV or JSON file")
+	format := fs.String("format", "", "csv or json (default: from file extension)")
+	mapping := fs.String("map", "", "field=column pairs separated by commas")
+	tz := fs.String("tz", "UTC", "time zone for dates without an offset")
+	dryRun := fs.Bool("dry-run", false, "validate and report without writing")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *username == "" || *kind == "" || *file == "" {
+		fs.Usage()
+		return errors.New("-user, -kind and -file are required")
+	}
+
+	var userID int
+	if err := database.DB.QueryRow("SELECT id FROM users WHERE username = ?", *username).Scan(&userID); err != nil {
+		return fmt.Errorf("user %q not found", *username)
+	}
+
+	loc, err := time.LoadLocation(*tz)
+	if err != nil {
+		return fmt.Errorf("invalid time zone %q", *tz)
+	}
+
+	fields, err := ParseMapping(*mapping)
+	if err != nil {
+		return err
+	}
+	if err := ValidateMapping(*kind, fields); err != nil {
+		return err
+	}
+
+	if *format == "" {
+		*format = FormatFromFileName(*file)
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	report, err := Run(Options{
+		UserID:   userID,
+		Kind:     *kind,
+		Format:   *format,
+		Mapping:  fields,
+		Location: loc,
+		DryRun:   *dryRun,
+		Source:   filepath.Base(*file),
+	}, f)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return err
+	}
+
+	if report.Errors > 0 {
+		return fmt.Errorf("%d rows have errors, nothing was imported", report.Errors)
+	}
+	return nil
+}
+
+// ParseMapping 解析 field=column,field=column 形式的映射
+func ParseMapping(s string) (map[string]string, error) {
+	mapping := map[string]string{}
+	if strings.TrimSpace(s) == "" {
+		return mapping, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("invalid mapping %q, expected field=column", pair)
+		}
+		mapping[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return mapping, nil
+}
+
+// FormatFromFileName 根据扩展名判断文件格式，无法判断时按 CSV 处理
+func FormatFromFileName(name string) string {
+	if strings.EqualFold(filepath.Ext(name), ".json") {
+		return "json"
+	}
+	return "csv"
+}
@@ -0,0 +1,312 @@
+// Package importer 从 CSV/JSON 导入规则、商品和历史积分记录
+//
+// 导入分两步：先逐行校验并检测重复，生成报告；没有错误且不是试运行时，
+// 在一个事务中写入所有非重复的行，任何一行失败则全部回滚。
+package importer
+
+import (
+	"database/sql"
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	"booonus-backend/internal/database"
+	"booonus-backend/internal/ledger"
+)
+
+// 支持导入的数据类型
+const (
+	KindRules     = "rules"
+	KindShopItems = "shop_items"
+	KindHistory   = "history"
+)
+
+// 每行的处理结果
+const (
+	StatusOK        = "ok"
+	StatusDuplicate = "duplicate"
+	StatusError     = "error"
+)
+
+// MaxRows 单次导入的最大行数
+const MaxRows = 10000
+
+var (
+	ErrInvalidKind   = errors.New("invalid import kind, expected rules, shop_items or history")
+	ErrInvalidFormat = errors.New("invalid import format, expected csv or json")
+	ErrTooManyRows   = errors.New("too many rows in import file")
+	ErrNoCouple      = errors.New("importing rules requires a couple")
+	ErrEmptyFile     = errors.New("import file has no rows")
+)
+
+// kindFields 每种数据可以映射的字段
+var kindFields = map[string][]string{
+	KindRules:     {"name", "description", "points", "target_type", "target_user"},
+	KindShopItems: {"name", "description", "price", "stock"},
+	KindHistory:   {"date", "points", "description", "user", "type"},
+}
+
+// Options 导入参数
+type Options struct {
+	UserID int
+	Kind   string
+	Format string // csv 或 json
+	// Mapping 字段名 -> 文件中的列名，未指定的字段使用同名的列
+	Mapping map[string]string
+	// Location 解析历史记录中不带时区的日期时使用的时区
+	Location *time.Location
+	DryRun   bool
+	Source   string // 来源文件名，记录在导入批次中
+}
+
+// RowResult 一行的处理结果
+type RowResult struct {
+	Row     int               `json:"row"` // CSV 为文件中的行号，JSON 为数组中的序号（从 1 开始）
+	Status  string            `json:"status"`
+	Message string            `json:"message,omitempty"`
+	Values  map[string]string `json:"values"`
+}
+
+// Report 导入报告
+type Report struct {
+	ImportID   *int        `json:"import_id"`
+	Kind       string      `json:"kind"`
+	DryRun     bool        `json:"dry_run"`
+	Applied    bool        `json:"applied"`
+	Total      int         `json:"total"`
+	Importable int         `json:"importable"`
+	Duplicates int         `json:"duplicates"`
+	Errors     int         `json:"errors"`
+	Rows       []RowResult `json:"rows"`
+}
+
+// member 家庭中的一个成员
+type member struct {
+	ID       int
+	Username string
+}
+
+// importContext 校验和写入时需要的用户信息
+type importContext struct {
+	opts      Options
+	members   []member // 导入用户排在最前
+	coupleID  int
+	founders  [2]int          // 创建家庭的两人，用于转换旧格式的 user1/user2
+	seen      map[string]bool // 已存在或文件中已出现的记录
+	earliest  map[int]time.Time
+	pointsSum map[int]int
+}
+
+// applyFunc 在事务中写入一行
+type applyFunc func(tx *sql.Tx, importID int) error
+
+// Run 按参数导入数据，返回导入报告
+// 文件格式错误等无法生成报告的情况返回 error；行内的错误记录在报告中
+func Run(opts Options, r io.Reader) (*Report, error) {
+	fields, ok := kindFields[opts.Kind]
+	if !ok {
+		return nil, ErrInvalidKind
+	}
+	if opts.Location == nil {
+		opts.Location = time.UTC
+	}
+
+	records, err := readRecords(r, opts.Format)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, ErrEmptyFile
+	}
+	if len(records) > MaxRows {
+		return nil, ErrTooManyRows
+	}
+
+	ctx, err := loadContext(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{Kind: opts.Kind, DryRun: opts.DryRun, Total: len(records), Rows: []RowResult{}}
+	var pending []applyFunc
+
+	for _, record := range records {
+		values := mapRecord(record.values, fields, opts.Mapping)
+		result := RowResult{Row: record.row, Status: StatusOK, Values: values}
+
+		var apply applyFunc
+		var key string
+		switch opts.Kind {
+		case KindRules:
+			apply, key, err = ctx.prepareRule(values)
+		case KindShopItems:
+			apply, key, err = ctx.prepareShopItem(values)
+		case KindHistory:
+			apply, key, err = ctx.prepareHistory(values)
+		}
+
+		switch {
+		case err != nil:
+			result.Status = StatusError
+			result.Message = err.Error()
+			report.Errors++
+		case ctx.seen[key]:
+			result.Status = StatusDuplicate
+			report.Duplicates++
+		default:
+			ctx.seen[key] = true
+			pending = append(pending, apply)
+			report.Importable++
+		}
+		report.Rows = append(report.Rows, result)
+	}
+
+	if opts.DryRun || report.Errors > 0 || len(pending) == 0 {
+		return report, nil
+	}
+
+	importID, err := ctx.apply(report, pending)
+	if err != nil {
+		return nil, err
+	}
+	report.ImportID = &importID
+	report.Applied = true
+	return report, nil
+}
+
+// apply 在一个事务中写入所有待导入的行
+func (ctx *importContext) apply(report *Report, pending []applyFunc) (int, error) {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var source interface{}
+	if ctx.opts.Source != "" {
+		source = ctx.opts.Source
+	}
+	result, err := tx.Exec(
+		"INSERT INTO imports (user_id, kind, source, total_rows, imported_rows, skipped_rows) VALUES (?, ?, ?, ?, ?, ?)",
+		ctx.opts.UserID, ctx.opts.Kind, source, report.Total, report.Importable, report.Duplicates,
+	)
+	if err != nil {
+		return 0, err
+	}
+	id, _ := result.LastInsertId()
+	importID := int(id)
+
+	for _, apply := range pending {
+		if err := apply(tx, importID); err != nil {
+			return 0, err
+		}
+	}
+
+	// 历史记录同时更新当前积分
+	for userID, points := range ctx.pointsSum {
+		if _, err := tx.Exec("UPDATE users SET points = points + ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", points, userID); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	// 补录的历史早于已有的余额快照，需要重新生成
+	for userID, earliest := range ctx.earliest {
+		if err := ledger.InvalidateSnapshots(userID, earliest); err != nil {
+			return importID, err
+		}
+	}
+
+	return importID, nil
+}
+
+// loadContext 加载用户、情侣和用于检测重复的已有数据
+func loadContext(opts Options) (*importContext, error) {
+	ctx := &importContext{
+		opts:      opts,
+		seen:      map[string]bool{},
+		earliest:  map[int]time.Time{},
+		pointsSum: map[int]int{},
+	}
+
+	var username string
+	if err := database.DB.QueryRow("SELECT username FROM users WHERE id = ?", opts.UserID).Scan(&username); err != nil {
+		return nil, err
+	}
+	ctx.members = []member{{ID: opts.UserID, Username: username}}
+
+	err := database.DB.QueryRow(`
+		SELECT c.id, c.user1_id, c.user2_id FROM couples c
+		JOIN users u ON u.couple_id = c.id
+		WHERE u.id = ? AND c.status = 'active'`,
+		opts.UserID,
+	).Scan(&ctx.coupleID, &ctx.founders[0], &ctx.founders[1])
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if err == nil {
+		if err := ctx.loadMembers(); err != nil {
+			return nil, err
+		}
+	}
+
+	var query string
+	var args []interface{}
+	switch opts.Kind {
+	case KindRules:
+		if ctx.coupleID == 0 {
+			return nil, ErrNoCouple
+		}
+		query, args = "SELECT name FROM rules WHERE couple_id = ? AND is_active = TRUE", []interface{}{ctx.coupleID}
+	case KindShopItems:
+		query, args = "SELECT name FROM shop_items WHERE user_id = ? AND is_active = TRUE", []interface{}{opts.UserID}
+	default:
+		return ctx, nil
+	}
+
+	rows, err := database.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		ctx.seen[nameKey(name)] = true
+	}
+	return ctx, rows.Err()
+}
+
+// loadMembers 加载家庭的其他现有成员
+func (ctx *importContext) loadMembers() error {
+	rows, err := database.DB.Query(`
+		SELECT u.id, u.username FROM household_members m
+		JOIN users u ON m.user_id = u.id
+		WHERE m.couple_id = ? AND m.left_at IS NULL AND u.id != ?
+		ORDER BY m.joined_at, m.id`,
+		ctx.coupleID, ctx.opts.UserID,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var m member
+		if err := rows.Scan(&m.ID, &m.Username); err != nil {
+			return err
+		}
+		ctx.members = append(ctx.members, m)
+	}
+	return rows.Err()
+}
+
+// nameKey 按名称判断重复时使用的键，忽略大小写和首尾空白
+func nameKey(name string) string {
+	return "name:" + strings.ToLower(strings.TrimSpace(name))
+}
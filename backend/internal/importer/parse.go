@@ -0,0 +1,161 @@
+package importer
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ParseError 文件内容无法解析
+type ParseError struct {
+	Message string
+}
+
+func (e *ParseError) Error() string {
+	return e.Message
+}
+
+// record 文件中的一行原始数据
+type record struct {
+	row    int
+	values map[string]string
+}
+
+// readRecords 读取 CSV（第一行为列名）或 JSON（对象数组）
+func readRecords(r io.Reader, format string) ([]record, error) {
+	switch format {
+	case "csv":
+		return readCSV(r)
+	case "json":
+		return readJSON(r)
+	}
+	return nil, ErrInvalidFormat
+}
+
+func readCSV(r io.Reader) ([]record, error) {
+	br := bufio.NewReader(r)
+	// 表格软件导出的 CSV 常带 UTF-8 BOM
+	if bom, err := br.Peek(3); err == nil && string(bom) == "\xef\xbb\xbf" {
+		br.Discard(3)
+	}
+
+	cr := csv.NewReader(br)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, ErrEmptyFile
+	}
+	if err != nil {
+		return nil, &ParseError{Message: "invalid CSV: " + err.Error()}
+	}
+	for i := range header {
+		header[i] = strings.TrimSpace(header[i])
+	}
+
+	var records []record
+	for {
+		fields, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, &ParseError{Message: "invalid CSV: " + err.Error()}
+		}
+		if len(records) >= MaxRows {
+			return nil, ErrTooManyRows
+		}
+
+		line, _ := cr.FieldPos(0)
+		values := make(map[string]string, len(header))
+		empty := true
+		for i, column := range header {
+			if i < len(fields) {
+				values[column] = fields[i]
+				if strings.TrimSpace(fields[i]) != "" {
+					empty = false
+				}
+			}
+		}
+		// 跳过空行
+		if empty {
+			continue
+		}
+		records = append(records, record{row: line, values: values})
+	}
+	return records, nil
+}
+
+func readJSON(r io.Reader) ([]record, error) {
+	var items []map[string]interface{}
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	if err := decoder.Decode(&items); err != nil {
+		return nil, &ParseError{Message: "invalid JSON: expected an array of objects"}
+	}
+	if len(items) > MaxRows {
+		return nil, ErrTooManyRows
+	}
+
+	records := make([]record, 0, len(items))
+	for i, item := range items {
+		values := make(map[string]string, len(item))
+		for key, v := range item {
+			switch value := v.(type) {
+			case nil:
+			case string:
+				values[key] = value
+			case json.Number:
+				values[key] = value.String()
+			case bool:
+				values[key] = strconv.FormatBool(value)
+			default:
+				data, _ := json.Marshal(value)
+				values[key] = string(data)
+			}
+		}
+		records = append(records, record{row: i + 1, values: values})
+	}
+	return records, nil
+}
+
+// mapRecord 按列映射取出各字段的值
+func mapRecord(values map[string]string, fields []string, mapping map[string]string) map[string]string {
+	mapped := make(map[string]string, len(fields))
+	for _, field := range fields {
+		column := field
+		if source, ok := mapping[field]; ok && source != "" {
+			column = source
+		}
+		if v := strings.TrimSpace(values[column]); v != "" {
+			mapped[field] = v
+		}
+	}
+	return mapped
+}
+
+// ValidateMapping 检查映射中的字段名
+func ValidateMapping(kind string, mapping map[string]string) error {
+	fields, ok := kindFields[kind]
+	if !ok {
+		return ErrInvalidKind
+	}
+	for field := range mapping {
+		known := false
+		for _, f := range fields {
+			if f == field {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("unknown field %q in mapping, expected one of %s", field, strings.Join(fields, ", "))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,262 @@
+package importer
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"booonus-backend/internal/database"
+)
+
+// historyDateLayouts 历史记录日期支持的格式（RFC3339 之外），按 Options.Location 解析
+var historyDateLayouts = []string{database.TimeLayout, "2006-01-02 15:04", "2006-01-02T15:04:05", "2006-01-02"}
+
+// prepareRule 校验一条规则
+func (ctx *importContext) prepareRule(values map[string]string) (applyFunc, string, error) {
+	name := values["name"]
+	if name == "" {
+		return nil, "", errors.New("name is required")
+	}
+	points, err := parseInt(values, "points", true)
+	if err != nil {
+		return nil, "", err
+	}
+	if points == 0 {
+		return nil, "", errors.New("points must not be zero")
+	}
+
+	targetType, targetUserID, err := ctx.ruleTarget(values["target_type"], values["target_user"])
+	if err != nil {
+		return nil, "", err
+	}
+	description := values["description"]
+
+	apply := func(tx *sql.Tx, importID int) error {
+		result, err := tx.Exec(
+			"INSERT INTO rules (couple_id, name, description, points, target_type, target_user_id, import_id) VALUES (?, ?, ?, ?, ?, ?, ?)",
+			ctx.coupleID, name, description, points, targetType, targetUserID, importID,
+		)
+		if err != nil {
+			return err
+		}
+		ruleID, _ := result.LastInsertId()
+
+		// 与手动创建的规则一样记录初始版本
+		_, err = tx.Exec(`
+			INSERT INTO rule_revisions
+				(rule_id, revision, name, description, points, target_type, target_user_id, is_active, points_expression, parameters, change_type, changed_by)
+			SELECT id, 1, name, description, points, target_type, target_user_id, is_active, points_expression, parameters, 'create', ?
+			FROM rules WHERE id = ?`,
+			ctx.opts.UserID, ruleID,
+		)
+		return err
+	}
+	return apply, nameKey(name), nil
+}
+
+// prepareShopItem 校验一个商品，商品属于导入的用户
+func (ctx *importContext) prepareShopItem(values map[string]string) (applyFunc, string, error) {
+	name := values["name"]
+	if name == "" {
+		return nil, "", errors.New("name is required")
+	}
+	price, err := parseInt(values, "price", true)
+	if err != nil {
+		return nil, "", err
+	}
+	if price < 1 {
+		return nil, "", errors.New("price must be at least 1")
+	}
+
+	var stock interface{}
+	if values["stock"] != "" {
+		n, err := parseInt(values, "stock", false)
+		if err != nil {
+			return nil, "", err
+		}
+		if n < 0 {
+			return nil, "", errors.New("stock must not be negative")
+		}
+		stock = n
+	}
+	description := values["description"]
+
+	apply := func(tx *sql.Tx, importID int) error {
+		_, err := tx.Exec(
+			"INSERT INTO shop_items (user_id, name, description, price, stock, import_id) VALUES (?, ?, ?, ?, ?, ?)",
+			ctx.opts.UserID, name, description, price, stock, importID,
+		)
+		return err
+	}
+	return apply, nameKey(name), nil
+}
+
+// prepareHistory 校验一条历史积分记录；导入的记录不可撤销
+// 同一用户、同一时间、积分和描述都相同的记录视为重复
+func (ctx *importContext) prepareHistory(values map[string]string) (applyFunc, string, error) {
+	if values["date"] == "" {
+		return nil, "", errors.New("date is required")
+	}
+	createdAt, err := ctx.parseDate(values["date"])
+	if err != nil {
+		return nil, "", err
+	}
+	if createdAt.After(time.Now()) {
+		return nil, "", errors.New("date must not be in the future")
+	}
+
+	points, err := parseInt(values, "points", true)
+	if err != nil {
+		return nil, "", err
+	}
+	if points == 0 {
+		return nil, "", errors.New("points must not be zero")
+	}
+
+	description := values["description"]
+	if description == "" {
+		return nil, "", errors.New("description is required")
+	}
+
+	userID := ctx.opts.UserID
+	if username := values["user"]; username != "" {
+		if userID, err = ctx.memberID(username); err != nil {
+			return nil, "", err
+		}
+	}
+
+	historyType := values["type"]
+	switch historyType {
+	case "":
+		historyType = "event"
+	case "rule", "event", "transaction":
+	default:
+		return nil, "", errors.New("type must be rule, event or transaction")
+	}
+
+	at := createdAt.UTC().Format(database.TimeLayout)
+	key := "history:" + strconv.Itoa(userID) + "|" + strconv.Itoa(points) + "|" + description + "|" + at
+
+	var exists int
+	err = database.DB.QueryRow(
+		"SELECT COUNT(*) FROM points_history WHERE user_id = ? AND points = ? AND description = ? AND created_at = ?",
+		userID, points, description, at,
+	).Scan(&exists)
+	if err != nil {
+		return nil, "", err
+	}
+	if exists > 0 {
+		ctx.seen[key] = true
+	}
+
+	apply := func(tx *sql.Tx, importID int) error {
+		_, err := tx.Exec(
+			"INSERT INTO points_history (user_id, points, type, description, can_revert, created_at, import_id) VALUES (?, ?, ?, ?, FALSE, ?, ?)",
+			userID, points, historyType, description, at, importID,
+		)
+		if err != nil {
+			return err
+		}
+		ctx.pointsSum[userID] += points
+		if earliest, ok := ctx.earliest[userID]; !ok || createdAt.Before(earliest) {
+			ctx.earliest[userID] = createdAt
+		}
+		return nil
+	}
+	return apply, key, nil
+}
+
+// parseDate 解析日期，不带时区的按 Options.Location 解析
+func (ctx *importContext) parseDate(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range historyDateLayouts {
+		if t, err := time.ParseInLocation(layout, s, ctx.opts.Location); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q, expected YYYY-MM-DD, YYYY-MM-DD HH:MM[:SS] or RFC3339", s)
+}
+
+// parseInt 解析整数字段
+func parseInt(values map[string]string, field string, required bool) (int, error) {
+	s, ok := values[field]
+	if !ok {
+		if required {
+			return 0, fmt.Errorf("%s is required", field)
+		}
+		return 0, nil
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(s, "+"))
+	if err != nil {
+		return 0, fmt.Errorf("%s must be an integer", field)
+	}
+	return n, nil
+}
+
+// ruleTarget 将规则的目标转换为数据库格式
+// 与接口一致接受 current_user/partner/both/member/any/all，member 需要 target_user 指定成员用户名；
+// 也接受旧格式的 user1/user2，表示创建家庭的两人
+func (ctx *importContext) ruleTarget(targetType, targetUser string) (string, interface{}, error) {
+	if targetUser != "" && targetType != "member" {
+		return "", nil, errors.New("target_user is only allowed for member target_type")
+	}
+
+	var userID int
+	switch targetType {
+	case "", "both", "any":
+		return "any", nil, nil
+	case "all":
+		return "all", nil, nil
+	case "current_user":
+		userID = ctx.opts.UserID
+	case "partner":
+		if len(ctx.members) != 2 {
+			return "", nil, errors.New("partner target_type requires a two-member household, use member with target_user")
+		}
+		userID = ctx.members[1].ID
+	case "user1", "user2":
+		userID = ctx.founders[0]
+		if targetType == "user2" {
+			userID = ctx.founders[1]
+		}
+		if !ctx.isMember(userID) {
+			return "", nil, fmt.Errorf("%s is no longer a member of the household", targetType)
+		}
+	case "member":
+		if targetUser == "" {
+			return "", nil, errors.New("target_user is required for member target_type")
+		}
+		var err error
+		if userID, err = ctx.memberID(targetUser); err != nil {
+			return "", nil, err
+		}
+	default:
+		return "", nil, errors.New("target_type must be current_user, partner, both, member, any or all")
+	}
+	return "member", userID, nil
+}
+
+// memberID 按用户名查找家庭成员
+func (ctx *importContext) memberID(username string) (int, error) {
+	for _, m := range ctx.members {
+		if strings.EqualFold(m.Username, username) {
+			return m.ID, nil
+		}
+	}
+	return 0, fmt.Errorf("user %q is not a member of the household", username)
+}
+
+// isMember 检查用户是否是家庭的现有成员
+func (ctx *importContext) isMember(userID int) bool {
+	for _, m := range ctx.members {
+		if m.ID == userID {
+			return true
+		}
+	}
+	return false
+}
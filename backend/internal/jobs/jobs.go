@@ -0,0 +1,31 @@
+// Package jobs 运行进程内的定时任务
+package jobs
+
+import (
+	"fmt"
+	"time"
+
+	"booonus-backend/pkg/logger"
+)
+
+// Every 启动时先执行一次任务，之后每隔 interval 执行一次
+func Every(name string, interval time.Duration, fn func()) {
+	go func() {
+		run(name, fn)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			run(name, fn)
+		}
+	}()
+}
+
+// run 执行一次任务，任务 panic 不影响后续执行
+func run(name string, fn func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error("Job " + name + " panicked: " + fmt.Sprint(r))
+		}
+	}()
+	fn()
+}
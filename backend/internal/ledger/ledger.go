@@ -0,0 +1,202 @@
+// Package ledger 根据积分历史重建任意时刻的积分余额
+//
+// 某条记录在时刻 t 之前是否计入余额，取决于 t 之前最后一次撤销操作：
+// 最后一次是撤销则不计入，是取消撤销或没有撤销操作则计入。
+// 因此之后发生的撤销不会改变过去时刻的余额，已生成的快照始终有效。
+package ledger
+
+import (
+	"database/sql"
+	"time"
+
+	"booonus-backend/internal/database"
+	"booonus-backend/pkg/logger"
+)
+
+// activeBefore 记录 ph 在某时刻之前是否有效（1 或 0），需要一个时间参数
+// 早期的撤销没有操作记录，这类记录按当前的撤销状态计算
+const activeBefore = `COALESCE(
+	(SELECT ra.action = 'cancel_revert' FROM revert_actions ra
+	 WHERE ra.history_id = ph.id AND ra.created_at < ?
+	 ORDER BY ra.created_at DESC, ra.id DESC LIMIT 1),
+	(SELECT 1 FROM revert_actions ra WHERE ra.history_id = ph.id LIMIT 1),
+	ph.is_reverted = FALSE)`
+
+// Statement 一段时间内的积分对账单
+type Statement struct {
+	UserID         int            `json:"user_id"`
+	From           time.Time      `json:"from"`
+	To             time.Time      `json:"to"`
+	OpeningBalance int            `json:"opening_balance"`
+	ClosingBalance int            `json:"closing_balance"`
+	Earned         int            `json:"earned"`
+	Spent          int            `json:"spent"`
+	Adjustments    int            `json:"adjustments"` // 期间内对更早记录的撤销/取消撤销
+	ByType         map[string]int `json:"by_type"`
+	Entries        int            `json:"entries"`
+	RevertedInside int            `json:"reverted_entries"` // 期间内产生且在期末已撤销的记录数
+}
+
+func dbTime(t time.Time) string {
+	return t.UTC().Format(database.TimeLayout)
+}
+
+// BalanceBefore 计算用户在时刻 t 之前所有积分变化累计的余额
+func BalanceBefore(userID int, t time.Time) (int, error) {
+	at := dbTime(t)
+
+	var snapshotAt string
+	var balance int
+	err := database.DB.QueryRow(
+		"SELECT snapshot_at, balance FROM balance_snapshots WHERE user_id = ? AND snapshot_at <= ? ORDER BY snapshot_at DESC LIMIT 1",
+		userID, at,
+	).Scan(&snapshotAt, &balance)
+	if err == sql.ErrNoRows {
+		err = database.DB.QueryRow(`
+			SELECT COALESCE(SUM(ph.points * `+activeBefore+`), 0)
+			FROM points_history ph
+			WHERE ph.user_id = ? AND ph.created_at < ?`,
+			at, userID, at,
+		).Scan(&balance)
+		return balance, err
+	}
+	if err != nil {
+		return 0, err
+	}
+	snapshot := dbTime(parseTime(snapshotAt))
+
+	// 快照之后产生的记录
+	var added int
+	err = database.DB.QueryRow(`
+		SELECT COALESCE(SUM(ph.points * `+activeBefore+`), 0)
+		FROM points_history ph
+		WHERE ph.user_id = ? AND ph.created_at >= ? AND ph.created_at < ?`,
+		at, userID, snapshot, at,
+	).Scan(&added)
+	if err != nil {
+		return 0, err
+	}
+
+	// 快照之前的记录在快照之后被撤销或取消撤销
+	var changed int
+	err = database.DB.QueryRow(`
+		SELECT COALESCE(SUM(ph.points * (`+activeBefore+` - `+activeBefore+`)), 0)
+		FROM points_history ph
+		WHERE ph.user_id = ? AND ph.created_at < ?
+		  AND ph.id IN (SELECT history_id FROM revert_actions WHERE created_at >= ? AND created_at < ?)`,
+		at, snapshot, userID, snapshot, snapshot, at,
+	).Scan(&changed)
+	if err != nil {
+		return 0, err
+	}
+
+	return balance + added + changed, nil
+}
+
+// StatementFor 生成用户在 [from, to) 期间的对账单
+func StatementFor(userID int, from, to time.Time) (Statement, error) {
+	s := Statement{UserID: userID, From: from, To: to, ByType: map[string]int{"rule": 0, "event": 0, "transaction": 0}}
+
+	var err error
+	if s.OpeningBalance, err = BalanceBefore(userID, from); err != nil {
+		return s, err
+	}
+	if s.ClosingBalance, err = BalanceBefore(userID, to); err != nil {
+		return s, err
+	}
+
+	// 期间内产生的记录，按期末的撤销状态计算
+	rows, err := database.DB.Query(`
+		SELECT ph.type, ph.points, `+activeBefore+`
+		FROM points_history ph
+		WHERE ph.user_id = ? AND ph.created_at >= ? AND ph.created_at < ?`,
+		dbTime(to), userID, dbTime(from), dbTime(to),
+	)
+	if err != nil {
+		return s, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var historyType string
+		var points int
+		var active bool
+		if err := rows.Scan(&historyType, &points, &active); err != nil {
+			return s, err
+		}
+		s.Entries++
+		if !active {
+			s.RevertedInside++
+			continue
+		}
+		if points > 0 {
+			s.Earned += points
+		} else {
+			s.Spent -= points
+		}
+		s.ByType[historyType] += points
+	}
+	if err := rows.Err(); err != nil {
+		return s, err
+	}
+
+	s.Adjustments = s.ClosingBalance - s.OpeningBalance - (s.Earned - s.Spent)
+	return s, nil
+}
+
+// TakeSnapshots 为所有用户生成最近一个 UTC 零点的余额快照，已存在的跳过
+func TakeSnapshots() {
+	// 留出一分钟余量，保证零点之前的记录都已写入
+	now := time.Now().UTC().Add(-time.Minute)
+	at := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	rows, err := database.DB.Query(
+		"SELECT id FROM users WHERE id NOT IN (SELECT user_id FROM balance_snapshots WHERE snapshot_at = ?)",
+		dbTime(at),
+	)
+	if err != nil {
+		logger.Error("Failed to get users for balance snapshots: " + err.Error())
+		return
+	}
+	var userIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err == nil {
+			userIDs = append(userIDs, id)
+		}
+	}
+	rows.Close()
+
+	for _, userID := range userIDs {
+		balance, err := BalanceBefore(userID, at)
+		if err != nil {
+			logger.Error("Failed to compute balance snapshot: " + err.Error())
+			continue
+		}
+		_, err = database.DB.Exec(
+			"INSERT OR IGNORE INTO balance_snapshots (user_id, snapshot_at, balance) VALUES (?, ?, ?)",
+			userID, dbTime(at), balance,
+		)
+		if err != nil {
+			logger.Error("Failed to save balance snapshot: " + err.Error())
+		}
+	}
+}
+
+// InvalidateSnapshots 删除某时刻及之后的快照，写入早于当前时间的记录后调用
+func InvalidateSnapshots(userID int, since time.Time) error {
+	_, err := database.DB.Exec(
+		"DELETE FROM balance_snapshots WHERE user_id = ? AND snapshot_at > ?",
+		userID, dbTime(since),
+	)
+	return err
+}
+
+// parseTime 解析数据库返回的时间，兼容驱动返回的 RFC3339 格式
+func parseTime(s string) time.Time {
+	if t, err := time.Parse(database.TimeLayout, s); err == nil {
+		return t
+	}
+	t, _ := time.Parse(time.RFC3339, s)
+	return t
+}
@@ -0,0 +1,72 @@
+// Package locale 界面语言：用户偏好中保存任意 BCP 47 语言标签，发送邮件和推送时匹配到支持的语言
+//
+// 目前支持简体中文（默认）和英文；没有设置或无法匹配时使用简体中文。
+package locale
+
+import (
+	"database/sql"
+
+	"booonus-backend/internal/database"
+
+	"golang.org/x/text/language"
+)
+
+// 支持的语言
+const (
+	Chinese = "zh-CN"
+	English = "en"
+	Default = Chinese
+)
+
+// supported 支持的语言，顺序与 matcher 的下标一致，第一个为默认语言
+var supported = []string{Chinese, English}
+
+var matcher = language.NewMatcher([]language.Tag{language.SimplifiedChinese, language.English})
+
+// Parse 检查语言标签，返回规范形式，如 "en-gb" 返回 "en-GB"
+func Parse(tag string) (string, bool) {
+	parsed, err := language.Parse(tag)
+	if err != nil || parsed == language.Und {
+		return "", false
+	}
+	return parsed.String(), true
+}
+
+// Match 把语言标签匹配到支持的语言，无法匹配时返回 Default
+func Match(tag string) string {
+	parsed, err := language.Parse(tag)
+	if err != nil {
+		return Default
+	}
+	return match(parsed)
+}
+
+// FromAcceptLanguage 按请求头 Accept-Language 匹配支持的语言，用于不知道用户的页面
+func FromAcceptLanguage(header string) string {
+	tags, _, err := language.ParseAcceptLanguage(header)
+	if err != nil {
+		return Default
+	}
+	return match(tags...)
+}
+
+// ForUser 用户偏好设置的语言匹配到的支持语言，用户不存在或查询失败时返回 Default
+func ForUser(userID int) string {
+	var tag sql.NullString
+	if err := database.DB.QueryRow("SELECT locale FROM users WHERE id = ?", userID).Scan(&tag); err != nil || !tag.Valid {
+		return Default
+	}
+	return Match(tag.String)
+}
+
+// match 按优先顺序匹配
+func match(tags ...language.Tag) string {
+	if len(tags) == 0 {
+		return Default
+	}
+	_, index, confidence := matcher.Match(tags...)
+	if confidence == language.No {
+		return Default
+	}
+	return supported[index]
+}
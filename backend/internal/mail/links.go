@@ -0,0 +1,76 @@
+package mail
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 链接令牌的错误
+var (
+	ErrInvalidLink = errors.New("invalid link")
+	ErrLinkExpired = errors.New("link has expired")
+)
+
+// linkSecret 链接签名密钥，优先使用 MAIL_LINK_SECRET，其次 JWT_SECRET
+var linkSecret = func() []byte {
+	for _, name := range []string{"MAIL_LINK_SECRET", "JWT_SECRET"} {
+		if secret := os.Getenv(name); secret != "" {
+			return []byte(secret)
+		}
+	}
+	return []byte("booonus-default-mail-secret-change-in-production")
+}()
+
+// SignLink 为邮件中的链接生成令牌，令牌包含用途、用户ID、附带的值和过期时间
+// expiresAt 为零值时不过期（如退订链接）
+func SignLink(purpose string, userID int, value string, expiresAt time.Time) string {
+	var expires int64
+	if !expiresAt.IsZero() {
+		expires = expiresAt.Unix()
+	}
+	payload := strings.Join([]string{purpose, strconv.Itoa(userID), strconv.FormatInt(expires, 10), value}, "|")
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(payload))
+	return encoded + "." + linkSignature(encoded)
+}
+
+// VerifyLink 验证令牌的签名、用途和过期时间，返回用户ID和附带的值
+func VerifyLink(token, purpose string) (int, string, error) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(linkSignature(encoded))) {
+		return 0, "", ErrInvalidLink
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return 0, "", ErrInvalidLink
+	}
+
+	fields := strings.SplitN(string(payload), "|", 4)
+	if len(fields) != 4 || fields[0] != purpose {
+		return 0, "", ErrInvalidLink
+	}
+	userID, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return 0, "", ErrInvalidLink
+	}
+	expires, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return 0, "", ErrInvalidLink
+	}
+	if expires != 0 && time.Now().Unix() > expires {
+		return 0, "", ErrLinkExpired
+	}
+	return userID, fields[3], nil
+}
+
+// linkSignature 计算令牌内容的签名
+func linkSignature(encoded string) string {
+	mac := hmac.New(sha256.New, linkSecret)
+	mac.Write([]byte(encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
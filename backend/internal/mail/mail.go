@@ -0,0 +1,240 @@
+// Package mail 通过 SMTP 发送邮件
+//
+// Init 按环境变量配置 SMTP，未配置 SMTP_HOST 时 Enabled 返回 false，调用方应跳过发信。
+// 邮件同时包含纯文本和 HTML 两部分，模板见 templates 目录；邮件中的链接用 SignLink 签名。
+package mail
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strings"
+	"time"
+)
+
+// 连接 SMTP 服务器的加密方式
+const (
+	TLSStartTLS = "starttls" // 明文连接后升级，默认方式
+	TLSImplicit = "tls"      // 直接建立 TLS 连接，通常为 465 端口
+	TLSNone     = "none"     // 不加密，只用于本地测试
+)
+
+// dialTimeout 连接 SMTP 服务器的超时时间
+const dialTimeout = 10 * time.Second
+
+// Message 一封邮件
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+	Headers map[string]string // 额外的邮件头，如 List-Unsubscribe
+}
+
+// Sender 发信接口
+type Sender interface {
+	Send(msg Message) error
+}
+
+// Mailer 全局发信实例，未配置时为 nil
+var Mailer Sender
+
+// Init 按环境变量配置 SMTP：SMTP_HOST、SMTP_PORT（默认 587）、SMTP_USERNAME、SMTP_PASSWORD、
+// SMTP_FROM（发件人，必填）、SMTP_TLS（starttls、tls 或 none，默认 starttls）
+func Init() error {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return nil
+	}
+
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+	mode := os.Getenv("SMTP_TLS")
+	if mode == "" {
+		mode = TLSStartTLS
+	}
+
+	sender, err := NewSMTPSender(host, port, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), os.Getenv("SMTP_FROM"), mode)
+	if err != nil {
+		return err
+	}
+	Mailer = sender
+	return nil
+}
+
+// Enabled 是否已配置发信
+func Enabled() bool {
+	return Mailer != nil
+}
+
+// Send 使用全局发信实例发送邮件
+func Send(msg Message) error {
+	if Mailer == nil {
+		return errors.New("mail is not configured")
+	}
+	return Mailer.Send(msg)
+}
+
+// ValidAddress 检查邮箱地址格式，只接受不带显示名的地址
+func ValidAddress(address string) bool {
+	parsed, err := mail.ParseAddress(address)
+	return err == nil && parsed.Address == address
+}
+
+// SMTPSender 通过 SMTP 服务器发信
+type SMTPSender struct {
+	host     string
+	port     string
+	username string
+	password string
+	from     *mail.Address
+	mode     string
+}
+
+// NewSMTPSender 创建 SMTP 发信实例
+func NewSMTPSender(host, port, username, password, from, mode string) (*SMTPSender, error) {
+	if mode != TLSStartTLS && mode != TLSImplicit && mode != TLSNone {
+		return nil, fmt.Errorf("invalid SMTP TLS mode %q", mode)
+	}
+	fromAddress, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SMTP from address: %w", err)
+	}
+	return &SMTPSender{
+		host:     host,
+		port:     port,
+		username: username,
+		password: password,
+		from:     fromAddress,
+		mode:     mode,
+	}, nil
+}
+
+// Send 发送一封邮件
+func (s *SMTPSender) Send(msg Message) error {
+	body, err := s.build(msg)
+	if err != nil {
+		return err
+	}
+
+	address := net.JoinHostPort(s.host, s.port)
+	var conn net.Conn
+	if s.mode == TLSImplicit {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: dialTimeout}, "tcp", address, &tls.Config{ServerName: s.host})
+	} else {
+		conn, err = net.DialTimeout("tcp", address, dialTimeout)
+	}
+	if err != nil {
+		return err
+	}
+
+	client, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if s.mode == TLSStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return errors.New("SMTP server does not support STARTTLS")
+		}
+		if err := client.StartTLS(&tls.Config{ServerName: s.host}); err != nil {
+			return err
+		}
+	}
+	if s.username != "" {
+		if err := client.Auth(smtp.PlainAuth("", s.username, s.password, s.host)); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(s.from.Address); err != nil {
+		return err
+	}
+	if err := client.Rcpt(msg.To); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(body); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// build 生成包含纯文本和 HTML 两部分的邮件内容
+func (s *SMTPSender) build(msg Message) ([]byte, error) {
+	var buf bytes.Buffer
+	parts := multipart.NewWriter(&buf)
+
+	headers := []string{
+		"From: " + s.from.String(),
+		"To: " + msg.To,
+		"Subject: " + mime.BEncoding.Encode("UTF-8", msg.Subject),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"Message-ID: " + messageID(s.from.Address),
+		"MIME-Version: 1.0",
+		"Content-Type: multipart/alternative; boundary=" + parts.Boundary(),
+	}
+	for name, value := range msg.Headers {
+		headers = append(headers, textproto.CanonicalMIMEHeaderKey(name)+": "+value)
+	}
+	buf.WriteString(strings.Join(headers, "\r\n") + "\r\n\r\n")
+
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=UTF-8", msg.Text},
+		{"text/html; charset=UTF-8", msg.HTML},
+	} {
+		if part.content == "" {
+			continue
+		}
+		w, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write([]byte(part.content)); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// messageID 生成 Message-ID，域名取发件人地址的域名
+func messageID(from string) string {
+	domain := "localhost"
+	if i := strings.LastIndex(from, "@"); i >= 0 {
+		domain = from[i+1:]
+	}
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return "<" + hex.EncodeToString(buf) + "@" + domain + ">"
+}
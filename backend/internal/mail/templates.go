@@ -0,0 +1,60 @@
+package mail
+
+import (
+	"bytes"
+	"embed"
+	htmltemplate "html/template"
+	texttemplate "text/template"
+
+	"booonus-backend/internal/locale"
+	"booonus-backend/internal/notifications"
+)
+
+//go:embed templates
+var templateFS embed.FS
+
+// templateFuncs 模板中可用的函数
+var templateFuncs = map[string]interface{}{
+	// signed 带符号的积分，如 +5、-3
+	"signed": notifications.FormatPoints,
+}
+
+var (
+	htmlTemplates = htmltemplate.Must(htmltemplate.New("").Funcs(templateFuncs).ParseFS(templateFS, "templates/*.html"))
+	textTemplates = texttemplate.Must(texttemplate.New("").Funcs(templateFuncs).ParseFS(templateFS, "templates/*.txt"))
+)
+
+// Render 渲染同名的纯文本和 HTML 模板，如 "digest" 对应 digest.txt 和 digest.html
+// 默认语言以外的语言使用带语言后缀的模板，如 digest.en.txt；没有该语言的模板时使用默认语言
+func Render(name, lang string, data interface{}) (string, string, error) {
+	var text, html bytes.Buffer
+	if err := textTemplates.ExecuteTemplate(&text, templateName(name, lang, ".txt"), data); err != nil {
+		return "", "", err
+	}
+	if err := htmlTemplates.ExecuteTemplate(&html, templateName(name, lang, ".html"), data); err != nil {
+		return "", "", err
+	}
+	return text.String(), html.String(), nil
+}
+
+// RenderPage 渲染打开邮件链接后显示的结果页面
+func RenderPage(lang, title, message string) ([]byte, error) {
+	var buf bytes.Buffer
+	err := htmlTemplates.ExecuteTemplate(&buf, "page.html", struct{ Lang, Title, Message string }{lang, title, message})
+	return buf.Bytes(), err
+}
+
+// templateName 选择语言对应的模板文件名
+func templateName(name, lang, ext string) string {
+	if lang == locale.Default {
+		return name + ext
+	}
+	localized := name + "." + lang + ext
+	if ext == ".html" && htmlTemplates.Lookup(localized) != nil {
+		return localized
+	}
+	if ext == ".txt" && textTemplates.Lookup(localized) != nil {
+		return localized
+	}
+	return name + ext
+}
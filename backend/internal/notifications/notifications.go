@@ -0,0 +1,244 @@
+// Package notifications 站内通知：积分变化、购买、撤销、邀请和提醒到期时通知相关用户
+//
+// 通知与产生它的操作在同一个事务中写入，操作回滚时通知也不会留下；事务提交后再调用 Push
+// 推送到手机。用户可以按类型分别关闭站内通知和推送；已读通知保留 ReadRetention，
+// 所有通知最多保留 Retention。
+package notifications
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+
+	"booonus-backend/internal/database"
+	"booonus-backend/internal/locale"
+	"booonus-backend/internal/push"
+	"booonus-backend/pkg/logger"
+)
+
+// 通知类型
+const (
+	TypePoints   = "points"   // 其他成员执行规则或事件改变了自己的积分
+	TypePurchase = "purchase" // 其他成员购买了自己的商品
+	TypeRevert   = "revert"   // 其他成员撤销或取消撤销了自己的积分记录
+	TypeInvite   = "invite"   // 被邀请成为情侣或加入家庭
+	TypeReminder = "reminder" // 到期的提醒
+)
+
+// Types 所有通知类型
+var Types = []string{TypePoints, TypePurchase, TypeRevert, TypeInvite, TypeReminder}
+
+// pushTitles 各语言、各类型推送的标题
+var pushTitles = map[string]map[string]string{
+	locale.Chinese: {
+		TypePoints:   "积分变化",
+		TypePurchase: "商品被购买",
+		TypeRevert:   "积分记录被撤销",
+		TypeInvite:   "家庭邀请",
+		TypeReminder: "提醒",
+	},
+	locale.English: {
+		TypePoints:   "Points changed",
+		TypePurchase: "Item purchased",
+		TypeRevert:   "Points entry reverted",
+		TypeInvite:   "Household invitation",
+		TypeReminder: "Reminder",
+	},
+}
+
+// 通知内容的文案，见 Notification.MessageKey
+const (
+	MessageRuleExecuted    = "rule_executed"    // 参数：规则名称
+	MessageEventCreated    = "event_created"    // 参数：事件名称
+	MessageEventAdjusted   = "event_adjusted"   // 参数：事件名称
+	MessageEventDeleted    = "event_deleted"    // 参数：事件名称
+	MessageItemSold        = "item_sold"        // 参数：商品名称
+	MessageReverted        = "reverted"         // 参数：积分记录的说明
+	MessageRevertCancelled = "revert_cancelled" // 参数：积分记录的说明
+	MessageCoupleInvited   = "couple_invited"
+	MessageHouseholdAdded  = "household_added"
+	MessageCoupleRestored  = "couple_restored"
+	MessageReminder        = "reminder" // 参数：提醒标题、提醒内容
+)
+
+// messages 各语言的通知文案，%s 依次替换为 MessageArgs
+var messages = map[string]map[string]string{
+	locale.Chinese: {
+		MessageRuleExecuted:    "执行规则: %s",
+		MessageEventCreated:    "事件: %s",
+		MessageEventAdjusted:   "事件调整: %s",
+		MessageEventDeleted:    "事件删除: %s",
+		MessageItemSold:        "出售商品: %s",
+		MessageReverted:        "撤销: %s",
+		MessageRevertCancelled: "取消撤销: %s",
+		MessageCoupleInvited:   "邀请你成为情侣",
+		MessageHouseholdAdded:  "将你加入了家庭",
+		MessageCoupleRestored:  "恢复了情侣关系",
+		MessageReminder:        "%s：%s",
+	},
+	locale.English: {
+		MessageRuleExecuted:    "Rule executed: %s",
+		MessageEventCreated:    "Event: %s",
+		MessageEventAdjusted:   "Event adjusted: %s",
+		MessageEventDeleted:    "Event deleted: %s",
+		MessageItemSold:        "Item sold: %s",
+		MessageReverted:        "Reverted: %s",
+		MessageRevertCancelled: "Revert cancelled: %s",
+		MessageCoupleInvited:   "invited you to be their partner",
+		MessageHouseholdAdded:  "added you to their household",
+		MessageCoupleRestored:  "restored your relationship",
+		MessageReminder:        "%s: %s",
+	},
+}
+
+// 通知保留期限
+const (
+	ReadRetention = 30 * 24 * time.Hour
+	Retention     = 90 * 24 * time.Hour
+)
+
+// Notification 一条通知
+type Notification struct {
+	ID            int        `json:"id"`
+	UserID        int        `json:"user_id"`
+	Type          string     `json:"type"`
+	ActorID       *int       `json:"actor_id"`
+	ActorName     *string    `json:"actor_name,omitempty"`
+	Message       string     `json:"message"`
+	Points        *int       `json:"points"`
+	ReferenceType string     `json:"reference_type,omitempty"`
+	ReferenceID   *int       `json:"reference_id"`
+	ReadAt        *time.Time `json:"read_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+
+	// MessageKey 不为空时，Message 按接收人设置的语言由该文案和 MessageArgs 生成
+	MessageKey  string   `json:"-"`
+	MessageArgs []string `json:"-"`
+}
+
+// ValidType 检查是否是已知的通知类型
+func ValidType(notificationType string) bool {
+	for _, t := range Types {
+		if t == notificationType {
+			return true
+		}
+	}
+	return false
+}
+
+// localizedMessage 以 lang 语言生成通知内容，没有 MessageKey 时原样返回 Message
+func (n Notification) localizedMessage(lang string) string {
+	if n.MessageKey == "" {
+		return n.Message
+	}
+	format, ok := messages[lang][n.MessageKey]
+	if !ok {
+		format = messages[locale.Default][n.MessageKey]
+	}
+	args := make([]interface{}, len(n.MessageArgs))
+	for i, arg := range n.MessageArgs {
+		args[i] = arg
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// Send 在事务中写入一条通知，内容使用接收人设置的语言
+// 操作人是接收人自己时不通知；接收人关闭了该类型时跳过
+func Send(tx *sql.Tx, n Notification) error {
+	if n.ActorID != nil && *n.ActorID == n.UserID {
+		return nil
+	}
+
+	enabled, err := inAppEnabled(tx, n.UserID, n.Type)
+	if err != nil || !enabled {
+		return err
+	}
+
+	var tag sql.NullString
+	if err := tx.QueryRow("SELECT locale FROM users WHERE id = ?", n.UserID).Scan(&tag); err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	var referenceType interface{}
+	if n.ReferenceType != "" {
+		referenceType = n.ReferenceType
+	}
+	_, err = tx.Exec(
+		"INSERT INTO notifications (user_id, type, actor_id, message, points, reference_type, reference_id) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		n.UserID, n.Type, n.ActorID, n.localizedMessage(locale.Match(tag.String)), n.Points, referenceType, n.ReferenceID,
+	)
+	return err
+}
+
+// Push 把通知推送到接收人的手机，应在事务提交后调用
+// 操作人是接收人自己时不推送；接收人关闭了该类型的推送时跳过；标题和内容使用接收人设置的语言
+func Push(n Notification) error {
+	if n.ActorID != nil && *n.ActorID == n.UserID {
+		return nil
+	}
+
+	var enabled bool
+	err := database.DB.QueryRow(
+		"SELECT push FROM notification_preferences WHERE user_id = ? AND type = ?",
+		n.UserID, n.Type,
+	).Scan(&enabled)
+	if err == sql.ErrNoRows {
+		enabled = true
+	} else if err != nil {
+		return err
+	}
+	if !enabled {
+		return nil
+	}
+
+	lang := locale.ForUser(n.UserID)
+	body := n.localizedMessage(lang)
+	if n.Points != nil {
+		body += " (" + FormatPoints(*n.Points) + ")"
+	}
+	data := map[string]string{"type": n.Type}
+	if n.ReferenceType != "" && n.ReferenceID != nil {
+		data["reference_type"] = n.ReferenceType
+		data["reference_id"] = strconv.Itoa(*n.ReferenceID)
+	}
+	push.Enqueue(n.UserID, push.Message{Title: pushTitles[lang][n.Type], Body: body, Data: data, Locale: lang})
+	return nil
+}
+
+// FormatPoints 带符号的积分，如 +5、-3
+func FormatPoints(points int) string {
+	if points > 0 {
+		return "+" + strconv.Itoa(points)
+	}
+	return strconv.Itoa(points)
+}
+
+// inAppEnabled 检查用户是否接收该类型的站内通知，没有设置时默认接收
+func inAppEnabled(tx *sql.Tx, userID int, notificationType string) (bool, error) {
+	var enabled bool
+	err := tx.QueryRow(
+		"SELECT in_app FROM notification_preferences WHERE user_id = ? AND type = ?",
+		userID, notificationType,
+	).Scan(&enabled)
+	if err == sql.ErrNoRows {
+		return true, nil
+	}
+	return enabled, err
+}
+
+// Cleanup 删除超过保留期限的通知
+func Cleanup() {
+	now := time.Now().UTC()
+	result, err := database.DB.Exec(
+		"DELETE FROM notifications WHERE (read_at IS NOT NULL AND read_at < ?) OR created_at < ?",
+		now.Add(-ReadRetention).Format(database.TimeLayout), now.Add(-Retention).Format(database.TimeLayout),
+	)
+	if err != nil {
+		logger.Error("Failed to clean up notifications: " + err.Error())
+		return
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		logger.Info("Cleaned up " + strconv.FormatInt(n, 10) + " notifications")
+	}
+}
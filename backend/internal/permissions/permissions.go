@@ -0,0 +1,111 @@
+// Package permissions 家庭成员角色及各角色的权限
+//
+// 角色由高到低为 owner、admin、member、child。权限检查由中间件完成，
+// 不在家庭中的用户只能操作自己的数据，不受角色限制。
+package permissions
+
+import (
+	"database/sql"
+
+	"booonus-backend/internal/database"
+)
+
+// 家庭成员角色
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+	RoleChild  = "child"
+)
+
+// Permission 一项可授予角色的操作
+type Permission string
+
+const (
+	RulesManage        Permission = "rules.manage"         // 创建、修改、删除、恢复规则
+	RulesExecute       Permission = "rules.execute"        // 对自己执行规则
+	RulesExecuteOthers Permission = "rules.execute_others" // 执行规则时作用于其他成员
+	EventsManage       Permission = "events.manage"        // 创建、修改、删除事件
+	Revert             Permission = "revert"               // 撤销积分记录和购买
+	ShopManage         Permission = "shop.manage"          // 管理自己的商品
+	SettingsManage     Permission = "settings.manage"      // 修改家庭名称和家庭设置
+	MembersManage      Permission = "members.manage"       // 添加、移除成员及修改角色
+)
+
+// matrix 每个角色拥有的权限
+var matrix = map[string][]Permission{
+	RoleOwner:  {RulesManage, RulesExecute, RulesExecuteOthers, EventsManage, Revert, ShopManage, SettingsManage, MembersManage},
+	RoleAdmin:  {RulesManage, RulesExecute, RulesExecuteOthers, EventsManage, Revert, ShopManage, SettingsManage, MembersManage},
+	RoleMember: {RulesManage, RulesExecute, RulesExecuteOthers, EventsManage, Revert, ShopManage},
+	RoleChild:  {RulesExecute},
+}
+
+// rank 角色的高低，只能管理角色比自己低的成员
+var rank = map[string]int{
+	RoleOwner:  3,
+	RoleAdmin:  2,
+	RoleMember: 1,
+	RoleChild:  0,
+}
+
+// Matrix 返回完整的权限矩阵
+func Matrix() map[string][]Permission {
+	return matrix
+}
+
+// Allowed 检查角色是否拥有权限，空角色（不在家庭中）不受限制
+func Allowed(role string, perm Permission) bool {
+	if role == "" {
+		return true
+	}
+	for _, p := range matrix[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidRole 检查是否是已知的角色
+func ValidRole(role string) bool {
+	_, ok := rank[role]
+	return ok
+}
+
+// Outranks 检查角色 a 是否高于角色 b
+func Outranks(a, b string) bool {
+	return rank[a] > rank[b]
+}
+
+// Lookup 获取用户当前所在的家庭及角色，不在家庭中时返回 0 和空角色
+func Lookup(userID int) (int, string, error) {
+	var coupleID int
+	var role string
+	err := database.DB.QueryRow(`
+		SELECT m.couple_id, m.role
+		FROM users u
+		JOIN household_members m ON m.couple_id = u.couple_id AND m.user_id = u.id
+		JOIN couples c ON c.id = m.couple_id
+		WHERE u.id = ? AND m.left_at IS NULL AND c.status = 'active'`,
+		userID,
+	).Scan(&coupleID, &role)
+	if err == sql.ErrNoRows {
+		return 0, "", nil
+	}
+	return coupleID, role, err
+}
+
+// SameHousehold 检查两个用户是否是同一个家庭的现有成员，查询失败时视为不是
+func SameHousehold(userID, otherID int) bool {
+	var count int
+	err := database.DB.QueryRow(`
+		SELECT COUNT(*)
+		FROM household_members m1
+		JOIN household_members m2 ON m1.couple_id = m2.couple_id
+		JOIN couples c ON m1.couple_id = c.id
+		WHERE m1.user_id = ? AND m2.user_id = ? AND m1.left_at IS NULL AND m2.left_at IS NULL
+		  AND c.status = 'active'`,
+		userID, otherID,
+	).Scan(&count)
+	return err == nil && count > 0
+}
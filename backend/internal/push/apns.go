@@ -0,0 +1,154 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// apnsDefaultBaseURL APNs 正式环境接口地址
+const apnsDefaultBaseURL = "https://api.push.apple.com"
+
+// apnsTokenLifetime 签名令牌的使用时间，APNs 要求在 20 到 60 分钟之间更换
+const apnsTokenLifetime = 50 * time.Minute
+
+// APNs 通过 Apple Push Notification service 的 HTTP/2 接口向 ios 设备推送
+// 使用 .p8 私钥签名的令牌认证
+type APNs struct {
+	baseURL string
+	topic   string
+	keyID   string
+	teamID  string
+	key     *ecdsa.PrivateKey
+	client  *http.Client
+
+	mu       sync.Mutex
+	token    string
+	issuedAt time.Time
+}
+
+// NewAPNs 创建 APNs 推送，keyPEM 为 .p8 私钥内容，baseURL 为空时使用正式环境地址
+// https 地址通过 HTTP/2 连接；http 地址只用于本地测试，使用 HTTP/1.1
+func NewAPNs(baseURL, topic, keyID, teamID string, keyPEM []byte) (*APNs, error) {
+	if topic == "" || keyID == "" || teamID == "" {
+		return nil, errors.New("APNs requires topic, key ID and team ID")
+	}
+	key, err := jwt.ParseECPrivateKeyFromPEM(keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("invalid APNs private key: %w", err)
+	}
+
+	if baseURL == "" {
+		baseURL = apnsDefaultBaseURL
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ForceAttemptHTTP2 = true
+	return &APNs{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		topic:   topic,
+		keyID:   keyID,
+		teamID:  teamID,
+		key:     key,
+		client:  &http.Client{Timeout: SendTimeout, Transport: transport},
+	}, nil
+}
+
+// Send 向一个设备发送消息
+func (a *APNs) Send(ctx context.Context, token string, msg Message) error {
+	authToken, err := a.authToken()
+	if err != nil {
+		return err
+	}
+
+	payload := map[string]interface{}{
+		"aps": map[string]interface{}{
+			"alert": map[string]string{"title": msg.Title, "body": msg.Body},
+			"sound": "default",
+		},
+	}
+	for k, v := range msg.Data {
+		if k != "aps" {
+			payload[k] = v
+		}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.baseURL+"/3/device/"+url.PathEscape(token), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "bearer "+authToken)
+	req.Header.Set("apns-topic", a.topic)
+	req.Header.Set("apns-push-type", "alert")
+	req.Header.Set("apns-priority", "10")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	var result struct {
+		Reason string `json:"reason"`
+	}
+	json.NewDecoder(resp.Body).Decode(&result)
+
+	// DeviceTokenNotForTopic 说明 APNS_TOPIC 配置错误，不是令牌的问题，不能删除令牌
+	switch {
+	case resp.StatusCode == http.StatusGone,
+		result.Reason == "BadDeviceToken",
+		result.Reason == "Unregistered":
+		return fmt.Errorf("%w: %s", ErrInvalidToken, result.Reason)
+	case result.Reason == "ExpiredProviderToken" || result.Reason == "InvalidProviderToken":
+		a.resetToken()
+	}
+	return fmt.Errorf("APNs returned status %d: %s", resp.StatusCode, result.Reason)
+}
+
+// authToken 返回签名令牌，超过使用时间后重新签名
+func (a *APNs) authToken() (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.token != "" && time.Since(a.issuedAt) < apnsTokenLifetime {
+		return a.token, nil
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"iss": a.teamID,
+		"iat": now.Unix(),
+	})
+	token.Header["kid"] = a.keyID
+	signed, err := token.SignedString(a.key)
+	if err != nil {
+		return "", err
+	}
+
+	a.token = signed
+	a.issuedAt = now
+	return a.token, nil
+}
+
+// resetToken 丢弃缓存的签名令牌，下次发送时重新签名
+func (a *APNs) resetToken() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.token = ""
+}
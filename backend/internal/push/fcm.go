@@ -0,0 +1,211 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// fcmDefaultBaseURL FCM HTTP v1 接口地址
+const fcmDefaultBaseURL = "https://fcm.googleapis.com"
+
+// fcmScope 获取访问令牌时申请的权限
+const fcmScope = "https://www.googleapis.com/auth/firebase.messaging"
+
+// fcmCredentials 服务账号 JSON 中用到的字段
+type fcmCredentials struct {
+	ProjectID   string `json:"project_id"`
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// FCM 通过 Firebase Cloud Messaging HTTP v1 接口向 android 设备推送
+// 访问令牌用服务账号私钥签名换取，过期前自动刷新
+type FCM struct {
+	baseURL     string
+	projectID   string
+	clientEmail string
+	tokenURI    string
+	key         *rsa.PrivateKey
+	client      *http.Client
+
+	mu          sync.Mutex
+	accessToken string
+	expiresAt   time.Time
+}
+
+// NewFCM 根据服务账号 JSON 创建 FCM 推送，baseURL 为空时使用正式接口地址
+func NewFCM(baseURL string, credentialsJSON []byte) (*FCM, error) {
+	var credentials fcmCredentials
+	if err := json.Unmarshal(credentialsJSON, &credentials); err != nil {
+		return nil, fmt.Errorf("invalid FCM credentials: %w", err)
+	}
+	if credentials.ProjectID == "" || credentials.ClientEmail == "" || credentials.TokenURI == "" {
+		return nil, errors.New("FCM credentials must include project_id, client_email and token_uri")
+	}
+	key, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(credentials.PrivateKey))
+	if err != nil {
+		return nil, fmt.Errorf("invalid FCM private key: %w", err)
+	}
+
+	if baseURL == "" {
+		baseURL = fcmDefaultBaseURL
+	}
+	return &FCM{
+		baseURL:     strings.TrimSuffix(baseURL, "/"),
+		projectID:   credentials.ProjectID,
+		clientEmail: credentials.ClientEmail,
+		tokenURI:    credentials.TokenURI,
+		key:         key,
+		client:      &http.Client{Timeout: SendTimeout},
+	}, nil
+}
+
+// Send 向一个设备发送消息
+func (f *FCM) Send(ctx context.Context, token string, msg Message) error {
+	accessToken, err := f.token(ctx)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"message": map[string]interface{}{
+			"token":        token,
+			"notification": map[string]string{"title": msg.Title, "body": msg.Body},
+			"data":         msg.Data,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		f.baseURL+"/v1/projects/"+url.PathEscape(f.projectID)+"/messages:send", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	var result struct {
+		Error struct {
+			Status  string `json:"status"`
+			Message string `json:"message"`
+			Details []struct {
+				ErrorCode       string `json:"errorCode"`
+				FieldViolations []struct {
+					Field string `json:"field"`
+				} `json:"fieldViolations"`
+			} `json:"details"`
+		} `json:"error"`
+	}
+	responseBody, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	json.Unmarshal(responseBody, &result)
+
+	errorCode := result.Error.Status
+	tokenViolation := false
+	for _, detail := range result.Error.Details {
+		if detail.ErrorCode != "" {
+			errorCode = detail.ErrorCode
+		}
+		for _, violation := range detail.FieldViolations {
+			if violation.Field == "message.token" {
+				tokenViolation = true
+			}
+		}
+	}
+
+	// 只有明确指向令牌的错误才删除令牌，其他错误（包括 404）都按可重试处理
+	switch {
+	case errorCode == "UNREGISTERED":
+		return fmt.Errorf("%w: %s", ErrInvalidToken, errorCode)
+	case errorCode == "INVALID_ARGUMENT" && tokenViolation:
+		return fmt.Errorf("%w: %s", ErrInvalidToken, result.Error.Message)
+	case resp.StatusCode == http.StatusUnauthorized:
+		f.resetToken()
+	}
+	return fmt.Errorf("FCM returned status %d: %s", resp.StatusCode, errorCode)
+}
+
+// token 返回有效的访问令牌，即将过期时重新获取
+func (f *FCM) token(ctx context.Context) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.accessToken != "" && time.Now().Before(f.expiresAt.Add(-time.Minute)) {
+		return f.accessToken, nil
+	}
+
+	now := time.Now()
+	assertion, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":   f.clientEmail,
+		"scope": fcmScope,
+		"aud":   f.tokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}).SignedString(f.key)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.tokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("FCM token endpoint returned status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	if result.AccessToken == "" {
+		return "", errors.New("FCM token endpoint returned no access token")
+	}
+
+	f.accessToken = result.AccessToken
+	f.expiresAt = now.Add(time.Duration(result.ExpiresIn) * time.Second)
+	return f.accessToken, nil
+}
+
+// resetToken 丢弃缓存的访问令牌，下次发送时重新获取
+func (f *FCM) resetToken() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.accessToken = ""
+}
@@ -0,0 +1,300 @@
+// Package push 向用户登记的手机推送通知
+//
+// 各平台通过 Notifier 接口发送，Init 按环境变量配置 FCM（android）和 APNs（ios）。
+// Enqueue 只把消息放入进程内队列，定时任务调用 Flush 批量发送：同一批中发往同一设备的
+// 多条消息合并为一条，临时失败按指数退避重试，平台返回令牌失效时删除该设备。
+package push
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"booonus-backend/internal/database"
+	"booonus-backend/internal/locale"
+	"booonus-backend/pkg/logger"
+)
+
+// 设备平台
+const (
+	PlatformAndroid = "android"
+	PlatformIOS     = "ios"
+)
+
+// Platforms 支持的设备平台
+var Platforms = []string{PlatformAndroid, PlatformIOS}
+
+// 队列参数
+const (
+	MaxAttempts    = 5
+	RetryBaseDelay = 5 * time.Second // 第 n 次失败后等待 RetryBaseDelay * 2^(n-1)
+	SendTimeout    = 10 * time.Second
+	BatchSize      = 200   // 每次 Flush 最多处理的消息数
+	MaxQueueSize   = 10000 // 队列满时丢弃新消息
+)
+
+// ErrInvalidToken 设备令牌已失效或不属于本应用，发送方应删除该令牌，不再重试
+var ErrInvalidToken = errors.New("invalid device token")
+
+// Message 一条推送消息
+type Message struct {
+	Title  string
+	Body   string
+	Data   map[string]string // 附加数据，客户端点击通知时用于跳转
+	Locale string            // 接收人的语言，合并多条消息时用于生成标题
+}
+
+// Notifier 推送平台接口
+type Notifier interface {
+	// Send 向一个设备发送消息；令牌失效时返回包装了 ErrInvalidToken 的错误，其他错误可以重试
+	Send(ctx context.Context, token string, msg Message) error
+}
+
+var (
+	notifiersMu sync.RWMutex
+	notifiers   = map[string]Notifier{}
+)
+
+// Init 按环境变量配置各平台的推送，未配置的平台不发送
+//
+// FCM：FCM_CREDENTIALS_FILE 为服务账号 JSON 文件，FCM_BASE_URL 可替换接口地址
+// APNs：APNS_KEY_FILE（.p8 私钥）、APNS_KEY_ID、APNS_TEAM_ID、APNS_TOPIC（应用 Bundle ID），
+// APNS_BASE_URL 可替换接口地址，如沙盒环境 https://api.sandbox.push.apple.com
+func Init() error {
+	if path := os.Getenv("FCM_CREDENTIALS_FILE"); path != "" {
+		credentials, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		fcm, err := NewFCM(os.Getenv("FCM_BASE_URL"), credentials)
+		if err != nil {
+			return err
+		}
+		SetNotifier(PlatformAndroid, fcm)
+		logger.Info("Push notifications enabled for android")
+	}
+
+	if path := os.Getenv("APNS_KEY_FILE"); path != "" {
+		key, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		apns, err := NewAPNs(os.Getenv("APNS_BASE_URL"), os.Getenv("APNS_TOPIC"), os.Getenv("APNS_KEY_ID"), os.Getenv("APNS_TEAM_ID"), key)
+		if err != nil {
+			return err
+		}
+		SetNotifier(PlatformIOS, apns)
+		logger.Info("Push notifications enabled for ios")
+	}
+	return nil
+}
+
+// SetNotifier 设置平台的推送实现，n 为 nil 时停用该平台
+func SetNotifier(platform string, n Notifier) {
+	notifiersMu.Lock()
+	defer notifiersMu.Unlock()
+	if n == nil {
+		delete(notifiers, platform)
+		return
+	}
+	notifiers[platform] = n
+}
+
+// notifier 获取平台的推送实现
+func notifier(platform string) Notifier {
+	notifiersMu.RLock()
+	defer notifiersMu.RUnlock()
+	return notifiers[platform]
+}
+
+// ValidPlatform 检查是否是支持的设备平台
+func ValidPlatform(platform string) bool {
+	for _, p := range Platforms {
+		if p == platform {
+			return true
+		}
+	}
+	return false
+}
+
+// pending 队列中的一条消息；DeviceID 为 0 时发往用户的所有设备，否则是某个设备的重试
+type pending struct {
+	UserID    int
+	DeviceID  int
+	Platform  string
+	Token     string
+	Msg       Message
+	Attempts  int
+	NotBefore time.Time
+}
+
+// device 一个待发送的设备及发往它的消息
+type device struct {
+	ID       int
+	UserID   int
+	Platform string
+	Token    string
+	Msgs     []Message
+	Attempts int
+}
+
+var (
+	queueMu sync.Mutex
+	queue   []pending
+)
+
+// Enqueue 把发给用户的消息放入队列，应在产生消息的事务提交后调用
+func Enqueue(userID int, msg Message) {
+	queueMu.Lock()
+	defer queueMu.Unlock()
+	if len(queue) >= MaxQueueSize {
+		logger.Warn("Push queue full, dropping message for user " + strconv.Itoa(userID))
+		return
+	}
+	queue = append(queue, pending{UserID: userID, Msg: msg})
+}
+
+// Flush 发送队列中到期的消息，由定时任务调用
+func Flush() {
+	due := takeDue(time.Now())
+	if len(due) == 0 {
+		return
+	}
+
+	devices, err := resolveDevices(due)
+	if err != nil {
+		logger.Error("Failed to get device tokens: " + err.Error())
+		requeue(due)
+		return
+	}
+
+	for _, d := range devices {
+		n := notifier(d.Platform)
+		if n == nil {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), SendTimeout)
+		err := n.Send(ctx, d.Token, collapse(d.Msgs))
+		cancel()
+
+		switch {
+		case err == nil:
+		case errors.Is(err, ErrInvalidToken):
+			logger.Info("Removing invalid device token " + strconv.Itoa(d.ID) + ": " + err.Error())
+			if _, err := database.DB.Exec("DELETE FROM device_tokens WHERE id = ?", d.ID); err != nil {
+				logger.Error("Failed to delete device token: " + err.Error())
+			}
+		case d.Attempts+1 >= MaxAttempts:
+			logger.Error("Giving up push to device " + strconv.Itoa(d.ID) + ": " + err.Error())
+		default:
+			logger.Warn("Push to device " + strconv.Itoa(d.ID) + " failed, will retry: " + err.Error())
+			retry := pending{
+				UserID:    d.UserID,
+				DeviceID:  d.ID,
+				Platform:  d.Platform,
+				Token:     d.Token,
+				Msg:       collapse(d.Msgs),
+				Attempts:  d.Attempts + 1,
+				NotBefore: time.Now().Add(retryDelay(d.Attempts + 1)),
+			}
+			requeue([]pending{retry})
+		}
+	}
+}
+
+// takeDue 从队列中取出最多 BatchSize 条到期的消息
+func takeDue(now time.Time) []pending {
+	queueMu.Lock()
+	defer queueMu.Unlock()
+
+	var due, rest []pending
+	for _, p := range queue {
+		if len(due) < BatchSize && !p.NotBefore.After(now) {
+			due = append(due, p)
+		} else {
+			rest = append(rest, p)
+		}
+	}
+	queue = rest
+	return due
+}
+
+// requeue 把消息放回队列
+func requeue(items []pending) {
+	queueMu.Lock()
+	defer queueMu.Unlock()
+	queue = append(queue, items...)
+}
+
+// resolveDevices 查出新消息接收人的设备，与重试的设备一起按设备分组
+func resolveDevices(due []pending) ([]*device, error) {
+	byUser := map[int][]Message{}
+	var userIDs []string
+	var devices []*device
+	for _, p := range due {
+		if p.DeviceID != 0 {
+			devices = append(devices, &device{ID: p.DeviceID, UserID: p.UserID, Platform: p.Platform, Token: p.Token, Msgs: []Message{p.Msg}, Attempts: p.Attempts})
+			continue
+		}
+		if _, ok := byUser[p.UserID]; !ok {
+			userIDs = append(userIDs, strconv.Itoa(p.UserID))
+		}
+		byUser[p.UserID] = append(byUser[p.UserID], p.Msg)
+	}
+	if len(userIDs) == 0 {
+		return devices, nil
+	}
+
+	// 用户ID都是整数，直接拼接
+	rows, err := database.DB.Query(
+		"SELECT id, user_id, platform, token FROM device_tokens WHERE user_id IN (" + strings.Join(userIDs, ",") + ") ORDER BY id",
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		d := &device{}
+		if err := rows.Scan(&d.ID, &d.UserID, &d.Platform, &d.Token); err != nil {
+			return nil, err
+		}
+		d.Msgs = byUser[d.UserID]
+		devices = append(devices, d)
+	}
+	return devices, rows.Err()
+}
+
+// collapsedTitles 合并后消息的标题，按语言，%d 为消息数量
+var collapsedTitles = map[string]string{
+	locale.Chinese: "%d 条新通知",
+	locale.English: "%d new notifications",
+}
+
+// collapse 把发往同一设备的多条消息合并为一条，标题使用最新一条消息的语言
+func collapse(msgs []Message) Message {
+	if len(msgs) == 1 {
+		return msgs[0]
+	}
+	latest := msgs[len(msgs)-1]
+	title, ok := collapsedTitles[latest.Locale]
+	if !ok {
+		title = collapsedTitles[locale.Default]
+	}
+	return Message{
+		Title:  fmt.Sprintf(title, len(msgs)),
+		Body:   latest.Body,
+		Data:   map[string]string{"count": strconv.Itoa(len(msgs))},
+		Locale: latest.Locale,
+	}
+}
+
+// retryDelay 第 attempt 次失败后到下次重试的等待时间
+func retryDelay(attempt int) time.Duration {
+	return RetryBaseDelay << (attempt - 1)
+}
@@ -0,0 +1,166 @@
+// Package realtime 进程内的发布/订阅中心，把家庭范围的事件推送给该家庭所有在线的连接
+//
+// 事件ID为 <进程启动时间>-<序号>，序号在进程内递增。最近的事件保存在缓冲区中，
+// 断线重连时可以从上次收到的ID继续；缓冲区已淘汰了需要的事件，或ID的启动时间与当前进程不同
+// （来自重启前的进程）时无法续传，订阅方应重新拉取全部数据。
+package realtime
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// subscriberBuffer 每个订阅的待发送事件数，写满说明连接跟不上，直接断开让客户端续传
+const subscriberBuffer = 64
+
+// Event 推送给客户端的一个事件
+type Event struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	CoupleID  int         `json:"couple_id"`
+	ActorID   int         `json:"actor_id"`
+	Data      interface{} `json:"data"`
+	CreatedAt time.Time   `json:"created_at"`
+	seq       uint64      // ID 中的序号
+}
+
+// Subscription 一个连接的订阅，事件从 C 读取；C 被关闭表示订阅已结束
+type Subscription struct {
+	C        chan Event
+	hub      *Hub
+	coupleID int
+	closed   bool
+}
+
+// Hub 并发安全的发布/订阅中心
+type Hub struct {
+	mu          sync.Mutex
+	epoch       string // 进程启动时间（Unix 毫秒），作为事件ID的前缀
+	lastSeq     uint64
+	evictedUpTo uint64 // 已从缓冲区淘汰的最大事件序号
+	history     []Event
+	maxHistory  int
+	subscribers map[int]map[*Subscription]struct{}
+}
+
+// NewHub 创建发布/订阅中心，maxHistory 为用于续传的最近事件数
+func NewHub(maxHistory int) *Hub {
+	return &Hub{
+		epoch:       strconv.FormatInt(time.Now().UnixMilli(), 10),
+		maxHistory:  maxHistory,
+		subscribers: make(map[int]map[*Subscription]struct{}),
+	}
+}
+
+// Publish 向家庭的所有订阅发布事件，返回事件ID；coupleID 为 0 时不发布
+func (h *Hub) Publish(coupleID, actorID int, eventType string, data interface{}) string {
+	if coupleID == 0 {
+		return ""
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.lastSeq++
+	event := Event{
+		ID:        h.epoch + "-" + strconv.FormatUint(h.lastSeq, 10),
+		seq:       h.lastSeq,
+		Type:      eventType,
+		CoupleID:  coupleID,
+		ActorID:   actorID,
+		Data:      data,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	h.history = append(h.history, event)
+	if len(h.history) > h.maxHistory {
+		evicted := len(h.history) - h.maxHistory
+		h.evictedUpTo = h.history[evicted-1].seq
+		h.history = append([]Event(nil), h.history[evicted:]...)
+	}
+
+	for sub := range h.subscribers[coupleID] {
+		select {
+		case sub.C <- event:
+		default:
+			h.closeLocked(sub)
+		}
+	}
+	return event.ID
+}
+
+// Subscribe 订阅家庭的事件
+// lastEventID 不为空时返回其后该家庭的事件用于补发；无法续传（包括ID格式不对或来自其他进程）时第三个返回值为 false
+func (h *Hub) Subscribe(coupleID int, lastEventID string) (*Subscription, []Event, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sub := &Subscription{
+		C:        make(chan Event, subscriberBuffer),
+		hub:      h,
+		coupleID: coupleID,
+	}
+	if h.subscribers[coupleID] == nil {
+		h.subscribers[coupleID] = make(map[*Subscription]struct{})
+	}
+	h.subscribers[coupleID][sub] = struct{}{}
+
+	if lastEventID == "" {
+		return sub, nil, true
+	}
+	seq, ok := h.parseID(lastEventID)
+	if !ok || seq > h.lastSeq || seq < h.evictedUpTo {
+		return sub, nil, false
+	}
+
+	var replay []Event
+	for _, event := range h.history {
+		if event.seq > seq && event.CoupleID == coupleID {
+			replay = append(replay, event)
+		}
+	}
+	return sub, replay, true
+}
+
+// parseID 解析本进程发出的事件ID，返回序号
+func (h *Hub) parseID(id string) (uint64, bool) {
+	epoch, seq, found := strings.Cut(id, "-")
+	if !found || epoch != h.epoch {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(seq, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+// Close 结束订阅，可以重复调用
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	s.hub.closeLocked(s)
+}
+
+// closeLocked 移除订阅并关闭通道，调用方需持有锁
+func (h *Hub) closeLocked(sub *Subscription) {
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	close(sub.C)
+
+	delete(h.subscribers[sub.coupleID], sub)
+	if len(h.subscribers[sub.coupleID]) == 0 {
+		delete(h.subscribers, sub.coupleID)
+	}
+}
+
+// Subscribers 家庭当前的订阅数
+func (h *Hub) Subscribers(coupleID int) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subscribers[coupleID])
+}
@@ -0,0 +1,64 @@
+package reminders
+
+import (
+	"strconv"
+
+	"booonus-backend/internal/database"
+	"booonus-backend/internal/notifications"
+	"booonus-backend/pkg/logger"
+)
+
+// InApp 写入站内通知并推送到手机，遵循接收人的通知设置
+type InApp struct{}
+
+// Name 投递方式名称
+func (InApp) Name() string {
+	return "in_app"
+}
+
+// Deliver 写入一条提醒类型的通知
+func (InApp) Deliver(d Delivery) error {
+	n := notifications.Notification{
+		UserID:        d.Reminder.UserID,
+		Type:          notifications.TypeReminder,
+		Message:       d.Reminder.Title,
+		ReferenceType: "reminder",
+		ReferenceID:   &d.Reminder.ID,
+	}
+	if d.Reminder.Message != "" {
+		n.MessageKey = notifications.MessageReminder
+		n.MessageArgs = []string{d.Reminder.Title, d.Reminder.Message}
+	}
+	// 提醒自己时没有操作人，否则通知会被当作自己的操作跳过
+	if d.Reminder.CreatedBy != d.Reminder.UserID {
+		n.ActorID = &d.Reminder.CreatedBy
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := notifications.Send(tx, n); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return notifications.Push(n)
+}
+
+// Log 只写日志，用于开发调试或没有其他投递方式时
+type Log struct{}
+
+// Name 投递方式名称
+func (Log) Name() string {
+	return "log"
+}
+
+// Deliver 记录一条提醒日志
+func (Log) Deliver(d Delivery) error {
+	logger.Info("Reminder " + strconv.Itoa(d.Reminder.ID) + " for user " + strconv.Itoa(d.Reminder.UserID) +
+		" at " + d.Occurrence.UTC().Format(database.TimeLayout) + ": " + d.Reminder.Title)
+	return nil
+}
@@ -0,0 +1,296 @@
+package reminders
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 重复方式
+const (
+	RepeatOnce    = "once"    // 只提醒一次
+	RepeatDaily   = "daily"   // 每 Interval 天
+	RepeatWeekly  = "weekly"  // 每 Interval 周的 Weekdays
+	RepeatMonthly = "monthly" // 每 Interval 个月，日期取开始日期的日，当月没有这一天时取月末
+)
+
+// 时间格式
+const (
+	DateLayout      = "2006-01-02"
+	TimeOfDayLayout = "15:04"
+)
+
+// maxSearchSteps 计算下一次提醒时最多检查的日期数
+// 不在间隔上的天、周、月会直接跳过（见 nextCandidate），任何间隔都只需要检查很少的日期
+const maxSearchSteps = 5 * 366
+
+// Schedule 提醒的重复规则，日期和时间按 Location 时区解释
+type Schedule struct {
+	Repeat    string
+	Interval  int
+	Weekdays  []int // 每周重复时的星期，1 为周一，7 为周日；为空时取开始日期的星期
+	TimeOfDay string
+	StartDate string
+	EndDate   string // 为空表示不结束，包含当天
+	Location  *time.Location
+}
+
+// ValidRepeat 检查是否是已知的重复方式
+func ValidRepeat(repeat string) bool {
+	return repeat == RepeatOnce || repeat == RepeatDaily || repeat == RepeatWeekly || repeat == RepeatMonthly
+}
+
+// Validate 检查重复规则的各字段
+func (s Schedule) Validate() error {
+	if !ValidRepeat(s.Repeat) {
+		return errors.New("invalid repeat")
+	}
+	if s.Interval < 1 || s.Interval > 365 {
+		return errors.New("interval must be between 1 and 365")
+	}
+	for _, day := range s.Weekdays {
+		if day < 1 || day > 7 {
+			return errors.New("weekdays must be between 1 (Monday) and 7 (Sunday)")
+		}
+	}
+	if _, err := time.Parse(TimeOfDayLayout, s.TimeOfDay); err != nil {
+		return errors.New("invalid time, expected HH:MM")
+	}
+	start, err := time.Parse(DateLayout, s.StartDate)
+	if err != nil {
+		return errors.New("invalid start date, expected YYYY-MM-DD")
+	}
+	if s.EndDate != "" {
+		end, err := time.Parse(DateLayout, s.EndDate)
+		if err != nil {
+			return errors.New("invalid end date, expected YYYY-MM-DD")
+		}
+		if end.Before(start) {
+			return errors.New("end date must not be before start date")
+		}
+	}
+	if s.Location == nil {
+		return errors.New("missing time zone")
+	}
+	return nil
+}
+
+// Next 返回 after 之后的下一次提醒时间，没有更多提醒时 ok 为 false
+// 日期按日历计算，夏令时切换前后提醒仍在当地的同一时刻
+func (s Schedule) Next(after time.Time) (time.Time, bool) {
+	clock, err := time.Parse(TimeOfDayLayout, s.TimeOfDay)
+	if err != nil {
+		return time.Time{}, false
+	}
+	start, err := time.Parse(DateLayout, s.StartDate)
+	if err != nil {
+		return time.Time{}, false
+	}
+	var end time.Time
+	if s.EndDate != "" {
+		if end, err = time.Parse(DateLayout, s.EndDate); err != nil {
+			return time.Time{}, false
+		}
+	}
+	interval := s.Interval
+	if interval < 1 {
+		interval = 1
+	}
+
+	// 日期运算在 UTC 中进行，避免夏令时影响天数
+	local := after.In(s.Location)
+	day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, time.UTC)
+	if day.Before(start) {
+		day = start
+	}
+	for i := 0; i < maxSearchSteps; i++ {
+		if (!end.IsZero() && day.After(end)) || (s.Repeat == RepeatOnce && day.After(start)) {
+			return time.Time{}, false
+		}
+		if s.matches(day, start, interval) {
+			at := time.Date(day.Year(), day.Month(), day.Day(), clock.Hour(), clock.Minute(), 0, 0, s.Location)
+			if at.After(after) {
+				return at, true
+			}
+			if s.Repeat == RepeatOnce {
+				return time.Time{}, false
+			}
+		}
+		day = s.nextCandidate(day, start, interval)
+	}
+	return time.Time{}, false
+}
+
+// First 返回第一次提醒的时间，没有提醒时 ok 为 false
+func (s Schedule) First() (time.Time, bool) {
+	start, err := time.Parse(DateLayout, s.StartDate)
+	if err != nil || s.Location == nil {
+		return time.Time{}, false
+	}
+	return s.Next(time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, s.Location).Add(-time.Second))
+}
+
+// RRule 按 RFC 5545 表示的重复规则（如 "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,FR;WKST=MO"），用于日历订阅，
+// 应与 First 返回的 DTSTART 一起使用；只提醒一次时为空
+// 当月没有开始日期的日时取月末，用 BYSETPOS=-1 取 BYMONTHDAY 中当月存在的最后一天表示
+func (s Schedule) RRule() string {
+	start, err := time.Parse(DateLayout, s.StartDate)
+	if err != nil {
+		return ""
+	}
+
+	var parts []string
+	switch s.Repeat {
+	case RepeatDaily:
+		parts = append(parts, "FREQ=DAILY")
+	case RepeatWeekly:
+		parts = append(parts, "FREQ=WEEKLY")
+	case RepeatMonthly:
+		parts = append(parts, "FREQ=MONTHLY")
+	default:
+		return ""
+	}
+	if s.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(s.Interval))
+	}
+
+	switch s.Repeat {
+	case RepeatWeekly:
+		weekdays := ParseWeekdays(FormatWeekdays(s.Weekdays))
+		if len(weekdays) == 0 {
+			weekdays = []int{isoWeekday(start)}
+		}
+		days := make([]string, 0, len(weekdays))
+		for _, day := range weekdays {
+			days = append(days, rruleWeekdays[day-1])
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","), "WKST=MO")
+	case RepeatMonthly:
+		if start.Day() <= 28 {
+			parts = append(parts, "BYMONTHDAY="+strconv.Itoa(start.Day()))
+		} else {
+			days := []string{}
+			for day := 28; day <= start.Day(); day++ {
+				days = append(days, strconv.Itoa(day))
+			}
+			parts = append(parts, "BYMONTHDAY="+strings.Join(days, ","), "BYSETPOS=-1")
+		}
+	}
+
+	// UNTIL 为结束日期当天提醒时刻的 UTC 时间
+	if s.EndDate != "" {
+		end, err := time.Parse(DateLayout, s.EndDate)
+		clock, clockErr := time.Parse(TimeOfDayLayout, s.TimeOfDay)
+		if err == nil && clockErr == nil && s.Location != nil {
+			until := time.Date(end.Year(), end.Month(), end.Day(), clock.Hour(), clock.Minute(), 0, 0, s.Location)
+			parts = append(parts, "UNTIL="+until.UTC().Format("20060102T150405Z"))
+		}
+	}
+	return strings.Join(parts, ";")
+}
+
+// rruleWeekdays RRULE 中星期的写法，下标 0 为周一
+var rruleWeekdays = []string{"MO", "TU", "WE", "TH", "FR", "SA", "SU"}
+
+// nextCandidate 返回 day 之后下一个可能有提醒的日期，跳过不在间隔上的天、周和月
+func (s Schedule) nextCandidate(day, start time.Time, interval int) time.Time {
+	switch s.Repeat {
+	case RepeatDaily:
+		return day.AddDate(0, 0, interval-daysBetween(start, day)%interval)
+	case RepeatWeekly:
+		monday := mondayOf(day)
+		if weeks := daysBetween(mondayOf(start), monday) / 7; weeks%interval != 0 {
+			return monday.AddDate(0, 0, 7*(interval-weeks%interval))
+		}
+	case RepeatMonthly:
+		if months := monthsBetween(start, day); months%interval != 0 {
+			return time.Date(day.Year(), day.Month()+time.Month(interval-months%interval), 1, 0, 0, 0, 0, time.UTC)
+		}
+	}
+	return day.AddDate(0, 0, 1)
+}
+
+// matches 检查某一天（UTC 零点表示的日期）是否有提醒
+func (s Schedule) matches(day, start time.Time, interval int) bool {
+	switch s.Repeat {
+	case RepeatOnce:
+		return day.Equal(start)
+	case RepeatDaily:
+		return daysBetween(start, day)%interval == 0
+	case RepeatWeekly:
+		weeks := daysBetween(mondayOf(start), mondayOf(day)) / 7
+		if weeks%interval != 0 {
+			return false
+		}
+		weekdays := s.Weekdays
+		if len(weekdays) == 0 {
+			weekdays = []int{isoWeekday(start)}
+		}
+		for _, weekday := range weekdays {
+			if weekday == isoWeekday(day) {
+				return true
+			}
+		}
+		return false
+	case RepeatMonthly:
+		if monthsBetween(start, day)%interval != 0 {
+			return false
+		}
+		target := start.Day()
+		if last := time.Date(day.Year(), day.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day(); target > last {
+			target = last
+		}
+		return day.Day() == target
+	}
+	return false
+}
+
+// FormatWeekdays 将星期列表转为数据库中保存的文本，如 "1,3,5"
+func FormatWeekdays(weekdays []int) string {
+	sorted := append([]int(nil), weekdays...)
+	sort.Ints(sorted)
+	parts := make([]string, 0, len(sorted))
+	for i, day := range sorted {
+		if i > 0 && day == sorted[i-1] {
+			continue
+		}
+		parts = append(parts, strconv.Itoa(day))
+	}
+	return strings.Join(parts, ",")
+}
+
+// ParseWeekdays 解析数据库中保存的星期列表
+func ParseWeekdays(value string) []int {
+	weekdays := []int{}
+	for _, part := range strings.Split(value, ",") {
+		if day, err := strconv.Atoi(strings.TrimSpace(part)); err == nil {
+			weekdays = append(weekdays, day)
+		}
+	}
+	return weekdays
+}
+
+// daysBetween 两个日期相差的天数
+func daysBetween(from, to time.Time) int {
+	return int(to.Sub(from).Hours() / 24)
+}
+
+// monthsBetween 两个日期相差的月数，不考虑日
+func monthsBetween(from, to time.Time) int {
+	return (to.Year()-from.Year())*12 + int(to.Month()) - int(from.Month())
+}
+
+// mondayOf 日期所在周的周一
+func mondayOf(day time.Time) time.Time {
+	return day.AddDate(0, 0, 1-isoWeekday(day))
+}
+
+// isoWeekday 星期，1 为周一，7 为周日
+func isoWeekday(day time.Time) int {
+	if day.Weekday() == time.Sunday {
+		return 7
+	}
+	return int(day.Weekday())
+}
@@ -0,0 +1,298 @@
+package reminders
+
+import (
+	"testing"
+	"time"
+)
+
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Skipf("time zone %s is not available: %v", name, err)
+	}
+	return loc
+}
+
+func TestScheduleNext(t *testing.T) {
+	newYork := mustLoadLocation(t, "America/New_York")
+	auckland := mustLoadLocation(t, "Pacific/Auckland")
+
+	tests := []struct {
+		name     string
+		schedule Schedule
+		after    string
+		want     []string // 依次调用 Next 得到的提醒时间（UTC），少于 5 个表示之后没有更多提醒
+	}{
+		{
+			name:     "daily across end of daylight saving time",
+			schedule: Schedule{Repeat: RepeatDaily, Interval: 1, TimeOfDay: "21:00", StartDate: "2026-10-30", Location: newYork},
+			after:    "2026-10-29T00:00:00Z",
+			want: []string{
+				"2026-10-31T01:00:00Z", // 10-30 21:00 EDT
+				"2026-11-01T01:00:00Z", // 10-31 21:00 EDT
+				"2026-11-02T02:00:00Z", // 11-01 21:00 EST
+				"2026-11-03T02:00:00Z",
+				"2026-11-04T02:00:00Z",
+			},
+		},
+		{
+			name:     "daily across start of daylight saving time in the southern hemisphere",
+			schedule: Schedule{Repeat: RepeatDaily, Interval: 1, TimeOfDay: "08:00", StartDate: "2026-09-26", Location: auckland},
+			after:    "2026-09-25T00:00:00Z",
+			want: []string{
+				"2026-09-25T20:00:00Z", // 09-26 08:00 NZST
+				"2026-09-26T19:00:00Z", // 09-27 08:00 NZDT
+				"2026-09-27T19:00:00Z",
+				"2026-09-28T19:00:00Z",
+				"2026-09-29T19:00:00Z",
+			},
+		},
+		{
+			name:     "daily every three days until end date",
+			schedule: Schedule{Repeat: RepeatDaily, Interval: 3, TimeOfDay: "09:00", StartDate: "2026-10-18", EndDate: "2026-10-25", Location: time.UTC},
+			after:    "2026-10-18T12:00:00Z",
+			want:     []string{"2026-10-21T09:00:00Z", "2026-10-24T09:00:00Z"},
+		},
+		{
+			name:     "end date is inclusive",
+			schedule: Schedule{Repeat: RepeatDaily, Interval: 1, TimeOfDay: "23:30", StartDate: "2026-10-18", EndDate: "2026-10-19", Location: time.UTC},
+			after:    "2026-10-01T00:00:00Z",
+			want:     []string{"2026-10-18T23:30:00Z", "2026-10-19T23:30:00Z"},
+		},
+		{
+			name:     "later the same day",
+			schedule: Schedule{Repeat: RepeatDaily, Interval: 1, TimeOfDay: "18:00", StartDate: "2026-10-01", Location: time.UTC},
+			after:    "2026-10-19T17:59:00Z",
+			want:     []string{"2026-10-19T18:00:00Z", "2026-10-20T18:00:00Z", "2026-10-21T18:00:00Z", "2026-10-22T18:00:00Z", "2026-10-23T18:00:00Z"},
+		},
+		{
+			name:     "every two weeks on Monday and Friday",
+			schedule: Schedule{Repeat: RepeatWeekly, Interval: 2, Weekdays: []int{1, 5}, TimeOfDay: "07:00", StartDate: "2026-10-21", Location: time.UTC},
+			after:    "2026-10-19T02:30:00Z",
+			want:     []string{"2026-10-23T07:00:00Z", "2026-11-02T07:00:00Z", "2026-11-06T07:00:00Z", "2026-11-16T07:00:00Z", "2026-11-20T07:00:00Z"},
+		},
+		{
+			name:     "weekly defaults to the weekday of the start date",
+			schedule: Schedule{Repeat: RepeatWeekly, Interval: 1, TimeOfDay: "10:00", StartDate: "2026-10-22", Location: time.UTC},
+			after:    "2026-10-19T00:00:00Z",
+			want:     []string{"2026-10-22T10:00:00Z", "2026-10-29T10:00:00Z", "2026-11-05T10:00:00Z", "2026-11-12T10:00:00Z", "2026-11-19T10:00:00Z"},
+		},
+		{
+			name:     "weekly on Sunday crosses the week boundary",
+			schedule: Schedule{Repeat: RepeatWeekly, Interval: 3, Weekdays: []int{7}, TimeOfDay: "20:00", StartDate: "2026-10-19", Location: time.UTC},
+			after:    "2026-10-19T00:00:00Z",
+			want:     []string{"2026-10-25T20:00:00Z", "2026-11-15T20:00:00Z", "2026-12-06T20:00:00Z", "2026-12-27T20:00:00Z", "2027-01-17T20:00:00Z"},
+		},
+		{
+			name:     "monthly on the 31st clamps to the end of shorter months",
+			schedule: Schedule{Repeat: RepeatMonthly, Interval: 1, TimeOfDay: "12:00", StartDate: "2026-10-31", Location: time.UTC},
+			after:    "2026-10-01T00:00:00Z",
+			want:     []string{"2026-10-31T12:00:00Z", "2026-11-30T12:00:00Z", "2026-12-31T12:00:00Z", "2027-01-31T12:00:00Z", "2027-02-28T12:00:00Z"},
+		},
+		{
+			name:     "monthly on the 30th in a leap year",
+			schedule: Schedule{Repeat: RepeatMonthly, Interval: 1, TimeOfDay: "12:00", StartDate: "2028-01-30", Location: time.UTC},
+			after:    "2028-01-01T00:00:00Z",
+			want:     []string{"2028-01-30T12:00:00Z", "2028-02-29T12:00:00Z", "2028-03-30T12:00:00Z", "2028-04-30T12:00:00Z", "2028-05-30T12:00:00Z"},
+		},
+		{
+			name:     "every three months",
+			schedule: Schedule{Repeat: RepeatMonthly, Interval: 3, TimeOfDay: "09:15", StartDate: "2026-11-30", Location: time.UTC},
+			after:    "2026-12-01T00:00:00Z",
+			want:     []string{"2027-02-28T09:15:00Z", "2027-05-30T09:15:00Z", "2027-08-30T09:15:00Z", "2027-11-30T09:15:00Z", "2028-02-29T09:15:00Z"},
+		},
+		{
+			name:     "once in the future",
+			schedule: Schedule{Repeat: RepeatOnce, Interval: 1, TimeOfDay: "08:00", StartDate: "2026-12-24", Location: newYork},
+			after:    "2026-10-19T00:00:00Z",
+			want:     []string{"2026-12-24T13:00:00Z"},
+		},
+		{
+			name:     "once in the past",
+			schedule: Schedule{Repeat: RepeatOnce, Interval: 1, TimeOfDay: "08:00", StartDate: "2026-10-01", Location: time.UTC},
+			after:    "2026-10-19T00:00:00Z",
+			want:     nil,
+		},
+		{
+			name:     "once earlier the same day",
+			schedule: Schedule{Repeat: RepeatOnce, Interval: 1, TimeOfDay: "08:00", StartDate: "2026-10-19", Location: time.UTC},
+			after:    "2026-10-19T09:00:00Z",
+			want:     nil,
+		},
+		// 最大间隔：下一次提醒在多年之后，需要直接跳过不在间隔上的周期
+		{
+			name:     "daily with the largest interval",
+			schedule: Schedule{Repeat: RepeatDaily, Interval: 365, TimeOfDay: "06:00", StartDate: "2026-01-01", Location: time.UTC},
+			after:    "2026-01-02T00:00:00Z",
+			want:     []string{"2027-01-01T06:00:00Z", "2028-01-01T06:00:00Z", "2028-12-31T06:00:00Z", "2029-12-31T06:00:00Z", "2030-12-31T06:00:00Z"},
+		},
+		{
+			name:     "weekly with the largest interval",
+			schedule: Schedule{Repeat: RepeatWeekly, Interval: 365, TimeOfDay: "06:00", StartDate: "2026-10-19", Location: time.UTC},
+			after:    "2026-10-20T00:00:00Z",
+			want:     []string{"2033-10-17T06:00:00Z", "2040-10-15T06:00:00Z", "2047-10-14T06:00:00Z", "2054-10-12T06:00:00Z", "2061-10-10T06:00:00Z"},
+		},
+		{
+			name:     "monthly with the largest interval",
+			schedule: Schedule{Repeat: RepeatMonthly, Interval: 365, TimeOfDay: "06:00", StartDate: "2026-10-15", Location: time.UTC},
+			after:    "2026-10-16T00:00:00Z",
+			want:     []string{"2057-03-15T06:00:00Z", "2087-08-15T06:00:00Z", "2118-01-15T06:00:00Z", "2148-06-15T06:00:00Z", "2178-11-15T06:00:00Z"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			after, err := time.Parse(time.RFC3339, tt.after)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for len(got) < 5 {
+				next, ok := tt.schedule.Next(after)
+				if !ok {
+					break
+				}
+				got = append(got, next.UTC().Format(time.RFC3339))
+				after = next
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("Next() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("Next() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestScheduleValidate(t *testing.T) {
+	valid := Schedule{Repeat: RepeatWeekly, Interval: 1, Weekdays: []int{1, 7}, TimeOfDay: "08:00", StartDate: "2026-10-19", Location: time.UTC}
+
+	tests := []struct {
+		name    string
+		modify  func(s *Schedule)
+		wantErr bool
+	}{
+		{"valid", func(s *Schedule) {}, false},
+		{"largest interval", func(s *Schedule) { s.Interval = 365 }, false},
+		{"end date on start date", func(s *Schedule) { s.EndDate = s.StartDate }, false},
+		{"unknown repeat", func(s *Schedule) { s.Repeat = "yearly" }, true},
+		{"zero interval", func(s *Schedule) { s.Interval = 0 }, true},
+		{"interval too large", func(s *Schedule) { s.Interval = 366 }, true},
+		{"weekday zero", func(s *Schedule) { s.Weekdays = []int{0} }, true},
+		{"weekday eight", func(s *Schedule) { s.Weekdays = []int{8} }, true},
+		{"invalid time", func(s *Schedule) { s.TimeOfDay = "24:00" }, true},
+		{"time with seconds", func(s *Schedule) { s.TimeOfDay = "08:00:00" }, true},
+		{"invalid start date", func(s *Schedule) { s.StartDate = "2026-02-30" }, true},
+		{"invalid end date", func(s *Schedule) { s.EndDate = "tomorrow" }, true},
+		{"end date before start date", func(s *Schedule) { s.EndDate = "2026-10-18" }, true},
+		{"missing location", func(s *Schedule) { s.Location = nil }, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := valid
+			s.Weekdays = append([]int(nil), valid.Weekdays...)
+			tt.modify(&s)
+			err := s.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestScheduleRRule(t *testing.T) {
+	newYork := mustLoadLocation(t, "America/New_York")
+
+	tests := []struct {
+		name      string
+		schedule  Schedule
+		wantRRule string
+		wantFirst string
+	}{
+		{
+			name:      "daily",
+			schedule:  Schedule{Repeat: RepeatDaily, Interval: 1, TimeOfDay: "21:00", StartDate: "2026-10-30", Location: newYork},
+			wantRRule: "FREQ=DAILY",
+			wantFirst: "2026-10-31T01:00:00Z",
+		},
+		{
+			name:      "every three days until end date",
+			schedule:  Schedule{Repeat: RepeatDaily, Interval: 3, TimeOfDay: "09:00", StartDate: "2026-10-18", EndDate: "2026-11-20", Location: newYork},
+			wantRRule: "FREQ=DAILY;INTERVAL=3;UNTIL=20261120T140000Z",
+			wantFirst: "2026-10-18T13:00:00Z",
+		},
+		{
+			name:      "every two weeks on Monday and Friday",
+			schedule:  Schedule{Repeat: RepeatWeekly, Interval: 2, Weekdays: []int{5, 1}, TimeOfDay: "07:00", StartDate: "2026-10-21", Location: time.UTC},
+			wantRRule: "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,FR;WKST=MO",
+			wantFirst: "2026-10-23T07:00:00Z",
+		},
+		{
+			name:      "weekly on the weekday of the start date",
+			schedule:  Schedule{Repeat: RepeatWeekly, Interval: 1, TimeOfDay: "10:00", StartDate: "2026-10-25", Location: time.UTC},
+			wantRRule: "FREQ=WEEKLY;BYDAY=SU;WKST=MO",
+			wantFirst: "2026-10-25T10:00:00Z",
+		},
+		{
+			name:      "monthly",
+			schedule:  Schedule{Repeat: RepeatMonthly, Interval: 3, TimeOfDay: "09:15", StartDate: "2026-11-15", Location: time.UTC},
+			wantRRule: "FREQ=MONTHLY;INTERVAL=3;BYMONTHDAY=15",
+			wantFirst: "2026-11-15T09:15:00Z",
+		},
+		{
+			name:      "monthly on the 30th clamps to the end of shorter months",
+			schedule:  Schedule{Repeat: RepeatMonthly, Interval: 1, TimeOfDay: "12:00", StartDate: "2028-01-30", Location: time.UTC},
+			wantRRule: "FREQ=MONTHLY;BYMONTHDAY=28,29,30;BYSETPOS=-1",
+			wantFirst: "2028-01-30T12:00:00Z",
+		},
+		{
+			name:      "once",
+			schedule:  Schedule{Repeat: RepeatOnce, Interval: 1, TimeOfDay: "08:00", StartDate: "2026-12-24", Location: newYork},
+			wantRRule: "",
+			wantFirst: "2026-12-24T13:00:00Z",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.schedule.RRule(); got != tt.wantRRule {
+				t.Errorf("RRule() = %q, want %q", got, tt.wantRRule)
+			}
+			first, ok := tt.schedule.First()
+			if !ok {
+				t.Fatal("First() returned no occurrence")
+			}
+			if got := first.UTC().Format(time.RFC3339); got != tt.wantFirst {
+				t.Errorf("First() = %s, want %s", got, tt.wantFirst)
+			}
+		})
+	}
+}
+
+func TestWeekdaysRoundTrip(t *testing.T) {
+	tests := []struct {
+		weekdays []int
+		want     string
+	}{
+		{[]int{5, 1, 3}, "1,3,5"},
+		{[]int{7, 7, 1}, "1,7"},
+		{nil, ""},
+	}
+
+	for _, tt := range tests {
+		got := FormatWeekdays(tt.weekdays)
+		if got != tt.want {
+			t.Errorf("FormatWeekdays(%v) = %q, want %q", tt.weekdays, got, tt.want)
+		}
+		if parsed := FormatWeekdays(ParseWeekdays(got)); parsed != tt.want {
+			t.Errorf("FormatWeekdays(ParseWeekdays(%q)) = %q", got, parsed)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+// Package reminders 定时提醒：按重复规则和时区计算下一次提醒时间，到期后通过各投递方式发送
+//
+// 提醒可以提醒自己，也可以提醒同一家庭的其他成员；可以关联规则或购买的商品。
+// DeliverDue 作为定时任务运行，错过的提醒只补发一次后跳到下一个时间。
+// 投递、稍后提醒和确认都记录在 reminder_events 中。
+package reminders
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"booonus-backend/internal/database"
+	"booonus-backend/internal/permissions"
+	"booonus-backend/pkg/logger"
+)
+
+// 关联对象类型
+const (
+	TargetRule    = "rule"    // 规则，如提醒记录家务
+	TargetVoucher = "voucher" // 购买的商品（transactions），如提醒兑现
+)
+
+// 提醒记录的操作
+const (
+	ActionDelivered    = "delivered"
+	ActionSnoozed      = "snoozed"
+	ActionAcknowledged = "acknowledged"
+)
+
+// batchSize 每次最多处理的到期提醒数量
+const batchSize = 200
+
+// Reminder 一条提醒
+type Reminder struct {
+	ID           int        `json:"id"`
+	CoupleID     *int       `json:"couple_id"`
+	CreatedBy    int        `json:"created_by"`
+	UserID       int        `json:"user_id"`
+	Title        string     `json:"title"`
+	Message      string     `json:"message"`
+	TargetType   string     `json:"target_type,omitempty"`
+	TargetID     *int       `json:"target_id"`
+	Repeat       string     `json:"repeat"`
+	Interval     int        `json:"interval"`
+	Weekdays     []int      `json:"weekdays"`
+	TimeOfDay    string     `json:"time"`
+	StartDate    string     `json:"start_date"`
+	EndDate      string     `json:"end_date,omitempty"`
+	Timezone     string     `json:"timezone"`
+	NextRunAt    *time.Time `json:"next_run_at"`
+	SnoozedUntil *time.Time `json:"snoozed_until"`
+	LastSentAt   *time.Time `json:"last_sent_at"`
+	IsActive     bool       `json:"is_active"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// Columns 查询提醒时的列，顺序与 Scan 一致
+const Columns = `id, couple_id, created_by, user_id, title, message, target_type, target_id,
+	repeat, repeat_interval, weekdays, time_of_day, start_date, end_date, timezone,
+	next_run_at, snoozed_until, last_sent_at, is_active, created_at, updated_at`
+
+// scanner 可以是 *sql.Row 或 *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// Scan 读取一行 Columns
+func Scan(row scanner) (Reminder, error) {
+	var r Reminder
+	var message, targetType, weekdays, endDate *string
+	err := row.Scan(&r.ID, &r.CoupleID, &r.CreatedBy, &r.UserID, &r.Title, &message, &targetType, &r.TargetID,
+		&r.Repeat, &r.Interval, &weekdays, &r.TimeOfDay, &r.StartDate, &endDate, &r.Timezone,
+		&r.NextRunAt, &r.SnoozedUntil, &r.LastSentAt, &r.IsActive, &r.CreatedAt, &r.UpdatedAt)
+	if err != nil {
+		return r, err
+	}
+	if message != nil {
+		r.Message = *message
+	}
+	if targetType != nil {
+		r.TargetType = *targetType
+	}
+	r.Weekdays = []int{}
+	if weekdays != nil {
+		r.Weekdays = ParseWeekdays(*weekdays)
+	}
+	if endDate != nil {
+		r.EndDate = *endDate
+	}
+	return r, nil
+}
+
+// Schedule 提醒的重复规则
+func (r Reminder) Schedule() (Schedule, error) {
+	loc, err := time.LoadLocation(r.Timezone)
+	if err != nil {
+		return Schedule{}, err
+	}
+	return Schedule{
+		Repeat:    r.Repeat,
+		Interval:  r.Interval,
+		Weekdays:  r.Weekdays,
+		TimeOfDay: r.TimeOfDay,
+		StartDate: r.StartDate,
+		EndDate:   r.EndDate,
+		Location:  loc,
+	}, nil
+}
+
+// Delivery 一次到期的提醒
+type Delivery struct {
+	Reminder   Reminder
+	Occurrence time.Time // 本次提醒对应的时间（计划时间或稍后提醒的时间）
+}
+
+// Deliverer 提醒的投递方式
+type Deliverer interface {
+	Name() string
+	Deliver(d Delivery) error
+}
+
+var (
+	deliverersMu sync.RWMutex
+	deliverers   = []Deliverer{InApp{}, Log{}}
+)
+
+// SetDeliverers 替换投递方式，默认为站内通知和日志
+func SetDeliverers(ds ...Deliverer) {
+	deliverersMu.Lock()
+	defer deliverersMu.Unlock()
+	deliverers = ds
+}
+
+// currentDeliverers 获取当前的投递方式
+func currentDeliverers() []Deliverer {
+	deliverersMu.RLock()
+	defer deliverersMu.RUnlock()
+	return append([]Deliverer(nil), deliverers...)
+}
+
+// RecordEvent 记录一次投递、稍后提醒或确认
+func RecordEvent(reminderID, userID int, action string, occurrence, snoozedUntil *time.Time, channel, errText string) error {
+	_, err := database.DB.Exec(
+		`INSERT INTO reminder_events (reminder_id, user_id, action, occurrence_at, snoozed_until, channel, error)
+		 VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		reminderID, userID, action, dbTimeOrNil(occurrence), dbTimeOrNil(snoozedUntil), nullIfEmpty(channel), nullIfEmpty(errText),
+	)
+	return err
+}
+
+// DeliverDue 定时任务：投递到期的提醒并计算下一次提醒时间
+func DeliverDue() {
+	now := time.Now()
+	nowText := now.UTC().Format(database.TimeLayout)
+
+	rows, err := database.DB.Query(`
+		SELECT `+Columns+`
+		FROM reminders
+		WHERE is_active = TRUE
+		  AND ((next_run_at IS NOT NULL AND next_run_at <= ?) OR (snoozed_until IS NOT NULL AND snoozed_until <= ?))
+		ORDER BY id
+		LIMIT ?`,
+		nowText, nowText, batchSize,
+	)
+	if err != nil {
+		logger.Error("Failed to get due reminders: " + err.Error())
+		return
+	}
+	var due []Reminder
+	for rows.Next() {
+		r, err := Scan(rows)
+		if err != nil {
+			logger.Error("Failed to scan reminder: " + err.Error())
+			continue
+		}
+		due = append(due, r)
+	}
+	rows.Close()
+
+	for _, r := range due {
+		deliver(r, now)
+	}
+}
+
+// deliver 投递一条到期的提醒，然后更新下一次提醒时间
+func deliver(r Reminder, now time.Time) {
+	// 提醒其他成员时，双方不再是同一家庭的成员就停用
+	if r.CreatedBy != r.UserID && !permissions.SameHousehold(r.CreatedBy, r.UserID) {
+		if _, err := database.DB.Exec("UPDATE reminders SET is_active = FALSE, updated_at = CURRENT_TIMESTAMP WHERE id = ?", r.ID); err != nil {
+			logger.Error("Failed to deactivate reminder: " + err.Error())
+		}
+		return
+	}
+
+	scheduleDue := r.NextRunAt != nil && !r.NextRunAt.After(now)
+	occurrence := now
+	if scheduleDue {
+		occurrence = *r.NextRunAt
+	} else if r.SnoozedUntil != nil {
+		occurrence = *r.SnoozedUntil
+	}
+
+	for _, d := range currentDeliverers() {
+		errText := ""
+		if err := d.Deliver(Delivery{Reminder: r, Occurrence: occurrence}); err != nil {
+			logger.Error("Failed to deliver reminder " + strconv.Itoa(r.ID) + " via " + d.Name() + ": " + err.Error())
+			errText = err.Error()
+		}
+		if err := RecordEvent(r.ID, r.UserID, ActionDelivered, &occurrence, nil, d.Name(), errText); err != nil {
+			logger.Error("Failed to record reminder event: " + err.Error())
+		}
+	}
+
+	// 错过的提醒已经补发了一次，下一次从现在开始计算
+	next := r.NextRunAt
+	if scheduleDue {
+		next = nil
+		if schedule, err := r.Schedule(); err == nil {
+			if at, ok := schedule.Next(now); ok {
+				next = &at
+			}
+		}
+	}
+	_, err := database.DB.Exec(
+		"UPDATE reminders SET next_run_at = ?, snoozed_until = NULL, last_sent_at = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?",
+		dbTimeOrNil(next), now.UTC().Format(database.TimeLayout), r.ID,
+	)
+	if err != nil {
+		logger.Error("Failed to update reminder: " + err.Error())
+	}
+}
+
+// dbTimeOrNil 转换为数据库中的存储格式，nil 时为 NULL
+func dbTimeOrNil(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UTC().Format(database.TimeLayout)
+}
+
+// nullIfEmpty 空字符串存为 NULL
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
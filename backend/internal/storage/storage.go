@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNotFound 对象不存在
+var ErrNotFound = errors.New("blob not found")
+
+// BlobStore 二进制对象存储接口
+type BlobStore interface {
+	// Put 写入对象，key 已存在时覆盖
+	Put(key string, r io.Reader) error
+	// Get 读取对象，调用方负责关闭
+	Get(key string) (io.ReadCloser, error)
+	// Delete 删除对象，对象不存在时不报错
+	Delete(key string) error
+}
+
+// Blobs 全局对象存储实例
+var Blobs BlobStore
+
+// Init 初始化对象存储
+func Init() error {
+	dir := os.Getenv("BLOB_STORAGE_DIR")
+	if dir == "" {
+		dir = "uploads"
+	}
+
+	store, err := NewLocalStore(dir)
+	if err != nil {
+		return err
+	}
+	Blobs = store
+	return nil
+}
+
+// LocalStore 基于本地文件系统的对象存储
+type LocalStore struct {
+	root string
+}
+
+// NewLocalStore 创建本地文件系统存储，root 目录不存在时自动创建
+func NewLocalStore(root string) (*LocalStore, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	return &LocalStore{root: root}, nil
+}
+
+// path 将 key 转换为存储目录下的文件路径，拒绝跳出根目录的 key
+func (s *LocalStore) path(key string) (string, error) {
+	cleaned := filepath.Clean("/" + key)
+	if cleaned == "/" || strings.Contains(key, "..") {
+		return "", errors.New("invalid blob key")
+	}
+	return filepath.Join(s.root, cleaned), nil
+}
+
+// Put 写入对象
+func (s *LocalStore) Put(key string, r io.Reader) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	// 先写临时文件再重命名，避免读到写了一半的对象
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Get 读取对象
+func (s *LocalStore) Get(key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+// Delete 删除对象
+func (s *LocalStore) Delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
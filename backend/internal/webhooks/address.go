@@ -0,0 +1,103 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+
+	"booonus-backend/pkg/logger"
+)
+
+// 地址检查的错误
+var (
+	ErrInvalidURL        = errors.New("URL must be an absolute http or https URL")
+	ErrUnresolvableHost  = errors.New("URL host could not be resolved")
+	ErrAddressNotAllowed = errors.New("URL must not point to a private, loopback or link-local address")
+)
+
+// 解析和建立连接的超时
+const (
+	resolveTimeout = 5 * time.Second
+	dialTimeout    = 5 * time.Second
+)
+
+// allowPrivateAddresses 是否允许投递到内网地址，见 Init
+var allowPrivateAddresses bool
+
+// Init 读取配置：WEBHOOK_ALLOW_PRIVATE=true 时允许投递到内网、本机和链路本地地址，只应在开发和测试中使用
+func Init() {
+	allowPrivateAddresses, _ = strconv.ParseBool(os.Getenv("WEBHOOK_ALLOW_PRIVATE"))
+	if allowPrivateAddresses {
+		logger.Info("Webhooks may be delivered to private addresses")
+	}
+}
+
+// ValidateURL 检查订阅地址：必须是 http 或 https 的绝对地址，主机解析出的所有地址都必须是公网地址
+// 解析结果可能在之后改变（DNS 重新绑定），投递时连接前还会检查实际连接的地址，见 newClient
+func ValidateURL(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return ErrInvalidURL
+	}
+	if allowPrivateAddresses {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, resolveTimeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil || len(addrs) == 0 {
+		return ErrUnresolvableHost
+	}
+	for _, addr := range addrs {
+		if !allowedIP(addr.IP) {
+			return ErrAddressNotAllowed
+		}
+	}
+	return nil
+}
+
+// allowedIP 检查是否允许连接该地址
+func allowedIP(ip net.IP) bool {
+	if allowPrivateAddresses {
+		return true
+	}
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified())
+}
+
+// newClient 投递使用的 HTTP 客户端：不使用代理，建立连接前检查解析后的实际地址，
+// 不跟随重定向，3xx 视为失败
+func newClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: dialTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !allowedIP(ip) {
+				return ErrAddressNotAllowed
+			}
+			return nil
+		},
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{
+		Timeout:   RequestTimeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
@@ -0,0 +1,402 @@
+// Package webhooks 向家庭配置的地址推送活动事件
+//
+// 事件与产生它的操作在同一个事务中写入 webhook_deliveries（发件箱），进程崩溃也不会丢失；
+// 后台任务按订阅并发、同一订阅内按顺序投递待发送的记录，失败时按指数退避重试，每次尝试都记录在
+// webhook_delivery_attempts 中。请求体用订阅的密钥做 HMAC-SHA256 签名，见 Sign。
+// 默认不投递到内网、本机和链路本地地址，见 ValidateURL 和 Init。
+package webhooks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"booonus-backend/internal/database"
+	"booonus-backend/pkg/logger"
+)
+
+// 投递参数
+const (
+	MaxAttempts     = 8                // 超过后标记为 failed，只能手动重新投递
+	RetryBaseDelay  = 30 * time.Second // 第 n 次失败后等待 RetryBaseDelay * 2^(n-1)
+	RetryMaxDelay   = 6 * time.Hour
+	RequestTimeout  = 10 * time.Second
+	BatchSize       = 50                  // 每次任务最多投递的记录数
+	DeliveryWorkers = 8                   // 同时投递的订阅数
+	Retention       = 30 * 24 * time.Hour // 已结束的投递记录保留期限
+	maxResponseBody = 1024                // 投递日志中保存的响应内容长度
+)
+
+// 请求头
+const (
+	HeaderEvent     = "X-Booonus-Event"
+	HeaderDelivery  = "X-Booonus-Delivery"
+	HeaderTimestamp = "X-Booonus-Timestamp"
+	HeaderSignature = "X-Booonus-Signature"
+)
+
+// EventPing 测试订阅时发送的事件类型
+const EventPing = "ping"
+
+// client 投递使用的 HTTP 客户端
+var client = newClient()
+
+// Payload 推送的请求体
+type Payload struct {
+	ID        string      `json:"id"` // 事件ID，重新投递时不变，可用于去重
+	Event     string      `json:"event"`
+	CoupleID  int         `json:"couple_id"`
+	ActorID   int         `json:"actor_id"`
+	Data      interface{} `json:"data"`
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+// Sign 计算签名：对 "时间戳.请求体" 做 HMAC-SHA256，结果为 "sha256=<hex>"
+// 接收方用同样的方式计算并比较 X-Booonus-Signature，同时检查时间戳防止重放
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewSecret 生成签名密钥
+func NewSecret() (string, error) {
+	return randomHex(24)
+}
+
+// Matches 检查订阅的事件类型过滤是否包含该事件，过滤为空表示全部事件
+func Matches(eventTypes, eventType string) bool {
+	if eventTypes == "" {
+		return true
+	}
+	for _, t := range strings.Split(eventTypes, ",") {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Enqueue 在事务中为家庭所有启用且匹配的订阅写入待投递记录，coupleID 为 0 时不处理
+func Enqueue(tx *sql.Tx, coupleID, actorID int, eventType string, data interface{}) error {
+	if coupleID == 0 {
+		return nil
+	}
+
+	rows, err := tx.Query("SELECT id, event_types FROM webhooks WHERE couple_id = ? AND is_active = TRUE", coupleID)
+	if err != nil {
+		return err
+	}
+	var webhookIDs []int
+	for rows.Next() {
+		var id int
+		var eventTypes string
+		if err := rows.Scan(&id, &eventTypes); err != nil {
+			rows.Close()
+			return err
+		}
+		if Matches(eventTypes, eventType) {
+			webhookIDs = append(webhookIDs, id)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(webhookIDs) == 0 {
+		return err
+	}
+
+	eventID, payload, err := newPayload(coupleID, actorID, eventType, data)
+	if err != nil {
+		return err
+	}
+	for _, webhookID := range webhookIDs {
+		if _, err := insertDelivery(tx, webhookID, eventID, eventType, payload, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Ping 为订阅写入一条测试事件，不检查订阅是否启用，返回投递记录ID
+func Ping(webhookID, coupleID, actorID int) (int64, error) {
+	eventID, payload, err := newPayload(coupleID, actorID, EventPing, map[string]interface{}{"webhook_id": webhookID})
+	if err != nil {
+		return 0, err
+	}
+	return insertDelivery(database.DB, webhookID, eventID, EventPing, payload, nil)
+}
+
+// Redeliver 复制一条投递记录重新发送，事件ID和请求体不变，返回新的投递记录ID
+func Redeliver(deliveryID int) (int64, error) {
+	var webhookID int
+	var eventID, eventType, payload string
+	err := database.DB.QueryRow(
+		"SELECT webhook_id, event_id, event_type, payload FROM webhook_deliveries WHERE id = ?",
+		deliveryID,
+	).Scan(&webhookID, &eventID, &eventType, &payload)
+	if err != nil {
+		return 0, err
+	}
+	return insertDelivery(database.DB, webhookID, eventID, eventType, payload, &deliveryID)
+}
+
+// execer database.DB 和 *sql.Tx 共有的方法
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// insertDelivery 写入一条立即可投递的记录
+func insertDelivery(db execer, webhookID int, eventID, eventType, payload string, redeliveryOf *int) (int64, error) {
+	result, err := db.Exec(
+		"INSERT INTO webhook_deliveries (webhook_id, event_id, event_type, payload, next_attempt_at, redelivery_of) VALUES (?, ?, ?, ?, ?, ?)",
+		webhookID, eventID, eventType, payload, time.Now().UTC().Format(database.TimeLayout), redeliveryOf,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// newPayload 生成事件ID和请求体
+func newPayload(coupleID, actorID int, eventType string, data interface{}) (string, string, error) {
+	eventID, err := randomHex(16)
+	if err != nil {
+		return "", "", err
+	}
+	body, err := json.Marshal(Payload{
+		ID:        eventID,
+		Event:     eventType,
+		CoupleID:  coupleID,
+		ActorID:   actorID,
+		Data:      data,
+		CreatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return eventID, string(body), nil
+}
+
+// pendingDelivery 一条待投递的记录
+type pendingDelivery struct {
+	ID        int
+	WebhookID int
+	EventType string
+	Payload   string
+	Attempts  int
+	URL       string
+	Secret    string
+}
+
+// DeliverPending 投递所有到期的记录，由定时任务调用
+// 不同订阅最多 DeliveryWorkers 个并发投递，一个订阅的地址无响应不会拖慢其他订阅；
+// 停用的订阅的记录保持待投递，重新启用后继续发送
+func DeliverPending() {
+	rows, err := database.DB.Query(`
+		SELECT d.id, d.webhook_id, d.event_type, d.payload, d.attempts, w.url, w.secret
+		FROM webhook_deliveries d
+		JOIN webhooks w ON w.id = d.webhook_id
+		WHERE d.status = 'pending' AND d.next_attempt_at <= ? AND w.is_active = TRUE
+		ORDER BY d.next_attempt_at ASC, d.id ASC
+		LIMIT ?`,
+		time.Now().UTC().Format(database.TimeLayout), BatchSize,
+	)
+	if err != nil {
+		logger.Error("Failed to get pending webhook deliveries: " + err.Error())
+		return
+	}
+	byWebhook := map[int][]pendingDelivery{}
+	var webhookIDs []int
+	for rows.Next() {
+		var d pendingDelivery
+		if err := rows.Scan(&d.ID, &d.WebhookID, &d.EventType, &d.Payload, &d.Attempts, &d.URL, &d.Secret); err != nil {
+			logger.Error("Failed to scan webhook delivery: " + err.Error())
+			continue
+		}
+		if byWebhook[d.WebhookID] == nil {
+			webhookIDs = append(webhookIDs, d.WebhookID)
+		}
+		byWebhook[d.WebhookID] = append(byWebhook[d.WebhookID], d)
+	}
+	rows.Close()
+
+	workers := make(chan struct{}, DeliveryWorkers)
+	var wg sync.WaitGroup
+	for _, webhookID := range webhookIDs {
+		deliveries := byWebhook[webhookID]
+		workers <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-workers }()
+			deliverWebhook(deliveries)
+		}()
+	}
+	wg.Wait()
+}
+
+// deliverWebhook 按顺序投递同一订阅的记录
+// 请求没有得到响应（连接失败、超时）时跳过剩余的记录，留到下次任务，避免每条记录都等到超时
+func deliverWebhook(deliveries []pendingDelivery) {
+	for _, d := range deliveries {
+		responded, err := deliver(d)
+		if err != nil {
+			logger.Error("Failed to record webhook delivery " + strconv.Itoa(d.ID) + ": " + err.Error())
+		}
+		if !responded {
+			return
+		}
+	}
+}
+
+// deliver 发送一次请求并记录结果，返回是否收到了响应；返回的错误只表示记录失败
+func deliver(d pendingDelivery) (bool, error) {
+	attempt := d.Attempts + 1
+	statusCode, responseBody, duration, sendErr := send(d)
+
+	var errText interface{}
+	var code interface{}
+	if statusCode != 0 {
+		code = statusCode
+	}
+	succeeded := sendErr == nil && statusCode >= 200 && statusCode < 300
+	if !succeeded {
+		if sendErr != nil {
+			errText = sendErr.Error()
+		} else {
+			errText = "Unexpected status code " + strconv.Itoa(statusCode)
+		}
+	}
+
+	responded := sendErr == nil
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return responded, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		"INSERT INTO webhook_delivery_attempts (delivery_id, attempt, status_code, error, response_body, duration_ms) VALUES (?, ?, ?, ?, ?, ?)",
+		d.ID, attempt, code, errText, responseBody, duration.Milliseconds(),
+	)
+	if err != nil {
+		return responded, err
+	}
+
+	now := time.Now().UTC()
+	switch {
+	case succeeded:
+		_, err = tx.Exec(
+			"UPDATE webhook_deliveries SET status = 'succeeded', attempts = ?, last_status_code = ?, last_error = NULL, next_attempt_at = NULL, delivered_at = ? WHERE id = ?",
+			attempt, code, now.Format(database.TimeLayout), d.ID,
+		)
+	case attempt >= MaxAttempts:
+		_, err = tx.Exec(
+			"UPDATE webhook_deliveries SET status = 'failed', attempts = ?, last_status_code = ?, last_error = ?, next_attempt_at = NULL WHERE id = ?",
+			attempt, code, errText, d.ID,
+		)
+	default:
+		_, err = tx.Exec(
+			"UPDATE webhook_deliveries SET attempts = ?, last_status_code = ?, last_error = ?, next_attempt_at = ? WHERE id = ?",
+			attempt, code, errText, now.Add(RetryDelay(attempt)).Format(database.TimeLayout), d.ID,
+		)
+	}
+	if err != nil {
+		return responded, err
+	}
+	return responded, tx.Commit()
+}
+
+// send 发送请求，返回状态码、截断的响应内容和耗时
+func send(d pendingDelivery) (int, string, time.Duration, error) {
+	body := []byte(d.Payload)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequest(http.MethodPost, d.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, "", 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Booonus-Webhook/1.0")
+	req.Header.Set(HeaderEvent, d.EventType)
+	req.Header.Set(HeaderDelivery, strconv.Itoa(d.ID))
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Sign(d.Secret, timestamp, body))
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, "", time.Since(start), err
+	}
+	defer resp.Body.Close()
+
+	responseBody, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+	return resp.StatusCode, string(responseBody), time.Since(start), nil
+}
+
+// RetryDelay 第 attempt 次尝试失败后到下次重试的等待时间
+func RetryDelay(attempt int) time.Duration {
+	delay := RetryBaseDelay
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+		if delay >= RetryMaxDelay {
+			return RetryMaxDelay
+		}
+	}
+	return delay
+}
+
+// Cleanup 删除超过保留期限的已结束投递记录及其尝试记录
+func Cleanup() {
+	cutoff := time.Now().UTC().Add(-Retention).Format(database.TimeLayout)
+	finished := "SELECT id FROM webhook_deliveries WHERE status != 'pending' AND created_at < ?"
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		logger.Error("Failed to begin transaction: " + err.Error())
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec("UPDATE webhook_deliveries SET redelivery_of = NULL WHERE redelivery_of IN ("+finished+")", cutoff); err != nil {
+		logger.Error("Failed to clean up webhook deliveries: " + err.Error())
+		return
+	}
+	if _, err = tx.Exec("DELETE FROM webhook_delivery_attempts WHERE delivery_id IN ("+finished+")", cutoff); err != nil {
+		logger.Error("Failed to clean up webhook delivery attempts: " + err.Error())
+		return
+	}
+	result, err := tx.Exec("DELETE FROM webhook_deliveries WHERE status != 'pending' AND created_at < ?", cutoff)
+	if err != nil {
+		logger.Error("Failed to clean up webhook deliveries: " + err.Error())
+		return
+	}
+	if err = tx.Commit(); err != nil {
+		logger.Error("Failed to commit transaction: " + err.Error())
+		return
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		logger.Info("Cleaned up " + strconv.FormatInt(n, 10) + " webhook deliveries")
+	}
+}
+
+// randomHex 生成 n 字节的随机十六进制字符串
+func randomHex(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -2,6 +2,8 @@ package models
 
 import (
 	"time"
+
+	"booonus-backend/internal/expr"
 )
 
 // User 用户模型
@@ -17,11 +19,22 @@ type User struct {
 }
 
 // Couple 情侣关系模型
+// 情侣关系即家庭，成员见 household_members，User1ID/User2ID 为创建时的两人
 type Couple struct {
 	ID        int       `json:"id" db:"id"`
 	User1ID   int       `json:"user1_id" db:"user1_id"`
 	User2ID   int       `json:"user2_id" db:"user2_id"`
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	// 情侣设置
+	EventEditPolicy      string `json:"event_edit_policy" db:"event_edit_policy"`           // "creator", "both"
+	RevertWindowHours    int    `json:"revert_window_hours" db:"revert_window_hours"`       // 记录创建后多少小时内可以撤销，0 表示不限
+	RevertReasonRequired bool   `json:"revert_reason_required" db:"revert_reason_required"` // 撤销时是否必须填写原因
+	RevertLimit          int    `json:"revert_limit" db:"revert_limit"`                     // 每条记录最多撤销的次数，0 表示不限
+	// 解除关系后归档
+	Status    string     `json:"status" db:"status"` // "active", "ended"
+	EndedAt   *time.Time `json:"ended_at" db:"ended_at"`
+	EndedBy   *int       `json:"ended_by" db:"ended_by"`
+	SettledAt *time.Time `json:"settled_at" db:"settled_at"` // 恢复期结束、兑换券已结算的时间
 }
 
 // Shop 小卖部商品模型
@@ -31,6 +44,7 @@ type Shop struct {
 	Name        string    `json:"name" db:"name"`
 	Description string    `json:"description" db:"description"`
 	Price       int       `json:"price" db:"price"`
+	Stock       *int      `json:"stock" db:"stock"` // 剩余库存，为空表示不限量
 	IsActive    bool      `json:"is_active" db:"is_active"`
 	CreatedAt   time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
@@ -38,32 +52,101 @@ type Shop struct {
 
 // Rule 规则模型
 type Rule struct {
-	ID          int       `json:"id" db:"id"`
-	CoupleID    int       `json:"couple_id" db:"couple_id"`
-	Name        string    `json:"name" db:"name"`
-	Description string    `json:"description" db:"description"`
-	Points      int       `json:"points" db:"points"`           // 正数为奖励，负数为惩罚
-	TargetType  string    `json:"target_type" db:"target_type"` // "user1", "user2", "both"
-	IsActive    bool      `json:"is_active" db:"is_active"`
-	CreatedAt   time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+	ID           int       `json:"id" db:"id"`
+	CoupleID     int       `json:"couple_id" db:"couple_id"`
+	Name         string    `json:"name" db:"name"`
+	Description  string    `json:"description" db:"description"`
+	Points       int       `json:"points" db:"points"`           // 正数为奖励，负数为惩罚
+	TargetType   string    `json:"target_type" db:"target_type"` // "member", "any", "all"
+	IsActive     bool      `json:"is_active" db:"is_active"`
+	TargetUserID *int      `json:"target_user_id" db:"target_user_id"` // 目标为 member 时指定的家庭成员
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at" db:"updated_at"`
+	// 积分表达式，设置后执行时按表达式计算积分，Points 作为表达式中的基础积分
+	PointsExpression *string      `json:"points_expression" db:"points_expression"`
+	Parameters       []expr.Param `json:"parameters" db:"parameters"` // 执行时需要提供的输入参数
 	// 置顶相关字段（仅在查询时填充，不存储在rules表中）
 	IsPinned *bool      `json:"is_pinned,omitempty"`
 	PinnedAt *time.Time `json:"pinned_at,omitempty"`
 }
 
+// RuleRevision 规则版本（每次修改生成一个不可变快照）
+type RuleRevision struct {
+	ID           int       `json:"id" db:"id"`
+	RuleID       int       `json:"rule_id" db:"rule_id"`
+	Revision     int       `json:"revision" db:"revision"`
+	Name         string    `json:"name" db:"name"`
+	Description  string    `json:"description" db:"description"`
+	Points       int       `json:"points" db:"points"`
+	TargetType   string    `json:"target_type" db:"target_type"`
+	TargetUserID *int      `json:"target_user_id" db:"target_user_id"`
+	IsActive     bool      `json:"is_active" db:"is_active"`
+	ChangeType   string    `json:"change_type" db:"change_type"`     // "create", "update", "delete", "restore", "deactivate"
+	RestoredFrom *int      `json:"restored_from" db:"restored_from"` // 恢复自哪个版本号
+	ChangedBy    *int      `json:"changed_by" db:"changed_by"`       // 修改人，旧数据补录时为空
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+	// 该版本的积分表达式和参数声明
+	PointsExpression *string      `json:"points_expression" db:"points_expression"`
+	Parameters       []expr.Param `json:"parameters" db:"parameters"`
+}
+
 // Event 事件模型
 type Event struct {
+	ID          int        `json:"id" db:"id"`
+	CoupleID    int        `json:"couple_id" db:"couple_id"`
+	CreatorID   int        `json:"creator_id" db:"creator_id"`
+	TargetID    int        `json:"target_id" db:"target_id"`
+	Name        string     `json:"name" db:"name"`
+	Description string     `json:"description" db:"description"`
+	Points      int        `json:"points" db:"points"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt   *time.Time `json:"updated_at" db:"updated_at"` // 最后修改时间，未修改过为空
+	DeletedAt   *time.Time `json:"deleted_at" db:"deleted_at"` // 删除时间（软删除）
+	DeletedBy   *int       `json:"deleted_by" db:"deleted_by"`
+}
+
+// EventRevision 事件版本（创建、每次修改和删除时的快照）
+type EventRevision struct {
 	ID          int       `json:"id" db:"id"`
-	CoupleID    int       `json:"couple_id" db:"couple_id"`
-	CreatorID   int       `json:"creator_id" db:"creator_id"`
-	TargetID    int       `json:"target_id" db:"target_id"`
+	EventID     int       `json:"event_id" db:"event_id"`
+	Revision    int       `json:"revision" db:"revision"`
 	Name        string    `json:"name" db:"name"`
 	Description string    `json:"description" db:"description"`
 	Points      int       `json:"points" db:"points"`
+	ChangeType  string    `json:"change_type" db:"change_type"` // "create", "update", "delete"
+	ChangedBy   *int      `json:"changed_by" db:"changed_by"`
 	CreatedAt   time.Time `json:"created_at" db:"created_at"`
 }
 
+// Comment 评论，可以挂在事件、积分记录或交易上
+type Comment struct {
+	ID         int        `json:"id" db:"id"`
+	TargetType string     `json:"target_type" db:"target_type"` // "event", "points_history", "transaction"
+	TargetID   int        `json:"target_id" db:"target_id"`
+	UserID     int        `json:"user_id" db:"user_id"`
+	Content    string     `json:"content" db:"content"` // 已删除的评论内容为空
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt  *time.Time `json:"updated_at" db:"updated_at"`
+	DeletedAt  *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
+}
+
+// Reaction 表情回应
+type Reaction struct {
+	ID         int       `json:"id" db:"id"`
+	TargetType string    `json:"target_type" db:"target_type"` // "event", "points_history", "transaction"
+	TargetID   int       `json:"target_id" db:"target_id"`
+	UserID     int       `json:"user_id" db:"user_id"`
+	Emoji      string    `json:"emoji" db:"emoji"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+}
+
+// ReactionCount 某个表情的回应汇总
+type ReactionCount struct {
+	Emoji   string `json:"emoji"`
+	Count   int    `json:"count"`
+	UserIDs []int  `json:"user_ids"`
+}
+
 // Transaction 交易记录模型
 type Transaction struct {
 	ID         int       `json:"id" db:"id"`
@@ -73,6 +156,9 @@ type Transaction struct {
 	Points     int       `json:"points" db:"points"`
 	Status     string    `json:"status" db:"status"` // "completed", "cancelled"
 	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+	// 卖家兑现商品（兑换券）的时间和操作人，兑现后不能再撤销
+	FulfilledAt *time.Time `json:"fulfilled_at" db:"fulfilled_at"`
+	FulfilledBy *int       `json:"fulfilled_by" db:"fulfilled_by"`
 }
 
 // PointsHistory 积分变化历史
@@ -86,4 +172,51 @@ type PointsHistory struct {
 	CanRevert   bool      `json:"can_revert" db:"can_revert"`
 	IsReverted  bool      `json:"is_reverted" db:"is_reverted"`
 	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	// 规则执行时使用的规则版本ID（仅 rule 类型）
+	RuleRevisionID *int `json:"rule_revision_id,omitempty" db:"rule_revision_id"`
+	// 规则执行记录ID，同一次执行产生的多条记录共享该ID（仅 rule 类型）
+	ExecutionID *int `json:"execution_id,omitempty" db:"execution_id"`
+	// 从表格或其他应用导入的记录所属的导入批次，导入的记录不能撤销
+	ImportID *int `json:"import_id,omitempty" db:"import_id"`
+	// 规则执行的附加信息（仅 rule 类型，来自 rule_executions）
+	ExecutedBy   *int    `json:"executed_by,omitempty" db:"executed_by"`
+	Note         *string `json:"note,omitempty" db:"note"`
+	AttachmentID *int    `json:"attachment_id,omitempty" db:"attachment_id"`
+	// 最近一次撤销的操作人、原因和时间（仅已撤销的记录）
+	RevertedBy     *int       `json:"reverted_by,omitempty"`
+	RevertedByName *string    `json:"reverted_by_name,omitempty"`
+	RevertReason   *string    `json:"revert_reason,omitempty"`
+	RevertedAt     *time.Time `json:"reverted_at,omitempty"`
+}
+
+// RevertAction 撤销和取消撤销的审计记录
+type RevertAction struct {
+	ID        int       `json:"id" db:"id"`
+	HistoryID int       `json:"history_id" db:"history_id"`
+	Action    string    `json:"action" db:"action"` // "revert", "cancel_revert"
+	ActorID   int       `json:"actor_id" db:"actor_id"`
+	Reason    string    `json:"reason" db:"reason"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// RuleExecution 规则执行记录
+type RuleExecution struct {
+	ID           int       `json:"id" db:"id"`
+	RuleID       int       `json:"rule_id" db:"rule_id"`
+	Mode         string    `json:"mode" db:"mode"`                   // "single", "all"
+	ExecutedBy   *int      `json:"executed_by" db:"executed_by"`     // 触发执行的用户
+	Note         *string   `json:"note" db:"note"`                   // 执行备注
+	AttachmentID *int      `json:"attachment_id" db:"attachment_id"` // 照片附件
+	Inputs       *string   `json:"inputs" db:"inputs"`               // 表达式规则的输入参数（JSON）
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+}
+
+// Attachment 上传的附件（内容保存在对象存储中）
+type Attachment struct {
+	ID          int       `json:"id" db:"id"`
+	OwnerID     int       `json:"owner_id" db:"owner_id"`
+	StorageKey  string    `json:"-" db:"storage_key"`
+	ContentType string    `json:"content_type" db:"content_type"`
+	Size        int64     `json:"size" db:"size"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
 }